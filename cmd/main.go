@@ -69,7 +69,7 @@ func action(cliCtx *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	factory.UERoutingConfig = ueRoutingCfg
+	factory.SetUERoutingConfig(ueRoutingCfg)
 
 	smf, err := service.NewApp(cfg)
 	if err != nil {
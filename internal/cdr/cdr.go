@@ -0,0 +1,153 @@
+// Package cdr writes the offline charging records of PDU sessions to local files.
+//
+// A record is written when the PDU session is released, and on the interim interval while it's active.
+// It holds the usage reported by UPFs since the previous record of the session, aggregated per URR type
+// (N3N6, N3N9 or N9N6, measured before or after QoS enforcement) and per rating group. The usage of PCC rules
+// is aggregated per charging key, which has the service identifier or the sponsor at the reporting level.
+//
+// In the "json" format each record is a JSON object on a line (wrapped here):
+//
+//	{"supi":"imsi-208930000000001","pduSessionId":1,"chargingId":1,"dnn":"internet",
+//	 "snssai":{"sst":1,"sd":"010203"},"ueIpv4Address":"10.60.0.1","startTime":"2023-03-03T10:00:00Z",
+//	 "stopTime":"2023-03-03T10:05:00Z","cause":"normalRelease","sequenceNumber":2,
+//	 "usages":[{"urrType":"N3N6_MBEQ","ratingGroup":1,"uplinkVolume":100,"downlinkVolume":200,
+//	 "totalVolume":300,"duration":300}]}
+//
+// In the "3gpp" format each record is a block with the field names of the PDU session charging record
+// of TS 32.298 5.1.5 and a line per usage, followed by an empty line (the lines are wrapped here):
+//
+//	SMFPDUSessionRecord
+//	  servedSUPI: imsi-208930000000001
+//	  pDUSessionId: 1
+//	  pDUSessionChargingID: 1
+//	  dataNetworkNameIdentifier: internet
+//	  sNSSAI: 1-010203
+//	  servedPDUAddress: 10.60.0.1
+//	  recordOpeningTime: 2023-03-03T10:00:00Z
+//	  stopTime: 2023-03-03T10:05:00Z
+//	  duration: 300
+//	  causeForRecClosing: normalRelease
+//	  localRecordSequenceNumber: 2
+//	  listOfMultipleUnitUsage:
+//	    ratingGroup: 1, uRRType: N3N6_MBEQ, dataVolumeUplink: 100, dataVolumeDownlink: 200,
+//	    totalVolume: 300, time: 300
+package cdr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+)
+
+const (
+	FormatJSON = "json"
+	Format3GPP = "3gpp"
+)
+
+// ClosingCause is the cause for record closing (TS 32.298 5.1.2.2.15)
+type ClosingCause string
+
+const (
+	CauseNormalRelease   ClosingCause = "normalRelease"
+	CauseAbnormalRelease ClosingCause = "abnormalRelease"
+	// CauseTimeLimit closes the interim record on the interim interval
+	CauseTimeLimit ClosingCause = "timeLimit"
+)
+
+// Record is the charging record of a PDU session for the usage between the start time and the stop time
+type Record struct {
+	Supi           string         `json:"supi"`
+	PduSessionID   int32          `json:"pduSessionId"`
+	ChargingID     uint32         `json:"chargingId"`
+	Dnn            string         `json:"dnn"`
+	Snssai         *models.Snssai `json:"snssai,omitempty"`
+	UeIPv4Address  string         `json:"ueIpv4Address,omitempty"`
+	UeIPv6Prefix   string         `json:"ueIpv6Prefix,omitempty"`
+	StartTime      time.Time      `json:"startTime"`
+	StopTime       time.Time      `json:"stopTime"`
+	Cause          ClosingCause   `json:"cause"`
+	SequenceNumber uint32         `json:"sequenceNumber"`
+	Usages         []Usage        `json:"usages,omitempty"`
+}
+
+// Usage is the usage of a URR type or charging key, the duration is in seconds
+type Usage struct {
+	UrrType        string `json:"urrType,omitempty"`
+	RatingGroup    uint32 `json:"ratingGroup,omitempty"`
+	ServiceID      uint32 `json:"serviceId,omitempty"`
+	SponsorID      string `json:"sponsorId,omitempty"`
+	AppSvcProvID   string `json:"appSvcProvId,omitempty"`
+	UplinkVolume   uint64 `json:"uplinkVolume"`
+	DownlinkVolume uint64 `json:"downlinkVolume"`
+	TotalVolume    uint64 `json:"totalVolume"`
+	Duration       uint64 `json:"duration,omitempty"`
+}
+
+// encode returns the record in the format, terminated by a newline
+func (r *Record) encode(format string) ([]byte, error) {
+	switch format {
+	case FormatJSON, "":
+		buf, err := json.Marshal(r)
+		if err != nil {
+			return nil, err
+		}
+		return append(buf, '\n'), nil
+	case Format3GPP:
+		return r.encode3GPP(), nil
+	default:
+		return nil, fmt.Errorf("unknown CDR format: %s", format)
+	}
+}
+
+func (r *Record) encode3GPP() []byte {
+	var b bytes.Buffer
+	b.WriteString("SMFPDUSessionRecord\n")
+	field := func(name string, value interface{}) {
+		fmt.Fprintf(&b, "  %s: %v\n", name, value)
+	}
+	field("servedSUPI", r.Supi)
+	field("pDUSessionId", r.PduSessionID)
+	field("pDUSessionChargingID", r.ChargingID)
+	field("dataNetworkNameIdentifier", r.Dnn)
+	if r.Snssai != nil {
+		if r.Snssai.Sd != "" {
+			field("sNSSAI", fmt.Sprintf("%d-%s", r.Snssai.Sst, r.Snssai.Sd))
+		} else {
+			field("sNSSAI", r.Snssai.Sst)
+		}
+	}
+	if r.UeIPv4Address != "" {
+		field("servedPDUAddress", r.UeIPv4Address)
+	}
+	if r.UeIPv6Prefix != "" {
+		field("servedPDUIPv6Prefix", r.UeIPv6Prefix)
+	}
+	field("recordOpeningTime", r.StartTime.Format(time.RFC3339))
+	field("stopTime", r.StopTime.Format(time.RFC3339))
+	field("duration", uint64(r.StopTime.Sub(r.StartTime)/time.Second))
+	field("causeForRecClosing", r.Cause)
+	field("localRecordSequenceNumber", r.SequenceNumber)
+	if len(r.Usages) > 0 {
+		b.WriteString("  listOfMultipleUnitUsage:\n")
+		for _, u := range r.Usages {
+			fmt.Fprintf(&b, "    ratingGroup: %d, ", u.RatingGroup)
+			if u.UrrType != "" {
+				fmt.Fprintf(&b, "uRRType: %s, ", u.UrrType)
+			}
+			if u.ServiceID != 0 {
+				fmt.Fprintf(&b, "serviceIdentifier: %d, ", u.ServiceID)
+			}
+			if u.SponsorID != "" {
+				fmt.Fprintf(&b, "sponsorIdentity: %s, applicationServiceProviderIdentity: %s, ",
+					u.SponsorID, u.AppSvcProvID)
+			}
+			fmt.Fprintf(&b, "dataVolumeUplink: %d, dataVolumeDownlink: %d, totalVolume: %d, time: %d\n",
+				u.UplinkVolume, u.DownlinkVolume, u.TotalVolume, u.Duration)
+		}
+	}
+	b.WriteString("\n")
+	return b.Bytes()
+}
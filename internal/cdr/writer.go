@@ -0,0 +1,175 @@
+package cdr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+)
+
+const (
+	DefaultMaxFileSize int64 = 10 * 1024 * 1024
+	DefaultMaxFileAge        = time.Hour
+
+	filePrefix = "smf-cdr-"
+	// the file being written has the suffix until it's rotated
+	activeSuffix = ".tmp"
+)
+
+// Config of the CDR files, zero values fall back to the defaults
+type Config struct {
+	Directory   string
+	Format      string
+	MaxFileSize int64
+	MaxFileAge  time.Duration
+	MaxFiles    int
+}
+
+// Writer writes the records to the files in the directory. The file is named by the time it's opened,
+// and it's completed when it's rotated on the size or age, or the writer is closed
+type Writer struct {
+	cfg Config
+
+	mu       sync.Mutex
+	file     *os.File
+	name     string
+	size     int64
+	openedAt time.Time
+	seq      uint32
+	now      func() time.Time
+}
+
+// NewWriter creates the directory of the CDR files, the files left by the previous run are completed
+func NewWriter(cfg Config) (*Writer, error) {
+	if cfg.Format == "" {
+		cfg.Format = FormatJSON
+	}
+	if cfg.Format != FormatJSON && cfg.Format != Format3GPP {
+		return nil, fmt.Errorf("unknown CDR format: %s", cfg.Format)
+	}
+	if cfg.MaxFileSize == 0 {
+		cfg.MaxFileSize = DefaultMaxFileSize
+	}
+	if cfg.MaxFileAge == 0 {
+		cfg.MaxFileAge = DefaultMaxFileAge
+	}
+	if err := os.MkdirAll(cfg.Directory, 0o750); err != nil {
+		return nil, err
+	}
+	w := &Writer{cfg: cfg, now: time.Now}
+
+	active, err := filepath.Glob(filepath.Join(cfg.Directory, filePrefix+"*"+activeSuffix))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range active {
+		if err := os.Rename(name, strings.TrimSuffix(name, activeSuffix)); err != nil {
+			logger.CdrLog.Warnf("Complete CDR file %s failed: %+v", name, err)
+		}
+	}
+	return w, nil
+}
+
+// Write appends the record to the current file, the file is rotated before it if it's full or too old
+func (w *Writer) Write(r *Record) error {
+	buf, err := r.encode(w.cfg.Format)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+	if w.file != nil && (w.size+int64(len(buf)) > w.cfg.MaxFileSize || now.Sub(w.openedAt) >= w.cfg.MaxFileAge) {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	if w.file == nil {
+		if err := w.open(now); err != nil {
+			return err
+		}
+	}
+	n, err := w.file.Write(buf)
+	w.size += int64(n)
+	return err
+}
+
+// Close completes the current file
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	return w.rotate()
+}
+
+func (w *Writer) open(now time.Time) error {
+	ext := ".jsonl"
+	if w.cfg.Format == Format3GPP {
+		ext = ".cdr"
+	}
+	w.seq++
+	w.name = filepath.Join(w.cfg.Directory,
+		fmt.Sprintf("%s%s-%06d%s", filePrefix, now.UTC().Format("20060102T150405"), w.seq, ext))
+	file, err := os.OpenFile(w.name+activeSuffix, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.size = 0
+	w.openedAt = now
+	return nil
+}
+
+// rotate completes the current file and removes the oldest completed files beyond the maximum
+func (w *Writer) rotate() error {
+	file := w.file
+	w.file = nil
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(w.name+activeSuffix, w.name); err != nil {
+		return err
+	}
+	logger.CdrLog.Infof("CDR file %s is completed", w.name)
+
+	if w.cfg.MaxFiles <= 0 {
+		return nil
+	}
+	names, err := w.completedFiles()
+	if err != nil {
+		return err
+	}
+	for len(names) > w.cfg.MaxFiles {
+		if err := os.Remove(names[0]); err != nil {
+			logger.CdrLog.Warnf("Remove CDR file %s failed: %+v", names[0], err)
+		}
+		names = names[1:]
+	}
+	return nil
+}
+
+// completedFiles returns the completed files from the oldest one
+func (w *Writer) completedFiles() ([]string, error) {
+	names, err := filepath.Glob(filepath.Join(w.cfg.Directory, filePrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+	completed := names[:0]
+	for _, name := range names {
+		if !strings.HasSuffix(name, activeSuffix) {
+			completed = append(completed, name)
+		}
+	}
+	sort.Strings(completed)
+	return completed, nil
+}
@@ -0,0 +1,128 @@
+package cdr
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+)
+
+func testRecord(seq uint32) *Record {
+	start := time.Date(2023, 3, 3, 10, 0, 0, 0, time.UTC)
+	return &Record{
+		Supi:           "imsi-208930000000001",
+		PduSessionID:   1,
+		ChargingID:     1,
+		Dnn:            "internet",
+		Snssai:         &models.Snssai{Sst: 1, Sd: "010203"},
+		UeIPv4Address:  "10.60.0.1",
+		StartTime:      start,
+		StopTime:       start.Add(5 * time.Minute),
+		Cause:          CauseNormalRelease,
+		SequenceNumber: seq,
+		Usages: []Usage{
+			{UrrType: "N3N6_MBEQ", RatingGroup: 1, UplinkVolume: 100, DownlinkVolume: 200, TotalVolume: 300},
+		},
+	}
+}
+
+func TestWriterJSON(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(Config{Directory: dir})
+	require.NoError(t, err)
+	require.NoError(t, w.Write(testRecord(1)))
+	require.NoError(t, w.Write(testRecord(2)))
+
+	// the file being written isn't completed
+	names, err := w.completedFiles()
+	require.NoError(t, err)
+	require.Empty(t, names)
+
+	require.NoError(t, w.Close())
+	names, err = w.completedFiles()
+	require.NoError(t, err)
+	require.Len(t, names, 1)
+	require.Equal(t, ".jsonl", filepath.Ext(names[0]))
+
+	file, err := os.Open(names[0])
+	require.NoError(t, err)
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	var records []Record
+	for scanner.Scan() {
+		var r Record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &r))
+		records = append(records, r)
+	}
+	require.Len(t, records, 2)
+	require.Equal(t, uint32(2), records[1].SequenceNumber)
+	require.Equal(t, uint64(300), records[0].Usages[0].TotalVolume)
+	require.True(t, testRecord(1).StopTime.Equal(records[0].StopTime))
+}
+
+func TestWriter3GPP(t *testing.T) {
+	buf := testRecord(1).encode3GPP()
+	lines := strings.Split(string(buf), "\n")
+	require.Equal(t, "SMFPDUSessionRecord", lines[0])
+	require.Contains(t, lines, "  servedSUPI: imsi-208930000000001")
+	require.Contains(t, lines, "  sNSSAI: 1-010203")
+	require.Contains(t, lines, "  duration: 300")
+	require.Contains(t, lines, "  causeForRecClosing: normalRelease")
+	require.Contains(t, lines, "    ratingGroup: 1, uRRType: N3N6_MBEQ, dataVolumeUplink: 100, dataVolumeDownlink: 200, "+
+		"totalVolume: 300, time: 0")
+	require.True(t, strings.HasSuffix(string(buf), "\n\n"))
+
+	_, err := NewWriter(Config{Directory: t.TempDir(), Format: "asn1"})
+	require.Error(t, err)
+}
+
+func TestWriterRotation(t *testing.T) {
+	dir := t.TempDir()
+	size := int64(len(mustEncode(t, testRecord(1))))
+	w, err := NewWriter(Config{Directory: dir, MaxFileSize: 2 * size, MaxFileAge: time.Minute, MaxFiles: 2})
+	require.NoError(t, err)
+	now := time.Date(2023, 3, 3, 10, 0, 0, 0, time.UTC)
+	w.now = func() time.Time { return now }
+
+	// rotated on the size
+	for i := uint32(1); i <= 3; i++ {
+		require.NoError(t, w.Write(testRecord(i)))
+	}
+	names, err := w.completedFiles()
+	require.NoError(t, err)
+	require.Len(t, names, 1)
+
+	// rotated on the age
+	now = now.Add(time.Minute)
+	require.NoError(t, w.Write(testRecord(4)))
+	now = now.Add(time.Minute)
+	require.NoError(t, w.Write(testRecord(5)))
+
+	// the oldest file is removed
+	names, err = w.completedFiles()
+	require.NoError(t, err)
+	require.Len(t, names, 2)
+	buf, err := os.ReadFile(names[0])
+	require.NoError(t, err)
+	require.Contains(t, string(buf), `"sequenceNumber":3`)
+
+	// the file left by the previous run is completed
+	w2, err := NewWriter(Config{Directory: dir})
+	require.NoError(t, err)
+	names, err = w2.completedFiles()
+	require.NoError(t, err)
+	require.Len(t, names, 3)
+}
+
+func mustEncode(t *testing.T, r *Record) []byte {
+	buf, err := r.encode(FormatJSON)
+	require.NoError(t, err)
+	return buf
+}
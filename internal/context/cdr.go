@@ -0,0 +1,190 @@
+package context
+
+import (
+	"sort"
+	"time"
+
+	"bitbucket.org/free5gc-team/smf/internal/cdr"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+)
+
+// OfflineCharging writes the charging records of PDU sessions to the local files without CHF
+type OfflineCharging struct {
+	Writer *cdr.Writer
+	// InterimInterval is the interval of the interim records, 0 if only the final record is written
+	InterimInterval time.Duration
+	// RatingGroup of the usage which isn't charged with a rating group by CHF
+	RatingGroup uint32
+}
+
+// chargingRecordState is the offline charging record of the PDU session being opened
+type chargingRecordState struct {
+	startTime time.Time
+	// reportIndex is the first usage report of UrrReports in the record
+	reportIndex    int
+	sequenceNumber uint32
+	interimTimer   *time.Timer
+}
+
+// StartChargingRecord opens the charging record of the PDU session, the interim records are written
+// on the interim interval until the final record is written
+func (smContext *SMContext) StartChargingRecord() {
+	offline := GetSelf().Cdr
+	if offline == nil || !smContext.chargingRecord.startTime.IsZero() {
+		return
+	}
+	smContext.chargingRecord.startTime = time.Now()
+	smContext.chargingRecord.reportIndex = len(smContext.UrrReports)
+	if offline.InterimInterval > 0 {
+		smContext.startInterimRecordTimer(offline.InterimInterval)
+	}
+}
+
+func (smContext *SMContext) startInterimRecordTimer(interval time.Duration) {
+	var timer *time.Timer
+	timer = time.AfterFunc(interval, func() {
+		smContext.SMLock.Lock()
+		closed := smContext.chargingRecord.interimTimer != timer
+		smContext.SMLock.Unlock()
+		if closed {
+			return
+		}
+
+		// the usage since the last report is queried from UPFs, or the record only has the usage
+		// reported by the reporting triggers of UPFs
+		if query := GetProcedureHandlers().UsageQuery; query != nil {
+			if failedUPFs := query(smContext); len(failedUPFs) > 0 {
+				logger.CdrLog.Warnf("Usage of %s on UPFs %v isn't queried for the interim record",
+					smContext.Ref, failedUPFs)
+			}
+		}
+
+		smContext.SMLock.Lock()
+		defer smContext.SMLock.Unlock()
+
+		// the record is closed while the timer fires
+		if smContext.chargingRecord.interimTimer != timer {
+			return
+		}
+		smContext.writeChargingRecord(cdr.CauseTimeLimit, time.Now())
+		smContext.startInterimRecordTimer(interval)
+	})
+	smContext.chargingRecord.interimTimer = timer
+}
+
+// CloseChargingRecord writes the final charging record of the PDU session with the closing cause,
+// it's normal release if the cause isn't set
+func (smContext *SMContext) CloseChargingRecord() {
+	if GetSelf().Cdr == nil || smContext.chargingRecord.startTime.IsZero() {
+		return
+	}
+	if timer := smContext.chargingRecord.interimTimer; timer != nil {
+		timer.Stop()
+		smContext.chargingRecord.interimTimer = nil
+	}
+	cause := smContext.RecordClosingCause
+	if cause == "" {
+		cause = cdr.CauseNormalRelease
+	}
+	smContext.writeChargingRecord(cause, time.Now())
+	smContext.chargingRecord.startTime = time.Time{}
+}
+
+func (smContext *SMContext) writeChargingRecord(cause cdr.ClosingCause, now time.Time) {
+	record := smContext.ChargingRecord(cause, now)
+	if err := GetSelf().Cdr.Writer.Write(record); err != nil {
+		logger.CdrLog.Errorf("Write charging record of %s failed: %+v", smContext.Ref, err)
+	}
+	smContext.chargingRecord.startTime = now
+	smContext.chargingRecord.reportIndex = len(smContext.UrrReports)
+}
+
+// ChargingRecord returns the charging record of the usage reported since the previous record,
+// which is aggregated per URR type and charging key
+func (smContext *SMContext) ChargingRecord(cause cdr.ClosingCause, now time.Time) *cdr.Record {
+	smContext.chargingRecord.sequenceNumber++
+	record := &cdr.Record{
+		Supi:           smContext.Supi,
+		PduSessionID:   smContext.PDUSessionID,
+		ChargingID:     smContext.PDUSessionChargingID(),
+		Dnn:            smContext.Dnn,
+		Snssai:         smContext.SNssai,
+		UeIPv4Address:  smContext.UeIPv4Address(),
+		UeIPv6Prefix:   smContext.UeIPv6Prefix(),
+		StartTime:      smContext.chargingRecord.startTime,
+		StopTime:       now,
+		Cause:          cause,
+		SequenceNumber: smContext.chargingRecord.sequenceNumber,
+	}
+
+	type usageKey struct {
+		urrType string
+		ChargingKey
+	}
+	usages := make(map[usageKey]*cdr.Usage)
+	index := smContext.chargingRecord.reportIndex
+	if index > len(smContext.UrrReports) {
+		index = 0
+	}
+	for _, report := range smContext.UrrReports[index:] {
+		// the usage monitored for PCF is measured by the other URRs as well
+		if smContext.isUsageMonitoringUrr(report.UrrId) {
+			continue
+		}
+		key := usageKey{ChargingKey: smContext.urrChargingKey(report.UrrId)}
+		if urrType, err := smContext.GetUrrTypeById(report.UrrId); err == nil {
+			key.urrType = urrType.String()
+		}
+		usage, ok := usages[key]
+		if !ok {
+			usage = &cdr.Usage{
+				UrrType:      key.urrType,
+				RatingGroup:  key.RatingGroup,
+				ServiceID:    key.ServiceID,
+				SponsorID:    key.SponsorID,
+				AppSvcProvID: key.AppSvcProvID,
+			}
+			usages[key] = usage
+		}
+		usage.UplinkVolume += report.UplinkVolume
+		usage.DownlinkVolume += report.DownlinkVolume
+		usage.TotalVolume += report.TotalVolume
+		usage.Duration += uint64(report.Duration / time.Second)
+	}
+	for _, usage := range usages {
+		record.Usages = append(record.Usages, *usage)
+	}
+	sort.Slice(record.Usages, func(i, j int) bool {
+		a, b := record.Usages[i], record.Usages[j]
+		if a.RatingGroup != b.RatingGroup {
+			return a.RatingGroup < b.RatingGroup
+		}
+		if a.ServiceID != b.ServiceID {
+			return a.ServiceID < b.ServiceID
+		}
+		if a.SponsorID != b.SponsorID {
+			return a.SponsorID < b.SponsorID
+		}
+		return a.UrrType < b.UrrType
+	})
+	return record
+}
+
+// urrChargingKey returns the charging key of the usage of the URR, which is the one of the PCC rules,
+// the rating group of the quota granted by CHF or the configured rating group of the offline charging
+func (smContext *SMContext) urrChargingKey(urrID uint32) ChargingKey {
+	if key, ok := smContext.UrrChargingKey(urrID); ok {
+		return key
+	}
+	if session := smContext.ChargingSession; session != nil {
+		for _, q := range session.Quotas {
+			if q.URRID == urrID {
+				return ChargingKey{RatingGroup: q.RatingGroup}
+			}
+		}
+	}
+	if offline := GetSelf().Cdr; offline != nil {
+		return ChargingKey{RatingGroup: offline.RatingGroup}
+	}
+	return ChargingKey{}
+}
@@ -0,0 +1,101 @@
+package context
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/smf/internal/cdr"
+)
+
+func TestChargingRecord(t *testing.T) {
+	origCdr := GetSelf().Cdr
+	GetSelf().Cdr = &OfflineCharging{RatingGroup: 10}
+	defer func() { GetSelf().Cdr = origCdr }()
+
+	smContext := &SMContext{
+		Supi:         "imsi-208930000000001",
+		PDUSessionID: 1,
+		Dnn:          "internet",
+		SNssai:       &models.Snssai{Sst: 1, Sd: "010203"},
+		PDUAddress:   net.ParseIP("10.60.0.1").To4(),
+		UrrIdMap:     map[UrrType]uint32{N3N6_MBEQ_URR: 1, N3N6_MAEQ_URR: 2},
+		ChargingSession: &ChargingSession{
+			Quotas: map[uint32]*ChargingQuota{1: {RatingGroup: 1, URRID: 3}},
+		},
+	}
+	start := time.Now()
+	smContext.chargingRecord.startTime = start
+	for _, report := range []UsageReport{
+		{UrrId: 1, UplinkVolume: 10, DownlinkVolume: 20, TotalVolume: 30},
+		{UrrId: 1, UplinkVolume: 1, DownlinkVolume: 2, TotalVolume: 3, Duration: time.Minute},
+		{UrrId: 2, UplinkVolume: 5, DownlinkVolume: 5, TotalVolume: 10},
+		{UrrId: 3, TotalVolume: 100},
+	} {
+		smContext.AddUsageReport(report)
+	}
+
+	record := smContext.ChargingRecord(cdr.CauseTimeLimit, start.Add(time.Minute))
+	require.Equal(t, "10.60.0.1", record.UeIPv4Address)
+	require.Equal(t, uint32(1), record.SequenceNumber)
+	require.Equal(t, cdr.CauseTimeLimit, record.Cause)
+	require.Equal(t, []cdr.Usage{
+		{RatingGroup: 1, TotalVolume: 100},
+		{UrrType: "N3N6_MAEQ", RatingGroup: 10, UplinkVolume: 5, DownlinkVolume: 5, TotalVolume: 10},
+		{UrrType: "N3N6_MBEQ", RatingGroup: 10, UplinkVolume: 11, DownlinkVolume: 22, TotalVolume: 33, Duration: 60},
+	}, record.Usages)
+
+	// the next record holds the usage reported after the previous one
+	smContext.chargingRecord.reportIndex = len(smContext.UrrReports)
+	smContext.AddUsageReport(UsageReport{UrrId: 2, TotalVolume: 7})
+	record = smContext.ChargingRecord(cdr.CauseNormalRelease, start.Add(2*time.Minute))
+	require.Equal(t, uint32(2), record.SequenceNumber)
+	require.Equal(t, []cdr.Usage{{UrrType: "N3N6_MAEQ", RatingGroup: 10, TotalVolume: 7}}, record.Usages)
+}
+
+func TestInterimChargingRecordQueriesUsage(t *testing.T) {
+	writer, err := cdr.NewWriter(cdr.Config{Directory: t.TempDir()})
+	require.NoError(t, err)
+	defer func() { require.NoError(t, writer.Close()) }()
+	origCdr := GetSelf().Cdr
+	GetSelf().Cdr = &OfflineCharging{Writer: writer, InterimInterval: 20 * time.Millisecond, RatingGroup: 10}
+	defer func() { GetSelf().Cdr = origCdr }()
+
+	smContext := &SMContext{
+		Supi:     "imsi-208930000000001",
+		UrrIdMap: map[UrrType]uint32{N3N6_MBEQ_URR: 1},
+	}
+	queried := make(chan struct{}, 1)
+	SetProcedureHandlers(ProcedureHandlers{UsageQuery: func(smContext *SMContext) []string {
+		// the SM context isn't locked while querying UPFs
+		smContext.SMLock.Lock()
+		smContext.AddUsageReport(UsageReport{UrrId: 1, TotalVolume: 30})
+		smContext.SMLock.Unlock()
+		select {
+		case queried <- struct{}{}:
+		default:
+		}
+		return nil
+	}})
+	defer SetProcedureHandlers(ProcedureHandlers{})
+
+	smContext.StartChargingRecord()
+	select {
+	case <-queried:
+	case <-time.After(time.Second):
+		t.Fatal("usage isn't queried for the interim record")
+	}
+	require.Eventually(t, func() bool {
+		smContext.SMLock.Lock()
+		defer smContext.SMLock.Unlock()
+		return smContext.chargingRecord.sequenceNumber > 0 &&
+			smContext.chargingRecord.reportIndex > 0
+	}, time.Second, 10*time.Millisecond)
+
+	smContext.SMLock.Lock()
+	smContext.CloseChargingRecord()
+	smContext.SMLock.Unlock()
+}
@@ -0,0 +1,443 @@
+package context
+
+import (
+	"math"
+	"net"
+	"sort"
+	"time"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+)
+
+// OnlineCharging is the online charging of PDU sessions by CHF (TS 32.255 5.2.2),
+// the PDU session level usage is charged with the rating group
+type OnlineCharging struct {
+	ChfUri          string
+	RatingGroup     uint32
+	RequestedVolume uint64
+	RequestedTime   time.Duration
+}
+
+type ChargingRequestType uint8
+
+const (
+	ChargingInitial ChargingRequestType = iota
+	ChargingUpdate
+	ChargingRelease
+)
+
+// Redirect Address Type of Redirect Information (TS 29.244 8.2.20)
+const (
+	redirectAddressTypeIPv4 uint8 = 0
+	redirectAddressTypeIPv6 uint8 = 1
+	redirectAddressTypeURL  uint8 = 2
+)
+
+// ChargingSession is the charging session of the PDU session on CHF
+type ChargingSession struct {
+	// Ref is the charging data reference allocated by CHF
+	Ref string
+	// ChargingID identifies the charging data of the PDU session
+	ChargingID               uint32
+	InvocationSequenceNumber uint32
+	// Quotas of the rating groups, key: rating group
+	Quotas map[uint32]*ChargingQuota
+}
+
+// ChargingQuota is the quota of a rating group granted by CHF, it's enforced by the quota URRs on PSA UPFs
+type ChargingQuota struct {
+	RatingGroup uint32
+	URRID       uint32
+
+	VolumeQuota uint64
+	TimeQuota   time.Duration
+	// the thresholds are the used units when UPF reports the usage before the quota is exhausted
+	VolumeThreshold uint64
+	TimeThreshold   time.Duration
+	ValidityTime    time.Duration
+
+	// FinalUnitAction is taken after the final units are used up, it's empty if the units aren't the final ones
+	FinalUnitAction models.FinalUnitAction
+	RedirectServer  *models.RedirectServer
+
+	// Usage is reported by UPFs and not yet reported to CHF
+	Usage ChargingUsage
+}
+
+// ChargingUsage is the usage of a rating group and the charging triggers of the usage reports
+type ChargingUsage struct {
+	TotalVolume    uint64
+	UplinkVolume   uint64
+	DownlinkVolume uint64
+	Time           time.Duration
+	Triggers       []models.TriggerType
+}
+
+// PDUSessionChargingID returns the charging ID of the PDU session, it's allocated at the first use
+func (smContext *SMContext) PDUSessionChargingID() uint32 {
+	if smContext.ChargingID == 0 {
+		smContext.ChargingID = AllocateChargingID()
+	}
+	return smContext.ChargingID
+}
+
+// StartChargingSession creates the charging session with the quota of the configured rating group,
+// the quota is requested from CHF by the initial charging data request
+func (smContext *SMContext) StartChargingSession() error {
+	charging := GetSelf().Charging
+	if charging == nil {
+		return nil
+	}
+	id, err := smContext.UrrIDGenerator.Allocate()
+	if err != nil {
+		return err
+	}
+	smContext.ChargingSession = &ChargingSession{
+		ChargingID: smContext.PDUSessionChargingID(),
+		Quotas: map[uint32]*ChargingQuota{
+			charging.RatingGroup: {
+				RatingGroup: charging.RatingGroup,
+				URRID:       uint32(id),
+			},
+		},
+	}
+	return nil
+}
+
+// NextInvocationSequenceNumber returns the sequence number of the next charging data request
+func (s *ChargingSession) NextInvocationSequenceNumber() uint32 {
+	n := s.InvocationSequenceNumber
+	s.InvocationSequenceNumber++
+	return n
+}
+
+func (s *ChargingSession) sortedQuotas() []*ChargingQuota {
+	quotas := make([]*ChargingQuota, 0, len(s.Quotas))
+	for _, q := range s.Quotas {
+		quotas = append(quotas, q)
+	}
+	sort.Slice(quotas, func(i, j int) bool { return quotas[i].RatingGroup < quotas[j].RatingGroup })
+	return quotas
+}
+
+// UnitUsage returns the usage of the rating groups to be reported to CHF and the units requested for them.
+// The units are requested for all rating groups initially, and for the ones whose quotas are exhausted,
+// reach the thresholds or expire afterwards unless the final units are granted. The usage is reset
+func (s *ChargingSession) UnitUsage(requestType ChargingRequestType) []models.MultipleUnitUsage {
+	var unitUsages []models.MultipleUnitUsage
+	for _, q := range s.sortedQuotas() {
+		unitUsage := models.MultipleUnitUsage{RatingGroup: int32(q.RatingGroup)}
+		request := requestType == ChargingInitial ||
+			(requestType == ChargingUpdate && q.reauthorizationTriggered() && q.FinalUnitAction == "")
+		if containers := q.Usage.usedUnitContainers(); containers != nil {
+			unitUsage.UsedUnitContainer = containers
+		} else if !request {
+			continue
+		}
+		if request {
+			unitUsage.RequestedUnit = requestedUnit()
+		}
+		q.Usage = ChargingUsage{}
+		unitUsages = append(unitUsages, unitUsage)
+	}
+	return unitUsages
+}
+
+func requestedUnit() *models.RequestedUnit {
+	charging := GetSelf().Charging
+	if charging == nil {
+		return nil
+	}
+	return &models.RequestedUnit{
+		TotalVolume: clampInt32(charging.RequestedVolume),
+		Time:        clampInt32(uint64(charging.RequestedTime / time.Second)),
+	}
+}
+
+// clampInt32 converts the units to the int32 of Nchf, the units above math.MaxInt32 are clamped
+func clampInt32(units uint64) int32 {
+	if units > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int32(units)
+}
+
+// usedUnitContainers returns the containers of the usage, nil if there is no usage to report.
+// The volumes of Nchf are int32, so the usage above math.MaxInt32 is split into more containers
+func (u *ChargingUsage) usedUnitContainers() []models.UsedUnitContainer {
+	if len(u.Triggers) == 0 && u.TotalVolume == 0 && u.Time == 0 {
+		return nil
+	}
+	container := models.UsedUnitContainer{
+		QuotaManagementIndicator: models.QuotaManagementIndicator_ONLINE_CHARGING,
+		Time:                     clampInt32(uint64(u.Time / time.Second)),
+	}
+	for _, trigger := range u.Triggers {
+		container.Triggers = append(container.Triggers, models.Trigger{
+			TriggerType:     trigger,
+			TriggerCategory: models.TriggerCategory_IMMEDIATE_REPORT,
+		})
+	}
+
+	var containers []models.UsedUnitContainer
+	total, uplink, downlink := u.TotalVolume, u.UplinkVolume, u.DownlinkVolume
+	for {
+		container.TotalVolume = clampInt32(total)
+		container.UplinkVolume = clampInt32(uplink)
+		container.DownlinkVolume = clampInt32(downlink)
+		containers = append(containers, container)
+		total -= uint64(container.TotalVolume)
+		uplink -= uint64(container.UplinkVolume)
+		downlink -= uint64(container.DownlinkVolume)
+		if total == 0 && uplink == 0 && downlink == 0 {
+			return containers
+		}
+		container = models.UsedUnitContainer{
+			QuotaManagementIndicator: models.QuotaManagementIndicator_ONLINE_CHARGING,
+		}
+	}
+}
+
+// reauthorizationTriggered reports whether the quota is exhausted, reaches the threshold or expires,
+// for which the units are requested from CHF immediately
+func (q *ChargingQuota) reauthorizationTriggered() bool {
+	for _, trigger := range q.Usage.Triggers {
+		switch trigger {
+		case models.TriggerType_QUOTA_EXHAUSTED, models.TriggerType_QUOTA_THRESHOLD,
+			models.TriggerType_VALIDITY_TIME:
+			return true
+		}
+	}
+	return false
+}
+
+// Exhausted reports whether UPF reports the quota is used up
+func (q *ChargingQuota) Exhausted() bool {
+	for _, trigger := range q.Usage.Triggers {
+		if trigger == models.TriggerType_QUOTA_EXHAUSTED {
+			return true
+		}
+	}
+	return false
+}
+
+// Grant applies the units granted by CHF to the quota, the thresholds of CHF are the remaining units
+// when the usage is to be reported
+func (q *ChargingQuota) Grant(info *models.MultipleUnitInformation) {
+	*q = ChargingQuota{RatingGroup: q.RatingGroup, URRID: q.URRID, Usage: q.Usage}
+	if unit := info.GrantedUnit; unit != nil {
+		q.VolumeQuota = uint64(unit.TotalVolume)
+		q.TimeQuota = time.Duration(unit.Time) * time.Second
+	}
+	if threshold := uint64(info.VolumeQuotaThreshold); threshold > 0 && threshold < q.VolumeQuota {
+		q.VolumeThreshold = q.VolumeQuota - threshold
+	}
+	if threshold := time.Duration(info.TimeQuotaThreshold) * time.Second; threshold > 0 && threshold < q.TimeQuota {
+		q.TimeThreshold = q.TimeQuota - threshold
+	}
+	q.ValidityTime = time.Duration(info.ValidityTime) * time.Second
+	if fui := info.FinalUnitIndication; fui != nil {
+		q.FinalUnitAction = fui.FinalUnitAction
+		q.RedirectServer = fui.RedirectServer
+	}
+}
+
+// setURR sets the quota and the thresholds to the URR, UPF reports the usage when the quota is exhausted,
+// the threshold is reached or the quota validity time expires
+func (q *ChargingQuota) setURR(urr *URR) {
+	urr.MeasureMethod = MesureMethodVol
+	urr.MeasurementPeriod = 0
+	urr.ReportingTrigger = pfcpType.ReportingTriggers{Volth: q.VolumeThreshold != 0}
+	for _, opt := range []UrrOpt{
+		NewVolumeThreshold(q.VolumeThreshold),
+		NewVolumeQuota(q.VolumeQuota),
+		NewTimeThreshold(q.TimeThreshold),
+		NewTimeQuota(q.TimeQuota),
+		NewQuotaValidityTime(q.ValidityTime),
+	} {
+		opt(urr)
+	}
+}
+
+// addChargingUrr adds the quota URRs to the PDRs of PSA of the data path,
+// the usage of the PDU session is charged on the N6 traffic
+func (dataPath *DataPath) addChargingUrr(smContext *SMContext) {
+	var psa *DataPathNode
+	for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+		psa = node
+	}
+	if psa == nil {
+		return
+	}
+
+	for _, q := range smContext.ChargingSession.sortedQuotas() {
+		key := getUrrIdKey(psa.UPF.UUID(), q.URRID)
+		urr, ok := smContext.UrrUpfMap[key]
+		if !ok {
+			var err error
+			if urr, err = psa.UPF.AddURR(q.URRID, q.setURR); err != nil {
+				logger.PduSessLog.Errorln("new charging URR failed:", err)
+				continue
+			}
+			smContext.UrrUpfMap[key] = urr
+		}
+		for _, tunnel := range []*GTPTunnel{psa.UpLinkTunnel, psa.DownLinkTunnel} {
+			if tunnel != nil && tunnel.PDR != nil {
+				tunnel.PDR.URR = append(tunnel.PDR.URR, urr)
+			}
+		}
+	}
+}
+
+// ChargingRules are the quota URRs and the FARs of a UPF updated by the units granted by CHF
+type ChargingRules struct {
+	URRs []*URR
+	FARs []*FAR
+}
+
+// ApplyChargingQuota updates the quota URRs with the quota granted by CHF. If redirect is set,
+// the uplink traffic of PSA is redirected to the redirect server of the final unit indication,
+// otherwise the redirection is removed. It returns the updated rules of each UPF
+func (smContext *SMContext) ApplyChargingQuota(q *ChargingQuota, redirect bool) map[*UPF]*ChargingRules {
+	var redirectInfo *pfcpType.RedirectInformation
+	if redirect {
+		redirectInfo = redirectInformation(q.RedirectServer)
+	}
+
+	rules := make(map[*UPF]*ChargingRules)
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		if !dataPath.Activated {
+			continue
+		}
+		var psa *DataPathNode
+		for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+			psa = node
+		}
+		if psa == nil || psa.UpLinkTunnel == nil || psa.UpLinkTunnel.PDR == nil {
+			continue
+		}
+		pdr := psa.UpLinkTunnel.PDR
+		for _, urr := range pdr.URR {
+			if urr.URRID != q.URRID {
+				continue
+			}
+			r := rules[psa.UPF]
+			if r == nil {
+				r = &ChargingRules{}
+				rules[psa.UPF] = r
+			}
+			// the URR is shared by the PDRs of the data paths anchored at the UPF
+			if !containsURR(r.URRs, urr) {
+				q.setURR(urr)
+				if urr.State != RULE_INITIAL {
+					urr.State = RULE_UPDATE
+				}
+				r.URRs = append(r.URRs, urr)
+			}
+			if far := pdr.FAR; far != nil && far.ForwardingParameters != nil &&
+				(redirectInfo != nil || far.ForwardingParameters.RedirectInformation != nil) {
+				far.ForwardingParameters.RedirectInformation = redirectInfo
+				if far.State != RULE_INITIAL {
+					far.State = RULE_UPDATE
+				}
+				r.FARs = append(r.FARs, far)
+			}
+		}
+	}
+	return rules
+}
+
+func containsURR(urrs []*URR, urr *URR) bool {
+	for _, u := range urrs {
+		if u == urr {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectInformation converts the redirect server of CHF to the Redirect Information of PFCP
+func redirectInformation(server *models.RedirectServer) *pfcpType.RedirectInformation {
+	if server == nil || server.RedirectServerAddress == "" {
+		return nil
+	}
+	var addrType uint8
+	switch server.RedirectAddressType {
+	case models.RedirectAddressType_IPV4_ADDR:
+		addrType = redirectAddressTypeIPv4
+	case models.RedirectAddressType_IPV6_ADDR:
+		addrType = redirectAddressTypeIPv6
+	case models.RedirectAddressType_URL:
+		addrType = redirectAddressTypeURL
+	default:
+		if ip := net.ParseIP(server.RedirectServerAddress); ip == nil {
+			addrType = redirectAddressTypeURL
+		} else if ip.To4() != nil {
+			addrType = redirectAddressTypeIPv4
+		} else {
+			addrType = redirectAddressTypeIPv6
+		}
+	}
+	return &pfcpType.RedirectInformation{
+		RedirectAddressType:         addrType,
+		RedirectServerAddressLength: uint16(len(server.RedirectServerAddress)),
+		RedirectServerAddress:       []byte(server.RedirectServerAddress),
+	}
+}
+
+// AddUsageReport records the usage report of UPF, the usage of the quota URR is accounted to its rating group
+// to be reported to CHF, and the one of the usage monitoring URR to its monitoring key to be reported to PCF
+func (smContext *SMContext) AddUsageReport(report UsageReport) {
+	smContext.UrrReports = append(smContext.UrrReports, report)
+	smContext.addMonitoredUsage(report)
+	if smContext.ChargingSession == nil {
+		return
+	}
+	for _, q := range smContext.ChargingSession.Quotas {
+		if q.URRID != report.UrrId {
+			continue
+		}
+		q.Usage.TotalVolume += report.TotalVolume
+		q.Usage.UplinkVolume += report.UplinkVolume
+		q.Usage.DownlinkVolume += report.DownlinkVolume
+		q.Usage.Time += report.Duration
+		if report.ReportTpye != "" {
+			q.Usage.Triggers = append(q.Usage.Triggers, report.ReportTpye)
+		}
+	}
+}
+
+// ChargingReportPending reports whether a quota is exhausted, reaches the threshold or expires,
+// and the usage is to be reported to CHF for the new quota
+func (smContext *SMContext) ChargingReportPending() bool {
+	if smContext.ChargingSession == nil {
+		return false
+	}
+	for _, q := range smContext.ChargingSession.Quotas {
+		if q.reauthorizationTriggered() {
+			return true
+		}
+	}
+	return false
+}
+
+// ChargingTriggerType converts the Usage Report Trigger of UPF to the charging trigger of CHF,
+// it's empty if the report isn't triggered by the quota
+func ChargingTriggerType(trigger *pfcpType.UsageReportTrigger) models.TriggerType {
+	if trigger == nil {
+		return ""
+	}
+	switch {
+	case trigger.Volqu, trigger.Timqu:
+		return models.TriggerType_QUOTA_EXHAUSTED
+	case trigger.Volth, trigger.Timth:
+		return models.TriggerType_QUOTA_THRESHOLD
+	case trigger.Quvti:
+		return models.TriggerType_VALIDITY_TIME
+	case trigger.Termr:
+		return models.TriggerType_FINAL
+	}
+	return ""
+}
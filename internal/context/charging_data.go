@@ -0,0 +1,106 @@
+package context
+
+import (
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+)
+
+// ChargingKey identifies the usage of PCC rules measured together by a URR at the reporting level of
+// the charging data (TS 29.512 5.6.2.11), which is the rating group, and the service identifier or the
+// sponsor and application service provider at the service or sponsored connectivity level
+type ChargingKey struct {
+	RatingGroup  uint32
+	ServiceID    uint32
+	SponsorID    string
+	AppSvcProvID string
+}
+
+// NewChargingKey returns the charging key of the charging data, the service identifier level is the default
+func NewChargingKey(chgData *models.ChargingData) ChargingKey {
+	key := ChargingKey{RatingGroup: uint32(chgData.RatingGroup)}
+	switch chgData.ReportingLevel {
+	case models.ReportingLevel_RAT_GR_LEVEL:
+	case models.ReportingLevel_SPON_CON_LEVEL:
+		key.SponsorID = chgData.SponsorId
+		key.AppSvcProvID = chgData.AppSvcProvId
+	default:
+		key.ServiceID = uint32(chgData.ServiceId)
+	}
+	return key
+}
+
+// measureMethod returns the measurement method of the URR for the metering method, volume by default
+func measureMethod(method models.MeteringMethod) string {
+	switch method {
+	case models.MeteringMethod_DURATION:
+		return MesureMethodTime
+	case models.MeteringMethod_DURATION_VOLUME:
+		return MesureMethodVolTime
+	}
+	return MesureMethodVol
+}
+
+// chargingKeyUrrID returns the URR ID of the charging key, the URR is shared by the PCC rules of the key
+func (c *SMContext) chargingKeyUrrID(key ChargingKey) (uint32, error) {
+	if id, ok := c.ChargingKeyUrrIDs[key]; ok {
+		return id, nil
+	}
+	id, err := c.UrrIDGenerator.Allocate()
+	if err != nil {
+		return 0, err
+	}
+	if c.ChargingKeyUrrIDs == nil {
+		c.ChargingKeyUrrIDs = make(map[ChargingKey]uint32)
+	}
+	c.ChargingKeyUrrIDs[key] = uint32(id)
+	return uint32(id), nil
+}
+
+// UrrChargingKey returns the charging key of the usage reported by the URR,
+// false if the URR doesn't measure the usage of PCC rules
+func (c *SMContext) UrrChargingKey(urrID uint32) (ChargingKey, bool) {
+	for key, id := range c.ChargingKeyUrrIDs {
+		if id == urrID {
+			return key, true
+		}
+	}
+	return ChargingKey{}, false
+}
+
+// addChargingKeyUrr adds the URR of the charging key of the PCC rule to the PDRs of PSA of the data path,
+// the usage is reported on the URR report period and threshold of the PDU session
+func (dataPath *DataPath) addChargingKeyUrr(smContext *SMContext, chgData *models.ChargingData) {
+	var psa *DataPathNode
+	for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+		psa = node
+	}
+	if psa == nil {
+		return
+	}
+
+	urrID, err := smContext.chargingKeyUrrID(NewChargingKey(chgData))
+	if err != nil {
+		logger.PduSessLog.Errorln("allocate URR ID of charging key failed:", err)
+		return
+	}
+	key := getUrrIdKey(psa.UPF.UUID(), urrID)
+	urr, ok := smContext.UrrUpfMap[key]
+	if !ok {
+		if urr, err = psa.UPF.AddURR(urrID,
+			NewMeasureMethod(measureMethod(chgData.MeteringMethod)),
+			NewMeasureInformation(true, false),
+			NewMeasurementPeriod(smContext.UrrReportTime),
+			NewVolumeThreshold(smContext.UrrReportThreshold)); err != nil {
+			logger.PduSessLog.Errorln("new charging key URR failed:", err)
+			return
+		}
+		urr.ReportingTrigger.Perio = smContext.UrrReportTime != 0
+		urr.ReportingTrigger.Volth = smContext.UrrReportThreshold != 0
+		smContext.UrrUpfMap[key] = urr
+	}
+	for _, tunnel := range []*GTPTunnel{psa.UpLinkTunnel, psa.DownLinkTunnel} {
+		if tunnel != nil && tunnel.PDR != nil {
+			tunnel.PDR.URR = append(tunnel.PDR.URR, urr)
+		}
+	}
+}
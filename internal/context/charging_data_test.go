@@ -0,0 +1,83 @@
+package context
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/util/idgenerator"
+)
+
+func TestNewChargingKey(t *testing.T) {
+	chgData := &models.ChargingData{
+		ChgId:        "ChgId-1",
+		RatingGroup:  10,
+		ServiceId:    100,
+		SponsorId:    "sponsor",
+		AppSvcProvId: "asp",
+	}
+	require.Equal(t, ChargingKey{RatingGroup: 10, ServiceID: 100}, NewChargingKey(chgData))
+
+	chgData.ReportingLevel = models.ReportingLevel_RAT_GR_LEVEL
+	require.Equal(t, ChargingKey{RatingGroup: 10}, NewChargingKey(chgData))
+
+	chgData.ReportingLevel = models.ReportingLevel_SPON_CON_LEVEL
+	require.Equal(t, ChargingKey{RatingGroup: 10, SponsorID: "sponsor", AppSvcProvID: "asp"},
+		NewChargingKey(chgData))
+}
+
+func TestAddChargingKeyUrr(t *testing.T) {
+	upf := NewUPF(mockIPv4NodeID, mockIfaces)
+	upf.UPFStatus = AssociatedSetUpSuccess
+	newDataPath := func() *DataPath {
+		return &DataPath{FirstDPNode: &DataPathNode{
+			UPF:            upf,
+			UpLinkTunnel:   &GTPTunnel{PDR: &PDR{}},
+			DownLinkTunnel: &GTPTunnel{PDR: &PDR{}},
+		}}
+	}
+	smContext := &SMContext{
+		UrrIDGenerator: idgenerator.NewGenerator(1, math.MaxUint32),
+		UrrUpfMap:      make(map[string]*URR),
+		UrrReportTime:  time.Minute,
+	}
+
+	video := &models.ChargingData{ChgId: "ChgId-1", RatingGroup: 10, ServiceId: 100,
+		MeteringMethod: models.MeteringMethod_DURATION_VOLUME}
+	dataPath1 := newDataPath()
+	dataPath1.addChargingKeyUrr(smContext, video)
+	urrs := dataPath1.FirstDPNode.UpLinkTunnel.PDR.URR
+	require.Len(t, urrs, 1)
+	require.Equal(t, urrs, dataPath1.FirstDPNode.DownLinkTunnel.PDR.URR)
+	require.Equal(t, MesureMethodVolTime, urrs[0].MeasureMethod)
+	require.True(t, urrs[0].ReportingTrigger.Perio)
+	require.False(t, urrs[0].ReportingTrigger.Volth)
+
+	// the PCC rules of the same charging key share the URR
+	dataPath2 := newDataPath()
+	dataPath2.addChargingKeyUrr(smContext, &models.ChargingData{ChgId: "ChgId-2", RatingGroup: 10, ServiceId: 100})
+	require.Same(t, urrs[0], dataPath2.FirstDPNode.UpLinkTunnel.PDR.URR[0])
+
+	dataPath3 := newDataPath()
+	dataPath3.addChargingKeyUrr(smContext, &models.ChargingData{ChgId: "ChgId-3", RatingGroup: 10, ServiceId: 200})
+	other := dataPath3.FirstDPNode.UpLinkTunnel.PDR.URR[0]
+	require.NotEqual(t, urrs[0].URRID, other.URRID)
+
+	key, ok := smContext.UrrChargingKey(other.URRID)
+	require.True(t, ok)
+	require.Equal(t, ChargingKey{RatingGroup: 10, ServiceID: 200}, key)
+	_, ok = smContext.UrrChargingKey(math.MaxUint32)
+	require.False(t, ok)
+
+	// the usage is recorded per service
+	smContext.AddUsageReport(UsageReport{UrrId: urrs[0].URRID, TotalVolume: 10})
+	smContext.AddUsageReport(UsageReport{UrrId: other.URRID, TotalVolume: 20})
+	record := smContext.ChargingRecord("", time.Now())
+	require.Len(t, record.Usages, 2)
+	require.Equal(t, uint32(100), record.Usages[0].ServiceID)
+	require.Equal(t, uint64(10), record.Usages[0].TotalVolume)
+	require.Equal(t, uint32(200), record.Usages[1].ServiceID)
+}
@@ -0,0 +1,139 @@
+package context
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+)
+
+func TestChargingQuotaGrant(t *testing.T) {
+	q := &ChargingQuota{RatingGroup: 1, URRID: 3}
+	q.Grant(&models.MultipleUnitInformation{
+		RatingGroup:          1,
+		GrantedUnit:          &models.GrantedUnit{TotalVolume: 1000, Time: 60},
+		VolumeQuotaThreshold: 200,
+		TimeQuotaThreshold:   10,
+		ValidityTime:         3600,
+	})
+	require.Equal(t, uint64(1000), q.VolumeQuota)
+	require.Equal(t, uint64(800), q.VolumeThreshold)
+	require.Equal(t, time.Minute, q.TimeQuota)
+	require.Equal(t, 50*time.Second, q.TimeThreshold)
+	require.Equal(t, time.Hour, q.ValidityTime)
+
+	urr := &URR{URRID: q.URRID, MeasureMethod: MesureMethodTime, MeasurementPeriod: time.Second}
+	q.setURR(urr)
+	require.Equal(t, MesureMethodVol, urr.MeasureMethod)
+	require.Zero(t, urr.MeasurementPeriod)
+	require.Equal(t, pfcpType.ReportingTriggers{
+		Volth: true, Volqu: true, Timth: true, Timqu: true, Quvti: true,
+	}, urr.ReportingTrigger)
+
+	// the final units without threshold
+	q.Grant(&models.MultipleUnitInformation{
+		RatingGroup: 1,
+		GrantedUnit: &models.GrantedUnit{TotalVolume: 500},
+		FinalUnitIndication: &models.FinalUnitIndication{
+			FinalUnitAction: models.FinalUnitAction_TERMINATE,
+		},
+	})
+	require.Zero(t, q.VolumeThreshold)
+	require.Zero(t, q.TimeQuota)
+	require.Equal(t, models.FinalUnitAction_TERMINATE, q.FinalUnitAction)
+	q.setURR(urr)
+	require.Equal(t, pfcpType.ReportingTriggers{Volqu: true}, urr.ReportingTrigger)
+}
+
+func TestChargingSessionUnitUsage(t *testing.T) {
+	origCharging := GetSelf().Charging
+	GetSelf().Charging = &OnlineCharging{RatingGroup: 1, RequestedVolume: 1000}
+	defer func() { GetSelf().Charging = origCharging }()
+
+	smContext := &SMContext{
+		ChargingSession: &ChargingSession{
+			Quotas: map[uint32]*ChargingQuota{
+				1: {RatingGroup: 1, URRID: 1},
+				2: {RatingGroup: 2, URRID: 2},
+			},
+		},
+	}
+	session := smContext.ChargingSession
+
+	unitUsages := session.UnitUsage(ChargingInitial)
+	require.Len(t, unitUsages, 2)
+	require.Equal(t, int32(1), unitUsages[0].RatingGroup)
+	require.Equal(t, int32(1000), unitUsages[0].RequestedUnit.TotalVolume)
+	require.Empty(t, unitUsages[0].UsedUnitContainer)
+
+	smContext.AddUsageReport(UsageReport{UrrId: 1, TotalVolume: 1000, UplinkVolume: 400, DownlinkVolume: 600,
+		ReportTpye: ChargingTriggerType(&pfcpType.UsageReportTrigger{Volqu: true})})
+	smContext.AddUsageReport(UsageReport{UrrId: 2, TotalVolume: 100, Duration: time.Minute})
+	require.Len(t, smContext.UrrReports, 2)
+	require.True(t, smContext.ChargingReportPending())
+
+	unitUsages = session.UnitUsage(ChargingUpdate)
+	require.Len(t, unitUsages, 2)
+	require.Equal(t, int32(1000), unitUsages[0].UsedUnitContainer[0].TotalVolume)
+	require.Equal(t, models.TriggerType_QUOTA_EXHAUSTED, unitUsages[0].UsedUnitContainer[0].Triggers[0].TriggerType)
+	require.NotNil(t, unitUsages[0].RequestedUnit)
+	// the usage without the quota used up is reported without requesting units
+	require.Equal(t, int32(60), unitUsages[1].UsedUnitContainer[0].Time)
+	require.Nil(t, unitUsages[1].RequestedUnit)
+	require.False(t, smContext.ChargingReportPending())
+
+	require.Empty(t, session.UnitUsage(ChargingRelease))
+}
+
+func TestUsedUnitContainersAboveInt32(t *testing.T) {
+	usage := ChargingUsage{
+		TotalVolume:    math.MaxInt32 + 100,
+		UplinkVolume:   100,
+		DownlinkVolume: math.MaxInt32,
+		Time:           time.Minute,
+		Triggers:       []models.TriggerType{models.TriggerType_QUOTA_THRESHOLD},
+	}
+	containers := usage.usedUnitContainers()
+	require.Len(t, containers, 2)
+	require.Equal(t, int32(math.MaxInt32), containers[0].TotalVolume)
+	require.Equal(t, int32(100), containers[0].UplinkVolume)
+	require.Equal(t, int32(math.MaxInt32), containers[0].DownlinkVolume)
+	require.Equal(t, int32(60), containers[0].Time)
+	require.Len(t, containers[0].Triggers, 1)
+	require.Equal(t, int32(100), containers[1].TotalVolume)
+	require.Zero(t, containers[1].UplinkVolume)
+	require.Zero(t, containers[1].DownlinkVolume)
+	require.Empty(t, containers[1].Triggers)
+
+	require.Nil(t, (&ChargingUsage{}).usedUnitContainers())
+}
+
+func TestPDUSessionChargingID(t *testing.T) {
+	smContext1, smContext2 := &SMContext{}, &SMContext{}
+	id := smContext1.PDUSessionChargingID()
+	require.NotZero(t, id)
+	require.Equal(t, id, smContext1.PDUSessionChargingID())
+	require.NotEqual(t, id, smContext2.PDUSessionChargingID())
+}
+
+func TestChargingTriggerType(t *testing.T) {
+	require.Equal(t, models.TriggerType_QUOTA_THRESHOLD,
+		ChargingTriggerType(&pfcpType.UsageReportTrigger{Timth: true}))
+	require.Equal(t, models.TriggerType_VALIDITY_TIME, ChargingTriggerType(&pfcpType.UsageReportTrigger{Quvti: true}))
+	require.Equal(t, models.TriggerType_FINAL, ChargingTriggerType(&pfcpType.UsageReportTrigger{Termr: true}))
+	require.Empty(t, ChargingTriggerType(&pfcpType.UsageReportTrigger{Perio: true}))
+	require.Empty(t, ChargingTriggerType(nil))
+}
+
+func TestRedirectInformation(t *testing.T) {
+	require.Nil(t, redirectInformation(nil))
+	info := redirectInformation(&models.RedirectServer{RedirectServerAddress: "http://topup.example.com"})
+	require.Equal(t, redirectAddressTypeURL, info.RedirectAddressType)
+	require.Equal(t, uint16(24), info.RedirectServerAddressLength)
+	info = redirectInformation(&models.RedirectServer{RedirectServerAddress: "2001:db8::1"})
+	require.Equal(t, redirectAddressTypeIPv6, info.RedirectAddressType)
+}
@@ -15,6 +15,8 @@ import (
 	"bitbucket.org/free5gc-team/openapi/Nudm_SubscriberDataManagement"
 	"bitbucket.org/free5gc-team/openapi/models"
 	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+	"bitbucket.org/free5gc-team/smf/internal/cdr"
+	"bitbucket.org/free5gc-team/smf/internal/dnaaa"
 	"bitbucket.org/free5gc-team/smf/internal/logger"
 	"bitbucket.org/free5gc-team/smf/pkg/factory"
 )
@@ -38,6 +40,8 @@ type SMFContext struct {
 	CPNodeID     pfcpType.NodeID
 	ExternalAddr string
 	ListenAddr   string
+	// ExternalAddrIPv6 is the IPv6 address of dual-stack N4
+	ExternalAddrIPv6 string
 
 	UDMProfile models.NfProfile
 
@@ -48,6 +52,7 @@ type SMFContext struct {
 	SnssaiInfos []*SnssaiSmfInfo
 
 	NrfUri                         string
+	NefUri                         string // NEF of PFDs, the PFDs of UE routing config are used if it's empty
 	NFManagementClient             *Nnrf_NFManagement.APIClient
 	NFDiscoveryClient              *Nnrf_NFDiscovery.APIClient
 	SubscriberDataManagementClient *Nudm_SubscriberDataManagement.APIClient
@@ -60,9 +65,12 @@ type SMFContext struct {
 	PFCPCancelFunc        context.CancelFunc
 	PfcpHeartbeatInterval time.Duration
 
-	// Now only "IPv4" supported
-	// TODO: support "IPv6", "IPv4v6", "Ethernet"
-	SupportedPDUSessionType string
+	// Ethernet PDU session is supported if any UPF has DNN with Ethernet PDU session type
+	EthernetSupport bool
+	// Online charging by CHF, nil if the PDU sessions aren't charged
+	Charging *OnlineCharging
+	// Offline charging records written by SMF, nil if they aren't written
+	Cdr *OfflineCharging
 
 	//*** For ULCL ** //
 	ULCLSupport         bool
@@ -70,6 +78,9 @@ type SMFContext struct {
 	UEPreConfigPathPool map[string]*UEPreConfigPaths
 	UEDefaultPathPool   map[string]*UEDefaultPaths
 	LocalSEIDCount      uint64
+	// ChargingIDCount starts from the start time of SMF in seconds,
+	// so the charging IDs aren't reused after SMF restarts
+	ChargingIDCount uint32
 }
 
 func ResolveIP(host string) net.IP {
@@ -88,6 +99,23 @@ func (s *SMFContext) ListenIP() net.IP {
 	return ResolveIP(s.ListenAddr)
 }
 
+// GetIPv4Uri returns the SBI URI advertised to the other NFs, which isn't the address the SBI server binds to
+func (s *SMFContext) GetIPv4Uri() string {
+	return fmt.Sprintf("%s://%s:%d", s.URIScheme, s.RegisterIPv4, s.SBIPort)
+}
+
+// DLBufferingInSMF reports whether the downlink data of any DNN is buffered in SMF, which needs N4-u
+func (s *SMFContext) DLBufferingInSMF() bool {
+	for _, snssaiInfo := range s.SnssaiInfos {
+		for _, dnnInfo := range snssaiInfo.DnnInfos {
+			if dnnInfo.Buffering.InSMF {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // RetrieveDnnInformation gets the corresponding dnn info from S-NSSAI and DNN
 func RetrieveDnnInformation(Snssai *models.Snssai, dnn string) *SnssaiSmfDnnInfo {
 	for _, snssaiInfo := range GetSelf().SnssaiInfos {
@@ -102,6 +130,15 @@ func AllocateLocalSEID() uint64 {
 	return atomic.AddUint64(&smfContext.LocalSEIDCount, 1)
 }
 
+// AllocateChargingID allocates the charging ID of a PDU session (TS 32.255 5.1.2.1.1), 0 isn't allocated
+func AllocateChargingID() uint32 {
+	for {
+		if id := atomic.AddUint32(&smfContext.ChargingIDCount, 1); id != 0 {
+			return id
+		}
+	}
+}
+
 func InitSmfContext(config *factory.Config) {
 	if config == nil {
 		logger.CtxLog.Error("Config is nil")
@@ -109,6 +146,7 @@ func InitSmfContext(config *factory.Config) {
 	}
 
 	logger.CtxLog.Infof("smfconfig Info: Version[%s] Description[%s]", config.Info.Version, config.Info.Description)
+	atomic.StoreUint32(&smfContext.ChargingIDCount, uint32(time.Now().Unix()))
 	configuration := config.Configuration
 	if configuration.SmfName != "" {
 		smfContext.Name = configuration.SmfName
@@ -153,9 +191,40 @@ func InitSmfContext(config *factory.Config) {
 		smfContext.NrfUri = fmt.Sprintf("%s://%s:%d", smfContext.URIScheme, "127.0.0.1", 29510)
 	}
 
+	smfContext.NefUri = configuration.NefUri
+
+	if charging := configuration.Charging; charging != nil {
+		smfContext.Charging = &OnlineCharging{
+			ChfUri:          charging.ChfUri,
+			RatingGroup:     charging.RatingGroup,
+			RequestedVolume: charging.RequestedVolume,
+			RequestedTime:   charging.RequestedTime,
+		}
+	}
+
+	if cdrConfig := configuration.Cdr; cdrConfig != nil {
+		writer, err := cdr.NewWriter(cdr.Config{
+			Directory:   cdrConfig.Directory,
+			Format:      cdrConfig.Format,
+			MaxFileSize: cdrConfig.MaxFileSize,
+			MaxFileAge:  cdrConfig.MaxFileAge,
+			MaxFiles:    cdrConfig.MaxFiles,
+		})
+		if err != nil {
+			logger.CtxLog.Errorf("CDR writer init failed, charging records aren't written: %+v", err)
+		} else {
+			smfContext.Cdr = &OfflineCharging{
+				Writer:          writer,
+				InterimInterval: cdrConfig.InterimInterval,
+				RatingGroup:     cdrConfig.RatingGroup,
+			}
+		}
+	}
+
 	if pfcp := configuration.PFCP; pfcp != nil {
 		smfContext.ListenAddr = pfcp.ListenAddr
 		smfContext.ExternalAddr = pfcp.ExternalAddr
+		smfContext.ExternalAddrIPv6 = pfcp.ExternalAddrIPv6
 
 		if ip := net.ParseIP(pfcp.NodeID); ip == nil {
 			smfContext.CPNodeID = pfcpType.NodeID{
@@ -210,6 +279,42 @@ func InitSmfContext(config *factory.Config) {
 			}
 			if dnnInfoConfig.PCSCF != nil {
 				dnnInfo.PCSCF.IPv4Addr = net.ParseIP(dnnInfoConfig.PCSCF.IPv4Addr).To4()
+				dnnInfo.PCSCF.IPv6Addr = net.ParseIP(dnnInfoConfig.PCSCF.IPv6Addr).To16()
+			}
+			if dnnInfoConfig.HomeRouted != nil {
+				dnnInfo.HSmfUri = dnnInfoConfig.HomeRouted.HSmfUri
+			}
+			if n6Tunnel := dnnInfoConfig.N6Tunnel; n6Tunnel != nil {
+				dnnInfo.N6Tunnel = &N6Tunnel{
+					ServerIP:   net.ParseIP(n6Tunnel.ServerIPv4).To4(),
+					ServerPort: uint16(n6Tunnel.ServerPort),
+					MTU:        n6Tunnel.MTU,
+				}
+				if dnnInfo.N6Tunnel.MTU == 0 {
+					dnnInfo.N6Tunnel.MTU = DefaultUnstructuredLinkMTU
+				}
+			}
+			if secondaryAuth := dnnInfoConfig.SecondaryAuth; secondaryAuth != nil {
+				switch secondaryAuth.Type {
+				case "radius":
+					nasIdentifier := secondaryAuth.Radius.NASIdentifier
+					if nasIdentifier == "" {
+						nasIdentifier = "SMF"
+					}
+					dnnInfo.DnAaa = dnaaa.NewRadiusClient(secondaryAuth.Radius.ServerAddr,
+						secondaryAuth.Radius.Secret, nasIdentifier)
+				}
+			}
+			dnnInfo.Buffering.SuggestedPacketsCount = DefaultSuggestedBufferingPacketsCount
+			if buffering := dnnInfoConfig.Buffering; buffering != nil {
+				dnnInfo.Buffering.InSMF = buffering.Location == "smf"
+				dnnInfo.Buffering.DDNDelay = buffering.DDNDelay
+				dnnInfo.Buffering.DDNThrottlingInterval = buffering.DDNThrottlingInterval
+				dnnInfo.Buffering.DDNFailureBackoff = buffering.DDNFailureBackoff
+				dnnInfo.Buffering.ExtendedBuffering = buffering.ExtendedBuffering
+				if buffering.SuggestedPacketsCount != 0 {
+					dnnInfo.Buffering.SuggestedPacketsCount = buffering.SuggestedPacketsCount
+				}
 			}
 			snssaiInfo.DnnInfos[dnnInfoConfig.Dnn] = &dnnInfo
 		}
@@ -227,16 +332,19 @@ func InitSmfContext(config *factory.Config) {
 
 	smfContext.ULCLSupport = configuration.ULCL
 
-	smfContext.SupportedPDUSessionType = "IPv4"
-
 	smfContext.UserPlaneInformation = NewUserPlaneInformation(&configuration.UserPlaneInformation)
 
+	smfContext.EthernetSupport = smfContext.UserPlaneInformation.SupportPDUSessionType(models.PduSessionType_ETHERNET)
+
 	SetupNFProfile(config)
 
 	smfContext.Locality = configuration.Locality
 }
 
 func InitSMFUERouting(routingConfig *factory.RoutingConfig) {
+	// PFDs are provisioned to UPFs when the PFCP associations are set up
+	GetPfdStore().Replace(PfdDatasFromConfig(routingConfig))
+
 	if !smfContext.ULCLSupport {
 		return
 	}
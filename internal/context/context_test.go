@@ -0,0 +1,20 @@
+package context
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+)
+
+func TestGetIPv4Uri(t *testing.T) {
+	s := &SMFContext{
+		URIScheme:    models.UriScheme_HTTPS,
+		BindingIPv4:  "0.0.0.0",
+		RegisterIPv4: "10.0.0.2",
+		SBIPort:      29502,
+	}
+	// the URI is reachable by the other NFs whatever address the SBI server binds to
+	require.Equal(t, "https://10.0.0.2:29502", s.GetIPv4Uri())
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/google/uuid"
 
+	"bitbucket.org/free5gc-team/nas/nasMessage"
 	"bitbucket.org/free5gc-team/openapi/models"
 	"bitbucket.org/free5gc-team/pfcp/pfcpType"
 	"bitbucket.org/free5gc-team/smf/internal/logger"
@@ -37,6 +38,8 @@ type GTPTunnel struct {
 
 	TEID uint32
 	PDR  *PDR
+	// TEIDChosen is set if the TEID is allocated by UPF with F-TEID CHOOSE instead of SMF
+	TEIDChosen bool
 }
 
 type DataPathNode struct {
@@ -143,10 +146,15 @@ func (node *DataPathNode) ActivateUpLinkTunnel(smContext *SMContext) error {
 		return err
 	}
 
-	if teid, err := destUPF.GenerateTEID(); err != nil {
+	if destUPF.AllocatesFTEID() {
+		// the TEID is set when UPF responds with the created PDR
+		node.UpLinkTunnel.TEIDChosen = true
+		node.UpLinkTunnel.TEID = 0
+	} else if teid, err := destUPF.GenerateTEID(); err != nil {
 		logger.CtxLog.Errorf("Generate uplink TEID fail: %s", err)
 		return err
 	} else {
+		node.UpLinkTunnel.TEIDChosen = false
 		node.UpLinkTunnel.TEID = teid
 	}
 
@@ -170,10 +178,15 @@ func (node *DataPathNode) ActivateDownLinkTunnel(smContext *SMContext) error {
 		return err
 	}
 
-	if teid, err := destUPF.GenerateTEID(); err != nil {
+	if destUPF.AllocatesFTEID() {
+		// the TEID is set when UPF responds with the created PDR
+		node.DownLinkTunnel.TEIDChosen = true
+		node.DownLinkTunnel.TEID = 0
+	} else if teid, err := destUPF.GenerateTEID(); err != nil {
 		logger.CtxLog.Errorf("Generate downlink TEID fail: %s", err)
 		return err
 	} else {
+		node.DownLinkTunnel.TEIDChosen = false
 		node.DownLinkTunnel.TEID = teid
 	}
 
@@ -214,8 +227,10 @@ func (node *DataPathNode) DeactivateUpLinkTunnel(smContext *SMContext) {
 		}
 	}
 
-	teid := node.UpLinkTunnel.TEID
-	node.UPF.teidGenerator.FreeID(int64(teid))
+	if !node.UpLinkTunnel.TEIDChosen {
+		teid := node.UpLinkTunnel.TEID
+		node.UPF.teidGenerator.FreeID(int64(teid))
+	}
 }
 
 func (node *DataPathNode) DeactivateDownLinkTunnel(smContext *SMContext) {
@@ -252,8 +267,10 @@ func (node *DataPathNode) DeactivateDownLinkTunnel(smContext *SMContext) {
 		}
 	}
 
-	teid := node.DownLinkTunnel.TEID
-	node.UPF.teidGenerator.FreeID(int64(teid))
+	if !node.DownLinkTunnel.TEIDChosen {
+		teid := node.DownLinkTunnel.TEID
+		node.UPF.teidGenerator.FreeID(int64(teid))
+	}
 }
 
 func (node *DataPathNode) GetUPFID() (id string, err error) {
@@ -426,8 +443,17 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 	} else {
 		logger.PduSessLog.Warn("No Create URR")
 	}
+	if smContext.SelectedPDUSessionType == nasMessage.PDUSessionTypeEthernet {
+		dataPath.addMACReportingUrr(smContext)
+	}
+	if smContext.ChargingSession != nil {
+		dataPath.addChargingUrr(smContext)
+	}
 
 	sessionRule := smContext.SelectedSessionRule()
+	if sessionRule != nil && sessionRule.RefUmData != "" {
+		dataPath.addUsageMonitoringUrr(smContext, sessionRule.RefUmData)
+	}
 
 	// Activate PDR
 	for curDataPathNode := firstDPNode; curDataPathNode != nil; curDataPathNode = curDataPathNode.Next() {
@@ -503,30 +529,24 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 				return
 			}
 
-			if upIP, err := iface.IP(smContext.SelectedPDUSessionType); err != nil {
+			if upIP, err := iface.TunnelIP(); err != nil {
 				logger.CtxLog.Errorln("ActivateTunnelAndPDR failed", err)
 				return
 			} else {
+				var chooseID uint8
+				if curDataPathNode.IsANUPF() {
+					chooseID = anULChooseID
+				}
 				ULPDR.PDI = PDI{
 					SourceInterface: pfcpType.SourceInterface{InterfaceValue: pfcpType.SourceInterfaceAccess},
-					LocalFTeid: &pfcpType.FTEID{
-						V4:          true,
-						Ipv4Address: upIP,
-						Teid:        curULTunnel.TEID,
-					},
+					LocalFTeid:      localFTEID(curULTunnel, upIP, chooseID),
 					NetworkInstance: &pfcpType.NetworkInstance{
 						NetworkInstance: smContext.Dnn,
 						FQDNEncoding:    factory.SmfConfig.Configuration.NwInstFqdnEncoding,
 					},
-					UEIPAddress: &pfcpType.UEIPAddress{
-						V4:          true,
-						Ipv4Address: smContext.PDUAddress.To4(),
-					},
+					UEIPAddress: smContext.UEIPAddressIE(false),
 				}
-			}
-
-			ULPDR.OuterHeaderRemoval = &pfcpType.OuterHeaderRemoval{
-				OuterHeaderRemovalDescription: pfcpType.OuterHeaderRemovalGtpUUdpIpv4,
+				ULPDR.OuterHeaderRemoval = gtpuOuterHeaderRemoval(upIP)
 			}
 
 			ULFAR := ULPDR.FAR
@@ -550,21 +570,25 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 			if curDataPathNode.IsAnchorUPF() {
 				ULFAR.ForwardingParameters.
 					DestinationInterface.InterfaceValue = pfcpType.DestinationInterfaceSgiLanN6Lan
+				if n6Tunnel := smContext.N6Tunnel(); n6Tunnel != nil {
+					// Unstructured data is sent to the application server by the N6 point-to-point tunnel
+					ULFAR.ForwardingParameters.OuterHeaderCreation = &pfcpType.OuterHeaderCreation{
+						OuterHeaderCreationDescription: pfcpType.OuterHeaderCreationUdpIpv4,
+						Ipv4Address:                    n6Tunnel.ServerIP,
+						PortNumber:                     n6Tunnel.ServerPort,
+					}
+				}
 			}
 
 			if nextULDest := curDataPathNode.Next(); nextULDest != nil {
 				nextULTunnel := nextULDest.UpLinkTunnel
 				iface = nextULTunnel.DestEndPoint.UPF.GetInterface(models.UpInterfaceType_N9, smContext.Dnn)
 
-				if upIP, err := iface.IP(smContext.SelectedPDUSessionType); err != nil {
+				if upIP, err := iface.TunnelIP(); err != nil {
 					logger.CtxLog.Errorln("ActivateTunnelAndPDR failed", err)
 					return
 				} else {
-					ULFAR.ForwardingParameters.OuterHeaderCreation = &pfcpType.OuterHeaderCreation{
-						OuterHeaderCreationDescription: pfcpType.OuterHeaderCreationGtpUUdpIpv4,
-						Ipv4Address:                    upIP,
-						Teid:                           nextULTunnel.TEID,
-					}
+					ULFAR.ForwardingParameters.OuterHeaderCreation = GTPUOuterHeaderCreation(upIP, nextULTunnel.TEID)
 				}
 			}
 		}
@@ -594,29 +618,25 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 						NetworkInstance: smContext.Dnn,
 						FQDNEncoding:    factory.SmfConfig.Configuration.NwInstFqdnEncoding,
 					},
-					UEIPAddress: &pfcpType.UEIPAddress{
-						V4:          true,
-						Sd:          true,
-						Ipv4Address: smContext.PDUAddress.To4(),
-					},
+					UEIPAddress:                   smContext.UEIPAddressIE(true),
+					EthernetPDUSessionInformation: smContext.SelectedPDUSessionType == nasMessage.PDUSessionTypeEthernet,
 				}
-			} else {
-				DLPDR.OuterHeaderRemoval = &pfcpType.OuterHeaderRemoval{
-					OuterHeaderRemovalDescription: pfcpType.OuterHeaderRemovalGtpUUdpIpv4,
+				if smContext.N6Tunnel() != nil {
+					// the downlink packets of N6 point-to-point tunnel are destined to the tunnel endpoint of UPF
+					DLPDR.OuterHeaderRemoval = &pfcpType.OuterHeaderRemoval{
+						OuterHeaderRemovalDescription: pfcpType.OuterHeaderRemovalUdpIpv4,
+					}
 				}
-
+			} else {
 				iface = DLDestUPF.GetInterface(models.UpInterfaceType_N9, smContext.Dnn)
-				if upIP, err := iface.IP(smContext.SelectedPDUSessionType); err != nil {
+				if upIP, err := iface.TunnelIP(); err != nil {
 					logger.CtxLog.Errorln("ActivateTunnelAndPDR failed", err)
 					return
 				} else {
+					DLPDR.OuterHeaderRemoval = gtpuOuterHeaderRemoval(upIP)
 					DLPDR.PDI = PDI{
 						SourceInterface: pfcpType.SourceInterface{InterfaceValue: pfcpType.SourceInterfaceCore},
-						LocalFTeid: &pfcpType.FTEID{
-							V4:          true,
-							Ipv4Address: upIP,
-							Teid:        curDLTunnel.TEID,
-						},
+						LocalFTeid:      localFTEID(curDLTunnel, upIP, 0),
 
 						// TODO: Should Uncomment this after FR5GC-1029 is solved
 						// UEIPAddress: &pfcpType.UEIPAddress{
@@ -645,17 +665,13 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 
 				iface = nextDLDest.UPF.GetInterface(models.UpInterfaceType_N9, smContext.Dnn)
 
-				if upIP, err := iface.IP(smContext.SelectedPDUSessionType); err != nil {
+				if upIP, err := iface.TunnelIP(); err != nil {
 					logger.CtxLog.Errorln("ActivateTunnelAndPDR failed", err)
 					return
 				} else {
 					DLFAR.ForwardingParameters = &ForwardingParameters{
 						DestinationInterface: pfcpType.DestinationInterface{InterfaceValue: pfcpType.DestinationInterfaceAccess},
-						OuterHeaderCreation: &pfcpType.OuterHeaderCreation{
-							OuterHeaderCreationDescription: pfcpType.OuterHeaderCreationGtpUUdpIpv4,
-							Ipv4Address:                    upIP,
-							Teid:                           nextDLTunnel.TEID,
-						},
+						OuterHeaderCreation:  GTPUOuterHeaderCreation(upIP, nextDLTunnel.TEID),
 					}
 				}
 			} else {
@@ -670,12 +686,8 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 						NetworkInstance: smContext.Dnn,
 						FQDNEncoding:    factory.SmfConfig.Configuration.NwInstFqdnEncoding,
 					}
-					DLFAR.ForwardingParameters.OuterHeaderCreation = new(pfcpType.OuterHeaderCreation)
-
-					dlOuterHeaderCreation := DLFAR.ForwardingParameters.OuterHeaderCreation
-					dlOuterHeaderCreation.OuterHeaderCreationDescription = pfcpType.OuterHeaderCreationGtpUUdpIpv4
-					dlOuterHeaderCreation.Teid = smContext.Tunnel.ANInformation.TEID
-					dlOuterHeaderCreation.Ipv4Address = smContext.Tunnel.ANInformation.IPAddress.To4()
+					DLFAR.ForwardingParameters.OuterHeaderCreation = GTPUOuterHeaderCreation(
+						anIP, smContext.Tunnel.ANInformation.TEID)
 				}
 			}
 		}
@@ -688,10 +700,7 @@ func (dataPath *DataPath) ActivateTunnelAndPDR(smContext *SMContext, precedence
 						NetworkInstance: smContext.Dnn,
 						FQDNEncoding:    factory.SmfConfig.Configuration.NwInstFqdnEncoding,
 					},
-					UEIPAddress: &pfcpType.UEIPAddress{
-						V4:          true,
-						Ipv4Address: smContext.PDUAddress.To4(),
-					},
+					UEIPAddress: smContext.UEIPAddressIE(false),
 				}
 			}
 		}
@@ -804,7 +813,16 @@ func (p *DataPath) UpdateFlowDescription(ulFlowDesc, dlFlowDesc string) {
 	}
 }
 
-func (p *DataPath) AddForwardingParameters(fwdPolicyID string, teid uint32) {
+func (p *DataPath) UpdateApplicationID(appID string) {
+	for curDPNode := p.FirstDPNode; curDPNode != nil; curDPNode = curDPNode.Next() {
+		curDPNode.DownLinkTunnel.PDR.PDI.SDFFilter = nil
+		curDPNode.DownLinkTunnel.PDR.PDI.ApplicationID = appID
+		curDPNode.UpLinkTunnel.PDR.PDI.SDFFilter = nil
+		curDPNode.UpLinkTunnel.PDR.PDI.ApplicationID = appID
+	}
+}
+
+func (p *DataPath) AddForwardingParameters(fwdPolicyID string, anFTEID *pfcpType.FTEID) {
 	for curDPNode := p.FirstDPNode; curDPNode != nil; curDPNode = curDPNode.Next() {
 		if curDPNode.IsAnchorUPF() {
 			curDPNode.UpLinkTunnel.PDR.FAR.ForwardingParameters.ForwardingPolicyID = fwdPolicyID
@@ -822,11 +840,24 @@ func (p *DataPath) AddForwardingParameters(fwdPolicyID string, teid uint32) {
 		// get old TEID
 		// TODO: remove this if RAN tunnel issue is fixed, because the AN tunnel is only one
 		if curDPNode.IsANUPF() {
-			curDPNode.UpLinkTunnel.PDR.PDI.LocalFTeid.Teid = teid
+			fteid := *anFTEID
+			curDPNode.UpLinkTunnel.PDR.PDI.LocalFTeid = &fteid
 		}
 	}
 }
 
+// PSA returns the last node of the data path, which is the PDU session anchor
+func (dataPath *DataPath) PSA() *DataPathNode {
+	if dataPath == nil {
+		return nil
+	}
+	var psa *DataPathNode
+	for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+		psa = node
+	}
+	return psa
+}
+
 func (dataPath *DataPath) CopyFirstDPNode() *DataPathNode {
 	if dataPath.FirstDPNode == nil {
 		return nil
@@ -0,0 +1,285 @@
+package context
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+	"bitbucket.org/free5gc-team/util/idgenerator"
+)
+
+// ddnDelayUnit is the unit of Downlink Data Notification Delay (TS 29.244 8.2.28)
+const ddnDelayUnit = 50 * time.Millisecond
+
+var (
+	// dlBufferTEIDGenerator allocates the TEIDs of the N4-u tunnels toward SMF,
+	// which identify the PDU sessions of the downlink data buffered in SMF
+	dlBufferTEIDGenerator = idgenerator.NewGenerator(1, math.MaxUint32)
+	dlBufferTEIDMap       sync.Map
+)
+
+// BufferingPolicy returns the downlink data buffering policy of the DNN of the PDU session
+func (smContext *SMContext) BufferingPolicy() BufferingPolicy {
+	if smContext.DNNInfo == nil {
+		return BufferingPolicy{SuggestedPacketsCount: DefaultSuggestedBufferingPacketsCount}
+	}
+	return smContext.DNNInfo.Buffering
+}
+
+// ddnDelayValue returns the delay in multiples of 50ms, the configured delay is at most 255 multiples
+func (p BufferingPolicy) ddnDelayValue() uint8 {
+	return uint8(p.DDNDelay / ddnDelayUnit)
+}
+
+// NewBAR adds a BAR on UPF for the downlink data buffered in UPF by the buffering policy of the PDU session,
+// the Downlink Data Notification Delay is sent to UPF supporting DDND only and the suggested buffering
+// packets count to UPF supporting UDBC only
+func (smContext *SMContext) NewBAR(upf *UPF) (*BAR, error) {
+	bar, err := upf.AddBAR()
+	if err != nil {
+		return nil, err
+	}
+	policy := smContext.BufferingPolicy()
+	if upf.SupportsUPFeature(UPFeatureDDND) {
+		bar.DownlinkDataNotificationDelay.DelayValue = policy.ddnDelayValue()
+	}
+	if upf.SupportsUPFeature(UPFeatureUDBC) {
+		bar.SuggestedBufferingPacketsCount.PacketCountValue = policy.SuggestedPacketsCount
+	}
+	return bar, nil
+}
+
+// BuffersInSMF reports whether the downlink data of the PDU session is buffered in SMF,
+// AN UPF must support BUCP to forward it to SMF, otherwise it is buffered in UPF
+func (smContext *SMContext) BuffersInSMF(anUPF *UPF) bool {
+	return smContext.BufferingPolicy().InSMF && anUPF.SupportsUPFeature(UPFeatureBUCP)
+}
+
+// ThrottleDDN reports whether the paging for the downlink data is throttled by the buffering policy
+// or suppressed after UE isn't reached, the time of the paging is recorded if it is not
+func (smContext *SMContext) ThrottleDDN(now time.Time) bool {
+	if now.Before(smContext.ddnSuppressedUntil) {
+		return true
+	}
+	interval := smContext.BufferingPolicy().DDNThrottlingInterval
+	if interval > 0 && !smContext.lastDDNTime.IsZero() && now.Sub(smContext.lastDDNTime) < interval {
+		return true
+	}
+	smContext.lastDDNTime = now
+	return false
+}
+
+// SuppressDDN suppresses the pagings for the downlink data until the time,
+// e.g. the back-off after UE isn't reached or the waiting time of the extended buffering
+func (smContext *SMContext) SuppressDDN(until time.Time) {
+	smContext.ddnSuppressedUntil = until
+}
+
+// DLDataHandling is the handling of the downlink data buffered in UPF decided on the result of the paging
+type DLDataHandling struct {
+	// Throttled is set if the paging isn't sent as it's throttled or suppressed
+	Throttled bool
+	// DropBuffered discards the buffered downlink data as UE isn't reached
+	DropBuffered bool
+	// ExtendedBuffering is the duration to buffer the downlink data for UE in MICO mode or extended DRX,
+	// 0 if the buffering isn't extended
+	ExtendedBuffering time.Duration
+	// BAR of the DL FAR on UPF updated with the extended buffering duration
+	BAR *BAR
+}
+
+// DLBAR returns the BAR of the DL FAR of the default path on AN UPF, nil if UPF isn't AN UPF or has no BAR
+func (smContext *SMContext) DLBAR(upf *UPF) *BAR {
+	if smContext.Tunnel == nil {
+		return nil
+	}
+	defaultPath := smContext.Tunnel.DataPathPool.GetDefaultPath()
+	if defaultPath == nil || defaultPath.FirstDPNode == nil || defaultPath.FirstDPNode.UPF != upf {
+		return nil
+	}
+	if tunnel := defaultPath.FirstDPNode.DownLinkTunnel; tunnel != nil && tunnel.PDR != nil && tunnel.PDR.FAR != nil {
+		return tunnel.PDR.FAR.BAR
+	}
+	return nil
+}
+
+// PagingQoS returns the 5QI and ARP of the QoS flow of the downlink data for AMF to derive the paging priority,
+// the default QoS of the PDU session is used if the QoS flow is unknown
+func (smContext *SMContext) PagingQoS(qfi uint8) (int32, *models.Arp) {
+	if qosFlow, ok := smContext.AdditonalQosFlows[qfi]; ok && qosFlow.QoSProfile != nil {
+		return qosFlow.QoSProfile.Var5qi, qosFlow.QoSProfile.Arp
+	}
+	if sessRule := smContext.SelectedSessionRule(); sessRule != nil && sessRule.AuthDefQos != nil {
+		return sessRule.AuthDefQos.Var5qi, sessRule.AuthDefQos.Arp
+	}
+	return 0, nil
+}
+
+// DLBuffer is the downlink data of a PDU session buffered in SMF. AN UPF forwards the packets to SMF over N4-u
+// while the UP connection is deactivated, and SMF sends them back to AN UPF when it's activated
+type DLBuffer struct {
+	// TEID is the N4-u tunnel toward SMF, which identifies the PDU session of the forwarded packets
+	TEID uint32
+	// PDR of AN UPF receives the packets sent back by SMF, it shares the DL FAR of the default path
+	UPF *UPF
+	PDR *PDR
+
+	// upfTEID is the TEID of PDR allocated by SMF, 0 if it's allocated by UPF
+	upfTEID uint32
+
+	mu      sync.Mutex
+	packets [][]byte
+	limit   int
+	// paged is set when the paging for the buffered packets is sent
+	paged bool
+}
+
+// Push buffers the packet and reports whether the paging is to be triggered as it isn't sent for the
+// buffered packets yet, the packets beyond the suggested buffering packets count are dropped
+func (b *DLBuffer) Push(packet []byte) (page, buffered bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.packets) >= b.limit {
+		return !b.paged, false
+	}
+	b.packets = append(b.packets, packet)
+	return !b.paged, true
+}
+
+// SetPaged records that the paging for the buffered packets is sent, the paging which is throttled
+// isn't recorded and the following packet triggers it again
+func (b *DLBuffer) SetPaged() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.paged = true
+}
+
+// Drain returns the buffered packets and empties the buffer, the following packet triggers the paging
+func (b *DLBuffer) Drain() [][]byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	packets := b.packets
+	b.packets = nil
+	b.paged = false
+	return packets
+}
+
+// SetupDLBuffer points the DL FAR of AN UPF of the data path to SMF to buffer the downlink data in SMF.
+// It returns the PDR receiving the packets sent back by SMF if it's to be created or updated on AN UPF
+func (smContext *SMContext) SetupDLBuffer(dataPath *DataPath) (*PDR, error) {
+	anUPF := dataPath.FirstDPNode.UPF
+	dlPDR := dataPath.FirstDPNode.DownLinkTunnel.PDR
+
+	var pdr *PDR
+	if b := smContext.DLBuffer; b == nil || b.UPF != anUPF {
+		smContext.ReleaseDLBuffer()
+		b, err := newDLBuffer(anUPF, dlPDR, smContext.BufferingPolicy().SuggestedPacketsCount)
+		if err != nil {
+			return nil, err
+		}
+		dlBufferTEIDMap.Store(b.TEID, smContext)
+		smContext.DLBuffer = b
+		pdr = b.PDR
+	} else if dataPath.IsDefaultPath && b.PDR.FAR != dlPDR.FAR {
+		b.PDR.FAR = dlPDR.FAR
+		b.PDR.State = RULE_UPDATE
+		pdr = b.PDR
+	}
+
+	dlPDR.FAR.ApplyAction = pfcpType.ApplyAction{Forw: true}
+	dlPDR.FAR.ForwardingParameters = &ForwardingParameters{
+		DestinationInterface: pfcpType.DestinationInterface{
+			InterfaceValue: pfcpType.DestinationInterfaceCpFunction,
+		},
+		OuterHeaderCreation: GTPUOuterHeaderCreation(
+			GetSelf().N4IP(anUPF.NodeID.ResolveNodeIdToIp()), smContext.DLBuffer.TEID),
+	}
+	return pdr, nil
+}
+
+func newDLBuffer(upf *UPF, dlPDR *PDR, limit uint8) (*DLBuffer, error) {
+	teid, err := dlBufferTEIDGenerator.Allocate()
+	if err != nil {
+		return nil, err
+	}
+	b := &DLBuffer{TEID: uint32(teid), UPF: upf, limit: int(limit)}
+
+	if len(upf.N3Interfaces) == 0 {
+		dlBufferTEIDGenerator.FreeID(teid)
+		return nil, fmt.Errorf("No N3 interface of UPF")
+	}
+	upIP, err := upf.N3Interfaces[0].TunnelIP()
+	if err != nil {
+		dlBufferTEIDGenerator.FreeID(teid)
+		return nil, err
+	}
+	fteid := chooseFTEID(upIP, 0)
+	if !upf.AllocatesFTEID() {
+		if b.upfTEID, err = upf.GenerateTEID(); err != nil {
+			dlBufferTEIDGenerator.FreeID(teid)
+			return nil, err
+		}
+		fteid = newFTEID(upIP, b.upfTEID)
+	}
+
+	freeTEIDs := func() {
+		dlBufferTEIDGenerator.FreeID(teid)
+		if b.upfTEID != 0 {
+			upf.teidGenerator.FreeID(int64(b.upfTEID))
+		}
+	}
+	b.PDR, err = upf.AddPDR()
+	if err != nil {
+		freeTEIDs()
+		return nil, err
+	}
+	// the packets are forwarded by the DL FAR, so the FAR allocated with the PDR isn't needed
+	if err = upf.RemoveFAR(b.PDR.FAR); err != nil {
+		freeTEIDs()
+		if errPDR := upf.RemovePDR(b.PDR); errPDR != nil {
+			logger.CtxLog.Warnf("Remove PDR of DL buffer failed: %+v", errPDR)
+		}
+		return nil, err
+	}
+	b.PDR.FAR = dlPDR.FAR
+	b.PDR.Precedence = dlPDR.Precedence
+	b.PDR.PDI = PDI{
+		SourceInterface: pfcpType.SourceInterface{InterfaceValue: pfcpType.SourceInterfaceCpFunction},
+		LocalFTeid:      fteid,
+		NetworkInstance: dlPDR.PDI.NetworkInstance,
+	}
+	b.PDR.OuterHeaderRemoval = gtpuOuterHeaderRemoval(upIP)
+	return b, nil
+}
+
+// ReleaseDLBuffer drops the packets buffered in SMF and frees the N4-u tunnel and the PDR on AN UPF
+func (smContext *SMContext) ReleaseDLBuffer() {
+	b := smContext.DLBuffer
+	if b == nil {
+		return
+	}
+	smContext.DLBuffer = nil
+	dlBufferTEIDMap.Delete(b.TEID)
+	dlBufferTEIDGenerator.FreeID(int64(b.TEID))
+	if b.upfTEID != 0 {
+		b.UPF.teidGenerator.FreeID(int64(b.upfTEID))
+	}
+	if err := b.UPF.RemovePDR(b.PDR); err != nil {
+		smContext.Log.Warnf("Remove PDR of DL buffer failed: %+v", err)
+	}
+}
+
+// GetSMContextByDLBufferTEID returns the PDU session of the downlink data forwarded to SMF over N4-u
+func GetSMContextByDLBufferTEID(teid uint32) *SMContext {
+	if value, ok := dlBufferTEIDMap.Load(teid); ok {
+		return value.(*SMContext)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package context
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+)
+
+func TestThrottleDDN(t *testing.T) {
+	smContext := &SMContext{
+		DNNInfo: &SnssaiSmfDnnInfo{
+			Buffering: BufferingPolicy{DDNThrottlingInterval: 10 * time.Second},
+		},
+	}
+	now := time.Now()
+	require.False(t, smContext.ThrottleDDN(now))
+	require.True(t, smContext.ThrottleDDN(now.Add(5*time.Second)))
+	require.False(t, smContext.ThrottleDDN(now.Add(10*time.Second)))
+
+	smContext.DNNInfo.Buffering.DDNThrottlingInterval = 0
+	require.False(t, smContext.ThrottleDDN(now.Add(11*time.Second)))
+}
+
+func TestPagingQoS(t *testing.T) {
+	smContext := &SMContext{
+		SessionRules: map[string]*SessionRule{
+			"SessRuleId-1": {
+				SessionRule: &models.SessionRule{
+					AuthDefQos: &models.AuthorizedDefaultQos{
+						Var5qi: 9,
+						Arp:    &models.Arp{PriorityLevel: 8},
+					},
+				},
+				DefQosQFI: 1,
+			},
+		},
+		SelectedSessionRuleID: "SessRuleId-1",
+		AdditonalQosFlows: map[uint8]*QoSFlow{
+			2: {QFI: 2, QoSProfile: &models.QosData{Var5qi: 5, Arp: &models.Arp{PriorityLevel: 1}}},
+		},
+	}
+
+	var5qi, arp := smContext.PagingQoS(2)
+	require.Equal(t, int32(5), var5qi)
+	require.Equal(t, int32(1), arp.PriorityLevel)
+
+	var5qi, arp = smContext.PagingQoS(0)
+	require.Equal(t, int32(9), var5qi)
+	require.Equal(t, int32(8), arp.PriorityLevel)
+}
+
+func TestDLBuffer(t *testing.T) {
+	upf := NewUPF(mockIPv4NodeID, mockIfaces)
+	upf.UPFStatus = AssociatedSetUpSuccess
+	upf.UPFunctionFeatures = &pfcpType.UPFunctionFeatures{
+		SupportedFeatures: 1<<UPFeatureBUCP | 1<<UPFeatureDDND | 1<<UPFeatureUDBC,
+	}
+
+	dlPDR, err := upf.AddPDR()
+	require.NoError(t, err)
+	anNode := &DataPathNode{UPF: upf, DownLinkTunnel: &GTPTunnel{PDR: dlPDR}}
+	dataPath := &DataPath{IsDefaultPath: true, FirstDPNode: anNode}
+	smContext := &SMContext{
+		DNNInfo: &SnssaiSmfDnnInfo{
+			Buffering: BufferingPolicy{InSMF: true, DDNDelay: 120 * time.Millisecond, SuggestedPacketsCount: 2},
+		},
+	}
+	require.True(t, smContext.BuffersInSMF(upf))
+
+	bar, err := smContext.NewBAR(upf)
+	require.NoError(t, err)
+	require.Equal(t, uint8(2), bar.DownlinkDataNotificationDelay.DelayValue)
+	require.Equal(t, uint8(2), bar.SuggestedBufferingPacketsCount.PacketCountValue)
+
+	// the BAR is created on UPF without DDND and UDBC, without the delay and the count
+	upf.UPFunctionFeatures.SupportedFeatures = 1 << UPFeatureBUCP
+	bar, err = smContext.NewBAR(upf)
+	require.NoError(t, err)
+	require.Zero(t, bar.DownlinkDataNotificationDelay.DelayValue)
+	require.Zero(t, bar.SuggestedBufferingPacketsCount.PacketCountValue)
+	upf.UPFunctionFeatures.SupportedFeatures = 1<<UPFeatureBUCP | 1<<UPFeatureDDND | 1<<UPFeatureUDBC
+
+	pdr, err := smContext.SetupDLBuffer(dataPath)
+	require.NoError(t, err)
+	require.NotNil(t, pdr)
+	require.Equal(t, dlPDR.FAR, pdr.FAR)
+	require.Equal(t, pfcpType.SourceInterfaceCpFunction, pdr.PDI.SourceInterface.InterfaceValue)
+	require.Equal(t, pfcpType.DestinationInterfaceCpFunction,
+		dlPDR.FAR.ForwardingParameters.DestinationInterface.InterfaceValue)
+	b := smContext.DLBuffer
+	require.Equal(t, b.TEID, dlPDR.FAR.ForwardingParameters.OuterHeaderCreation.Teid)
+	require.Equal(t, smContext, GetSMContextByDLBufferTEID(b.TEID))
+
+	// the PDR is created once for the following deactivations
+	pdr, err = smContext.SetupDLBuffer(dataPath)
+	require.NoError(t, err)
+	require.Nil(t, pdr)
+
+	page, buffered := b.Push([]byte{1})
+	require.True(t, page)
+	require.True(t, buffered)
+	// the paging throttled is triggered by the following packet
+	page, buffered = b.Push([]byte{2})
+	require.True(t, page)
+	require.True(t, buffered)
+	b.SetPaged()
+	page, buffered = b.Push([]byte{3})
+	require.False(t, page)
+	require.False(t, buffered)
+	require.Equal(t, [][]byte{{1}, {2}}, b.Drain())
+	require.Empty(t, b.Drain())
+	page, _ = b.Push([]byte{4})
+	require.True(t, page)
+
+	smContext.ReleaseDLBuffer()
+	require.Nil(t, smContext.DLBuffer)
+	require.Nil(t, GetSMContextByDLBufferTEID(b.TEID))
+}
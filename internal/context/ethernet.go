@@ -0,0 +1,366 @@
+package context
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+
+	"bitbucket.org/free5gc-team/nas/nasType"
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+)
+
+// VlanTag presents the tag control information of a C-TAG or S-TAG (IEEE 802.1Q)
+type VlanTag struct {
+	PCP uint8
+	DEI bool
+	VID uint16
+}
+
+// EthernetPacketFilter is the Ethernet packet filter of PDI and QoS rule of Ethernet PDU session,
+// TS 29.244 8.2.44 and TS 24.501 9.11.4.13
+type EthernetPacketFilter struct {
+	SourceMAC      net.HardwareAddr
+	DestinationMAC net.HardwareAddr
+	// EtherType is 0 if any EtherType is matched
+	EtherType uint16
+	CTag      *VlanTag
+	STag      *VlanTag
+}
+
+// NewEthernetPacketFilter parses the Ethernet flow description provided by PCF (TS 29.514 5.6.2.17),
+// the first VLAN tag is the C-TAG and the second one is the S-TAG
+func NewEthernetPacketFilter(desc *models.EthFlowDescription) (*EthernetPacketFilter, error) {
+	if desc == nil {
+		return nil, fmt.Errorf("no Ethernet flow description")
+	}
+	var err error
+	filter := new(EthernetPacketFilter)
+	if desc.SourceMacAddr != "" {
+		if filter.SourceMAC, err = net.ParseMAC(desc.SourceMacAddr); err != nil {
+			return nil, fmt.Errorf("parse source MAC address fail: %s", err)
+		}
+	}
+	if desc.DestMacAddr != "" {
+		if filter.DestinationMAC, err = net.ParseMAC(desc.DestMacAddr); err != nil {
+			return nil, fmt.Errorf("parse destination MAC address fail: %s", err)
+		}
+	}
+	if desc.EthType != "" {
+		etherType, parseErr := strconv.ParseUint(desc.EthType, 16, 16)
+		if parseErr != nil {
+			return nil, fmt.Errorf("parse EtherType fail: %s", parseErr)
+		}
+		filter.EtherType = uint16(etherType)
+	}
+	if len(desc.VlanTags) > 2 {
+		return nil, fmt.Errorf("too many VLAN tags: %d", len(desc.VlanTags))
+	}
+	for i, tag := range desc.VlanTags {
+		tci, parseErr := strconv.ParseUint(tag, 16, 16)
+		if parseErr != nil {
+			return nil, fmt.Errorf("parse VLAN tag fail: %s", parseErr)
+		}
+		vlanTag := &VlanTag{
+			PCP: uint8(tci >> 13),
+			DEI: tci&0x1000 != 0,
+			VID: uint16(tci & 0x0fff),
+		}
+		if i == 0 {
+			filter.CTag = vlanTag
+		} else {
+			filter.STag = vlanTag
+		}
+	}
+	return filter, nil
+}
+
+// Swapped returns the filter of the reverse direction, e.g. the uplink filter of a downlink filter
+func (f *EthernetPacketFilter) Swapped() *EthernetPacketFilter {
+	swapped := *f
+	swapped.SourceMAC, swapped.DestinationMAC = f.DestinationMAC, f.SourceMAC
+	return &swapped
+}
+
+// NASComponents returns the packet filter components of the QoS rule, TS 24.501 Table 9.11.4.13.1
+func (f *EthernetPacketFilter) NASComponents() nasType.PacketFilterComponentList {
+	components := make(nasType.PacketFilterComponentList, 0)
+	if f.DestinationMAC != nil {
+		components = append(components, &packetFilterMACAddress{
+			componentType: packetFilterComponentTypeDestinationMACAddress,
+			Address:       f.DestinationMAC,
+		})
+	}
+	if f.SourceMAC != nil {
+		components = append(components, &packetFilterMACAddress{
+			componentType: packetFilterComponentTypeSourceMACAddress,
+			Address:       f.SourceMAC,
+		})
+	}
+	if f.CTag != nil {
+		components = append(components,
+			&packetFilterVID{componentType: packetFilterComponentTypeCTagVID, VID: f.CTag.VID},
+			&packetFilterPCPDEI{componentType: packetFilterComponentTypeCTagPCPDEI, PCP: f.CTag.PCP, DEI: f.CTag.DEI})
+	}
+	if f.STag != nil {
+		components = append(components,
+			&packetFilterVID{componentType: packetFilterComponentTypeSTagVID, VID: f.STag.VID},
+			&packetFilterPCPDEI{componentType: packetFilterComponentTypeSTagPCPDEI, PCP: f.STag.PCP, DEI: f.STag.DEI})
+	}
+	if f.EtherType != 0 {
+		components = append(components, &packetFilterEtherType{Value: f.EtherType})
+	}
+	if len(components) == 0 {
+		components = append(components, &nasType.PacketFilterMatchAll{})
+	}
+	return components
+}
+
+// packet filter component types of Ethernet PDU session, TS 24.501 Table 9.11.4.13.1
+const (
+	packetFilterComponentTypeDestinationMACAddress nasType.PacketFilterComponentType = 0x81
+	packetFilterComponentTypeSourceMACAddress      nasType.PacketFilterComponentType = 0x82
+	packetFilterComponentTypeCTagVID               nasType.PacketFilterComponentType = 0x83
+	packetFilterComponentTypeSTagVID               nasType.PacketFilterComponentType = 0x84
+	packetFilterComponentTypeCTagPCPDEI            nasType.PacketFilterComponentType = 0x85
+	packetFilterComponentTypeSTagPCPDEI            nasType.PacketFilterComponentType = 0x86
+	packetFilterComponentTypeEtherType             nasType.PacketFilterComponentType = 0x87
+)
+
+type packetFilterMACAddress struct {
+	componentType nasType.PacketFilterComponentType
+	Address       net.HardwareAddr
+}
+
+func (p *packetFilterMACAddress) Type() nasType.PacketFilterComponentType {
+	return p.componentType
+}
+
+func (p *packetFilterMACAddress) MarshalBinary() ([]byte, error) {
+	if len(p.Address) != 6 {
+		return nil, fmt.Errorf("invalid MAC address length: %d", len(p.Address))
+	}
+	return append([]byte{uint8(p.componentType)}, p.Address...), nil
+}
+
+func (p *packetFilterMACAddress) UnmarshalBinary(b []byte) error {
+	if len(b) != 7 {
+		return fmt.Errorf("invalid MAC address component length: %d", len(b))
+	}
+	p.componentType = nasType.PacketFilterComponentType(b[0])
+	p.Address = net.HardwareAddr(append([]byte{}, b[1:]...))
+	return nil
+}
+
+type packetFilterVID struct {
+	componentType nasType.PacketFilterComponentType
+	VID           uint16
+}
+
+func (p *packetFilterVID) Type() nasType.PacketFilterComponentType {
+	return p.componentType
+}
+
+func (p *packetFilterVID) MarshalBinary() ([]byte, error) {
+	b := []byte{uint8(p.componentType), 0, 0}
+	binary.BigEndian.PutUint16(b[1:], p.VID&0x0fff)
+	return b, nil
+}
+
+func (p *packetFilterVID) UnmarshalBinary(b []byte) error {
+	if len(b) != 3 {
+		return fmt.Errorf("invalid VID component length: %d", len(b))
+	}
+	p.componentType = nasType.PacketFilterComponentType(b[0])
+	p.VID = binary.BigEndian.Uint16(b[1:]) & 0x0fff
+	return nil
+}
+
+type packetFilterPCPDEI struct {
+	componentType nasType.PacketFilterComponentType
+	PCP           uint8
+	DEI           bool
+}
+
+func (p *packetFilterPCPDEI) Type() nasType.PacketFilterComponentType {
+	return p.componentType
+}
+
+func (p *packetFilterPCPDEI) MarshalBinary() ([]byte, error) {
+	v := (p.PCP & 0x07) << 1
+	if p.DEI {
+		v |= 0x01
+	}
+	return []byte{uint8(p.componentType), v}, nil
+}
+
+func (p *packetFilterPCPDEI) UnmarshalBinary(b []byte) error {
+	if len(b) != 2 {
+		return fmt.Errorf("invalid PCP/DEI component length: %d", len(b))
+	}
+	p.componentType = nasType.PacketFilterComponentType(b[0])
+	p.PCP = (b[1] >> 1) & 0x07
+	p.DEI = b[1]&0x01 != 0
+	return nil
+}
+
+type packetFilterEtherType struct {
+	Value uint16
+}
+
+func (p *packetFilterEtherType) Type() nasType.PacketFilterComponentType {
+	return packetFilterComponentTypeEtherType
+}
+
+func (p *packetFilterEtherType) MarshalBinary() ([]byte, error) {
+	b := []byte{uint8(packetFilterComponentTypeEtherType), 0, 0}
+	binary.BigEndian.PutUint16(b[1:], p.Value)
+	return b, nil
+}
+
+func (p *packetFilterEtherType) UnmarshalBinary(b []byte) error {
+	if len(b) != 3 {
+		return fmt.Errorf("invalid EtherType component length: %d", len(b))
+	}
+	p.Value = binary.BigEndian.Uint16(b[1:])
+	return nil
+}
+
+// NewMACAddressReporting makes the URR only report the MAC addresses detected or removed by UPF,
+// TS 29.244 5.13.5
+func NewMACAddressReporting() UrrOpt {
+	return func(urr *URR) {
+		urr.MeasureMethod = ""
+		urr.MeasurementInformation = pfcpType.MeasurementInformation{}
+		urr.ReportingTrigger = pfcpType.ReportingTriggers{Macar: true}
+	}
+}
+
+// addMACReportingUrr adds the URR for MAC address reporting to the uplink PDR of PSA,
+// the source MAC addresses of the uplink traffic are the MAC addresses behind UE
+func (dataPath *DataPath) addMACReportingUrr(smContext *SMContext) {
+	var psa *DataPathNode
+	for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+		psa = node
+	}
+	if psa == nil || psa.UpLinkTunnel == nil || psa.UpLinkTunnel.PDR == nil {
+		return
+	}
+
+	urrId, ok := smContext.UrrIdMap[N6_MACAR_URR]
+	if !ok {
+		id, err := smContext.UrrIDGenerator.Allocate()
+		if err != nil {
+			logger.PduSessLog.Errorln("allocate MAC address reporting URR ID failed:", err)
+			return
+		}
+		urrId = uint32(id)
+		smContext.UrrIdMap[N6_MACAR_URR] = urrId
+	}
+
+	key := getUrrIdKey(psa.UPF.UUID(), urrId)
+	urr, ok := smContext.UrrUpfMap[key]
+	if !ok {
+		var err error
+		if urr, err = psa.UPF.AddURR(urrId, NewMACAddressReporting()); err != nil {
+			logger.PduSessLog.Errorln("new MAC address reporting URR failed:", err)
+			return
+		}
+		smContext.UrrUpfMap[key] = urr
+	}
+	psa.UpLinkTunnel.PDR.URR = append(psa.UpLinkTunnel.PDR.URR, urr)
+}
+
+// NewEthernetPacketFilters parses the Ethernet flow descriptions of the flow information into the uplink
+// and downlink packet filters. A bidirectional flow description is in the downlink direction and it's
+// swapped for the uplink, the filter of a direction is nil if no flow description covers it
+func NewEthernetPacketFilters(flowInfos []models.FlowInformation) (ulFilter, dlFilter *EthernetPacketFilter,
+	err error,
+) {
+	for i := range flowInfos {
+		flowInfo := &flowInfos[i]
+		if flowInfo.EthFlowDescription == nil {
+			continue
+		}
+		filter, parseErr := NewEthernetPacketFilter(flowInfo.EthFlowDescription)
+		if parseErr != nil {
+			return nil, nil, parseErr
+		}
+		switch flowInfo.FlowDirection {
+		case models.FlowDirectionRm_UPLINK:
+			if ulFilter == nil {
+				ulFilter = filter
+			}
+		case models.FlowDirectionRm_DOWNLINK:
+			if dlFilter == nil {
+				dlFilter = filter
+			}
+		default:
+			if ulFilter == nil {
+				ulFilter = filter.Swapped()
+			}
+			if dlFilter == nil {
+				dlFilter = filter
+			}
+		}
+	}
+	if ulFilter == nil && dlFilter == nil {
+		return nil, nil, fmt.Errorf("no Ethernet flow description")
+	}
+	return ulFilter, dlFilter, nil
+}
+
+// UpdateDataPathEthernetPacketFilter applies the Ethernet packet filters to the PDRs of the data path.
+// The PDRs of the direction without filter are given lower precedence than the default data path,
+// so the traffic of that direction isn't detected by the PCC rule
+func (r *PCCRule) UpdateDataPathEthernetPacketFilter(ulFilter, dlFilter *EthernetPacketFilter) error {
+	if r.Datapath == nil {
+		return fmt.Errorf("pcc[%s]: no data path", r.PccRuleId)
+	}
+	if ulFilter == nil && dlFilter == nil {
+		return fmt.Errorf("pcc[%s]: no Ethernet packet filter", r.PccRuleId)
+	}
+	for node := r.Datapath.FirstDPNode; node != nil; node = node.Next() {
+		applyEthernetPacketFilter(node.UpLinkTunnel.PDR, ulFilter)
+		applyEthernetPacketFilter(node.DownLinkTunnel.PDR, dlFilter)
+	}
+	return nil
+}
+
+func applyEthernetPacketFilter(pdr *PDR, filter *EthernetPacketFilter) {
+	if pdr == nil {
+		return
+	}
+	pdr.PDI.EthernetPacketFilter = filter
+	if filter == nil {
+		pdr.Precedence = DefaultPrecedence + 1
+	}
+}
+
+// UpdateUeMacAddresses updates the MAC addresses behind UE reported by UPF
+func (c *SMContext) UpdateUeMacAddresses(detected, removed []net.HardwareAddr) {
+	for _, mac := range removed {
+		for i, addr := range c.UeMacAddresses {
+			if addr.String() == mac.String() {
+				c.UeMacAddresses = append(c.UeMacAddresses[:i], c.UeMacAddresses[i+1:]...)
+				break
+			}
+		}
+		c.Log.Infof("MAC address[%s] is removed", mac)
+	}
+	for _, mac := range detected {
+		found := false
+		for _, addr := range c.UeMacAddresses {
+			if addr.String() == mac.String() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			c.UeMacAddresses = append(c.UeMacAddresses, mac)
+		}
+		c.Log.Infof("MAC address[%s] is detected", mac)
+	}
+}
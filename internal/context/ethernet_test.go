@@ -0,0 +1,112 @@
+package context
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/nas/nasType"
+	"bitbucket.org/free5gc-team/openapi/models"
+)
+
+func TestNewEthernetPacketFilter(t *testing.T) {
+	filter, err := NewEthernetPacketFilter(&models.EthFlowDescription{
+		DestMacAddr:   "02:00:00:00:00:01",
+		SourceMacAddr: "02:00:00:00:00:02",
+		EthType:       "0800",
+		VlanTags:      []string{"b064", "0c8"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "02:00:00:00:00:01", filter.DestinationMAC.String())
+	require.Equal(t, "02:00:00:00:00:02", filter.SourceMAC.String())
+	require.Equal(t, uint16(0x0800), filter.EtherType)
+	require.Equal(t, &VlanTag{PCP: 5, DEI: true, VID: 100}, filter.CTag)
+	require.Equal(t, &VlanTag{VID: 200}, filter.STag)
+
+	swapped := filter.Swapped()
+	require.Equal(t, filter.SourceMAC, swapped.DestinationMAC)
+	require.Equal(t, filter.DestinationMAC, swapped.SourceMAC)
+
+	var buf []byte
+	for _, component := range filter.NASComponents() {
+		b, err := component.MarshalBinary()
+		require.NoError(t, err)
+		buf = append(buf, b...)
+	}
+	require.Equal(t, []byte{
+		0x81, 0x02, 0x00, 0x00, 0x00, 0x00, 0x01,
+		0x82, 0x02, 0x00, 0x00, 0x00, 0x00, 0x02,
+		0x83, 0x00, 0x64,
+		0x85, 0x0b,
+		0x84, 0x00, 0xc8,
+		0x86, 0x00,
+		0x87, 0x08, 0x00,
+	}, buf)
+
+	_, err = NewEthernetPacketFilter(&models.EthFlowDescription{DestMacAddr: "invalid"})
+	require.Error(t, err)
+
+	matchAll, err := NewEthernetPacketFilter(&models.EthFlowDescription{})
+	require.NoError(t, err)
+	require.Equal(t, nasType.PacketFilterComponentList{&nasType.PacketFilterMatchAll{}}, matchAll.NASComponents())
+}
+
+func TestNewEthernetPacketFilters(t *testing.T) {
+	ueMAC := &models.EthFlowDescription{SourceMacAddr: "02:00:00:00:00:01"}
+
+	ulFilter, dlFilter, err := NewEthernetPacketFilters([]models.FlowInformation{
+		{EthFlowDescription: ueMAC, FlowDirection: models.FlowDirectionRm_UPLINK},
+	})
+	require.NoError(t, err)
+	require.Nil(t, dlFilter)
+	require.Equal(t, "02:00:00:00:00:01", ulFilter.SourceMAC.String())
+
+	ulFilter, dlFilter, err = NewEthernetPacketFilters([]models.FlowInformation{
+		{EthFlowDescription: ueMAC, FlowDirection: models.FlowDirectionRm_DOWNLINK},
+	})
+	require.NoError(t, err)
+	require.Nil(t, ulFilter)
+	require.Equal(t, "02:00:00:00:00:01", dlFilter.SourceMAC.String())
+
+	ulFilter, dlFilter, err = NewEthernetPacketFilters([]models.FlowInformation{
+		{EthFlowDescription: ueMAC, FlowDirection: models.FlowDirectionRm_BIDIRECTIONAL},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "02:00:00:00:00:01", dlFilter.SourceMAC.String())
+	require.Equal(t, "02:00:00:00:00:01", ulFilter.DestinationMAC.String())
+
+	_, _, err = NewEthernetPacketFilters([]models.FlowInformation{{FlowDescription: "permit out ip from any to assigned"}})
+	require.Error(t, err)
+}
+
+func TestUpdateDataPathEthernetPacketFilter(t *testing.T) {
+	ulPDR, dlPDR := &PDR{Precedence: 30}, &PDR{Precedence: 30}
+	pcc := &PCCRule{
+		PccRule: &models.PccRule{PccRuleId: "1"},
+		Datapath: &DataPath{FirstDPNode: &DataPathNode{
+			UpLinkTunnel:   &GTPTunnel{PDR: ulPDR},
+			DownLinkTunnel: &GTPTunnel{PDR: dlPDR},
+		}},
+	}
+	ulFilter := &EthernetPacketFilter{EtherType: 0x0800}
+	require.NoError(t, pcc.UpdateDataPathEthernetPacketFilter(ulFilter, nil))
+	require.Equal(t, ulFilter, ulPDR.PDI.EthernetPacketFilter)
+	require.Equal(t, uint32(30), ulPDR.Precedence)
+	require.Nil(t, dlPDR.PDI.EthernetPacketFilter)
+	require.Greater(t, dlPDR.Precedence, DefaultPrecedence)
+
+	require.Error(t, pcc.UpdateDataPathEthernetPacketFilter(nil, nil))
+}
+
+func TestUpdateUeMacAddresses(t *testing.T) {
+	smctx := NewSMContext("imsi-208930000000006", 10)
+	defer RemoveSMContext(smctx.Ref)
+
+	mac1, _ := net.ParseMAC("02:00:00:00:00:01")
+	mac2, _ := net.ParseMAC("02:00:00:00:00:02")
+	smctx.UpdateUeMacAddresses([]net.HardwareAddr{mac1, mac2, mac1}, nil)
+	require.Equal(t, []net.HardwareAddr{mac1, mac2}, smctx.UeMacAddresses)
+	smctx.UpdateUeMacAddresses(nil, []net.HardwareAddr{mac1})
+	require.Equal(t, []net.HardwareAddr{mac2}, smctx.UeMacAddresses)
+}
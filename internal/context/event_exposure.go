@@ -0,0 +1,513 @@
+package context
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+)
+
+// Nsmf_EventExposure subscriptions, key: subId
+var eeSubscriptionPool sync.Map
+
+// SMF events which can be subscribed through Nsmf_EventExposure
+var supportedSmfEvents = map[models.SmfEvent]bool{
+	models.SmfEvent_PDU_SES_EST: true,
+	models.SmfEvent_PDU_SES_REL: true,
+	models.SmfEvent_UE_IP_CH:    true,
+	models.SmfEvent_DNAI_CH:     true,
+	models.SmfEvent_PLMN_CH:     true,
+	models.SmfEvent_AC_TY_CH:    true,
+	models.SmfEvent_QFI_ALLOC:   true,
+}
+
+type EventExposureSubscription struct {
+	*models.NsmfEventExposure
+
+	notifCb        NotifCallback
+	reportNbr      int32
+	pendingReports []models.EventNotification // buffered for periodic reporting
+	periodicTicker *time.Ticker
+	periodicStop   chan struct{}
+	expiryTimer    *time.Timer
+	mu             sync.RWMutex
+}
+
+// ValidateEventExposureSubscription checks the NsmfEventExposure body (TS 29.508 6.1.6.2.2)
+func ValidateEventExposureSubscription(sub *models.NsmfEventExposure) error {
+	if sub == nil {
+		return fmt.Errorf("NsmfEventExposure is nil")
+	}
+	if sub.NotifUri == "" {
+		return fmt.Errorf("notifUri is missing")
+	}
+	if sub.NotifId == "" {
+		return fmt.Errorf("notifId is missing")
+	}
+	if len(sub.EventSubs) == 0 {
+		return fmt.Errorf("eventSubs is empty")
+	}
+	for _, evtSub := range sub.EventSubs {
+		if !supportedSmfEvents[evtSub.Event] {
+			return fmt.Errorf("event [%s] is not supported", evtSub.Event)
+		}
+	}
+
+	// Exactly one of UE ID, group ID and any UE indication shall be present
+	targets := 0
+	if sub.Supi != "" || sub.Gpsi != "" {
+		targets++
+	}
+	if sub.GroupId != "" {
+		targets++
+	}
+	if sub.AnyUeInd {
+		targets++
+	}
+	if targets != 1 {
+		return fmt.Errorf("exactly one of supi/gpsi, groupId and anyUeInd shall be present")
+	}
+	if sub.PduSeId != 0 && sub.Supi == "" && sub.Gpsi == "" {
+		return fmt.Errorf("pduSeId is only applicable to a single UE")
+	}
+
+	if sub.Expiry != nil && !sub.Expiry.After(time.Now()) {
+		return fmt.Errorf("expiry [%s] is in the past", sub.Expiry)
+	}
+	if sub.MaxReportNbr < 0 {
+		return fmt.Errorf("maxReportNbr [%d] is negative", sub.MaxReportNbr)
+	}
+	if sub.NotifMethod == models.NotificationMethod_PERIODIC && sub.RepPeriod <= 0 {
+		return fmt.Errorf("repPeriod is mandatory for periodic notification")
+	}
+	return nil
+}
+
+// NewEventExposureSubscription validates the subscription, assigns a subId and stores it
+func NewEventExposureSubscription(sub *models.NsmfEventExposure) (*EventExposureSubscription, error) {
+	if err := ValidateEventExposureSubscription(sub); err != nil {
+		return nil, err
+	}
+
+	sub.SubId = uuid.New().String()
+	s := &EventExposureSubscription{
+		NsmfEventExposure: sub,
+	}
+	s.startExpiryTimer()
+	eeSubscriptionPool.Store(sub.SubId, s)
+	logger.CtxLog.Infof("Event exposure subscription[%s] is created", sub.SubId)
+	return s, nil
+}
+
+func GetEventExposureSubscription(subId string) *EventExposureSubscription {
+	if value, ok := eeSubscriptionPool.Load(subId); ok {
+		s := value.(*EventExposureSubscription)
+		if !s.IsExpired() {
+			return s
+		}
+		RemoveEventExposureSubscription(subId)
+	}
+	return nil
+}
+
+// ReplaceEventExposureSubscription replaces the stored subscription with sub and restarts its expiry timer
+func ReplaceEventExposureSubscription(subId string, sub *models.NsmfEventExposure) (
+	*EventExposureSubscription, error,
+) {
+	s := GetEventExposureSubscription(subId)
+	if s == nil {
+		return nil, fmt.Errorf("subscription[%s] not found", subId)
+	}
+	if err := ValidateEventExposureSubscription(sub); err != nil {
+		return nil, err
+	}
+
+	sub.SubId = subId
+	s.stopExpiryTimer()
+	// Send the reports buffered in the reporting period of the replaced subscription
+	s.flushPendingReports()
+	s.stopPeriodicReport()
+	s.mu.Lock()
+	s.NsmfEventExposure = sub
+	s.reportNbr = 0
+	notifCb := s.notifCb
+	s.mu.Unlock()
+	s.startExpiryTimer()
+	if notifCb != nil {
+		s.Activate(notifCb)
+	}
+	logger.CtxLog.Infof("Event exposure subscription[%s] is updated", subId)
+	return s, nil
+}
+
+func RemoveEventExposureSubscription(subId string) bool {
+	value, ok := eeSubscriptionPool.LoadAndDelete(subId)
+	if !ok {
+		return false
+	}
+	s := value.(*EventExposureSubscription)
+	s.stopExpiryTimer()
+	// Send the reports buffered in the last reporting period
+	s.flushPendingReports()
+	s.stopPeriodicReport()
+	// Deliver the reports held while the subscription was muted
+	GetNotificationDispatcher().Unmute(subId)
+	logger.CtxLog.Infof("Event exposure subscription[%s] is deleted", subId)
+	return true
+}
+
+func (s *EventExposureSubscription) startExpiryTimer() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Expiry == nil {
+		return
+	}
+	subId := s.SubId
+	s.expiryTimer = time.AfterFunc(time.Until(*s.Expiry), func() {
+		logger.CtxLog.Infof("Event exposure subscription[%s] is expired", subId)
+		RemoveEventExposureSubscription(subId)
+	})
+}
+
+func (s *EventExposureSubscription) stopExpiryTimer() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.expiryTimer != nil {
+		s.expiryTimer.Stop()
+		s.expiryTimer = nil
+	}
+}
+
+func (s *EventExposureSubscription) IsExpired() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Expiry != nil && !s.Expiry.After(time.Now())
+}
+
+// Activate sets the callback of the subscription, sends the immediate report if requested
+// and starts the periodic reporting
+func (s *EventExposureSubscription) Activate(notifCb NotifCallback) {
+	s.mu.Lock()
+	s.notifCb = notifCb
+	immeRep := s.ImmeRep
+	s.mu.Unlock()
+
+	if immeRep {
+		s.sendImmediateReport()
+	}
+	s.startPeriodicReport()
+}
+
+// sendImmediateReport reports the current status of all matching PDU sessions
+func (s *EventExposureSubscription) sendImmediateReport() {
+	var reports []models.EventNotification
+	smContextPool.Range(func(key, value interface{}) bool {
+		smContext := value.(*SMContext)
+		if smContext.State() != Active || !s.Match(smContext) {
+			return true
+		}
+		for _, en := range smContext.currentEventReports() {
+			if s.EventSubscription(en.Event) != nil {
+				reports = append(reports, en)
+			}
+		}
+		return true
+	})
+	if len(reports) == 0 {
+		return
+	}
+	reports = reports[:s.takeReports(int32(len(reports)))]
+	if len(reports) == 0 {
+		return
+	}
+	s.mu.RLock()
+	n := newEventExposureNotification(s.NotifUri, s.NotifId, reports[0])
+	n.SubId = s.SubId
+	s.mu.RUnlock()
+	n.EventNotifs = reports
+	s.dispatch(n)
+}
+
+// UeIPChangeNotification returns the UE_IP_CH event notification of the UE IPv4 address or IPv6 prefix,
+// which is added or released
+func (c *SMContext) UeIPChangeNotification(released bool) models.EventNotification {
+	var en models.EventNotification
+	if released {
+		en.ReIpv4Addr = c.UeIPv4Address()
+		en.ReIpv6Prefix = c.UeIPv6Prefix()
+	} else {
+		en.AdIpv4Addr = c.UeIPv4Address()
+		en.AdIpv6Prefix = c.UeIPv6Prefix()
+	}
+	return en
+}
+
+// QFIAllocNotification returns the event notification of the QFI allocated to the QoS flow of the PDU session
+func (c *SMContext) QFIAllocNotification(qfi uint8) models.EventNotification {
+	return models.EventNotification{
+		PduSeId: c.PDUSessionID,
+		Qfi:     int32(qfi),
+	}
+}
+
+// currentEventReports returns the event notifications describing the current status of the PDU session
+func (c *SMContext) currentEventReports() []models.EventNotification {
+	var reports []models.EventNotification
+	add := func(event models.SmfEvent, en models.EventNotification) {
+		reports = append(reports, c.newEventNotification(event, en))
+	}
+
+	add(models.SmfEvent_PDU_SES_EST, models.EventNotification{})
+	if c.UeIPv4Address() != "" || c.UeIPv6Prefix() != "" {
+		add(models.SmfEvent_UE_IP_CH, c.UeIPChangeNotification(false))
+	}
+	for _, qfi := range c.qosDataToQFI {
+		add(models.SmfEvent_QFI_ALLOC, c.QFIAllocNotification(qfi))
+	}
+	if c.SmContextCreateData != nil {
+		if c.ServingNetwork != nil {
+			add(models.SmfEvent_PLMN_CH, models.EventNotification{
+				PlmnId: c.ServingNetwork,
+			})
+		}
+		if c.AnType != "" {
+			add(models.SmfEvent_AC_TY_CH, models.EventNotification{
+				AccType: c.AnType,
+			})
+		}
+	}
+	return reports
+}
+
+func (s *EventExposureSubscription) startPeriodicReport() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.NotifMethod != models.NotificationMethod_PERIODIC || s.RepPeriod <= 0 {
+		return
+	}
+	ticker := time.NewTicker(time.Duration(s.RepPeriod) * time.Second)
+	stop := make(chan struct{})
+	s.periodicTicker = ticker
+	s.periodicStop = stop
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				s.flushPendingReports()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *EventExposureSubscription) stopPeriodicReport() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.periodicTicker != nil {
+		s.periodicTicker.Stop()
+		close(s.periodicStop)
+		s.periodicTicker = nil
+		s.periodicStop = nil
+	}
+	s.pendingReports = nil
+}
+
+// flushPendingReports sends the event notifications buffered during the reporting period
+func (s *EventExposureSubscription) flushPendingReports() {
+	s.mu.Lock()
+	reports := s.pendingReports
+	s.pendingReports = nil
+	s.mu.Unlock()
+	if len(reports) == 0 {
+		return
+	}
+
+	s.mu.RLock()
+	n := newEventExposureNotification(s.NotifUri, s.NotifId, reports[0])
+	n.SubId = s.SubId
+	s.mu.RUnlock()
+	n.EventNotifs = reports
+	s.dispatch(n)
+}
+
+// dispatch hands the notification to the notification dispatcher
+func (s *EventExposureSubscription) dispatch(n *EventExposureNotification) {
+	s.mu.RLock()
+	notifCb := s.notifCb
+	s.mu.RUnlock()
+	if notifCb == nil {
+		logger.CtxLog.Warnf("Event exposure subscription[%s] has no notification callback", n.SubId)
+		return
+	}
+	dispatchEventExposureNotification(n, notifCb)
+}
+
+// takeReports counts n reports against maxReportNbr and returns how many of them may be sent,
+// the subscription is removed once the limit is reached. Every report is counted when it's built,
+// whether it's sent at once, buffered for the periodic report or held while muted
+func (s *EventExposureSubscription) takeReports(n int32) int32 {
+	s.mu.Lock()
+	maxReportNbr := s.MaxReportNbr
+	if s.NotifMethod == models.NotificationMethod_ONE_TIME {
+		maxReportNbr = 1
+	}
+	if maxReportNbr == 0 {
+		s.mu.Unlock()
+		return n
+	}
+	if remain := maxReportNbr - s.reportNbr; n > remain {
+		n = remain
+	}
+	s.reportNbr += n
+	reached := s.reportNbr >= maxReportNbr
+	subId := s.SubId
+	s.mu.Unlock()
+
+	if reached {
+		logger.CtxLog.Infof("Event exposure subscription[%s] reaches the max number of reports", subId)
+		// Keep the callback for the last reports, only the subscription is removed
+		go RemoveEventExposureSubscription(subId)
+	}
+	return n
+}
+
+// Match reports whether the subscription targets the PDU session of smContext
+func (s *EventExposureSubscription) Match(smContext *SMContext) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	switch {
+	case s.AnyUeInd:
+	case s.Supi != "":
+		if s.Supi != smContext.Supi {
+			return false
+		}
+	case s.Gpsi != "":
+		if smContext.SmContextCreateData == nil || s.Gpsi != smContext.Gpsi {
+			return false
+		}
+	case s.GroupId != "":
+		found := false
+		for _, groupId := range smContext.InternalGroupIds {
+			if groupId == s.GroupId {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	default:
+		return false
+	}
+
+	if s.PduSeId != 0 && s.PduSeId != smContext.PDUSessionID {
+		return false
+	}
+	if s.Dnn != "" && (smContext.SmContextCreateData == nil || s.Dnn != smContext.Dnn) {
+		return false
+	}
+	if s.Snssai != nil {
+		if smContext.SmContextCreateData == nil || smContext.SNssai == nil ||
+			s.Snssai.Sst != smContext.SNssai.Sst || s.Snssai.Sd != smContext.SNssai.Sd {
+			return false
+		}
+	}
+	return true
+}
+
+// EventSubscription returns the subscribed event, or nil if the event is not subscribed
+func (s *EventExposureSubscription) EventSubscription(event models.SmfEvent) *models.EventSubscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i := range s.EventSubs {
+		if s.EventSubs[i].Event == event {
+			evtSub := s.EventSubs[i]
+			return &evtSub
+		}
+	}
+	return nil
+}
+
+// BuildEventExposureNotification adds an event notification for every subscription
+// matching this SM Context. Notifications are sent by SendEventExposureNotification.
+func (c *SMContext) BuildEventExposureNotification(event models.SmfEvent, en models.EventNotification) {
+	en = c.newEventNotification(event, en)
+
+	eeSubscriptionPool.Range(func(key, value interface{}) bool {
+		s := value.(*EventExposureSubscription)
+		if s.IsExpired() || !s.Match(c) {
+			return true
+		}
+		evtSub := s.EventSubscription(event)
+		if evtSub == nil {
+			return true
+		}
+		subEn := en
+		if event == models.SmfEvent_DNAI_CH && evtSub.DnaiChgType != "" {
+			subEn.DnaiChgType = evtSub.DnaiChgType
+		}
+		if s.takeReports(1) == 0 {
+			return true
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.NotifMethod == models.NotificationMethod_PERIODIC {
+			s.pendingReports = append(s.pendingReports, subEn)
+			return true
+		}
+		k := s.NotifUri + s.NotifId
+		if n, ok := c.EventExposureNotification[k]; ok {
+			n.EventNotifs = append(n.EventNotifs, subEn)
+		} else {
+			n = newEventExposureNotification(s.NotifUri, s.NotifId, subEn)
+			n.SubId = s.SubId
+			c.EventExposureNotification[k] = n
+		}
+		return true
+	})
+}
+
+func (c *SMContext) newEventNotification(event models.SmfEvent, en models.EventNotification) models.EventNotification {
+	now := time.Now()
+	en.Event = event
+	en.TimeStamp = &now
+	en.PduSeId = c.PDUSessionID
+	if c.SmContextCreateData != nil {
+		en.Supi = c.Supi
+		en.Gpsi = c.Gpsi
+	}
+	return en
+}
+
+// SendEventExposureNotification sends all pending event notifications of this SM Context
+func (c *SMContext) SendEventExposureNotification(notifCb NotifCallback) {
+	for k, n := range c.EventExposureNotification {
+		c.Log.Infof("Send Event Exposure Notification [%s] to [%s]", n.NotifId, n.Uri)
+		dispatchEventExposureNotification(n, notifCb)
+		delete(c.EventExposureNotification, k)
+	}
+}
+
+// dispatchEventExposureNotification delivers the notification through the notification dispatcher,
+// the subId (or notifId if no subscription) is used as the mute key
+func dispatchEventExposureNotification(n *EventExposureNotification, notifCb NotifCallback) {
+	key := n.SubId
+	if key == "" {
+		key = n.NotifId
+	}
+	notification := n.NsmfEventExposureNotification
+	GetNotificationDispatcher().Dispatch(&Notification{
+		Uri: n.Uri,
+		Key: key,
+		Send: func() error {
+			return notifCb(n.Uri, notification)
+		},
+	})
+}
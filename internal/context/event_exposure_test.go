@@ -0,0 +1,361 @@
+package context
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+)
+
+func TestValidateEventExposureSubscription(t *testing.T) {
+	past := time.Now().Add(-time.Minute)
+
+	testCases := []struct {
+		name    string
+		sub     *models.NsmfEventExposure
+		wantErr bool
+	}{
+		{
+			name: "valid any UE subscription",
+			sub: &models.NsmfEventExposure{
+				AnyUeInd:  true,
+				NotifId:   "notif-1",
+				NotifUri:  "http://127.0.0.1:8000/notify",
+				EventSubs: []models.EventSubscription{{Event: models.SmfEvent_PDU_SES_EST}},
+			},
+		},
+		{
+			name: "missing notifUri",
+			sub: &models.NsmfEventExposure{
+				Supi:      "imsi-208930000000001",
+				NotifId:   "notif-1",
+				EventSubs: []models.EventSubscription{{Event: models.SmfEvent_PDU_SES_EST}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unsupported event",
+			sub: &models.NsmfEventExposure{
+				Supi:      "imsi-208930000000001",
+				NotifId:   "notif-1",
+				NotifUri:  "http://127.0.0.1:8000/notify",
+				EventSubs: []models.EventSubscription{{Event: models.SmfEvent_COMM_FAIL}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "both supi and groupId",
+			sub: &models.NsmfEventExposure{
+				Supi:      "imsi-208930000000001",
+				GroupId:   "group-1",
+				NotifId:   "notif-1",
+				NotifUri:  "http://127.0.0.1:8000/notify",
+				EventSubs: []models.EventSubscription{{Event: models.SmfEvent_PDU_SES_REL}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "expired",
+			sub: &models.NsmfEventExposure{
+				Supi:      "imsi-208930000000001",
+				NotifId:   "notif-1",
+				NotifUri:  "http://127.0.0.1:8000/notify",
+				EventSubs: []models.EventSubscription{{Event: models.SmfEvent_UE_IP_CH}},
+				Expiry:    &past,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateEventExposureSubscription(tc.sub)
+			if tc.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestEventExposureSubscriptionLifecycle(t *testing.T) {
+	expiry := time.Now().Add(100 * time.Millisecond)
+	sub, err := NewEventExposureSubscription(&models.NsmfEventExposure{
+		Supi:      "imsi-208930000000001",
+		NotifId:   "notif-1",
+		NotifUri:  "http://127.0.0.1:8000/notify",
+		EventSubs: []models.EventSubscription{{Event: models.SmfEvent_PDU_SES_EST}},
+		Expiry:    &expiry,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, sub.SubId)
+	require.Equal(t, sub, GetEventExposureSubscription(sub.SubId))
+
+	_, err = ReplaceEventExposureSubscription(sub.SubId, &models.NsmfEventExposure{
+		Supi:      "imsi-208930000000001",
+		NotifId:   "notif-2",
+		NotifUri:  "http://127.0.0.1:8000/notify",
+		EventSubs: []models.EventSubscription{{Event: models.SmfEvent_PDU_SES_REL}},
+		Expiry:    &expiry,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "notif-2", GetEventExposureSubscription(sub.SubId).NotifId)
+
+	require.Eventually(t, func() bool {
+		return GetEventExposureSubscription(sub.SubId) == nil
+	}, time.Second, 10*time.Millisecond)
+	require.False(t, RemoveEventExposureSubscription(sub.SubId))
+}
+
+func TestBuildEventExposureNotification(t *testing.T) {
+	smctx := NewSMContext("imsi-208930000000002", 10)
+	smctx.SmContextCreateData = &models.SmContextCreateData{
+		Supi:   "imsi-208930000000002",
+		Dnn:    "internet",
+		SNssai: &models.Snssai{Sst: 1, Sd: "010203"},
+	}
+	smctx.InternalGroupIds = []string{"group-1"}
+
+	subs := []*models.NsmfEventExposure{
+		{
+			Supi:      "imsi-208930000000002",
+			NotifId:   "supi",
+			NotifUri:  "http://127.0.0.1:8000/supi",
+			EventSubs: []models.EventSubscription{{Event: models.SmfEvent_PDU_SES_EST}},
+		},
+		{
+			GroupId:   "group-1",
+			NotifId:   "group",
+			NotifUri:  "http://127.0.0.1:8000/group",
+			EventSubs: []models.EventSubscription{{Event: models.SmfEvent_PDU_SES_EST}},
+		},
+		{
+			AnyUeInd:  true,
+			Dnn:       "ims",
+			NotifId:   "other-dnn",
+			NotifUri:  "http://127.0.0.1:8000/any",
+			EventSubs: []models.EventSubscription{{Event: models.SmfEvent_PDU_SES_EST}},
+		},
+		{
+			Supi:      "imsi-208930000000002",
+			NotifId:   "other-event",
+			NotifUri:  "http://127.0.0.1:8000/supi",
+			EventSubs: []models.EventSubscription{{Event: models.SmfEvent_PDU_SES_REL}},
+		},
+	}
+	for _, s := range subs {
+		created, err := NewEventExposureSubscription(s)
+		require.NoError(t, err)
+		defer RemoveEventExposureSubscription(created.SubId)
+	}
+
+	smctx.BuildEventExposureNotification(models.SmfEvent_PDU_SES_EST, models.EventNotification{})
+	require.Len(t, smctx.EventExposureNotification, 2)
+	for _, notifId := range []string{"supi", "group"} {
+		var uri string
+		for _, s := range subs {
+			if s.NotifId == notifId {
+				uri = s.NotifUri
+			}
+		}
+		n, ok := smctx.EventExposureNotification[uri+notifId]
+		require.True(t, ok)
+		require.Len(t, n.EventNotifs, 1)
+		require.Equal(t, models.SmfEvent_PDU_SES_EST, n.EventNotifs[0].Event)
+		require.Equal(t, int32(10), n.EventNotifs[0].PduSeId)
+	}
+}
+
+func TestEventExposureMaxReportNbr(t *testing.T) {
+	smctx := NewSMContext("imsi-208930000000003", 10)
+	smctx.SmContextCreateData = &models.SmContextCreateData{
+		Supi: "imsi-208930000000003",
+		Dnn:  "internet",
+	}
+
+	sub, err := NewEventExposureSubscription(&models.NsmfEventExposure{
+		Supi:        "imsi-208930000000003",
+		NotifId:     "one-time",
+		NotifUri:    "http://127.0.0.1:8000/one-time",
+		NotifMethod: models.NotificationMethod_ONE_TIME,
+		EventSubs: []models.EventSubscription{
+			{Event: models.SmfEvent_PDU_SES_EST},
+			{Event: models.SmfEvent_PDU_SES_REL},
+		},
+	})
+	require.NoError(t, err)
+	defer RemoveEventExposureSubscription(sub.SubId)
+
+	smctx.BuildEventExposureNotification(models.SmfEvent_PDU_SES_EST, models.EventNotification{})
+	smctx.BuildEventExposureNotification(models.SmfEvent_PDU_SES_REL, models.EventNotification{})
+	n, ok := smctx.EventExposureNotification["http://127.0.0.1:8000/one-timeone-time"]
+	require.True(t, ok)
+	require.Equal(t, sub.SubId, n.SubId)
+	require.Len(t, n.EventNotifs, 1)
+	require.Equal(t, models.SmfEvent_PDU_SES_EST, n.EventNotifs[0].Event)
+
+	require.Eventually(t, func() bool {
+		return GetEventExposureSubscription(sub.SubId) == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestQFIAllocNotification(t *testing.T) {
+	smctx := NewSMContext("imsi-208930000000004", 10)
+	defer RemoveSMContext(smctx.Ref)
+	smctx.SmContextCreateData = &models.SmContextCreateData{
+		Supi: "imsi-208930000000004",
+		Dnn:  "internet",
+	}
+
+	sub, err := NewEventExposureSubscription(&models.NsmfEventExposure{
+		Supi:      "imsi-208930000000004",
+		NotifId:   "qfi",
+		NotifUri:  "http://127.0.0.1:8000/qfi",
+		EventSubs: []models.EventSubscription{{Event: models.SmfEvent_QFI_ALLOC}},
+	})
+	require.NoError(t, err)
+	defer RemoveEventExposureSubscription(sub.SubId)
+
+	qfi := smctx.AssignQFI("QosData-1")
+	require.NotZero(t, qfi)
+	// the QFI is allocated once per QoS data
+	require.Equal(t, qfi, smctx.AssignQFI("QosData-1"))
+
+	n, ok := smctx.EventExposureNotification["http://127.0.0.1:8000/qfiqfi"]
+	require.True(t, ok)
+	require.Len(t, n.EventNotifs, 1)
+	require.Equal(t, models.SmfEvent_QFI_ALLOC, n.EventNotifs[0].Event)
+	require.Equal(t, int32(qfi), n.EventNotifs[0].Qfi)
+	require.Equal(t, int32(10), n.EventNotifs[0].PduSeId)
+}
+
+// recordNotifications returns the callback recording the event notifications and the getter of them
+func recordNotifications() (NotifCallback, func() []models.EventNotification) {
+	var mu sync.Mutex
+	var notifs []models.EventNotification
+	notifCb := func(uri string, notification *models.NsmfEventExposureNotification) error {
+		mu.Lock()
+		defer mu.Unlock()
+		notifs = append(notifs, notification.EventNotifs...)
+		return nil
+	}
+	return notifCb, func() []models.EventNotification {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]models.EventNotification(nil), notifs...)
+	}
+}
+
+func TestRemoveMutedEventExposureSubscription(t *testing.T) {
+	smctx := NewSMContext("imsi-208930000000005", 10)
+	defer RemoveSMContext(smctx.Ref)
+	smctx.SmContextCreateData = &models.SmContextCreateData{
+		Supi: "imsi-208930000000005",
+		Dnn:  "internet",
+	}
+
+	sub, err := NewEventExposureSubscription(&models.NsmfEventExposure{
+		Supi:      "imsi-208930000000005",
+		NotifId:   "muted",
+		NotifUri:  "http://127.0.0.1:8000/muted",
+		EventSubs: []models.EventSubscription{{Event: models.SmfEvent_PDU_SES_REL}},
+	})
+	require.NoError(t, err)
+	notifCb, notifs := recordNotifications()
+	sub.Activate(notifCb)
+	require.NoError(t, GetNotificationDispatcher().Mute(sub.SubId))
+
+	smctx.BuildEventExposureNotification(models.SmfEvent_PDU_SES_REL, models.EventNotification{})
+	smctx.SendEventExposureNotification(notifCb)
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(t, notifs())
+
+	// the report held while muted is delivered before the subscription is removed
+	require.True(t, RemoveEventExposureSubscription(sub.SubId))
+	require.False(t, GetNotificationDispatcher().IsMuted(sub.SubId))
+	require.Eventually(t, func() bool {
+		return len(notifs()) == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, models.SmfEvent_PDU_SES_REL, notifs()[0].Event)
+}
+
+func TestImmediateReportMaxReportNbr(t *testing.T) {
+	smctx := NewSMContext("imsi-208930000000006", 10)
+	defer RemoveSMContext(smctx.Ref)
+	smctx.SmContextCreateData = &models.SmContextCreateData{
+		Supi: "imsi-208930000000006",
+		Dnn:  "internet",
+	}
+	smctx.SetState(Active)
+
+	sub, err := NewEventExposureSubscription(&models.NsmfEventExposure{
+		Supi:         "imsi-208930000000006",
+		NotifId:      "immediate",
+		NotifUri:     "http://127.0.0.1:8000/immediate",
+		ImmeRep:      true,
+		MaxReportNbr: 1,
+		EventSubs: []models.EventSubscription{
+			{Event: models.SmfEvent_PDU_SES_EST},
+			{Event: models.SmfEvent_PDU_SES_REL},
+		},
+	})
+	require.NoError(t, err)
+	defer RemoveEventExposureSubscription(sub.SubId)
+	notifCb, notifs := recordNotifications()
+	sub.Activate(notifCb)
+
+	// the immediate report is counted against the max number of reports
+	require.Eventually(t, func() bool {
+		return GetEventExposureSubscription(sub.SubId) == nil
+	}, time.Second, 10*time.Millisecond)
+	smctx.BuildEventExposureNotification(models.SmfEvent_PDU_SES_REL, models.EventNotification{})
+	require.Empty(t, smctx.EventExposureNotification)
+	require.Eventually(t, func() bool {
+		return len(notifs()) == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, models.SmfEvent_PDU_SES_EST, notifs()[0].Event)
+}
+
+func TestReplacePeriodicEventExposureSubscription(t *testing.T) {
+	smctx := NewSMContext("imsi-208930000000007", 10)
+	defer RemoveSMContext(smctx.Ref)
+	smctx.SmContextCreateData = &models.SmContextCreateData{
+		Supi: "imsi-208930000000007",
+		Dnn:  "internet",
+	}
+
+	sub, err := NewEventExposureSubscription(&models.NsmfEventExposure{
+		Supi:        "imsi-208930000000007",
+		NotifId:     "periodic-1",
+		NotifUri:    "http://127.0.0.1:8000/periodic",
+		NotifMethod: models.NotificationMethod_PERIODIC,
+		RepPeriod:   3600,
+		EventSubs:   []models.EventSubscription{{Event: models.SmfEvent_PDU_SES_REL}},
+	})
+	require.NoError(t, err)
+	defer RemoveEventExposureSubscription(sub.SubId)
+	notifCb, notifs := recordNotifications()
+	sub.Activate(notifCb)
+
+	smctx.BuildEventExposureNotification(models.SmfEvent_PDU_SES_REL, models.EventNotification{})
+	require.Empty(t, notifs())
+
+	// the report buffered in the reporting period is sent before the subscription is replaced
+	_, err = ReplaceEventExposureSubscription(sub.SubId, &models.NsmfEventExposure{
+		Supi:        "imsi-208930000000007",
+		NotifId:     "periodic-2",
+		NotifUri:    "http://127.0.0.1:8000/periodic",
+		NotifMethod: models.NotificationMethod_PERIODIC,
+		RepPeriod:   3600,
+		EventSubs:   []models.EventSubscription{{Event: models.SmfEvent_PDU_SES_REL}},
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return len(notifs()) == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, models.SmfEvent_PDU_SES_REL, notifs()[0].Event)
+}
@@ -0,0 +1,120 @@
+package context
+
+import (
+	"net"
+
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+)
+
+// smfCSIDs is the number of the PDN connection sets of the PFCP sessions of SMF, which is the maximum
+// number of CSIDs in an FQ-CSID IE. The sessions are grouped by their local SEIDs, so a partial failure
+// of SMF is signalled per set, and the CSIDs are the same to delete the sessions after SMF restarts
+const smfCSIDs = 15
+
+// FQ-CSID Node-ID Types, TS 29.244 8.2.46
+const (
+	fqcsidNodeIDTypeIPv4 uint8 = 0
+	fqcsidNodeIDTypeIPv6 uint8 = 1
+)
+
+// FQCSID is a fully qualified PDN connection set identifier (TS 23.007 16) which identifies the sets of
+// PFCP sessions sharing a node or a part of it, the sessions of a set are deleted together when it fails
+type FQCSID struct {
+	NodeAddress net.IP
+	CSIDs       []uint16
+}
+
+// LocalFQCSID returns the FQ-CSID of SMF with all its CSIDs, whose sets have all the PFCP sessions of SMF
+func (s *SMFContext) LocalFQCSID() *FQCSID {
+	fqcsid := &FQCSID{NodeAddress: s.ExternalIP()}
+	for csid := uint16(1); csid <= smfCSIDs; csid++ {
+		fqcsid.CSIDs = append(fqcsid.CSIDs, csid)
+	}
+	return fqcsid
+}
+
+// SessionFQCSID returns the FQ-CSID of SMF allocated to the PFCP session of the local SEID
+func (s *SMFContext) SessionFQCSID(localSEID uint64) *FQCSID {
+	return &FQCSID{
+		NodeAddress: s.ExternalIP(),
+		CSIDs:       []uint16{SessionCSID(localSEID)},
+	}
+}
+
+// SessionCSID returns the CSID of the set of the PFCP session of the local SEID
+func SessionCSID(localSEID uint64) uint16 {
+	return uint16(localSEID%smfCSIDs) + 1
+}
+
+// Matches reports whether the FQ-CSIDs are of the same node and have a CSID in common
+func (f *FQCSID) Matches(other *FQCSID) bool {
+	if f == nil || other == nil || !f.NodeAddress.Equal(other.NodeAddress) {
+		return false
+	}
+	for _, csid := range f.CSIDs {
+		for _, otherCSID := range other.CSIDs {
+			if csid == otherCSID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ToPFCP converts the FQ-CSID to the PFCP IE
+func (f *FQCSID) ToPFCP() *pfcpType.FQCSID {
+	fqcsid := &pfcpType.FQCSID{
+		NumberOfCsids:              uint8(len(f.CSIDs)),
+		PdnConnectionSetIdentifier: f.CSIDs,
+	}
+	if ipv4 := f.NodeAddress.To4(); ipv4 != nil {
+		fqcsid.FqcsidNodeIdType = fqcsidNodeIDTypeIPv4
+		fqcsid.NodeAddress = ipv4
+	} else {
+		fqcsid.FqcsidNodeIdType = fqcsidNodeIDTypeIPv6
+		fqcsid.NodeAddress = f.NodeAddress.To16()
+	}
+	return fqcsid
+}
+
+// FQCSIDFromPFCP converts the PFCP IE, the FQ-CSID with MCC/MNC based node address is not supported
+func FQCSIDFromPFCP(fqcsid *pfcpType.FQCSID) *FQCSID {
+	if fqcsid == nil {
+		return nil
+	}
+	switch fqcsid.FqcsidNodeIdType {
+	case fqcsidNodeIDTypeIPv4, fqcsidNodeIDTypeIPv6:
+		return &FQCSID{
+			NodeAddress: net.IP(fqcsid.NodeAddress),
+			CSIDs:       fqcsid.PdnConnectionSetIdentifier,
+		}
+	default:
+		return nil
+	}
+}
+
+// SMContextsOfFQCSIDs returns the SM contexts whose PFCP sessions on UPF are in the sets of the FQ-CSIDs of UPF
+func SMContextsOfFQCSIDs(upf *UPF, fqcsids []*FQCSID) []*SMContext {
+	nodeIDtoIP := upf.NodeID.ResolveNodeIdToIp().String()
+
+	var smContexts []*SMContext
+	smContextPool.Range(func(key, value interface{}) bool {
+		smContext := value.(*SMContext)
+		smContext.SMLock.Lock()
+		matched := false
+		if pfcpSessionContext, ok := smContext.PFCPContext[nodeIDtoIP]; ok {
+			for _, fqcsid := range fqcsids {
+				if pfcpSessionContext.RemoteFQCSID.Matches(fqcsid) {
+					matched = true
+					break
+				}
+			}
+		}
+		smContext.SMLock.Unlock()
+		if matched {
+			smContexts = append(smContexts, smContext)
+		}
+		return true
+	})
+	return smContexts
+}
@@ -0,0 +1,60 @@
+package context
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFQCSIDMatches(t *testing.T) {
+	fqcsid := &FQCSID{
+		NodeAddress: net.ParseIP("10.4.0.1"),
+		CSIDs:       []uint16{1, 2},
+	}
+
+	require.True(t, fqcsid.Matches(&FQCSID{NodeAddress: net.ParseIP("10.4.0.1"), CSIDs: []uint16{2}}))
+	require.False(t, fqcsid.Matches(&FQCSID{NodeAddress: net.ParseIP("10.4.0.1"), CSIDs: []uint16{3}}))
+	require.False(t, fqcsid.Matches(&FQCSID{NodeAddress: net.ParseIP("10.4.0.2"), CSIDs: []uint16{1}}))
+	require.False(t, fqcsid.Matches(nil))
+
+	var noFQCSID *FQCSID
+	require.False(t, noFQCSID.Matches(fqcsid))
+}
+
+func TestSessionFQCSID(t *testing.T) {
+	require.NotEqual(t, SessionCSID(1), SessionCSID(2))
+	require.Equal(t, SessionCSID(1), SessionCSID(1+smfCSIDs))
+
+	// the FQ-CSID of SMF covers the sets of all its PFCP sessions
+	local := GetSelf().LocalFQCSID()
+	require.Len(t, local.CSIDs, smfCSIDs)
+	for seid := uint64(1); seid <= 2*smfCSIDs; seid++ {
+		require.True(t, local.Matches(GetSelf().SessionFQCSID(seid)))
+	}
+}
+
+func TestFQCSIDToPFCP(t *testing.T) {
+	testCases := []struct {
+		name   string
+		fqcsid *FQCSID
+	}{
+		{
+			name:   "IPv4 node address",
+			fqcsid: &FQCSID{NodeAddress: net.ParseIP("10.4.0.1"), CSIDs: []uint16{1}},
+		},
+		{
+			name:   "IPv6 node address",
+			fqcsid: &FQCSID{NodeAddress: net.ParseIP("2001:db8::1"), CSIDs: []uint16{1, 65535}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pfcpFQCSID := tc.fqcsid.ToPFCP()
+			require.Equal(t, uint8(len(tc.fqcsid.CSIDs)), pfcpFQCSID.NumberOfCsids)
+			require.True(t, tc.fqcsid.Matches(FQCSIDFromPFCP(pfcpFQCSID)))
+		})
+	}
+	require.Nil(t, FQCSIDFromPFCP(nil))
+}
@@ -0,0 +1,142 @@
+package context
+
+import (
+	"fmt"
+	"net"
+
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+)
+
+// anULChooseID is the CHOOSE ID of the uplink PDRs of AN UPF, the PDRs of all data paths share the N3 tunnel
+// so UPF allocates the same F-TEID to them
+const anULChooseID uint8 = 1
+
+// AllocatesFTEID reports whether the F-TEIDs of PDRs are allocated by UPF with F-TEID CHOOSE,
+// it's configured for UPF and UPF must support FTUP
+func (upf *UPF) AllocatesFTEID() bool {
+	return upf.ChooseFTEID && upf.SupportsUPFeature(UPFeatureFTUP)
+}
+
+// chooseFTEID returns the F-TEID for UPF to allocate in the address family of the tunnel IP of UPF,
+// the PDRs with the same CHOOSE ID get the same F-TEID
+func chooseFTEID(upIP net.IP, chooseID uint8) *pfcpType.FTEID {
+	ipv6 := upIP != nil && upIP.To4() == nil
+	return &pfcpType.FTEID{
+		V4:       !ipv6,
+		V6:       ipv6,
+		Ch:       true,
+		Chid:     chooseID != 0,
+		ChooseId: chooseID,
+	}
+}
+
+// localFTEID returns the F-TEID of the PDR of the tunnel terminated at UPF,
+// which is allocated by UPF if the TEID isn't allocated by SMF
+func localFTEID(tunnel *GTPTunnel, upIP net.IP, chooseID uint8) *pfcpType.FTEID {
+	if tunnel.TEIDChosen && tunnel.TEID == 0 {
+		return chooseFTEID(upIP, chooseID)
+	}
+	return newFTEID(upIP, tunnel.TEID)
+}
+
+// SetCreatedFTEIDs sets the F-TEIDs allocated by UPF, which are in the Created PDR IEs of PFCP Session
+// Establishment or Modification Response, to the PDRs and tunnels of the data paths terminated at UPF
+// and the PDR receiving the downlink data buffered in SMF
+func (smContext *SMContext) SetCreatedFTEIDs(upf *UPF, createdFTEIDs map[uint16]*pfcpType.FTEID) {
+	if len(createdFTEIDs) == 0 || smContext.Tunnel == nil {
+		return
+	}
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+			if node.UPF != upf {
+				continue
+			}
+			for _, tunnel := range []*GTPTunnel{node.UpLinkTunnel, node.DownLinkTunnel} {
+				if tunnel == nil || tunnel.PDR == nil {
+					continue
+				}
+				if fteid, ok := createdFTEIDs[tunnel.PDR.PDRID]; ok {
+					tunnel.PDR.PDI.LocalFTeid = fteid
+					if tunnel.TEIDChosen {
+						tunnel.TEID = fteid.Teid
+					}
+				}
+			}
+		}
+	}
+	if b := smContext.DLBuffer; b != nil && b.UPF == upf {
+		if fteid, ok := createdFTEIDs[b.PDR.PDRID]; ok {
+			b.PDR.PDI.LocalFTeid = fteid
+		}
+	}
+}
+
+// UpdateFARsToCreatedFTEIDs points the N9 FARs of the neighbouring UPFs to the F-TEIDs allocated by UPFs,
+// it returns the updated FARs of each UPF which are to be sent by PFCP Session Modification
+func (smContext *SMContext) UpdateFARsToCreatedFTEIDs() map[*UPF][]*FAR {
+	updatedFARs := make(map[*UPF][]*FAR)
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		if !dataPath.Activated {
+			continue
+		}
+		for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+			if next := node.Next(); next != nil && node.UpLinkTunnel != nil {
+				if far := node.UpLinkTunnel.PDR.FAR; updateFARToFTEID(far, next.UpLinkTunnel) {
+					updatedFARs[node.UPF] = append(updatedFARs[node.UPF], far)
+				}
+			}
+			if prev := node.Prev(); prev != nil && node.DownLinkTunnel != nil {
+				if far := node.DownLinkTunnel.PDR.FAR; updateFARToFTEID(far, prev.DownLinkTunnel) {
+					updatedFARs[node.UPF] = append(updatedFARs[node.UPF], far)
+				}
+			}
+		}
+	}
+	return updatedFARs
+}
+
+// updateFARToFTEID sets the outer header of the FAR to the F-TEID of the PDR of the tunnel,
+// it reports whether the FAR is updated
+func updateFARToFTEID(far *FAR, tunnel *GTPTunnel) bool {
+	if far == nil || far.ForwardingParameters == nil || far.ForwardingParameters.OuterHeaderCreation == nil ||
+		tunnel == nil || tunnel.PDR == nil {
+		return false
+	}
+	fteid := tunnel.PDR.PDI.LocalFTeid
+	if fteid == nil || fteid.Ch {
+		return false
+	}
+	ohc := far.ForwardingParameters.OuterHeaderCreation
+	ip := FTEIDIP(fteid)
+	if ohc.Teid == fteid.Teid && OuterHeaderCreationIP(ohc).Equal(ip) {
+		return false
+	}
+	far.ForwardingParameters.OuterHeaderCreation = GTPUOuterHeaderCreation(ip, fteid.Teid)
+	if far.State == RULE_CREATE {
+		far.State = RULE_UPDATE
+	}
+	return true
+}
+
+// allocatedIP returns the IP of the F-TEID of the PDR of the tunnel, it's nil before the F-TEID is allocated
+func (tunnel *GTPTunnel) allocatedIP() net.IP {
+	if tunnel == nil || tunnel.PDR == nil {
+		return nil
+	}
+	if fteid := tunnel.PDR.PDI.LocalFTeid; fteid != nil && !fteid.Ch {
+		return FTEIDIP(fteid)
+	}
+	return nil
+}
+
+// N3TunnelIP returns the IP of the N3 uplink tunnel of AN UPF, it's the IP of the F-TEID of the uplink PDR
+// which may be allocated by UPF, or the IP of the first N3 interface before the PDR is set up
+func (node *DataPathNode) N3TunnelIP() (net.IP, error) {
+	if ip := node.UpLinkTunnel.allocatedIP(); ip != nil {
+		return ip, nil
+	}
+	if len(node.UPF.N3Interfaces) == 0 {
+		return nil, fmt.Errorf("No N3 interface of UPF")
+	}
+	return node.UPF.N3Interfaces[0].TunnelIP()
+}
@@ -0,0 +1,67 @@
+package context
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+)
+
+func TestSetCreatedFTEIDs(t *testing.T) {
+	anUPF, psaUPF := &UPF{}, &UPF{}
+	anNode := &DataPathNode{UPF: anUPF}
+	psaNode := &DataPathNode{UPF: psaUPF}
+	anNode.UpLinkTunnel = &GTPTunnel{
+		DestEndPoint: anNode,
+		PDR: &PDR{
+			PDRID: 1,
+			PDI:   PDI{LocalFTeid: chooseFTEID(nil, anULChooseID)},
+			FAR: &FAR{
+				State: RULE_CREATE,
+				ForwardingParameters: &ForwardingParameters{
+					OuterHeaderCreation: &pfcpType.OuterHeaderCreation{},
+				},
+			},
+		},
+		TEIDChosen: true,
+	}
+	anNode.DownLinkTunnel = &GTPTunnel{SrcEndPoint: psaNode, DestEndPoint: anNode}
+	psaNode.UpLinkTunnel = &GTPTunnel{
+		SrcEndPoint:  anNode,
+		DestEndPoint: psaNode,
+		PDR: &PDR{
+			PDRID: 1,
+			PDI:   PDI{LocalFTeid: chooseFTEID(nil, 0)},
+			FAR:   &FAR{State: RULE_CREATE},
+		},
+		TEIDChosen: true,
+	}
+	smContext := &SMContext{
+		Tunnel: &UPTunnel{
+			DataPathPool: DataPathPool{1: {Activated: true, FirstDPNode: anNode}},
+		},
+	}
+
+	smContext.SetCreatedFTEIDs(anUPF, map[uint16]*pfcpType.FTEID{
+		1: {V4: true, Ipv4Address: net.ParseIP("10.200.200.1").To4(), Teid: 100},
+	})
+	require.Equal(t, uint32(100), anNode.UpLinkTunnel.TEID)
+	require.Zero(t, psaNode.UpLinkTunnel.TEID)
+	ip, err := anNode.N3TunnelIP()
+	require.NoError(t, err)
+	require.Equal(t, net.ParseIP("10.200.200.1").To4(), ip)
+
+	// the UL FAR of AN UPF is updated after PSA UPF allocates the F-TEID
+	require.Empty(t, smContext.UpdateFARsToCreatedFTEIDs())
+	smContext.SetCreatedFTEIDs(psaUPF, map[uint16]*pfcpType.FTEID{
+		1: {V4: true, Ipv4Address: net.ParseIP("10.200.201.2").To4(), Teid: 200},
+	})
+	updatedFARs := smContext.UpdateFARsToCreatedFTEIDs()
+	require.Len(t, updatedFARs[anUPF], 1)
+	far := anNode.UpLinkTunnel.PDR.FAR
+	require.Equal(t, RULE_UPDATE, far.State)
+	require.Equal(t, uint32(200), far.ForwardingParameters.OuterHeaderCreation.Teid)
+	require.Empty(t, smContext.UpdateFARsToCreatedFTEIDs())
+}
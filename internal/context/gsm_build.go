@@ -26,13 +26,24 @@ func BuildGSMPDUSessionEstablishmentAccept(smContext *SMContext) ([]byte, error)
 	pDUSessionEstablishmentAccept.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
 	pDUSessionEstablishmentAccept.SetPTI(smContext.Pti)
 
+	if eapMsg := smContext.EAPMessage; eapMsg != nil {
+		pDUSessionEstablishmentAccept.EAPMessage = nasType.
+			NewEAPMessage(nasMessage.PDUSessionEstablishmentAcceptEAPMessageType)
+		pDUSessionEstablishmentAccept.EAPMessage.SetLen(uint16(len(eapMsg)))
+		pDUSessionEstablishmentAccept.EAPMessage.SetEAPMessage(eapMsg)
+	}
+
 	if v := smContext.EstAcceptCause5gSMValue; v != 0 {
 		pDUSessionEstablishmentAccept.Cause5GSM = nasType.NewCause5GSM(nasMessage.PDUSessionEstablishmentAcceptCause5GSMType)
 		pDUSessionEstablishmentAccept.Cause5GSM.SetCauseValue(v)
 	}
 	pDUSessionEstablishmentAccept.SetPDUSessionType(smContext.SelectedPDUSessionType)
 
-	pDUSessionEstablishmentAccept.SetSSCMode(1)
+	sscMode := smContext.SSCMode
+	if sscMode == 0 {
+		sscMode = 1
+	}
+	pDUSessionEstablishmentAccept.SetSSCMode(sscMode)
 	pDUSessionEstablishmentAccept.SessionAMBR = nasConvert.ModelsToSessionAMBR(sessRule.AuthSessAmbr)
 	pDUSessionEstablishmentAccept.SessionAMBR.SetLen(uint8(len(pDUSessionEstablishmentAccept.SessionAMBR.Octet)))
 
@@ -78,7 +89,8 @@ func BuildGSMPDUSessionEstablishmentAccept(smContext *SMContext) ([]byte, error)
 	pDUSessionEstablishmentAccept.AuthorizedQosRules.SetLen(uint16(len(qosRulesBytes)))
 	pDUSessionEstablishmentAccept.AuthorizedQosRules.SetQosRule(qosRulesBytes)
 
-	if smContext.PDUAddress != nil {
+	// the address of Unstructured PDU session is the N6 tunnel endpoint of UPF and it is not provided to UE
+	if smContext.PDUAddress != nil && smContext.SelectedPDUSessionType != nasMessage.PDUSessionTypeUnstructured {
 		addr, addrLen := smContext.PDUAddressToNAS()
 		pDUSessionEstablishmentAccept.PDUAddress = nasType.
 			NewPDUAddress(nasMessage.PDUSessionEstablishmentAcceptPDUAddressType)
@@ -130,7 +142,9 @@ func BuildGSMPDUSessionEstablishmentAccept(smContext *SMContext) ([]byte, error)
 	if smContext.ProtocolConfigurationOptions.DNSIPv4Request ||
 		smContext.ProtocolConfigurationOptions.DNSIPv6Request ||
 		smContext.ProtocolConfigurationOptions.PCSCFIPv4Request ||
-		smContext.ProtocolConfigurationOptions.IPv4LinkMTURequest {
+		smContext.ProtocolConfigurationOptions.PCSCFIPv6Request ||
+		smContext.ProtocolConfigurationOptions.IPv4LinkMTURequest ||
+		smContext.ProtocolConfigurationOptions.UnstructuredLinkMTURequest {
 		pDUSessionEstablishmentAccept.ExtendedProtocolConfigurationOptions = nasType.NewExtendedProtocolConfigurationOptions(
 			nasMessage.PDUSessionEstablishmentAcceptExtendedProtocolConfigurationOptionsType,
 		)
@@ -160,6 +174,14 @@ func BuildGSMPDUSessionEstablishmentAccept(smContext *SMContext) ([]byte, error)
 			}
 		}
 
+		// IPv6 PCSCF
+		if smContext.ProtocolConfigurationOptions.PCSCFIPv6Request {
+			err := addPCSCFIPv6Address(protocolConfigurationOptions, smContext.DNNInfo.PCSCF.IPv6Addr)
+			if err != nil {
+				logger.GsmLog.Warnln("Error while adding PCSCF IPv6 Addr: ", err)
+			}
+		}
+
 		// MTU
 		if smContext.ProtocolConfigurationOptions.IPv4LinkMTURequest {
 			err := protocolConfigurationOptions.AddIPv4LinkMTU(1400)
@@ -167,6 +189,11 @@ func BuildGSMPDUSessionEstablishmentAccept(smContext *SMContext) ([]byte, error)
 				logger.GsmLog.Warnln("Error while adding MTU: ", err)
 			}
 		}
+		if smContext.ProtocolConfigurationOptions.UnstructuredLinkMTURequest {
+			if n6Tunnel := smContext.N6Tunnel(); n6Tunnel != nil {
+				addUnstructuredLinkMTU(protocolConfigurationOptions, n6Tunnel.MTU)
+			}
+		}
 
 		pcoContents := protocolConfigurationOptions.Marshal()
 		pcoContentsLength := len(pcoContents)
@@ -194,6 +221,33 @@ func BuildGSMPDUSessionEstablishmentReject(smContext *SMContext, cause uint8) ([
 	pDUSessionEstablishmentReject.SetPDUSessionID(uint8(smContext.PDUSessionID))
 	pDUSessionEstablishmentReject.SetCauseValue(cause)
 
+	if eapMsg := smContext.EAPMessage; eapMsg != nil {
+		pDUSessionEstablishmentReject.EAPMessage = nasType.
+			NewEAPMessage(nasMessage.PDUSessionEstablishmentRejectEAPMessageType)
+		pDUSessionEstablishmentReject.EAPMessage.SetLen(uint16(len(eapMsg)))
+		pDUSessionEstablishmentReject.EAPMessage.SetEAPMessage(eapMsg)
+	}
+
+	return m.PlainNasEncode()
+}
+
+// BuildGSMPDUSessionAuthenticationCommand makes the PDU Session Authentication Command
+// which relays the EAP request of DN-AAA to UE, TS 24.501 8.3.5
+func BuildGSMPDUSessionAuthenticationCommand(smContext *SMContext, eapMsg []byte) ([]byte, error) {
+	m := nas.NewMessage()
+	m.GsmMessage = nas.NewGsmMessage()
+	m.GsmHeader.SetMessageType(nas.MsgTypePDUSessionAuthenticationCommand)
+	m.GsmHeader.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
+	m.PDUSessionAuthenticationCommand = nasMessage.NewPDUSessionAuthenticationCommand(0x0)
+	pDUSessionAuthenticationCommand := m.PDUSessionAuthenticationCommand
+
+	pDUSessionAuthenticationCommand.SetMessageType(nas.MsgTypePDUSessionAuthenticationCommand)
+	pDUSessionAuthenticationCommand.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
+	pDUSessionAuthenticationCommand.SetPDUSessionID(uint8(smContext.PDUSessionID))
+	pDUSessionAuthenticationCommand.SetPTI(0x00)
+	pDUSessionAuthenticationCommand.EAPMessage.SetLen(uint16(len(eapMsg)))
+	pDUSessionAuthenticationCommand.EAPMessage.SetEAPMessage(eapMsg)
+
 	return m.PlainNasEncode()
 }
 
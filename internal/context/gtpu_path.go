@@ -0,0 +1,123 @@
+package context
+
+import (
+	"net"
+)
+
+// SetGTPUPeerStatus records whether the user plane path from UPF to the remote GTP-U peer has failed,
+// which is reported by PFCP Node Report (TS 29.244 5.22). It reports whether the status has changed
+func (upf *UPF) SetGTPUPeerStatus(peer net.IP, failed bool) bool {
+	key := peer.String()
+
+	upf.gtpuPeerMu.Lock()
+	defer upf.gtpuPeerMu.Unlock()
+	if upf.failedGTPUPeers[key] == failed {
+		return false
+	}
+	if failed {
+		upf.failedGTPUPeers[key] = true
+	} else {
+		delete(upf.failedGTPUPeers, key)
+	}
+	return true
+}
+
+// IsGTPUPeerFailed reports whether the user plane path from UPF to the remote GTP-U peer has failed
+func (upf *UPF) IsGTPUPeerFailed(peer net.IP) bool {
+	upf.gtpuPeerMu.RLock()
+	defer upf.gtpuPeerMu.RUnlock()
+	return upf.failedGTPUPeers[peer.String()]
+}
+
+// isPathToUPFFailed reports whether the user plane path from UPF to any GTP-U endpoint of the peer UPF has failed
+func (upf *UPF) isPathToUPFFailed(peer *UPF) bool {
+	upf.gtpuPeerMu.RLock()
+	defer upf.gtpuPeerMu.RUnlock()
+	for key := range upf.failedGTPUPeers {
+		if peer.HasGTPUAddress(net.ParseIP(key)) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasGTPUAddress reports whether the IP is a GTP-U endpoint of the N3 or N9 interfaces of UPF
+func (upf *UPF) HasGTPUAddress(ip net.IP) bool {
+	for _, ifaces := range [][]*UPFInterfaceInfo{upf.N3Interfaces, upf.N9Interfaces} {
+		for _, iface := range ifaces {
+			for _, addr := range iface.IPv4EndPointAddresses {
+				if addr.Equal(ip) {
+					return true
+				}
+			}
+			for _, addr := range iface.IPv6EndPointAddresses {
+				if addr.Equal(ip) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// isUserPlanePathFailed reports whether the user plane path between the UP nodes
+// has been reported as failed by either of them
+func isUserPlanePathFailed(a, b *UPNode) bool {
+	return a.hasFailedPathTo(b) || b.hasFailedPathTo(a)
+}
+
+func (u *UPNode) hasFailedPathTo(peer *UPNode) bool {
+	if u.Type != UPNODE_UPF || u.UPF == nil {
+		return false
+	}
+	switch peer.Type {
+	case UPNODE_AN:
+		return peer.ANIP != nil && u.UPF.IsGTPUPeerFailed(peer.ANIP)
+	case UPNODE_UPF:
+		return peer.UPF != nil && u.UPF.isPathToUPFFailed(peer.UPF)
+	}
+	return false
+}
+
+// SMContextsOverGTPUPath returns the SM contexts whose activated data paths go through the user plane path
+// between UPF and the remote GTP-U peer, which is either the AN or another UPF of the data path
+func (upf *UPF) SMContextsOverGTPUPath(peer net.IP) []*SMContext {
+	var smContexts []*SMContext
+	smContextPool.Range(func(key, value interface{}) bool {
+		smContext := value.(*SMContext)
+		smContext.SMLock.Lock()
+		over := smContext.isOverGTPUPath(upf, peer)
+		smContext.SMLock.Unlock()
+		if over {
+			smContexts = append(smContexts, smContext)
+		}
+		return true
+	})
+	return smContexts
+}
+
+func (smContext *SMContext) isOverGTPUPath(upf *UPF, peer net.IP) bool {
+	if smContext.Tunnel == nil {
+		return false
+	}
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		if !dataPath.Activated {
+			continue
+		}
+		for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+			if node.UPF != upf {
+				continue
+			}
+			if node.IsANUPF() && peer.Equal(smContext.Tunnel.ANInformation.IPAddress) {
+				return true
+			}
+			if prev := node.Prev(); prev != nil && prev.UPF.HasGTPUAddress(peer) {
+				return true
+			}
+			if next := node.Next(); next != nil && next.UPF.HasGTPUAddress(peer) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,501 @@
+package context
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+
+	"bitbucket.org/free5gc-team/nas/nasMessage"
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+	"bitbucket.org/free5gc-team/smf/internal/util"
+	"bitbucket.org/free5gc-team/smf/pkg/factory"
+)
+
+// HsmfSessionRuleID is the ID of the session rule built from the PDU session parameters of H-SMF
+const HsmfSessionRuleID = "HsmfSessRule"
+
+// NewTunnelInfo converts a GTP-U endpoint to models.TunnelInfo (TS 29.502 6.1.6.2.17)
+func NewTunnelInfo(ip net.IP, teid uint32) *models.TunnelInfo {
+	info := &models.TunnelInfo{
+		GtpTeid: fmt.Sprintf("%08x", teid),
+	}
+	if ipv4 := ip.To4(); ipv4 != nil {
+		info.Ipv4Addr = ipv4.String()
+	} else {
+		info.Ipv6Addr = ip.String()
+	}
+	return info
+}
+
+// ParseTunnelInfo converts models.TunnelInfo to a GTP-U endpoint
+func ParseTunnelInfo(info *models.TunnelInfo) (net.IP, uint32, error) {
+	if info == nil {
+		return nil, 0, fmt.Errorf("tunnel info is nil")
+	}
+
+	var ip net.IP
+	switch {
+	case info.Ipv4Addr != "":
+		ip = net.ParseIP(info.Ipv4Addr).To4()
+	case info.Ipv6Addr != "":
+		ip = net.ParseIP(info.Ipv6Addr)
+	}
+	if ip == nil {
+		return nil, 0, fmt.Errorf("invalid tunnel address [%s][%s]", info.Ipv4Addr, info.Ipv6Addr)
+	}
+
+	teid, err := hex.DecodeString(info.GtpTeid)
+	if err != nil || len(teid) != 4 {
+		return nil, 0, fmt.Errorf("invalid gtpTeid [%s]", info.GtpTeid)
+	}
+	return ip, binary.BigEndian.Uint32(teid), nil
+}
+
+// NewSmContextCreateDataFromPduSessionCreateData keeps the PduSessionCreateData from V-SMF
+// in the SmContextCreateData form used by the SM Context
+func NewSmContextCreateDataFromPduSessionCreateData(
+	createData *models.PduSessionCreateData,
+) *models.SmContextCreateData {
+	return &models.SmContextCreateData{
+		Supi:                createData.Supi,
+		UnauthenticatedSupi: createData.UnauthenticatedSupi,
+		Pei:                 createData.Pei,
+		Gpsi:                createData.Gpsi,
+		PduSessionId:        createData.PduSessionId,
+		Dnn:                 createData.Dnn,
+		SNssai:              createData.SNssai,
+		ServingNetwork:      createData.ServingNetwork,
+		RequestType:         createData.RequestType,
+		AnType:              createData.AnType,
+		RatType:             createData.RatType,
+		UeLocation:          createData.UeLocation,
+		UeTimeZone:          createData.UeTimeZone,
+		AddUeLocation:       createData.AddUeLocation,
+		SelMode:             createData.SelMode,
+		UdmGroupId:          createData.UdmGroupId,
+		RoutingIndicator:    createData.RoutingIndicator,
+		SupportedFeatures:   createData.SupportedFeatures,
+	}
+}
+
+// BuildPduSessionCreateData builds the PduSessionCreateData toward H-SMF of a home-routed PDU session
+func (c *SMContext) BuildPduSessionCreateData(vcnTunnelInfo *models.TunnelInfo) *models.PduSessionCreateData {
+	return &models.PduSessionCreateData{
+		Supi:                c.Supi,
+		UnauthenticatedSupi: c.UnauthenticatedSupi,
+		Pei:                 c.Pei,
+		Gpsi:                c.Gpsi,
+		PduSessionId:        c.PDUSessionID,
+		Dnn:                 c.Dnn,
+		SNssai:              c.SNssai,
+		VsmfId:              GetSelf().NfInstanceID,
+		ServingNetwork:      c.ServingNetwork,
+		RequestType:         c.RequestType,
+		AnType:              c.AnType,
+		RatType:             c.RatType,
+		UeLocation:          c.UeLocation,
+		UeTimeZone:          c.UeTimeZone,
+		AddUeLocation:       c.AddUeLocation,
+		VcnTunnelInfo:       vcnTunnelInfo,
+		SelMode:             c.SelMode,
+		UdmGroupId:          c.UdmGroupId,
+		RoutingIndicator:    c.RoutingIndicator,
+		SupportedFeatures:   c.SupportedFeatures,
+	}
+}
+
+// UpdateVcnTunnelInfo sets the V-UPF N9 tunnel as the downlink destination of the H-UPF
+func (c *SMContext) UpdateVcnTunnelInfo(info *models.TunnelInfo) error {
+	ip, teid, err := ParseTunnelInfo(info)
+	if err != nil {
+		return fmt.Errorf("vcnTunnelInfo: %v", err)
+	}
+	c.Tunnel.UpdateANInformation(ip, teid)
+	return nil
+}
+
+// BuildHcnTunnelInfo returns the uplink N9 tunnel of the H-UPF
+func (c *SMContext) BuildHcnTunnelInfo() (*models.TunnelInfo, error) {
+	defaultPath := c.Tunnel.DataPathPool.GetDefaultPath()
+	if defaultPath == nil || defaultPath.FirstDPNode == nil {
+		return nil, fmt.Errorf("no default data path")
+	}
+	node := defaultPath.FirstDPNode
+	if node.UpLinkTunnel == nil {
+		return nil, fmt.Errorf("no uplink tunnel in H-UPF")
+	}
+	if ip := node.UpLinkTunnel.allocatedIP(); ip != nil {
+		return NewTunnelInfo(ip, node.UpLinkTunnel.TEID), nil
+	}
+
+	iface := node.UPF.GetInterface(models.UpInterfaceType_N9, c.Dnn)
+	if iface == nil {
+		if len(node.UPF.N3Interfaces) == 0 {
+			return nil, fmt.Errorf("no N9 or N3 interface in H-UPF")
+		}
+		iface = node.UPF.N3Interfaces[0]
+	}
+	ip, err := iface.TunnelIP()
+	if err != nil {
+		return nil, err
+	}
+	return NewTunnelInfo(ip, node.UpLinkTunnel.TEID), nil
+}
+
+// BuildQosFlowsSetupList returns the default QoS flow of the PDU session
+func (c *SMContext) BuildQosFlowsSetupList() []models.QosFlowSetupItem {
+	sessRule := c.SelectedSessionRule()
+	if sessRule == nil || sessRule.AuthDefQos == nil {
+		return nil
+	}
+	return []models.QosFlowSetupItem{
+		{
+			Qfi: int32(sessRule.DefQosQFI),
+			QosFlowProfile: &models.QosFlowProfile{
+				Var5qi: sessRule.AuthDefQos.Var5qi,
+				Arp:    sessRule.AuthDefQos.Arp,
+			},
+		},
+	}
+}
+
+// PduSessionType returns the selected PDU session type in models form
+func (c *SMContext) PduSessionType() models.PduSessionType {
+	switch c.SelectedPDUSessionType {
+	case nasMessage.PDUSessionTypeIPv6:
+		return models.PduSessionType_IPV6
+	case nasMessage.PDUSessionTypeIPv4IPv6:
+		return models.PduSessionType_IPV4_V6
+	case nasMessage.PDUSessionTypeUnstructured:
+		return models.PduSessionType_UNSTRUCTURED
+	case nasMessage.PDUSessionTypeEthernet:
+		return models.PduSessionType_ETHERNET
+	default:
+		return models.PduSessionType_IPV4
+	}
+}
+
+// SetPduSessionType sets the selected PDU session type from the models form
+func (c *SMContext) SetPduSessionType(pduType models.PduSessionType) {
+	switch pduType {
+	case models.PduSessionType_IPV6:
+		c.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv6
+	case models.PduSessionType_IPV4_V6:
+		c.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4IPv6
+	case models.PduSessionType_UNSTRUCTURED:
+		c.SelectedPDUSessionType = nasMessage.PDUSessionTypeUnstructured
+	case models.PduSessionType_ETHERNET:
+		c.SelectedPDUSessionType = nasMessage.PDUSessionTypeEthernet
+	default:
+		c.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4
+	}
+}
+
+// ActivateVsmfDataPath selects the V-UPF and allocates its N3 and N9 tunnels,
+// the forwarding rules are set by StitchN9Tunnel once the H-UPF tunnel is known
+func (c *SMContext) ActivateVsmfDataPath() error {
+	c.SelectionParam = &UPFSelectionParams{
+		Dnn: c.Dnn,
+		SNssai: &SNssai{
+			Sst: c.SNssai.Sst,
+			Sd:  c.SNssai.Sd,
+		},
+	}
+	upNode, err := GetUserPlaneInformation().SelectVUPF(c.SelectionParam)
+	if err != nil {
+		return err
+	}
+	c.SelectedUPF = upNode
+	return c.activateVsmfDataPath(upNode)
+}
+
+func (c *SMContext) activateVsmfDataPath(upNode *UPNode) error {
+	dataPath := GenerateDataPath(UPPath{upNode})
+	if dataPath == nil {
+		return fmt.Errorf("fail to generate V-UPF data path")
+	}
+	dataPath.IsDefaultPath = true
+	c.AllocateLocalSEIDForDataPath(dataPath)
+
+	node := dataPath.FirstDPNode
+	if err := node.ActivateUpLinkTunnel(c); err != nil {
+		return err
+	}
+	if err := node.ActivateDownLinkTunnel(c); err != nil {
+		node.DeactivateUpLinkTunnel(c)
+		return err
+	}
+	c.Tunnel.AddDataPath(dataPath)
+	return nil
+}
+
+// vUpfNode returns the V-UPF of a home-routed PDU session
+func (c *SMContext) vUpfNode() (*DataPathNode, error) {
+	dataPath := c.Tunnel.DataPathPool.GetDefaultPath()
+	if dataPath == nil || dataPath.FirstDPNode == nil {
+		return nil, fmt.Errorf("no V-UPF data path")
+	}
+	return dataPath.FirstDPNode, nil
+}
+
+// BuildVcnTunnelInfo returns the N9 tunnel of the V-UPF for the downlink traffic from H-UPF
+func (c *SMContext) BuildVcnTunnelInfo() (*models.TunnelInfo, error) {
+	node, err := c.vUpfNode()
+	if err != nil {
+		return nil, err
+	}
+	if ip := node.DownLinkTunnel.allocatedIP(); ip != nil {
+		return NewTunnelInfo(ip, node.DownLinkTunnel.TEID), nil
+	}
+	iface := node.UPF.GetInterface(models.UpInterfaceType_N9, c.Dnn)
+	if iface == nil {
+		return nil, fmt.Errorf("no N9 interface in V-UPF")
+	}
+	ip, err := iface.TunnelIP()
+	if err != nil {
+		return nil, err
+	}
+	return NewTunnelInfo(ip, node.DownLinkTunnel.TEID), nil
+}
+
+// ApplyPduSessionCreatedData applies the PDU session parameters decided by H-SMF and
+// stitches the V-UPF to the H-UPF N9 tunnel
+func (c *SMContext) ApplyPduSessionCreatedData(createdData *models.PduSessionCreatedData) error {
+	if createdData.SessionAmbr == nil {
+		return fmt.Errorf("no session AMBR from H-SMF")
+	}
+	if len(createdData.QosFlowsSetupList) == 0 || createdData.QosFlowsSetupList[0].QosFlowProfile == nil {
+		return fmt.Errorf("no QoS flow from H-SMF")
+	}
+	c.SetPduSessionType(createdData.PduType)
+	c.SSCMode = SscModeFromModels(models.SscMode(createdData.SscMode))
+
+	qosFlow := createdData.QosFlowsSetupList[0]
+	sessRule := NewSessionRule(&models.SessionRule{
+		SessRuleId:   HsmfSessionRuleID,
+		AuthSessAmbr: createdData.SessionAmbr,
+		AuthDefQos: &models.AuthorizedDefaultQos{
+			Var5qi: qosFlow.QosFlowProfile.Var5qi,
+			Arp:    qosFlow.QosFlowProfile.Arp,
+		},
+	})
+	sessRule.DefQosQFI = uint8(qosFlow.Qfi)
+	c.SessionRules[HsmfSessionRuleID] = sessRule
+	c.SelectedSessionRuleID = HsmfSessionRuleID
+
+	if err := c.setPDUAddress(createdData.UeIpv4Address, createdData.UeIpv6Prefix); err != nil {
+		return err
+	}
+	return c.StitchN9Tunnel(createdData.HcnTunnelInfo)
+}
+
+// setPDUAddress sets the UE IPv4 address and IPv6 prefix allocated by another SMF
+func (c *SMContext) setPDUAddress(ueIpv4Address, ueIpv6Prefix string) error {
+	if ueIpv4Address != "" {
+		ip := net.ParseIP(ueIpv4Address).To4()
+		if ip == nil {
+			return fmt.Errorf("invalid ueIpv4Address [%s]", ueIpv4Address)
+		}
+		c.PDUAddress = ip
+	}
+	if ueIpv6Prefix != "" {
+		_, ipNet, err := net.ParseCIDR(ueIpv6Prefix)
+		if err != nil || ipNet.IP.To4() != nil {
+			return fmt.Errorf("invalid ueIpv6Prefix [%s]", ueIpv6Prefix)
+		}
+		if c.PDUAddress != nil {
+			// IPv4v6 PDU session
+			c.PDUAddressIPv6 = ipNet.IP
+		} else {
+			c.PDUAddress = ipNet.IP
+		}
+	}
+	return nil
+}
+
+// BuildHsmfUpdatedQos sets the session AMBR and the QoS flows of the PDU session
+// for V-SMF to update the V-UPF and AN
+func (c *SMContext) BuildHsmfUpdatedQos(updatedData *models.HsmfUpdatedData) {
+	if sessRule := c.SelectedSessionRule(); sessRule != nil {
+		updatedData.SessionAmbr = sessRule.AuthSessAmbr
+	}
+	updatedData.QosFlowsSetupList = c.BuildQosFlowsSetupList()
+	for _, qosFlow := range c.AdditonalQosFlows {
+		updatedData.QosFlowsSetupList = append(updatedData.QosFlowsSetupList, models.QosFlowSetupItem{
+			Qfi: int32(qosFlow.QFI),
+			QosFlowProfile: &models.QosFlowProfile{
+				Var5qi: qosFlow.QoSProfile.Var5qi,
+				Arp:    qosFlow.QoSProfile.Arp,
+			},
+		})
+	}
+}
+
+// ApplyHsmfUpdatedData applies the session AMBR and the QoS flows modified by H-SMF.
+// It returns the V-UPF QERs to be updated and whether any QoS flow is to be added or modified in AN
+func (c *SMContext) ApplyHsmfUpdatedData(updatedData *models.HsmfUpdatedData) ([]*QER, bool, error) {
+	if updatedData.SessionAmbr == nil && len(updatedData.QosFlowsSetupList) == 0 {
+		return nil, false, nil
+	}
+	sessRule := c.SessionRules[HsmfSessionRuleID]
+	if sessRule == nil {
+		return nil, false, fmt.Errorf("no session rule from H-SMF")
+	}
+
+	qfis := make(map[uint8]bool)
+	anModified := false
+	for _, item := range updatedData.QosFlowsSetupList {
+		if item.QosFlowProfile == nil {
+			return nil, false, fmt.Errorf("no QoS flow profile of QFI[%d]", item.Qfi)
+		}
+		qfi := uint8(item.Qfi)
+		qfis[qfi] = true
+		if qfi == sessRule.DefQosQFI {
+			sessRule.AuthDefQos.Var5qi = item.QosFlowProfile.Var5qi
+			sessRule.AuthDefQos.Arp = item.QosFlowProfile.Arp
+			continue
+		}
+
+		qosFlow, ok := c.AdditonalQosFlows[qfi]
+		if !ok {
+			c.AddQosFlow(qfi, &models.QosData{
+				QosId:  strconv.Itoa(int(qfi)),
+				Var5qi: item.QosFlowProfile.Var5qi,
+				Arp:    item.QosFlowProfile.Arp,
+			})
+			anModified = true
+		} else if qosFlow.QoSProfile.Var5qi != item.QosFlowProfile.Var5qi ||
+			!reflect.DeepEqual(qosFlow.QoSProfile.Arp, item.QosFlowProfile.Arp) {
+			qosFlow.QoSProfile.Var5qi = item.QosFlowProfile.Var5qi
+			qosFlow.QoSProfile.Arp = item.QosFlowProfile.Arp
+			qosFlow.State = QoSFlowToBeModify
+			anModified = true
+		}
+	}
+	if len(updatedData.QosFlowsSetupList) > 0 {
+		// the QoS flows not in the list are released by H-SMF
+		for qfi := range c.AdditonalQosFlows {
+			if !qfis[qfi] {
+				c.RemoveQosFlow(qfi)
+			}
+		}
+	}
+
+	if updatedData.SessionAmbr == nil || reflect.DeepEqual(updatedData.SessionAmbr, sessRule.AuthSessAmbr) {
+		return nil, anModified, nil
+	}
+	sessRule.AuthSessAmbr = updatedData.SessionAmbr
+
+	node, err := c.vUpfNode()
+	if err != nil {
+		return nil, anModified, err
+	}
+	qerID, ok := c.AMBRQerMap[node.UPF.GetUUID()]
+	if !ok {
+		return nil, anModified, nil
+	}
+	for _, qer := range node.UpLinkTunnel.PDR.QER {
+		if qer.QERID == qerID {
+			qer.MBR = &pfcpType.MBR{
+				ULMBR: util.BitRateTokbps(sessRule.AuthSessAmbr.Uplink),
+				DLMBR: util.BitRateTokbps(sessRule.AuthSessAmbr.Downlink),
+			}
+			qer.State = RULE_UPDATE
+			return []*QER{qer}, anModified, nil
+		}
+	}
+	return nil, anModified, nil
+}
+
+// StitchN9Tunnel sets up the V-UPF forwarding rules between AN and the N9 tunnel of H-UPF
+func (c *SMContext) StitchN9Tunnel(hcnTunnelInfo *models.TunnelInfo) error {
+	hIP, hTeid, err := ParseTunnelInfo(hcnTunnelInfo)
+	if err != nil {
+		return fmt.Errorf("hcnTunnelInfo: %v", err)
+	}
+	node, err := c.vUpfNode()
+	if err != nil {
+		return err
+	}
+	dataPath := c.Tunnel.DataPathPool.GetDefaultPath()
+
+	n3 := node.UPF.GetInterface(models.UpInterfaceType_N3, c.Dnn)
+	n9 := node.UPF.GetInterface(models.UpInterfaceType_N9, c.Dnn)
+	if n3 == nil || n9 == nil {
+		return fmt.Errorf("V-UPF should have both N3 and N9 interfaces of DNN[%s]", c.Dnn)
+	}
+	n3IP, err := n3.TunnelIP()
+	if err != nil {
+		return err
+	}
+	n9IP, err := n9.TunnelIP()
+	if err != nil {
+		return err
+	}
+
+	var qers []*QER
+	if sessRule := c.SelectedSessionRule(); sessRule != nil && sessRule.AuthSessAmbr != nil {
+		qer, err := node.UPF.AddQER()
+		if err != nil {
+			return err
+		}
+		qer.QFI.QFI = sessRule.DefQosQFI
+		qer.GateStatus = &pfcpType.GateStatus{
+			ULGate: pfcpType.GateOpen,
+			DLGate: pfcpType.GateOpen,
+		}
+		qer.MBR = &pfcpType.MBR{
+			ULMBR: util.BitRateTokbps(sessRule.AuthSessAmbr.Uplink),
+			DLMBR: util.BitRateTokbps(sessRule.AuthSessAmbr.Downlink),
+		}
+		c.AMBRQerMap[node.UPF.GetUUID()] = qer.QERID
+		qers = append(qers, qer)
+	}
+	networkInstance := &pfcpType.NetworkInstance{
+		NetworkInstance: c.Dnn,
+		FQDNEncoding:    factory.SmfConfig.Configuration.NwInstFqdnEncoding,
+	}
+
+	// Uplink: N3 tunnel from AN -> N9 tunnel to H-UPF
+	ULPDR := node.UpLinkTunnel.PDR
+	ULPDR.Precedence = DefaultPrecedence
+	ULPDR.QER = qers
+	ULPDR.PDI = PDI{
+		SourceInterface: pfcpType.SourceInterface{InterfaceValue: pfcpType.SourceInterfaceAccess},
+		LocalFTeid:      localFTEID(node.UpLinkTunnel, n3IP, anULChooseID),
+		NetworkInstance: networkInstance,
+	}
+	ULPDR.OuterHeaderRemoval = gtpuOuterHeaderRemoval(n3IP)
+	ULPDR.FAR.ApplyAction = pfcpType.ApplyAction{Forw: true}
+	ULPDR.FAR.ForwardingParameters = &ForwardingParameters{
+		DestinationInterface: pfcpType.DestinationInterface{InterfaceValue: pfcpType.DestinationInterfaceCore},
+		NetworkInstance:      networkInstance,
+		OuterHeaderCreation:  GTPUOuterHeaderCreation(hIP, hTeid),
+	}
+
+	// Downlink: N9 tunnel from H-UPF -> N3 tunnel to AN
+	DLPDR := node.DownLinkTunnel.PDR
+	DLPDR.Precedence = DefaultPrecedence
+	DLPDR.QER = qers
+	DLPDR.PDI = PDI{
+		SourceInterface: pfcpType.SourceInterface{InterfaceValue: pfcpType.SourceInterfaceCore},
+		LocalFTeid:      localFTEID(node.DownLinkTunnel, n9IP, 0),
+		NetworkInstance: networkInstance,
+	}
+	DLPDR.OuterHeaderRemoval = gtpuOuterHeaderRemoval(n9IP)
+	DLPDR.FAR.ApplyAction = pfcpType.ApplyAction{Forw: true}
+	DLPDR.FAR.ForwardingParameters = &ForwardingParameters{
+		DestinationInterface: pfcpType.DestinationInterface{InterfaceValue: pfcpType.DestinationInterfaceAccess},
+		NetworkInstance:      networkInstance,
+	}
+	if anIP := c.Tunnel.ANInformation.IPAddress; anIP != nil {
+		DLPDR.FAR.ForwardingParameters.OuterHeaderCreation = GTPUOuterHeaderCreation(anIP, c.Tunnel.ANInformation.TEID)
+	}
+
+	dataPath.Activated = true
+	return nil
+}
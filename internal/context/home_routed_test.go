@@ -0,0 +1,186 @@
+package context
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+)
+
+func TestTunnelInfo(t *testing.T) {
+	info := NewTunnelInfo(net.ParseIP("10.200.200.102"), 0x12ab)
+	require.Equal(t, &models.TunnelInfo{Ipv4Addr: "10.200.200.102", GtpTeid: "000012ab"}, info)
+
+	ip, teid, err := ParseTunnelInfo(info)
+	require.NoError(t, err)
+	require.Equal(t, net.ParseIP("10.200.200.102").To4(), ip)
+	require.Equal(t, uint32(0x12ab), teid)
+
+	ip, teid, err = ParseTunnelInfo(NewTunnelInfo(net.ParseIP("2001:db8::1"), 1))
+	require.NoError(t, err)
+	require.Equal(t, net.ParseIP("2001:db8::1"), ip)
+	require.Equal(t, uint32(1), teid)
+
+	for _, invalid := range []*models.TunnelInfo{
+		nil,
+		{GtpTeid: "00000001"},
+		{Ipv4Addr: "10.200.200.102", GtpTeid: "1"},
+		{Ipv4Addr: "10.200.200.102", GtpTeid: "zzzzzzzz"},
+	} {
+		_, _, err = ParseTunnelInfo(invalid)
+		require.Error(t, err)
+	}
+}
+
+func TestNewSmContextCreateDataFromPduSessionCreateData(t *testing.T) {
+	createData := &models.PduSessionCreateData{
+		Supi:           "imsi-208930000000001",
+		PduSessionId:   1,
+		Dnn:            "internet",
+		SNssai:         &models.Snssai{Sst: 1, Sd: "010203"},
+		ServingNetwork: &models.PlmnId{Mcc: "466", Mnc: "92"},
+		AnType:         models.AccessType__3_GPP_ACCESS,
+		VsmfId:         "vsmf-1",
+	}
+	smCreateData := NewSmContextCreateDataFromPduSessionCreateData(createData)
+	require.Equal(t, createData.Supi, smCreateData.Supi)
+	require.Equal(t, createData.PduSessionId, smCreateData.PduSessionId)
+	require.Equal(t, createData.Dnn, smCreateData.Dnn)
+	require.Equal(t, createData.SNssai, smCreateData.SNssai)
+	require.Equal(t, createData.ServingNetwork, smCreateData.ServingNetwork)
+	require.Equal(t, createData.AnType, smCreateData.AnType)
+}
+
+func TestVsmfDataPath(t *testing.T) {
+	initConfig()
+	smfContext := GetSelf()
+	smfContext.UserPlaneInformation = NewUserPlaneInformation(&userPlaneConfig)
+	for _, n := range smfContext.UserPlaneInformation.UPFs {
+		n.UPF.UPFStatus = AssociatedSetUpSuccess
+	}
+
+	smctx := NewSMContext("imsi-208930000000003", 10)
+	defer RemoveSMContext(smctx.Ref)
+	smctx.SmContextCreateData = &models.SmContextCreateData{
+		Supi:         "imsi-208930000000003",
+		PduSessionId: 10,
+		Dnn:          "internet",
+		SNssai: &models.Snssai{
+			Sst: 1,
+			Sd:  "010203",
+		},
+		AnType: models.AccessType__3_GPP_ACCESS,
+	}
+	smctx.SelectedPDUSessionType = 1
+	smctx.Role = SMFRoleVSMF
+
+	// UPF1 is the only UPF next to AN with N9 interface
+	require.NoError(t, smctx.ActivateVsmfDataPath())
+	require.Equal(t, "10.4.0.11", smctx.SelectedUPF.NodeID.ResolveNodeIdToIp().String())
+	require.Nil(t, smctx.PDUAddress)
+
+	vcnTunnelInfo, err := smctx.BuildVcnTunnelInfo()
+	require.NoError(t, err)
+	require.Equal(t, "10.3.0.11", vcnTunnelInfo.Ipv4Addr)
+
+	createData := smctx.BuildPduSessionCreateData(vcnTunnelInfo)
+	require.Equal(t, vcnTunnelInfo, createData.VcnTunnelInfo)
+	require.Equal(t, int32(10), createData.PduSessionId)
+
+	require.Error(t, smctx.ApplyPduSessionCreatedData(&models.PduSessionCreatedData{
+		PduType:       models.PduSessionType_IPV4,
+		HcnTunnelInfo: NewTunnelInfo(net.ParseIP("10.100.0.1"), 0x10),
+	}))
+
+	require.NoError(t, smctx.ApplyPduSessionCreatedData(&models.PduSessionCreatedData{
+		PduType:       models.PduSessionType_IPV4,
+		HcnTunnelInfo: NewTunnelInfo(net.ParseIP("10.100.0.1"), 0x10),
+		UeIpv4Address: "10.60.0.1",
+		SessionAmbr: &models.Ambr{
+			Uplink:   "1000 Kbps",
+			Downlink: "1000 Kbps",
+		},
+		QosFlowsSetupList: []models.QosFlowSetupItem{
+			{
+				Qfi: 2,
+				QosFlowProfile: &models.QosFlowProfile{
+					Var5qi: 9,
+					Arp:    &models.Arp{PriorityLevel: 8},
+				},
+			},
+		},
+	}))
+	require.Equal(t, uint8(2), smctx.SelectedSessionRule().DefQosQFI)
+	require.Equal(t, net.ParseIP("10.60.0.1").To4(), smctx.PDUAddress)
+
+	dataPath := smctx.Tunnel.DataPathPool.GetDefaultPath()
+	require.True(t, dataPath.Activated)
+	node := dataPath.FirstDPNode
+
+	ulPDR := node.UpLinkTunnel.PDR
+	require.Equal(t, pfcpType.SourceInterfaceAccess, ulPDR.PDI.SourceInterface.InterfaceValue)
+	require.Equal(t, node.UpLinkTunnel.TEID, ulPDR.PDI.LocalFTeid.Teid)
+	ulOHC := ulPDR.FAR.ForwardingParameters.OuterHeaderCreation
+	require.Equal(t, net.ParseIP("10.100.0.1").To4(), ulOHC.Ipv4Address)
+	require.Equal(t, uint32(0x10), ulOHC.Teid)
+	require.Equal(t, uint8(2), ulPDR.QER[0].QFI.QFI)
+
+	dlPDR := node.DownLinkTunnel.PDR
+	require.Equal(t, pfcpType.SourceInterfaceCore, dlPDR.PDI.SourceInterface.InterfaceValue)
+	require.Equal(t, net.ParseIP("10.3.0.11").To4(), dlPDR.PDI.LocalFTeid.Ipv4Address)
+	require.Nil(t, dlPDR.FAR.ForwardingParameters.OuterHeaderCreation)
+
+	// AN tunnel from PDU Session Resource Setup Response
+	smctx.Tunnel.UpdateANInformation(net.ParseIP("10.1.0.1"), 0x20)
+	dlOHC := dlPDR.FAR.ForwardingParameters.OuterHeaderCreation
+	require.Equal(t, net.ParseIP("10.1.0.1").To4(), dlOHC.Ipv4Address)
+	require.Equal(t, uint32(0x20), dlOHC.Teid)
+
+	// nothing is modified by H-SMF
+	qers, anModified, err := smctx.ApplyHsmfUpdatedData(&models.HsmfUpdatedData{})
+	require.NoError(t, err)
+	require.Nil(t, qers)
+	require.False(t, anModified)
+
+	// H-SMF modifies the session AMBR and adds a QoS flow
+	qers, anModified, err = smctx.ApplyHsmfUpdatedData(&models.HsmfUpdatedData{
+		SessionAmbr: &models.Ambr{
+			Uplink:   "2000 Kbps",
+			Downlink: "2000 Kbps",
+		},
+		QosFlowsSetupList: []models.QosFlowSetupItem{
+			{
+				Qfi: 2,
+				QosFlowProfile: &models.QosFlowProfile{
+					Var5qi: 9,
+					Arp:    &models.Arp{PriorityLevel: 8},
+				},
+			},
+			{
+				Qfi: 3,
+				QosFlowProfile: &models.QosFlowProfile{
+					Var5qi: 5,
+					Arp:    &models.Arp{PriorityLevel: 1},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.True(t, anModified)
+	require.Len(t, qers, 1)
+	require.Equal(t, ulPDR.QER[0], qers[0])
+	require.Equal(t, RULE_UPDATE, qers[0].State)
+	require.Equal(t, uint64(2000), qers[0].MBR.ULMBR)
+	require.Contains(t, smctx.AdditonalQosFlows, uint8(3))
+
+	// the QoS flow is released by H-SMF
+	_, anModified, err = smctx.ApplyHsmfUpdatedData(&models.HsmfUpdatedData{
+		QosFlowsSetupList: smctx.BuildQosFlowsSetupList(),
+	})
+	require.NoError(t, err)
+	require.False(t, anModified)
+	require.Empty(t, smctx.AdditonalQosFlows)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	"bitbucket.org/free5gc-team/aper"
+	"bitbucket.org/free5gc-team/nas/nasMessage"
 	"bitbucket.org/free5gc-team/ngap/ngapConvert"
 	"bitbucket.org/free5gc-team/ngap/ngapType"
 	"bitbucket.org/free5gc-team/openapi/models"
@@ -12,9 +13,24 @@ import (
 
 const DefaultNonGBR5QI = 9
 
+// ngapPDUSessionType converts the PDU session type of NAS to NGAP
+func ngapPDUSessionType(pduSessionType uint8) aper.Enumerated {
+	switch pduSessionType {
+	case nasMessage.PDUSessionTypeIPv6:
+		return ngapType.PDUSessionTypePresentIpv6
+	case nasMessage.PDUSessionTypeIPv4IPv6:
+		return ngapType.PDUSessionTypePresentIpv4v6
+	case nasMessage.PDUSessionTypeEthernet:
+		return ngapType.PDUSessionTypePresentEthernet
+	case nasMessage.PDUSessionTypeUnstructured:
+		return ngapType.PDUSessionTypePresentUnstructured
+	default:
+		return ngapType.PDUSessionTypePresentIpv4
+	}
+}
+
 func BuildPDUSessionResourceSetupRequestTransfer(ctx *SMContext) ([]byte, error) {
 	ANUPF := ctx.Tunnel.DataPathPool.GetDefaultPath().FirstDPNode
-	UpNode := ANUPF.UPF
 	teidOct := make([]byte, 4)
 	binary.BigEndian.PutUint32(teidOct, ANUPF.UpLinkTunnel.TEID)
 
@@ -47,7 +63,7 @@ func BuildPDUSessionResourceSetupRequestTransfer(ctx *SMContext) ([]byte, error)
 	ie = ngapType.PDUSessionResourceSetupRequestTransferIEs{}
 	ie.Id.Value = ngapType.ProtocolIEIDULNGUUPTNLInformation
 	ie.Criticality.Value = ngapType.CriticalityPresentReject
-	if n3IP, err := UpNode.N3Interfaces[0].IP(ctx.SelectedPDUSessionType); err != nil {
+	if n3IP, err := ANUPF.N3TunnelIP(); err != nil {
 		return nil, err
 	} else {
 		ie.Value = ngapType.PDUSessionResourceSetupRequestTransferIEsValue{
@@ -76,7 +92,7 @@ func BuildPDUSessionResourceSetupRequestTransfer(ctx *SMContext) ([]byte, error)
 	ie.Value = ngapType.PDUSessionResourceSetupRequestTransferIEsValue{
 		Present: ngapType.PDUSessionResourceSetupRequestTransferIEsPresentPDUSessionType,
 		PDUSessionType: &ngapType.PDUSessionType{
-			Value: ngapType.PDUSessionTypePresentIpv4,
+			Value: ngapPDUSessionType(ctx.SelectedPDUSessionType),
 		},
 	}
 	resourceSetupRequestTransfer.ProtocolIEs.List = append(resourceSetupRequestTransfer.ProtocolIEs.List, ie)
@@ -225,10 +241,74 @@ func BuildPDUSessionResourceModifyRequestTransfer(ctx *SMContext) ([]byte, error
 	}
 }
 
+// BuildPDUSessionResourceModifyULTunnelRequestTransfer moves the UL NG-U tunnel of AN to the AN UPF
+// of the default data path, e.g. after the PDU session is rerouted (TS 38.413 9.3.4.3)
+func BuildPDUSessionResourceModifyULTunnelRequestTransfer(ctx *SMContext) ([]byte, error) {
+	ANUPF := ctx.Tunnel.DataPathPool.GetDefaultPath().FirstDPNode
+	n3IP, err := ANUPF.N3TunnelIP()
+	if err != nil {
+		return nil, err
+	}
+	ulTeidOct := make([]byte, 4)
+	binary.BigEndian.PutUint32(ulTeidOct, ANUPF.UpLinkTunnel.TEID)
+
+	anIP := ctx.Tunnel.ANInformation.IPAddress
+	if ipv4 := anIP.To4(); ipv4 != nil {
+		anIP = ipv4
+	}
+	dlTeidOct := make([]byte, 4)
+	binary.BigEndian.PutUint32(dlTeidOct, ctx.Tunnel.ANInformation.TEID)
+
+	resourceModifyRequestTransfer := ngapType.PDUSessionResourceModifyRequestTransfer{}
+
+	// UL NG-U UP TNL Modify List
+	ie := ngapType.PDUSessionResourceModifyRequestTransferIEs{}
+	ie.Id.Value = ngapType.ProtocolIEIDULNGUUPTNLModifyList
+	ie.Criticality.Value = ngapType.CriticalityPresentReject
+	ie.Value.Present = ngapType.PDUSessionResourceModifyRequestTransferIEsPresentULNGUUPTNLModifyList
+	ie.Value.ULNGUUPTNLModifyList = &ngapType.ULNGUUPTNLModifyList{
+		List: []ngapType.ULNGUUPTNLModifyItem{
+			{
+				ULNGUUPTNLInformation: ngapType.UPTransportLayerInformation{
+					Present: ngapType.UPTransportLayerInformationPresentGTPTunnel,
+					GTPTunnel: &ngapType.GTPTunnel{
+						TransportLayerAddress: ngapType.TransportLayerAddress{
+							Value: aper.BitString{
+								Bytes:     n3IP,
+								BitLength: uint64(len(n3IP) * 8),
+							},
+						},
+						GTPTEID: ngapType.GTPTEID{Value: ulTeidOct},
+					},
+				},
+				DLNGUUPTNLInformation: ngapType.UPTransportLayerInformation{
+					Present: ngapType.UPTransportLayerInformationPresentGTPTunnel,
+					GTPTunnel: &ngapType.GTPTunnel{
+						TransportLayerAddress: ngapType.TransportLayerAddress{
+							Value: aper.BitString{
+								Bytes:     anIP,
+								BitLength: uint64(len(anIP) * 8),
+							},
+						},
+						GTPTEID: ngapType.GTPTEID{Value: dlTeidOct},
+					},
+				},
+			},
+		},
+	}
+
+	resourceModifyRequestTransfer.ProtocolIEs.List = append(resourceModifyRequestTransfer.ProtocolIEs.List, ie)
+
+	if buf, err := aper.MarshalWithParams(resourceModifyRequestTransfer, "valueExt"); err != nil {
+		return nil, fmt.Errorf("encode resourceModifyRequestTransfer failed: %s", err)
+	} else {
+		return buf, nil
+	}
+}
+
 // TS 38.413 9.3.4.9
 func BuildPathSwitchRequestAcknowledgeTransfer(ctx *SMContext) ([]byte, error) {
 	ANUPF := ctx.Tunnel.DataPathPool.GetDefaultPath().FirstDPNode
-	UpNode := ANUPF.UPF
 	teidOct := make([]byte, 4)
 	binary.BigEndian.PutUint32(teidOct, ANUPF.UpLinkTunnel.TEID)
 
@@ -242,7 +322,7 @@ func BuildPathSwitchRequestAcknowledgeTransfer(ctx *SMContext) ([]byte, error) {
 	ULNGUUPTNLInformation.Present = ngapType.UPTransportLayerInformationPresentGTPTunnel
 	ULNGUUPTNLInformation.GTPTunnel = new(ngapType.GTPTunnel)
 
-	if n3IP, err := UpNode.N3Interfaces[0].IP(ctx.SelectedPDUSessionType); err != nil {
+	if n3IP, err := ANUPF.N3TunnelIP(); err != nil {
 		return nil, err
 	} else {
 		gtpTunnel := ULNGUUPTNLInformation.GTPTunnel
@@ -370,7 +450,7 @@ func BuildHandoverCommandTransfer(ctx *SMContext) ([]byte, error) {
 		handoverCommandTransfer.DLForwardingUPTNLInformation.Present = ngapType.UPTransportLayerInformationPresentGTPTunnel
 		handoverCommandTransfer.DLForwardingUPTNLInformation.GTPTunnel = new(ngapType.GTPTunnel)
 
-		if n3IP, err := UpNode.N3Interfaces[0].IP(ctx.SelectedPDUSessionType); err != nil {
+		if n3IP, err := UpNode.N3Interfaces[0].TunnelIP(); err != nil {
 			return nil, err
 		} else {
 			gtpTunnel := handoverCommandTransfer.DLForwardingUPTNLInformation.GTPTunnel
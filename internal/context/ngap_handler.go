@@ -59,7 +59,7 @@ func HandlePDUSessionResourceSetupResponseTransfer(b []byte, ctx *SMContext) (er
 	GTPTunnel := QosFlowPerTNLInformation.UPTransportLayerInformation.GTPTunnel
 
 	ctx.Tunnel.UpdateANInformation(
-		GTPTunnel.TransportLayerAddress.Value.Bytes,
+		TransportLayerIP(GTPTunnel.TransportLayerAddress.Value.Bytes),
 		binary.BigEndian.Uint32(GTPTunnel.GTPTEID.Value))
 
 	ctx.UpCnxState = models.UpCnxState_ACTIVATED
@@ -81,7 +81,7 @@ func HandlePDUSessionResourceModifyResponseTransfer(b []byte, ctx *SMContext) er
 		GTPTunnel := DLInfo.GTPTunnel
 
 		ctx.Tunnel.UpdateANInformation(
-			GTPTunnel.TransportLayerAddress.Value.Bytes,
+			TransportLayerIP(GTPTunnel.TransportLayerAddress.Value.Bytes),
 			binary.BigEndian.Uint32(GTPTunnel.GTPTEID.Value))
 	}
 
@@ -154,7 +154,7 @@ func HandlePathSwitchRequestTransfer(b []byte, ctx *SMContext) error {
 	GTPTunnel := pathSwitchRequestTransfer.DLNGUUPTNLInformation.GTPTunnel
 
 	ctx.Tunnel.UpdateANInformation(
-		GTPTunnel.TransportLayerAddress.Value.Bytes,
+		TransportLayerIP(GTPTunnel.TransportLayerAddress.Value.Bytes),
 		binary.BigEndian.Uint32(GTPTunnel.GTPTEID.Value))
 
 	ctx.UpSecurityFromPathSwitchRequestSameAsLocalStored = true
@@ -237,7 +237,7 @@ func HandleHandoverRequestAcknowledgeTransfer(b []byte, ctx *SMContext) (err err
 	DLNGUUPGTPTunnel := handoverRequestAcknowledgeTransfer.DLNGUUPTNLInformation.GTPTunnel
 
 	ctx.Tunnel.UpdateANInformation(
-		DLNGUUPGTPTunnel.TransportLayerAddress.Value.Bytes,
+		TransportLayerIP(DLNGUUPGTPTunnel.TransportLayerAddress.Value.Bytes),
 		binary.BigEndian.Uint32(DLNGUUPGTPTunnel.GTPTEID.Value))
 
 	DLForwardingInfo := handoverRequestAcknowledgeTransfer.DLForwardingUPTNLInformation
@@ -271,14 +271,9 @@ func HandleHandoverRequestAcknowledgeTransfer(b []byte, ctx *SMContext) (err err
 		} else {
 			ctx.IndirectForwardingTunnel.FirstDPNode.UpLinkTunnel.TEID = teid
 			ctx.IndirectForwardingTunnel.FirstDPNode.UpLinkTunnel.PDR = indirectFowardingPDR
-			indirectFowardingPDR.PDI.LocalFTeid = &pfcpType.FTEID{
-				V4:          originPDR.PDI.LocalFTeid.V4,
-				Teid:        ctx.IndirectForwardingTunnel.FirstDPNode.UpLinkTunnel.TEID,
-				Ipv4Address: originPDR.PDI.LocalFTeid.Ipv4Address,
-			}
-			indirectFowardingPDR.OuterHeaderRemoval = &pfcpType.OuterHeaderRemoval{
-				OuterHeaderRemovalDescription: pfcpType.OuterHeaderRemovalGtpUUdpIpv4,
-			}
+			upIP := FTEIDIP(originPDR.PDI.LocalFTeid)
+			indirectFowardingPDR.PDI.LocalFTeid = newFTEID(upIP, ctx.IndirectForwardingTunnel.FirstDPNode.UpLinkTunnel.TEID)
+			indirectFowardingPDR.OuterHeaderRemoval = gtpuOuterHeaderRemoval(upIP)
 
 			indirectFowardingPDR.FAR.ApplyAction = pfcpType.ApplyAction{
 				Forw: true,
@@ -287,11 +282,9 @@ func HandleHandoverRequestAcknowledgeTransfer(b []byte, ctx *SMContext) (err err
 				DestinationInterface: pfcpType.DestinationInterface{
 					InterfaceValue: pfcpType.DestinationInterfaceAccess,
 				},
-				OuterHeaderCreation: &pfcpType.OuterHeaderCreation{
-					OuterHeaderCreationDescription: pfcpType.OuterHeaderCreationGtpUUdpIpv4,
-					Teid:                           binary.BigEndian.Uint32(DLForwardingGTPTunnel.GTPTEID.Value),
-					Ipv4Address:                    DLForwardingGTPTunnel.TransportLayerAddress.Value.Bytes,
-				},
+				OuterHeaderCreation: GTPUOuterHeaderCreation(
+					TransportLayerIP(DLForwardingGTPTunnel.TransportLayerAddress.Value.Bytes),
+					binary.BigEndian.Uint32(DLForwardingGTPTunnel.GTPTEID.Value)),
 			}
 		}
 	} else if ctx.DLForwardingType == DirectForwarding {
@@ -0,0 +1,254 @@
+package context
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+	"bitbucket.org/free5gc-team/smf/pkg/factory"
+)
+
+const (
+	DefaultNotificationMaxRetryTimes  = 3
+	DefaultNotificationInitialBackoff = 500 * time.Millisecond
+	DefaultNotificationMaxBackoff     = 8 * time.Second
+	DefaultNotificationMaxQueueLength = 1024
+	DefaultNotificationMaxDeadLetters = 256
+)
+
+// Notification is a notification to be delivered by the NotificationDispatcher
+type Notification struct {
+	// Uri is the callback URI, notifications to the same URI are delivered in order
+	Uri string
+	// Key identifies the notification source (e.g. subscription ID) and is used for muting
+	Key string
+	// Send delivers the notification, a non-nil error triggers a retry
+	Send func() error
+
+	Attempts int
+	LastErr  error
+	Created  time.Time
+}
+
+// DeadLetter is a notification which could not be delivered
+type DeadLetter struct {
+	Uri      string
+	Key      string
+	Attempts int
+	Reason   string
+	Created  time.Time
+	Dropped  time.Time
+}
+
+type notificationQueue struct {
+	items   []*Notification
+	running bool
+}
+
+// NotificationDispatcher delivers notifications with per-URI queues and bounded retries
+type NotificationDispatcher struct {
+	MaxRetryTimes  int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	MaxQueueLength int
+	MaxDeadLetters int
+
+	mu          sync.Mutex
+	queues      map[string]*notificationQueue // key: Uri
+	muted       map[string][]*Notification    // key: Notification.Key
+	deadLetters []*DeadLetter
+}
+
+var (
+	notificationDispatcher     *NotificationDispatcher
+	notificationDispatcherOnce sync.Once
+)
+
+func NewNotificationDispatcher(cfg *factory.NotificationDelivery) *NotificationDispatcher {
+	d := &NotificationDispatcher{
+		MaxRetryTimes:  DefaultNotificationMaxRetryTimes,
+		InitialBackoff: DefaultNotificationInitialBackoff,
+		MaxBackoff:     DefaultNotificationMaxBackoff,
+		MaxQueueLength: DefaultNotificationMaxQueueLength,
+		MaxDeadLetters: DefaultNotificationMaxDeadLetters,
+		queues:         make(map[string]*notificationQueue),
+		muted:          make(map[string][]*Notification),
+	}
+	if cfg != nil {
+		if cfg.MaxRetryTimes > 0 {
+			d.MaxRetryTimes = cfg.MaxRetryTimes
+		}
+		if cfg.InitialBackoff > 0 {
+			d.InitialBackoff = cfg.InitialBackoff
+		}
+		if cfg.MaxBackoff > 0 {
+			d.MaxBackoff = cfg.MaxBackoff
+		}
+		if cfg.MaxQueueLength > 0 {
+			d.MaxQueueLength = cfg.MaxQueueLength
+		}
+		if cfg.MaxDeadLetters > 0 {
+			d.MaxDeadLetters = cfg.MaxDeadLetters
+		}
+	}
+	return d
+}
+
+// GetNotificationDispatcher returns the dispatcher shared by all SMF notifications
+func GetNotificationDispatcher() *NotificationDispatcher {
+	notificationDispatcherOnce.Do(func() {
+		var cfg *factory.NotificationDelivery
+		if factory.SmfConfig != nil && factory.SmfConfig.Configuration != nil {
+			cfg = factory.SmfConfig.Configuration.NotificationDelivery
+		}
+		notificationDispatcher = NewNotificationDispatcher(cfg)
+	})
+	return notificationDispatcher
+}
+
+// Dispatch queues the notification for delivery. Notifications of a muted key
+// are held until the key is unmuted.
+func (d *NotificationDispatcher) Dispatch(n *Notification) {
+	if n.Created.IsZero() {
+		n.Created = time.Now()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if held, ok := d.muted[n.Key]; ok && n.Key != "" {
+		if len(held) >= d.MaxQueueLength {
+			d.addDeadLetterLocked(n, "muted queue is full")
+			return
+		}
+		d.muted[n.Key] = append(held, n)
+		return
+	}
+	d.enqueueLocked(n)
+}
+
+func (d *NotificationDispatcher) enqueueLocked(n *Notification) {
+	q, ok := d.queues[n.Uri]
+	if !ok {
+		q = new(notificationQueue)
+		d.queues[n.Uri] = q
+	}
+	if len(q.items) >= d.MaxQueueLength {
+		d.addDeadLetterLocked(n, "queue is full")
+		return
+	}
+	q.items = append(q.items, n)
+	if !q.running {
+		q.running = true
+		go d.run(n.Uri, q)
+	}
+}
+
+func (d *NotificationDispatcher) run(uri string, q *notificationQueue) {
+	defer func() {
+		if p := recover(); p != nil {
+			// Print stack for panic to log. Fatalf() will let program exit.
+			logger.CtxLog.Fatalf("panic: %v\n%s", p, string(debug.Stack()))
+		}
+	}()
+
+	for {
+		d.mu.Lock()
+		if len(q.items) == 0 {
+			q.running = false
+			delete(d.queues, uri)
+			d.mu.Unlock()
+			return
+		}
+		n := q.items[0]
+		q.items = q.items[1:]
+		d.mu.Unlock()
+
+		d.deliver(n)
+	}
+}
+
+func (d *NotificationDispatcher) deliver(n *Notification) {
+	backoff := d.InitialBackoff
+	for {
+		n.Attempts++
+		n.LastErr = n.Send()
+		if n.LastErr == nil {
+			return
+		}
+		if n.Attempts > d.MaxRetryTimes {
+			logger.CtxLog.Warnf("Notification to [%s] failed after %d attempts: %v", n.Uri, n.Attempts, n.LastErr)
+			d.mu.Lock()
+			d.addDeadLetterLocked(n, n.LastErr.Error())
+			d.mu.Unlock()
+			return
+		}
+		logger.CtxLog.Debugf("Notification to [%s] failed: %v, retry in %s", n.Uri, n.LastErr, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > d.MaxBackoff {
+			backoff = d.MaxBackoff
+		}
+	}
+}
+
+func (d *NotificationDispatcher) addDeadLetterLocked(n *Notification, reason string) {
+	if len(d.deadLetters) >= d.MaxDeadLetters {
+		d.deadLetters = d.deadLetters[1:]
+	}
+	d.deadLetters = append(d.deadLetters, &DeadLetter{
+		Uri:      n.Uri,
+		Key:      n.Key,
+		Attempts: n.Attempts,
+		Reason:   reason,
+		Created:  n.Created,
+		Dropped:  time.Now(),
+	})
+}
+
+// Mute holds the notifications of key until Unmute is called
+func (d *NotificationDispatcher) Mute(key string) error {
+	if key == "" {
+		return fmt.Errorf("empty notification key")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.muted[key]; !ok {
+		d.muted[key] = nil
+	}
+	return nil
+}
+
+// Unmute delivers the held notifications of key and stops muting it
+func (d *NotificationDispatcher) Unmute(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	held, ok := d.muted[key]
+	if !ok {
+		return
+	}
+	delete(d.muted, key)
+	for _, n := range held {
+		d.enqueueLocked(n)
+	}
+}
+
+func (d *NotificationDispatcher) IsMuted(key string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.muted[key]
+	return ok
+}
+
+// DeadLetters returns a copy of the undelivered notifications
+func (d *NotificationDispatcher) DeadLetters() []DeadLetter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	letters := make([]DeadLetter, 0, len(d.deadLetters))
+	for _, l := range d.deadLetters {
+		letters = append(letters, *l)
+	}
+	return letters
+}
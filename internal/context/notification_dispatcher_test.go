@@ -0,0 +1,101 @@
+package context
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/smf/pkg/factory"
+)
+
+func newTestNotificationDispatcher() *NotificationDispatcher {
+	return NewNotificationDispatcher(&factory.NotificationDelivery{
+		MaxRetryTimes:  2,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     4 * time.Millisecond,
+		MaxQueueLength: 4,
+		MaxDeadLetters: 2,
+	})
+}
+
+func TestNotificationDispatcherRetry(t *testing.T) {
+	d := newTestNotificationDispatcher()
+
+	var mu sync.Mutex
+	attempts := 0
+	d.Dispatch(&Notification{
+		Uri: "http://127.0.0.1:8000/retry",
+		Key: "sub-1",
+		Send: func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts++
+			if attempts < 3 {
+				return fmt.Errorf("attempt %d failed", attempts)
+			}
+			return nil
+		},
+	})
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return attempts == 3
+	}, time.Second, time.Millisecond)
+	require.Empty(t, d.DeadLetters())
+
+	// Always failed notifications are moved to the dead letters, the oldest one is dropped
+	for i := 0; i < 3; i++ {
+		d.Dispatch(&Notification{
+			Uri:  "http://127.0.0.1:8000/fail",
+			Key:  fmt.Sprintf("sub-%d", i),
+			Send: func() error { return fmt.Errorf("failed") },
+		})
+	}
+	require.Eventually(t, func() bool {
+		letters := d.DeadLetters()
+		return len(letters) == 2 && letters[1].Key == "sub-2"
+	}, time.Second, time.Millisecond)
+	letters := d.DeadLetters()
+	require.Equal(t, "sub-1", letters[0].Key)
+	require.Equal(t, 3, letters[0].Attempts)
+	require.Equal(t, "failed", letters[0].Reason)
+}
+
+func TestNotificationDispatcherOrderAndMute(t *testing.T) {
+	d := newTestNotificationDispatcher()
+
+	var mu sync.Mutex
+	var delivered []int
+	send := func(i int) func() error {
+		return func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			delivered = append(delivered, i)
+			return nil
+		}
+	}
+	deliveredCopy := func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), delivered...)
+	}
+
+	require.Error(t, d.Mute(""))
+	require.NoError(t, d.Mute("sub-1"))
+	require.True(t, d.IsMuted("sub-1"))
+	for i := 0; i < 3; i++ {
+		d.Dispatch(&Notification{Uri: "http://127.0.0.1:8000/order", Key: "sub-1", Send: send(i)})
+	}
+	time.Sleep(10 * time.Millisecond)
+	require.Empty(t, deliveredCopy())
+
+	d.Unmute("sub-1")
+	require.False(t, d.IsMuted("sub-1"))
+	require.Eventually(t, func() bool {
+		return len(deliveredCopy()) == 3
+	}, time.Second, time.Millisecond)
+	require.Equal(t, []int{0, 1, 2}, deliveredCopy())
+}
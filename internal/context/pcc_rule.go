@@ -41,6 +41,14 @@ func (r *PCCRule) FlowDescription() string {
 	return ""
 }
 
+// EthFlowDescription returns the Ethernet flow description of Ethernet PDU session
+func (r *PCCRule) EthFlowDescription() *models.EthFlowDescription {
+	if len(r.FlowInfos) > 0 {
+		return r.FlowInfos[0].EthFlowDescription
+	}
+	return nil
+}
+
 func (r *PCCRule) RefQosDataID() string {
 	if len(r.RefQosData) > 0 {
 		// now 1 pcc rule only maps to 1 QoS data
@@ -61,6 +69,24 @@ func (r *PCCRule) RefTcDataID() string {
 	return ""
 }
 
+// RefChgDataID returns the charging data of the PCC rule, "" if the usage of the rule isn't charged
+func (r *PCCRule) RefChgDataID() string {
+	if len(r.RefChgData) > 0 {
+		// now 1 pcc rule only maps to 1 Charging data
+		return r.RefChgData[0]
+	}
+	return ""
+}
+
+// RefUmDataID returns the usage monitoring data of the PCC rule, "" if the usage of the rule isn't monitored
+func (r *PCCRule) RefUmDataID() string {
+	if len(r.RefUmData) > 0 {
+		// now 1 pcc rule only maps to 1 Usage Monitoring data
+		return r.RefUmData[0]
+	}
+	return ""
+}
+
 func (r *PCCRule) UpdateDataPathFlowDescription(dlFlowDesc string) error {
 	if r.Datapath == nil {
 		return fmt.Errorf("pcc[%s]: no data path", r.PccRuleId)
@@ -77,6 +103,16 @@ func (r *PCCRule) UpdateDataPathFlowDescription(dlFlowDesc string) error {
 	return nil
 }
 
+// UpdateDataPathApplicationID sets the application identifier to the PDRs of the data path,
+// the traffic of the application is detected by the PFDs provisioned to UPF
+func (r *PCCRule) UpdateDataPathApplicationID(appID string) error {
+	if r.Datapath == nil {
+		return fmt.Errorf("pcc[%s]: no data path", r.PccRuleId)
+	}
+	r.Datapath.UpdateApplicationID(appID)
+	return nil
+}
+
 func getUplinkFlowDescription(dlFlowDesc string) string {
 	ulIPFilterRule, err := flowdesc.Decode(dlFlowDesc)
 	if err != nil {
@@ -107,14 +143,14 @@ func (r *PCCRule) AddDataPathForwardingParameters(c *SMContext,
 	routeProfExist := false
 	// specify N6 routing information
 	if tgtRoute.RouteProfId != "" {
-		routeProf, routeProfExist = factory.UERoutingConfig.RouteProf[factory.RouteProfID(tgtRoute.RouteProfId)]
+		routeProf, routeProfExist = factory.GetUERoutingConfig().RouteProf[factory.RouteProfID(tgtRoute.RouteProfId)]
 		if !routeProfExist {
 			logger.CtxLog.Warnf("Route Profile ID [%s] is not support", tgtRoute.RouteProfId)
 			return
 		}
 	}
 	r.Datapath.AddForwardingParameters(routeProf.ForwardingPolicyID,
-		c.Tunnel.DataPathPool.GetDefaultPath().FirstDPNode.GetUpLinkPDR().PDI.LocalFTeid.Teid)
+		c.Tunnel.DataPathPool.GetDefaultPath().FirstDPNode.GetUpLinkPDR().PDI.LocalFTeid)
 }
 
 func (r *PCCRule) BuildNasQoSRule(smCtx *SMContext,
@@ -138,6 +174,34 @@ func (r *PCCRule) BuildNasQoSRule(smCtx *SMContext,
 	return &rule, nil
 }
 
+// createNasEthPacketFilter creates the packet filter of Ethernet PDU session, TS 24.501 9.11.4.13
+func createNasEthPacketFilter(pfInfo *models.FlowInformation, smCtx *SMContext) (*nasType.PacketFilter, error) {
+	ethFilter, err := NewEthernetPacketFilter(pfInfo.EthFlowDescription)
+	if err != nil {
+		return nil, err
+	}
+
+	pf := new(nasType.PacketFilter)
+	pfId, err := smCtx.PacketFilterIDGenerator.Allocate()
+	if err != nil {
+		return nil, err
+	}
+	pf.Identifier = uint8(pfId)
+	smCtx.PacketFilterIDToNASPFID[pfInfo.PackFiltId] = uint8(pfId)
+
+	switch pfInfo.FlowDirection {
+	case models.FlowDirectionRm_DOWNLINK:
+		pf.Direction = nasType.PacketFilterDirectionDownlink
+	case models.FlowDirectionRm_UPLINK:
+		pf.Direction = nasType.PacketFilterDirectionUplink
+	case models.FlowDirectionRm_BIDIRECTIONAL:
+		pf.Direction = nasType.PacketFilterDirectionBidirectional
+	}
+
+	pf.Components = ethFilter.NASComponents()
+	return pf, nil
+}
+
 func createNasPacketFilter(
 	pfInfo *models.FlowInformation,
 	smCtx *SMContext,
@@ -261,6 +325,14 @@ func BuildNASPacketFiltersFromFlowInformation(pfInfo *models.FlowInformation,
 	var pfList []nasType.PacketFilter
 	var err error
 
+	if pfInfo.EthFlowDescription != nil {
+		pf, err := createNasEthPacketFilter(pfInfo, smCtx)
+		if err != nil {
+			return nil, errors.Wrap(err, "create packet filter fail")
+		}
+		return append(pfList, *pf), nil
+	}
+
 	ipFilterRule := flowdesc.NewIPFilterRule()
 	if pfInfo.FlowDescription != "" {
 		ipFilterRule, err = flowdesc.Decode(pfInfo.FlowDescription)
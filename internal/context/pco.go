@@ -1,9 +1,43 @@
 // ProtocolConfigurationOptions
 package context
 
+import (
+	"fmt"
+	"net"
+
+	"bitbucket.org/free5gc-team/nas/nasConvert"
+	"bitbucket.org/free5gc-team/nas/nasMessage"
+)
+
 type ProtocolConfigurationOptions struct {
 	DNSIPv4Request     bool
 	DNSIPv6Request     bool
 	PCSCFIPv4Request   bool
+	PCSCFIPv6Request   bool
 	IPv4LinkMTURequest bool
+
+	UnstructuredLinkMTURequest bool
+}
+
+// addPCSCFIPv6Address adds the P-CSCF IPv6 Address container (TS 24.008 10.5.6.3)
+func addPCSCFIPv6Address(pco *nasConvert.ProtocolConfigurationOptions, pcscfIP net.IP) error {
+	if pcscfIP == nil || pcscfIP.To4() != nil {
+		return fmt.Errorf("the P-CSCF IP [%s] should be IPv6", pcscfIP)
+	}
+
+	unit := nasConvert.NewProtocolOrContainerUnit()
+	unit.ProtocolOrContainerID = nasMessage.PCSCFIPv6AddressDL
+	unit.LengthOfContents = uint8(net.IPv6len)
+	unit.Contents = append(unit.Contents, pcscfIP.To16()...)
+	pco.ProtocolOrContainerList = append(pco.ProtocolOrContainerList, unit)
+	return nil
+}
+
+// addUnstructuredLinkMTU adds the Unstructured link MTU container (TS 24.008 10.5.6.3)
+func addUnstructuredLinkMTU(pco *nasConvert.ProtocolConfigurationOptions, mtu uint16) {
+	unit := nasConvert.NewProtocolOrContainerUnit()
+	unit.ProtocolOrContainerID = nasMessage.UnstructuredLinkMTUDL
+	unit.LengthOfContents = 2
+	unit.Contents = append(unit.Contents, uint8(mtu>>8), uint8(mtu))
+	pco.ProtocolOrContainerList = append(pco.ProtocolOrContainerList, unit)
 }
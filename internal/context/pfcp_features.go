@@ -0,0 +1,179 @@
+package context
+
+import (
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+)
+
+// UPFeature is a feature of UP Function Features (TS 29.244 8.2.25), numbered from bit 1 of octet 5.
+// The features of octet 5 and 6 are in SupportedFeatures, the following ones in SupportedFeatures1 and 2
+type UPFeature uint8
+
+const (
+	UPFeatureBUCP UPFeature = iota
+	UPFeatureDDND
+	UPFeatureDLBD
+	UPFeatureTRST
+	UPFeatureFTUP
+	UPFeaturePFDM
+	UPFeatureHEEU
+	UPFeatureTREU
+	UPFeatureEMPU
+	UPFeaturePDIU
+	UPFeatureUDBC
+	UPFeatureQUOAC
+	UPFeatureTRACE
+	UPFeatureFRRT
+	UPFeaturePFDE
+	UPFeatureEPFAR
+	UPFeatureDPDRA
+	UPFeatureADPDP
+	UPFeatureUEIP
+	UPFeatureSSET
+	UPFeatureMNOP
+	UPFeatureMTE
+	UPFeatureBUNDL
+	UPFeatureGCOM
+	UPFeatureMPAS
+	UPFeatureRTTL
+	UPFeatureVTIME
+	UPFeatureNORP
+	UPFeatureIPTV
+	UPFeatureIP6PL
+	UPFeatureTSCU
+	UPFeatureMPTCP
+	UPFeatureATSSSLL
+	UPFeatureQFQM
+	UPFeatureGPQM
+)
+
+var upFeatureNames = []string{
+	"BUCP", "DDND", "DLBD", "TRST", "FTUP", "PFDM", "HEEU", "TREU",
+	"EMPU", "PDIU", "UDBC", "QUOAC", "TRACE", "FRRT", "PFDE", "EPFAR",
+	"DPDRA", "ADPDP", "UEIP", "SSET", "MNOP", "MTE", "BUNDL", "GCOM",
+	"MPAS", "RTTL", "VTIME", "NORP", "IPTV", "IP6PL", "TSCU", "MPTCP",
+	"ATSSS-LL", "QFQM", "GPQM",
+}
+
+func (f UPFeature) String() string {
+	if int(f) < len(upFeatureNames) {
+		return upFeatureNames[f]
+	}
+	return "UNKNOWN"
+}
+
+// CPFeature is a feature of CP Function Features (TS 29.244 8.2.58), numbered from bit 1 of octet 5
+type CPFeature uint8
+
+const (
+	CPFeatureLOAD CPFeature = iota
+	CPFeatureOVRL
+	CPFeatureEPFAR
+	CPFeatureSSET
+	CPFeatureBUNDL
+	CPFeatureMPAS
+	CPFeatureARDR
+	CPFeatureUIAUR
+)
+
+var cpFeatureNames = []string{"LOAD", "OVRL", "EPFAR", "SSET", "BUNDL", "MPAS", "ARDR", "UIAUR"}
+
+func (f CPFeature) String() string {
+	if int(f) < len(cpFeatureNames) {
+		return cpFeatureNames[f]
+	}
+	return "UNKNOWN"
+}
+
+// supportedCPFeatures is the optional CP features implemented by SMF, which are advertised to UPFs.
+// None of them is implemented yet, the list grows as they are
+var supportedCPFeatures []CPFeature
+
+// CPFunctionFeatures returns the CP Function Features IE advertised in PFCP association
+func (s *SMFContext) CPFunctionFeatures() *pfcpType.CPFunctionFeatures {
+	features := &pfcpType.CPFunctionFeatures{}
+	for _, f := range supportedCPFeatures {
+		features.SupportedFeatures |= 1 << f
+	}
+	return features
+}
+
+// SetFunctionFeatures stores the UP features announced by UPF in PFCP association
+// together with the CP features advertised to it
+func (upf *UPF) SetFunctionFeatures(upFeatures *pfcpType.UPFunctionFeatures) {
+	upf.UPFunctionFeatures = upFeatures
+	upf.CPFunctionFeatures = GetSelf().CPFunctionFeatures()
+}
+
+// SupportsUPFeature reports whether UPF announced the feature in PFCP association,
+// no feature is supported before the association is set up
+func (upf *UPF) SupportsUPFeature(f UPFeature) bool {
+	features := upf.UPFunctionFeatures
+	if features == nil {
+		return false
+	}
+	var word uint16
+	switch f / 16 {
+	case 0:
+		word = features.SupportedFeatures
+	case 1:
+		word = features.SupportedFeatures1
+	case 2:
+		word = features.SupportedFeatures2
+	}
+	return word&(1<<(f%16)) != 0
+}
+
+// SupportsCPFeature reports whether SMF advertised the feature to UPF in PFCP association
+func (upf *UPF) SupportsCPFeature(f CPFeature) bool {
+	return upf.CPFunctionFeatures != nil && upf.CPFunctionFeatures.SupportedFeatures&(1<<f) != 0
+}
+
+// SupportedUPFeatures returns the names of the UP features announced by UPF
+func (upf *UPF) SupportedUPFeatures() []string {
+	names := []string{}
+	for i := range upFeatureNames {
+		if upf.SupportsUPFeature(UPFeature(i)) {
+			names = append(names, UPFeature(i).String())
+		}
+	}
+	return names
+}
+
+// SupportedCPFeatures returns the names of the CP features advertised to UPF
+func (upf *UPF) SupportedCPFeatures() []string {
+	names := []string{}
+	for i := range cpFeatureNames {
+		if upf.SupportsCPFeature(CPFeature(i)) {
+			names = append(names, CPFeature(i).String())
+		}
+	}
+	return names
+}
+
+// UnsupportedFeatureUsages returns the functions of SMF which are skipped on UPF
+// because it lacks the features they need
+func (upf *UPF) UnsupportedFeatureUsages() []string {
+	usages := []string{}
+	if upf.UPFunctionFeatures == nil {
+		return usages
+	}
+	if !upf.SupportsUPFeature(UPFeatureDDND) {
+		usages = append(usages, "DDND: BARs carry no Downlink Data Notification Delay")
+	}
+	if !upf.SupportsUPFeature(UPFeatureUDBC) {
+		usages = append(usages, "UDBC: BARs carry no suggested buffering packets count")
+	}
+	if upf.ChooseFTEID && !upf.SupportsUPFeature(UPFeatureFTUP) {
+		usages = append(usages, "FTUP: F-TEIDs are allocated by SMF instead of UPF")
+	}
+	if !upf.SupportsUPFeature(UPFeaturePFDM) && len(GetPfdStore().All()) > 0 {
+		usages = append(usages, "PFDM: PFDs are not provisioned, applications are not detected by PFDs")
+	}
+	if !upf.SupportsUPFeature(UPFeatureQFQM) {
+		usages = append(usages, "QFQM: QoS flows are not monitored per QoS flow")
+	}
+	if !upf.SupportsUPFeature(UPFeatureGPQM) {
+		usages = append(usages, "GPQM: GTP-U paths are not monitored")
+	}
+	return usages
+}
@@ -0,0 +1,31 @@
+package context
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+)
+
+func TestSupportsUPFeature(t *testing.T) {
+	upf := &UPF{}
+	require.False(t, upf.SupportsUPFeature(UPFeatureFTUP))
+	require.Empty(t, upf.UnsupportedFeatureUsages())
+
+	upf.SetFunctionFeatures(&pfcpType.UPFunctionFeatures{
+		SupportedFeatures:  1<<UPFeatureFTUP | 1<<UPFeaturePFDM,
+		SupportedFeatures2: 1 << (UPFeatureQFQM % 16),
+	})
+	require.NotNil(t, upf.CPFunctionFeatures)
+	require.True(t, upf.SupportsUPFeature(UPFeatureFTUP))
+	require.True(t, upf.SupportsUPFeature(UPFeaturePFDM))
+	require.True(t, upf.SupportsUPFeature(UPFeatureQFQM))
+	require.False(t, upf.SupportsUPFeature(UPFeatureDDND))
+	require.Equal(t, []string{"FTUP", "PFDM", "QFQM"}, upf.SupportedUPFeatures())
+	usages := upf.UnsupportedFeatureUsages()
+	require.Len(t, usages, 3)
+	require.Contains(t, usages[0], "DDND")
+	require.Contains(t, usages[1], "UDBC")
+	require.Contains(t, usages[2], "GPQM")
+}
@@ -0,0 +1,117 @@
+package context
+
+import (
+	"net"
+
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+)
+
+// ExternalIPv6 returns the IPv6 N4 address of SMF, which is the external address if it's IPv6,
+// or the additional IPv6 external address of dual-stack N4
+func (s *SMFContext) ExternalIPv6() net.IP {
+	if ip := s.ExternalIP(); ip != nil && ip.To4() == nil {
+		return ip
+	}
+	if s.ExternalAddrIPv6 != "" {
+		return net.ParseIP(s.ExternalAddrIPv6)
+	}
+	return nil
+}
+
+// ExternalIPv4 returns the IPv4 N4 address of SMF, nil for IPv6 only N4
+func (s *SMFContext) ExternalIPv4() net.IP {
+	return s.ExternalIP().To4()
+}
+
+// CPFSEID returns the F-SEID of SMF with the IPv4 and IPv6 N4 addresses of SMF
+func (s *SMFContext) CPFSEID(seid uint64) *pfcpType.FSEID {
+	fseid := &pfcpType.FSEID{Seid: seid}
+	if ipv4 := s.ExternalIPv4(); ipv4 != nil {
+		fseid.V4 = true
+		fseid.Ipv4Address = ipv4
+	}
+	if ipv6 := s.ExternalIPv6(); ipv6 != nil {
+		fseid.V6 = true
+		fseid.Ipv6Address = ipv6
+	}
+	return fseid
+}
+
+// N4IP returns the N4 address of SMF in the address family of the peer, the other family is used
+// if SMF has no address in it
+func (s *SMFContext) N4IP(peer net.IP) net.IP {
+	ipv4, ipv6 := s.ExternalIPv4(), s.ExternalIPv6()
+	if (peer.To4() != nil && ipv4 != nil) || ipv6 == nil {
+		return ipv4
+	}
+	return ipv6
+}
+
+// newFTEID returns the F-TEID of the IPv4 or IPv6 tunnel endpoint
+func newFTEID(ip net.IP, teid uint32) *pfcpType.FTEID {
+	fteid := &pfcpType.FTEID{Teid: teid}
+	if ipv4 := ip.To4(); ipv4 != nil {
+		fteid.V4 = true
+		fteid.Ipv4Address = ipv4
+	} else {
+		fteid.V6 = true
+		fteid.Ipv6Address = ip
+	}
+	return fteid
+}
+
+// FTEIDIP returns the IP of the F-TEID, the IPv4 address is preferred for the dual-stack F-TEID
+func FTEIDIP(fteid *pfcpType.FTEID) net.IP {
+	if fteid.V4 {
+		return fteid.Ipv4Address
+	}
+	if fteid.V6 {
+		return fteid.Ipv6Address
+	}
+	return nil
+}
+
+// GTPUOuterHeaderCreation returns the GTP-U/UDP outer header creation toward the IPv4 or IPv6 tunnel endpoint
+func GTPUOuterHeaderCreation(ip net.IP, teid uint32) *pfcpType.OuterHeaderCreation {
+	ohc := &pfcpType.OuterHeaderCreation{Teid: teid}
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ohc.OuterHeaderCreationDescription = pfcpType.OuterHeaderCreationGtpUUdpIpv4
+		ohc.Ipv4Address = ipv4
+	} else {
+		ohc.OuterHeaderCreationDescription = pfcpType.OuterHeaderCreationGtpUUdpIpv6
+		ohc.Ipv6Address = ip
+	}
+	return ohc
+}
+
+// OuterHeaderCreationIP returns the IP of the tunnel endpoint of the outer header creation
+func OuterHeaderCreationIP(ohc *pfcpType.OuterHeaderCreation) net.IP {
+	if ohc.OuterHeaderCreationDescription == pfcpType.OuterHeaderCreationGtpUUdpIpv6 {
+		return ohc.Ipv6Address
+	}
+	return ohc.Ipv4Address
+}
+
+// gtpuOuterHeaderRemoval returns the GTP-U/UDP outer header removal of the IPv4 or IPv6 local tunnel endpoint
+func gtpuOuterHeaderRemoval(ip net.IP) *pfcpType.OuterHeaderRemoval {
+	if ip != nil && ip.To4() == nil {
+		return &pfcpType.OuterHeaderRemoval{
+			OuterHeaderRemovalDescription: pfcpType.OuterHeaderRemovalGtpUUdpIpv6,
+		}
+	}
+	return &pfcpType.OuterHeaderRemoval{
+		OuterHeaderRemovalDescription: pfcpType.OuterHeaderRemovalGtpUUdpIpv4,
+	}
+}
+
+// TransportLayerIP returns the IP of the NGAP transport layer address (TS 38.414 5.1), which is
+// an IPv4 address, an IPv6 address or both of them. The IPv4 address is preferred by the dual-stack N3
+func TransportLayerIP(addr []byte) net.IP {
+	switch len(addr) {
+	case net.IPv4len, net.IPv6len:
+		return net.IP(addr)
+	case net.IPv4len + net.IPv6len:
+		return net.IP(addr[:net.IPv4len])
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package context
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+)
+
+func TestCPFSEID(t *testing.T) {
+	s := &SMFContext{ExternalAddr: "10.200.200.1", ExternalAddrIPv6: "2001:db8::1"}
+	fseid := s.CPFSEID(1)
+	require.True(t, fseid.V4)
+	require.True(t, fseid.V6)
+	require.Equal(t, net.ParseIP("10.200.200.1").To4(), fseid.Ipv4Address)
+	require.Equal(t, net.ParseIP("2001:db8::1"), fseid.Ipv6Address)
+	require.Equal(t, net.ParseIP("10.200.200.1").To4(), s.N4IP(net.ParseIP("10.200.200.101")))
+	require.Equal(t, net.ParseIP("2001:db8::1"), s.N4IP(net.ParseIP("2001:db8::101")))
+
+	s = &SMFContext{ExternalAddr: "2001:db8::1"}
+	fseid = s.CPFSEID(1)
+	require.False(t, fseid.V4)
+	require.True(t, fseid.V6)
+	require.Equal(t, net.ParseIP("2001:db8::1"), s.N4IP(net.ParseIP("10.200.200.101")))
+}
+
+func TestGTPUOuterHeader(t *testing.T) {
+	ipv6 := net.ParseIP("2001:db8::101")
+	ohc := GTPUOuterHeaderCreation(ipv6, 1)
+	require.EqualValues(t, pfcpType.OuterHeaderCreationGtpUUdpIpv6, ohc.OuterHeaderCreationDescription)
+	require.Equal(t, ipv6, OuterHeaderCreationIP(ohc))
+	require.EqualValues(t, pfcpType.OuterHeaderRemovalGtpUUdpIpv6,
+		gtpuOuterHeaderRemoval(ipv6).OuterHeaderRemovalDescription)
+
+	fteid := newFTEID(ipv6, 1)
+	require.True(t, fteid.V6)
+	require.Equal(t, ipv6, FTEIDIP(fteid))
+	require.True(t, chooseFTEID(ipv6, 0).V6)
+
+	ipv4 := net.ParseIP("10.200.200.101")
+	ohc = GTPUOuterHeaderCreation(ipv4, 1)
+	require.Equal(t, ipv4.To4(), OuterHeaderCreationIP(ohc))
+	require.Equal(t, ipv4.To4(), FTEIDIP(newFTEID(ipv4, 1)))
+}
+
+func TestTransportLayerIP(t *testing.T) {
+	ipv4 := net.ParseIP("10.200.200.101").To4()
+	ipv6 := net.ParseIP("2001:db8::101")
+	require.Equal(t, ipv4, TransportLayerIP(ipv4))
+	require.Equal(t, ipv6, TransportLayerIP(ipv6))
+	require.Equal(t, ipv4, TransportLayerIP(append(append([]byte{}, ipv4...), ipv6...)))
+	require.Nil(t, TransportLayerIP([]byte{1, 2}))
+}
@@ -35,6 +35,7 @@ const (
 	MeasureInfoMBQE     = 0x1  // Measure Before Qos Enforce(MQBE)
 	MesureMethodVol     = "vol"
 	MesureMethodTime    = "time"
+	MesureMethodVolTime = "vol,time"
 	MeasurePeriodReport = 0x0100 // 0x10: PERIO
 )
 
@@ -46,7 +47,14 @@ type URR struct {
 	MeasurementPeriod      time.Duration
 	MeasurementInformation pfcpType.MeasurementInformation
 	VolumeThreshold        uint64
-	State                  RuleState
+	// thresholds of the total, uplink and downlink volumes monitored for PCF, it overrides VolumeThreshold
+	VolumeThresholds *pfcpType.VolumeThreshold
+	// quota granted by CHF for online charging, UPF reports the usage when it's exhausted or expires
+	VolumeQuota       uint64
+	TimeQuota         time.Duration
+	TimeThreshold     time.Duration
+	QuotaValidityTime time.Duration
+	State             RuleState
 }
 
 type UrrOpt func(urr *URR)
@@ -58,6 +66,12 @@ func NewMeasureInformation(isMeasurePkt, isMeasureBeforeQos bool) UrrOpt {
 	}
 }
 
+func NewMeasureMethod(method string) UrrOpt {
+	return func(urr *URR) {
+		urr.MeasureMethod = method
+	}
+}
+
 func NewMeasurementPeriod(time time.Duration) UrrOpt {
 	return func(urr *URR) {
 		urr.MeasurementPeriod = time
@@ -70,6 +84,34 @@ func NewVolumeThreshold(threshold uint64) UrrOpt {
 	}
 }
 
+func NewVolumeQuota(quota uint64) UrrOpt {
+	return func(urr *URR) {
+		urr.VolumeQuota = quota
+		urr.ReportingTrigger.Volqu = quota != 0
+	}
+}
+
+func NewTimeQuota(quota time.Duration) UrrOpt {
+	return func(urr *URR) {
+		urr.TimeQuota = quota
+		urr.ReportingTrigger.Timqu = quota != 0
+	}
+}
+
+func NewTimeThreshold(threshold time.Duration) UrrOpt {
+	return func(urr *URR) {
+		urr.TimeThreshold = threshold
+		urr.ReportingTrigger.Timth = threshold != 0
+	}
+}
+
+func NewQuotaValidityTime(validity time.Duration) UrrOpt {
+	return func(urr *URR) {
+		urr.QuotaValidityTime = validity
+		urr.ReportingTrigger.Quvti = validity != 0
+	}
+}
+
 func MeasureInformation(isMeasurePkt, isMeasureBeforeQos bool) pfcpType.MeasurementInformation {
 	var measureInformation pfcpType.MeasurementInformation
 	measureInformation.Mnop = isMeasurePkt
@@ -85,6 +127,10 @@ type PDI struct {
 	UEIPAddress     *pfcpType.UEIPAddress
 	SDFFilter       *pfcpType.SDFFilter
 	ApplicationID   string
+
+	// for Ethernet PDU session
+	EthernetPDUSessionInformation bool
+	EthernetPacketFilter          *EthernetPacketFilter
 }
 
 // Forwarding Action Rule. 7.5.2.3-1
@@ -105,6 +151,8 @@ type ForwardingParameters struct {
 	OuterHeaderCreation  *pfcpType.OuterHeaderCreation
 	ForwardingPolicyID   string
 	SendEndMarker        bool
+	// RedirectInformation redirects the traffic after the final units of online charging are used up
+	RedirectInformation *pfcpType.RedirectInformation
 }
 
 // Buffering Action Rule 7.5.2.6-1
@@ -3,6 +3,7 @@ package context
 import (
 	"fmt"
 	"net"
+	"sort"
 
 	"bitbucket.org/free5gc-team/pfcp/pfcpType"
 )
@@ -28,6 +29,9 @@ type PFCPSessionContext struct {
 	NodeID     pfcpType.NodeID
 	LocalSEID  uint64
 	RemoteSEID uint64
+	// LocalFQCSID is the FQ-CSID of SMF and RemoteFQCSID is the one allocated by UPF to the PFCP session
+	LocalFQCSID  *FQCSID
+	RemoteFQCSID *FQCSID
 }
 
 func (pfcpSessionContext *PFCPSessionContext) String() string {
@@ -45,6 +49,22 @@ func (pfcpSessionContext *PFCPSessionContext) String() string {
 	return str
 }
 
+// URRIDs returns the IDs of the URRs of the PDRs in the PFCP session
+func (pfcpSessionContext *PFCPSessionContext) URRIDs() []uint32 {
+	var ids []uint32
+	seen := make(map[uint32]bool)
+	for _, pdr := range pfcpSessionContext.PDRs {
+		for _, urr := range pdr.URR {
+			if urr != nil && !seen[urr.URRID] {
+				seen[urr.URRID] = true
+				ids = append(ids, urr.URRID)
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
 func (pfcpSessionResponseStatus PFCPSessionResponseStatus) String() string {
 	switch pfcpSessionResponseStatus {
 	case SessionUpdateSuccess:
@@ -0,0 +1,248 @@
+package context
+
+import (
+	"sync"
+	"time"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+	"bitbucket.org/free5gc-team/smf/pkg/factory"
+)
+
+// Pfd is a packet flow description of an application, TS 29.551 5.6.2.4
+type Pfd struct {
+	PfdID            string
+	FlowDescriptions []string
+	Urls             []string
+	DomainNames      []string
+}
+
+// PfdDataForApp is the PFDs of an application identifier which are provisioned to UPFs
+// by PFCP PFD management (TS 29.244 6.2.5)
+type PfdDataForApp struct {
+	AppID string
+	Pfds  []Pfd
+	// CachingTime is the time when the PFDs expire, zero if they don't expire
+	CachingTime time.Time
+}
+
+// Expired reports whether the caching time of the PFDs has passed
+func (p *PfdDataForApp) Expired(now time.Time) bool {
+	return !p.CachingTime.IsZero() && !now.Before(p.CachingTime)
+}
+
+// FlowDescription returns the first flow description of the PFDs, empty if none
+func (p *PfdDataForApp) FlowDescription() string {
+	for _, pfd := range p.Pfds {
+		if len(pfd.FlowDescriptions) > 0 {
+			return pfd.FlowDescriptions[0]
+		}
+	}
+	return ""
+}
+
+// PfdStore keeps the PFDs of application identifiers. The PFDs fetched from NEF are cached
+// until their caching time and the PFDs of the UE routing config are the fallback
+type PfdStore struct {
+	mu sync.RWMutex
+	// static is the PFDs of the UE routing config
+	static map[string]*PfdDataForApp
+	// cached is the PFDs fetched from NEF
+	cached map[string]*PfdDataForApp
+	timers map[*PfdDataForApp]*time.Timer
+	// onExpired is called with the application identifier when its PFDs expire
+	onExpired func(appID string)
+}
+
+var pfdStore = NewPfdStore()
+
+// NewPfdStore returns an empty PFD store
+func NewPfdStore() *PfdStore {
+	return &PfdStore{
+		static: make(map[string]*PfdDataForApp),
+		cached: make(map[string]*PfdDataForApp),
+		timers: make(map[*PfdDataForApp]*time.Timer),
+	}
+}
+
+// GetPfdStore returns the PFD store of SMF
+func GetPfdStore() *PfdStore {
+	return pfdStore
+}
+
+// SetExpiredHandler sets the function called when the PFDs of an application identifier expire
+func (s *PfdStore) SetExpiredHandler(onExpired func(appID string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onExpired = onExpired
+}
+
+// Get returns the PFDs of the application identifier, nil if they don't exist or have expired
+func (s *PfdStore) Get(appID string) *PfdDataForApp {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.get(appID, time.Now())
+}
+
+func (s *PfdStore) get(appID string, now time.Time) *PfdDataForApp {
+	if pfdData, ok := s.cached[appID]; ok && !pfdData.Expired(now) {
+		return pfdData
+	}
+	if pfdData, ok := s.static[appID]; ok && !pfdData.Expired(now) {
+		return pfdData
+	}
+	return nil
+}
+
+// All returns the PFDs of all application identifiers which haven't expired
+func (s *PfdStore) All() []*PfdDataForApp {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	now := time.Now()
+	pfdDatas := make([]*PfdDataForApp, 0, len(s.static)+len(s.cached))
+	for appID := range s.appIDs() {
+		if pfdData := s.get(appID, now); pfdData != nil {
+			pfdDatas = append(pfdDatas, pfdData)
+		}
+	}
+	return pfdDatas
+}
+
+func (s *PfdStore) appIDs() map[string]bool {
+	appIDs := make(map[string]bool)
+	for appID := range s.static {
+		appIDs[appID] = true
+	}
+	for appID := range s.cached {
+		appIDs[appID] = true
+	}
+	return appIDs
+}
+
+// Put stores the PFDs of an application identifier fetched from NEF,
+// the PFDs which have expired are not stored
+func (s *PfdStore) Put(pfdData *PfdDataForApp) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(s.cached, pfdData.AppID)
+	if pfdData.Expired(time.Now()) {
+		logger.CtxLog.Warnf("PFDs of application[%s] have expired at %s", pfdData.AppID, pfdData.CachingTime)
+		return false
+	}
+	s.put(s.cached, pfdData)
+	return true
+}
+
+// Delete removes the PFDs of an application identifier fetched from NEF, the PFDs of the UE routing config
+// are used if they exist
+func (s *PfdStore) Delete(appID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(s.cached, appID)
+}
+
+// Replace replaces the PFDs of the UE routing config, it returns the application identifiers
+// which have no PFD any more
+func (s *PfdStore) Replace(pfdDatas []*PfdDataForApp) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	oldAppIDs := s.appIDs()
+	for appID := range s.static {
+		s.remove(s.static, appID)
+	}
+	for _, pfdData := range pfdDatas {
+		if pfdData.Expired(now) {
+			logger.CtxLog.Warnf("PFDs of application[%s] have expired at %s", pfdData.AppID, pfdData.CachingTime)
+			continue
+		}
+		s.put(s.static, pfdData)
+	}
+	var removed []string
+	for appID := range oldAppIDs {
+		if s.get(appID, now) == nil {
+			removed = append(removed, appID)
+		}
+	}
+	return removed
+}
+
+func (s *PfdStore) put(pfdDatas map[string]*PfdDataForApp, pfdData *PfdDataForApp) {
+	pfdDatas[pfdData.AppID] = pfdData
+	if pfdData.CachingTime.IsZero() {
+		return
+	}
+	s.timers[pfdData] = time.AfterFunc(time.Until(pfdData.CachingTime), func() {
+		s.mu.Lock()
+		if pfdDatas[pfdData.AppID] != pfdData {
+			// the PFDs have been updated
+			s.mu.Unlock()
+			return
+		}
+		s.remove(pfdDatas, pfdData.AppID)
+		onExpired := s.onExpired
+		s.mu.Unlock()
+
+		logger.CtxLog.Infof("PFDs of application[%s] expire", pfdData.AppID)
+		if onExpired != nil {
+			onExpired(pfdData.AppID)
+		}
+	})
+}
+
+func (s *PfdStore) remove(pfdDatas map[string]*PfdDataForApp, appID string) {
+	pfdData, ok := pfdDatas[appID]
+	if !ok {
+		return
+	}
+	delete(pfdDatas, appID)
+	if timer, ok := s.timers[pfdData]; ok {
+		timer.Stop()
+		delete(s.timers, pfdData)
+	}
+}
+
+// PfdDataFromModels converts the PFDs provided by NEF
+func PfdDataFromModels(pfdDataForApp *models.PfdDataForApp) *PfdDataForApp {
+	pfdData := &PfdDataForApp{
+		AppID: pfdDataForApp.ApplicationId,
+	}
+	if pfdDataForApp.CachingTime != nil {
+		pfdData.CachingTime = *pfdDataForApp.CachingTime
+	}
+	for _, pfdContent := range pfdDataForApp.Pfds {
+		pfdData.Pfds = append(pfdData.Pfds, Pfd{
+			PfdID:            pfdContent.PfdId,
+			FlowDescriptions: pfdContent.FlowDescriptions,
+			Urls:             pfdContent.Urls,
+			DomainNames:      pfdContent.DomainNames,
+		})
+	}
+	return pfdData
+}
+
+// PfdDatasFromConfig converts the PFDs of the UE routing config
+func PfdDatasFromConfig(routingConfig *factory.RoutingConfig) []*PfdDataForApp {
+	if routingConfig == nil {
+		return nil
+	}
+	pfdDatas := make([]*PfdDataForApp, 0, len(routingConfig.PfdDatas))
+	for _, pfdDataConfig := range routingConfig.PfdDatas {
+		pfdData := &PfdDataForApp{
+			AppID: pfdDataConfig.AppID,
+		}
+		if pfdDataConfig.CachingTime != nil {
+			pfdData.CachingTime = *pfdDataConfig.CachingTime
+		}
+		for _, pfdConfig := range pfdDataConfig.Pfds {
+			pfdData.Pfds = append(pfdData.Pfds, Pfd{
+				PfdID:            pfdConfig.PfdID,
+				FlowDescriptions: pfdConfig.FlowDescriptions,
+				Urls:             pfdConfig.Urls,
+				DomainNames:      pfdConfig.DomainNames,
+			})
+		}
+		pfdDatas = append(pfdDatas, pfdData)
+	}
+	return pfdDatas
+}
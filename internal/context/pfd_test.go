@@ -0,0 +1,87 @@
+package context
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/smf/pkg/factory"
+)
+
+func TestPfdDatasFromConfig(t *testing.T) {
+	cachingTime := time.Now().Add(time.Hour)
+	pfdDatas := PfdDatasFromConfig(&factory.RoutingConfig{
+		PfdDatas: []*factory.PfdDataForApp{
+			{
+				AppID: "app1",
+				Pfds: []factory.PfdContent{
+					{
+						PfdID:            "pfd1",
+						FlowDescriptions: []string{"permit out ip from 10.60.0.1 to 10.60.0.0/16"},
+						Urls:             []string{"http://app1.example.com/"},
+						DomainNames:      []string{"app1.example.com"},
+					},
+				},
+				CachingTime: &cachingTime,
+			},
+		},
+	})
+	require.Len(t, pfdDatas, 1)
+	require.Equal(t, "app1", pfdDatas[0].AppID)
+	require.Equal(t, cachingTime, pfdDatas[0].CachingTime)
+	require.Equal(t, []string{"app1.example.com"}, pfdDatas[0].Pfds[0].DomainNames)
+}
+
+func TestPfdStore(t *testing.T) {
+	store := NewPfdStore()
+	expiredCh := make(chan string, 1)
+	store.SetExpiredHandler(func(appID string) {
+		expiredCh <- appID
+	})
+
+	static := &PfdDataForApp{AppID: "app1"}
+	require.Empty(t, store.Replace([]*PfdDataForApp{static}))
+	require.True(t, store.Put(&PfdDataForApp{
+		AppID:       "app1",
+		CachingTime: time.Now().Add(50 * time.Millisecond),
+	}))
+	// the PFDs which have expired are not cached
+	require.False(t, store.Put(&PfdDataForApp{
+		AppID:       "app2",
+		CachingTime: time.Now().Add(-time.Second),
+	}))
+	require.NotEqual(t, static, store.Get("app1"))
+	require.Nil(t, store.Get("app2"))
+	require.Len(t, store.All(), 1)
+
+	// the PFDs of the UE routing config are used after the cached PFDs expire
+	select {
+	case appID := <-expiredCh:
+		require.Equal(t, "app1", appID)
+	case <-time.After(time.Second):
+		t.Fatal("PFDs of app1 don't expire")
+	}
+	require.Equal(t, static, store.Get("app1"))
+
+	require.True(t, store.Put(&PfdDataForApp{AppID: "app3"}))
+	store.Delete("app3")
+	require.Nil(t, store.Get("app3"))
+
+	require.True(t, store.Put(&PfdDataForApp{AppID: "app4"}))
+	removed := store.Replace(nil)
+	require.Equal(t, []string{"app1"}, removed)
+	require.NotNil(t, store.Get("app4"))
+}
+
+func TestPfdDataFlowDescription(t *testing.T) {
+	pfdData := &PfdDataForApp{
+		AppID: "app1",
+		Pfds: []Pfd{
+			{PfdID: "pfd1", DomainNames: []string{"app1.example.com"}},
+			{PfdID: "pfd2", FlowDescriptions: []string{"permit out ip from 10.60.0.1 to 10.60.0.0/16"}},
+		},
+	}
+	require.Equal(t, "permit out ip from 10.60.0.1 to 10.60.0.0/16", pfdData.FlowDescription())
+	require.Empty(t, (&PfdDataForApp{AppID: "app2"}).FlowDescription())
+}
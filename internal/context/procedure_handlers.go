@@ -0,0 +1,44 @@
+package context
+
+import (
+	"net"
+	"time"
+)
+
+// ProcedureHandlers are the procedures of SMF triggered by the packages they depend on, e.g. the PDU session
+// procedures of the SBI producers triggered by the PFCP handlers. The packages can't import the procedures
+// without an import cycle, so SMF service registers the procedures on start and the packages call them from
+// here. A procedure which isn't registered, e.g. in the tests of a package, is skipped
+type ProcedureHandlers struct {
+	// UPFRelease drains the PDU sessions of UPF which releases the PFCP association
+	// within the graceful release period
+	UPFRelease func(upf *UPF, gracefulReleasePeriod time.Duration)
+	// UserPlanePathFailure releases or reroutes the PDU sessions over the user plane path
+	// from UPF to the remote GTP-U peer which UPF reports failed
+	UserPlanePathFailure func(upf *UPF, remoteGTPUPeer net.IP)
+	// SessionSetDeletion releases the PDU sessions whose PFCP sessions are deleted
+	// by PFCP Session Set Deletion of UPF
+	SessionSetDeletion func(upf *UPF, smContexts []*SMContext)
+	// ChargingReport reports the usage to CHF for the new quota when the quota is exhausted,
+	// reaches the threshold or expires
+	ChargingReport func(smContext *SMContext)
+	// UsageMonitoringReport reports the accumulated usage to PCF when the usage of a monitoring key
+	// reaches its threshold
+	UsageMonitoringReport func(smContext *SMContext)
+	// UsageQuery queries the usage of the URRs of the PDU session from UPFs without the SM lock,
+	// and returns the UPFs whose usage isn't recorded
+	UsageQuery func(smContext *SMContext) (failedUPFs []string)
+	// PfdsUpdate provisions the updated PFDs to UPFs, the PFDs of removedAppIDs are removed
+	PfdsUpdate func(pfdDatas []*PfdDataForApp, removedAppIDs []string)
+}
+
+var procedureHandlers ProcedureHandlers
+
+// SetProcedureHandlers registers the procedure handlers before the PFCP and SBI servers start
+func SetProcedureHandlers(h ProcedureHandlers) {
+	procedureHandlers = h
+}
+
+func GetProcedureHandlers() *ProcedureHandlers {
+	return &procedureHandlers
+}
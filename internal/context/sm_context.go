@@ -2,6 +2,7 @@ package context
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
 	"math"
 	"net"
@@ -24,6 +25,8 @@ import (
 	"bitbucket.org/free5gc-team/openapi/Npcf_SMPolicyControl"
 	"bitbucket.org/free5gc-team/openapi/models"
 	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+	"bitbucket.org/free5gc-team/smf/internal/cdr"
+	"bitbucket.org/free5gc-team/smf/internal/dnaaa"
 	"bitbucket.org/free5gc-team/smf/internal/logger"
 	"bitbucket.org/free5gc-team/smf/pkg/factory"
 	"bitbucket.org/free5gc-team/util/idgenerator"
@@ -43,6 +46,15 @@ const (
 	NoForwarding
 )
 
+// SMFRole is the role of the SMF for a PDU session in home-routed roaming
+type SMFRole int
+
+const (
+	SMFRoleNonRoaming SMFRole = iota
+	SMFRoleHSMF
+	SMFRoleVSMF
+)
+
 type UrrType int
 
 const (
@@ -52,21 +64,22 @@ const (
 	N3N9_MAEQ_URR
 	N9N6_MBEQ_URR
 	N9N6_MAEQ_URR
+	N6_MACAR_URR
 	NOT_FOUND_URR
 )
 
 func (t UrrType) String() string {
-	urrTypeList := []string{"N3N6_MBEQ", "N3N6_MAEQ", "N3N9_MBEQ", "N3N9_MAEQ", "N9N6_MBEQ", "N9N6_MAEQ"}
+	urrTypeList := []string{"N3N6_MBEQ", "N3N6_MAEQ", "N3N9_MBEQ", "N3N9_MAEQ", "N9N6_MBEQ", "N9N6_MAEQ", "N6_MACAR"}
 	return urrTypeList[t]
 }
 
 func (t UrrType) IsBeforeQos() bool {
-	urrTypeList := []bool{true, false, true, false, true, false}
+	urrTypeList := []bool{true, false, true, false, true, false, false}
 	return urrTypeList[t]
 }
 
 func (t UrrType) Direct() string {
-	urrTypeList := []string{"N3N6", "N3N6", "N3N9", "N3N9", "N9N6", "N9N6"}
+	urrTypeList := []string{"N3N6", "N3N6", "N3N9", "N3N9", "N9N6", "N9N6", "N6"}
 	return urrTypeList[t]
 }
 
@@ -95,7 +108,8 @@ func GetSMContextCount() uint64 {
 type EventExposureNotification struct {
 	*models.NsmfEventExposureNotification
 
-	Uri string
+	Uri   string
+	SubId string
 }
 
 type UsageReport struct {
@@ -109,6 +123,8 @@ type UsageReport struct {
 	UplinkPktNum   uint64
 	DownlinkPktNum uint64
 
+	Duration time.Duration
+
 	ReportTpye models.TriggerType
 }
 
@@ -134,8 +150,26 @@ type SMContext struct {
 	PDUAddress             net.IP
 	UseStaticIP            bool
 	SelectedPDUSessionType uint8
+	// SSCMode is the SSC mode (1, 2 or 3) selected for the PDU session
+	SSCMode uint8
+	// the /64 prefix is in PDUAddress for IPv6 PDU session and in PDUAddressIPv6 for IPv4v6 PDU session,
+	// InterfaceIdentifier is provided to UE for its IPv6 link-local address
+	PDUAddressIPv6      net.IP
+	UseStaticIPv6       bool
+	InterfaceIdentifier []byte
+	// MAC addresses behind UE of Ethernet PDU session reported by UPF
+	UeMacAddresses []net.HardwareAddr
+
+	// Secondary authentication by DN-AAA, DNSpecificIdentity is provided by UE
+	// in the SM PDU DN request container and EAPMessage is the EAP-Success or EAP-Failure
+	// sent to UE in PDU Session Establishment Accept or Reject
+	DNSpecificIdentity string
+	DnAaaSession       *dnaaa.Session
+	DnAaaAuthorization *dnaaa.Authorization
+	EAPMessage         []byte
 
 	DnnConfiguration models.DnnConfiguration
+	InternalGroupIds []string
 
 	SMPolicyID string
 
@@ -159,6 +193,12 @@ type SMContext struct {
 	SelectedPCFProfile models.NfProfile
 	SmStatusNotifyUri  string
 
+	// Home-routed roaming related
+	Role              SMFRole
+	VsmfId            string
+	VsmfPduSessionUri string
+	HsmfPduSessionUri string
+
 	Tunnel      *UPTunnel
 	SelectedUPF *UPNode
 	BPManager   *BPManager
@@ -173,9 +213,11 @@ type SMContext struct {
 	SessionRules        map[string]*SessionRule
 	TrafficControlDatas map[string]*TrafficControlData
 	QosDatas            map[string]*models.QosData
+	ChargingDatas       map[string]*models.ChargingData
 
 	UpPathChgEarlyNotification map[string]*EventExposureNotification // Key: Uri+NotifId
 	UpPathChgLateNotification  map[string]*EventExposureNotification // Key: Uri+NotifId
+	EventExposureNotification  map[string]*EventExposureNotification // Key: Uri+NotifId
 	DataPathToBeRemoved        map[int64]*DataPath                   // Key: pathID
 
 	SelectedSessionRuleID string
@@ -198,6 +240,25 @@ type SMContext struct {
 	UrrReportTime      time.Duration
 	UrrReportThreshold uint64
 	UrrReports         []UsageReport
+	// URRs of the charging keys of PCC rules
+	ChargingKeyUrrIDs map[ChargingKey]uint32
+	// Usage monitoring controlled by PCF, key: monitoring key
+	UsageMonitors         map[string]*UsageMonitor
+	UsageMonitoringUrrIDs map[string]uint32
+
+	// ChargingID is shared by the online charging and the charging records, 0 until it's allocated
+	ChargingID uint32
+	// Online charging by CHF, nil if the PDU session isn't charged
+	ChargingSession *ChargingSession
+	// Offline charging record, RecordClosingCause is the cause of the final record
+	chargingRecord     chargingRecordState
+	RecordClosingCause cdr.ClosingCause
+
+	// Downlink data buffering
+	// DLBuffer is the downlink data buffered in SMF, nil if it is buffered in UPF
+	DLBuffer           *DLBuffer
+	lastDDNTime        time.Time
+	ddnSuppressedUntil time.Time
 
 	// NAS
 	Pti                     uint8
@@ -213,6 +274,8 @@ type SMContext struct {
 	Log *logrus.Entry
 
 	// 5GSM Timers
+	// T3590 is PDU SESSION AUTHENTICATION COMMAND timer
+	T3590 *Timer
 	// T3591 is PDU SESSION MODIFICATION COMMAND timer
 	T3591 *Timer
 	// T3592 is PDU SESSION RELEASE COMMAND timer
@@ -260,6 +323,7 @@ func NewSMContext(id string, pduSessID int32) *SMContext {
 	smContext.QosDatas = make(map[string]*models.QosData)
 	smContext.UpPathChgEarlyNotification = make(map[string]*EventExposureNotification)
 	smContext.UpPathChgLateNotification = make(map[string]*EventExposureNotification)
+	smContext.EventExposureNotification = make(map[string]*EventExposureNotification)
 	smContext.DataPathToBeRemoved = make(map[int64]*DataPath)
 
 	smContext.ProtocolConfigurationOptions = &ProtocolConfigurationOptions{}
@@ -324,14 +388,14 @@ func RemoveSMContext(ref string) {
 	if smContext.SelectedUPF != nil && smContext.PDUAddress != nil {
 		logger.PduSessLog.Infof("UE[%s] PDUSessionID[%d] Release IP[%s]",
 			smContext.Supi, smContext.PDUSessionID, smContext.PDUAddress.String())
-		GetUserPlaneInformation().
-			ReleaseUEIP(smContext.SelectedUPF, smContext.PDUAddress, smContext.UseStaticIP)
+		smContext.ReleaseUeIP()
 		smContext.SelectedUPF = nil
 	}
 
 	for _, pfcpSessionContext := range smContext.PFCPContext {
 		seidSMContextMap.Delete(pfcpSessionContext.LocalSEID)
 	}
+	smContext.ReleaseDLBuffer()
 
 	smContextPool.Delete(ref)
 	canonicalRef.Delete(canonicalName(smContext.Supi, smContext.PDUSessionID))
@@ -397,17 +461,120 @@ func (smContext *SMContext) State() SMContextState {
 func (smContext *SMContext) PDUAddressToNAS() ([12]byte, uint8) {
 	var addr [12]byte
 	var addrLen uint8
-	copy(addr[:], smContext.PDUAddress)
 	switch smContext.SelectedPDUSessionType {
 	case nasMessage.PDUSessionTypeIPv4:
+		copy(addr[:], smContext.PDUAddress.To4())
 		addrLen = 4 + 1
 	case nasMessage.PDUSessionTypeIPv6:
+		// only the interface identifier is sent to UE (TS 24.501 9.11.4.10), SMF doesn't send the Router
+		// Advertisement of the /64 prefix (TS 23.501 5.8.2.2.2), so it has to be advertised by PSA UPF
+		copy(addr[:], smContext.InterfaceIdentifier)
+		addrLen = 8 + 1
 	case nasMessage.PDUSessionTypeIPv4IPv6:
+		copy(addr[:], smContext.InterfaceIdentifier)
+		copy(addr[8:], smContext.PDUAddress.To4())
 		addrLen = 12 + 1
 	}
 	return addr, addrLen
 }
 
+// PDNType returns the PDN type of the PFCP session by the PDU session type (TS 29.244 8.2.79)
+func (smContext *SMContext) PDNType() uint8 {
+	switch smContext.SelectedPDUSessionType {
+	case nasMessage.PDUSessionTypeIPv6:
+		return pfcpType.PDNTypeIpv6
+	case nasMessage.PDUSessionTypeIPv4IPv6:
+		return pfcpType.PDNTypeIpv4v6
+	case nasMessage.PDUSessionTypeEthernet:
+		return pfcpType.PDNTypeEthernet
+	case nasMessage.PDUSessionTypeUnstructured:
+		return pfcpType.PDNTypeNonIp
+	default:
+		return pfcpType.PDNTypeIpv4
+	}
+}
+
+// UeIPv6Prefix returns the /64 IPv6 prefix of UE in CIDR notation, or empty string if UE has no IPv6 prefix
+func (smContext *SMContext) UeIPv6Prefix() string {
+	if smContext.PDUAddressIPv6 != nil {
+		return fmt.Sprintf("%s/%d", smContext.PDUAddressIPv6, IPv6PrefixLen)
+	}
+	if smContext.PDUAddress == nil || smContext.PDUAddress.To4() != nil {
+		return ""
+	}
+	return fmt.Sprintf("%s/%d", smContext.PDUAddress, IPv6PrefixLen)
+}
+
+// UeIPv4Address returns the IPv4 address of UE, or empty string if UE has no IPv4 address
+func (smContext *SMContext) UeIPv4Address() string {
+	if smContext.SelectedPDUSessionType == nasMessage.PDUSessionTypeUnstructured {
+		// the address is the N6 tunnel endpoint of UPF and it is not used by UE
+		return ""
+	}
+	if ipv4 := smContext.PDUAddress.To4(); ipv4 != nil {
+		return ipv4.String()
+	}
+	return ""
+}
+
+// N6Tunnel returns the N6 point-to-point tunnel of Unstructured PDU session, or nil for other PDU session types.
+// The IPv4 address in PDUAddress is the tunnel endpoint of UPF (TS 23.501 5.6.10.3)
+func (smContext *SMContext) N6Tunnel() *N6Tunnel {
+	if smContext.SelectedPDUSessionType != nasMessage.PDUSessionTypeUnstructured || smContext.DNNInfo == nil {
+		return nil
+	}
+	return smContext.DNNInfo.N6Tunnel
+}
+
+// ReleaseUeIP releases the UE IPv4 address and IPv6 prefix to the UE IP pools of the selected UPF
+func (smContext *SMContext) ReleaseUeIP() {
+	if smContext.Role == SMFRoleVSMF {
+		// allocated by H-SMF
+		smContext.PDUAddress = nil
+		smContext.PDUAddressIPv6 = nil
+		return
+	}
+	upi := GetUserPlaneInformation()
+	if smContext.PDUAddress != nil {
+		upi.ReleaseUEIP(smContext.SelectedUPF, smContext.PDUAddress, smContext.UseStaticIP)
+		smContext.PDUAddress = nil
+	}
+	if smContext.PDUAddressIPv6 != nil {
+		upi.ReleaseUEIP(smContext.SelectedUPF, smContext.PDUAddressIPv6, smContext.UseStaticIPv6)
+		smContext.PDUAddressIPv6 = nil
+	}
+}
+
+// UEIPAddressIE returns the UE IP Address IE of PDI, sd is set for the destination address of downlink packets.
+// The UPF matches the /64 prefix of UE for IPv6 PDU session
+func (smContext *SMContext) UEIPAddressIE(sd bool) *pfcpType.UEIPAddress {
+	switch smContext.SelectedPDUSessionType {
+	case nasMessage.PDUSessionTypeEthernet:
+		// Ethernet PDU session has no UE IP, UPF matches the traffic by the Ethernet PDU session information
+		return nil
+	case nasMessage.PDUSessionTypeIPv6:
+		return &pfcpType.UEIPAddress{
+			V6:          true,
+			Sd:          sd,
+			Ipv6Address: smContext.PDUAddress.To16(),
+		}
+	case nasMessage.PDUSessionTypeIPv4IPv6:
+		return &pfcpType.UEIPAddress{
+			V4:          true,
+			V6:          true,
+			Sd:          sd,
+			Ipv4Address: smContext.PDUAddress.To4(),
+			Ipv6Address: smContext.PDUAddressIPv6.To16(),
+		}
+	default:
+		return &pfcpType.UEIPAddress{
+			V4:          true,
+			Sd:          sd,
+			Ipv4Address: smContext.PDUAddress.To4(),
+		}
+	}
+}
+
 // PCFSelection will select PCF for this SM Context
 func (smContext *SMContext) PCFSelection() error {
 	// Send NFDiscovery for find PCF
@@ -473,9 +640,10 @@ func (smContext *SMContext) AllocateLocalSEIDForUPPath(path UPPath) {
 			allocatedSEID := AllocateLocalSEID()
 
 			smContext.PFCPContext[NodeIDtoIP] = &PFCPSessionContext{
-				PDRs:      make(map[uint16]*PDR),
-				NodeID:    upNode.NodeID,
-				LocalSEID: allocatedSEID,
+				PDRs:        make(map[uint16]*PDR),
+				NodeID:      upNode.NodeID,
+				LocalSEID:   allocatedSEID,
+				LocalFQCSID: GetSelf().SessionFQCSID(allocatedSEID),
 			}
 
 			seidSMContextMap.Store(allocatedSEID, smContext)
@@ -491,9 +659,10 @@ func (smContext *SMContext) AllocateLocalSEIDForDataPath(dataPath *DataPath) {
 		if _, exist := smContext.PFCPContext[NodeIDtoIP]; !exist {
 			allocatedSEID := AllocateLocalSEID()
 			smContext.PFCPContext[NodeIDtoIP] = &PFCPSessionContext{
-				PDRs:      make(map[uint16]*PDR),
-				NodeID:    node.UPF.NodeID,
-				LocalSEID: allocatedSEID,
+				PDRs:        make(map[uint16]*PDR),
+				NodeID:      node.UPF.NodeID,
+				LocalSEID:   allocatedSEID,
+				LocalFQCSID: GetSelf().SessionFQCSID(allocatedSEID),
 			}
 
 			seidSMContextMap.Store(allocatedSEID, smContext)
@@ -501,6 +670,17 @@ func (smContext *SMContext) AllocateLocalSEIDForDataPath(dataPath *DataPath) {
 	}
 }
 
+// ReleasePFCPSessionContexts removes the PFCP session contexts and the QERs of the PDU session
+// after its PFCP sessions are deleted, they are allocated again when a new data path is activated
+func (smContext *SMContext) ReleasePFCPSessionContexts() {
+	for nodeIDtoIP, pfcpSessionContext := range smContext.PFCPContext {
+		seidSMContextMap.Delete(pfcpSessionContext.LocalSEID)
+		delete(smContext.PFCPContext, nodeIDtoIP)
+	}
+	smContext.AMBRQerMap = make(map[uuid.UUID]uint32)
+	smContext.QerUpfMap = make(map[string]uint32)
+}
+
 func (smContext *SMContext) PutPDRtoPFCPSession(nodeID pfcpType.NodeID, pdr *PDR) error {
 	NodeIDtoIP := nodeID.ResolveNodeIdToIp().String()
 	if pfcpSessCtx, exist := smContext.PFCPContext[NodeIDtoIP]; exist {
@@ -518,6 +698,42 @@ func (c *SMContext) findPSAandAllocUeIP(param *UPFSelectionParams) error {
 	}
 
 	upi := GetUserPlaneInformation()
+	if param.PDUSessionType == nasMessage.PDUSessionTypeEthernet {
+		// UE IP is not allocated for Ethernet PDU session
+		var err error
+		c.SelectedUPF, err = upi.SelectUPFForNonIPSession(param)
+		return err
+	}
+
+	err := c.selectPSAandAllocUeIP(upi, param)
+	if err == nil || param.PDUSessionType != nasMessage.PDUSessionTypeIPv4IPv6 {
+		return err
+	}
+	// IPv4v6 PDU session falls back to the address family which a UPF can allocate, e.g. no UPF has
+	// the IPv6 prefix pool paired with the IPv4 address pool, and UE is told by #50 or #51 (TS 24.501 6.4.1.3)
+	c.Log.Warnf("%v, fall back to single-stack PDU session", err)
+	ipv4Param := *param
+	ipv4Param.PDUSessionType = nasMessage.PDUSessionTypeIPv4
+	ipv4Param.PDUAddressIPv6 = nil
+	if c.selectPSAandAllocUeIP(upi, &ipv4Param) == nil {
+		*param = ipv4Param
+		c.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4
+		c.EstAcceptCause5gSMValue = nasMessage.Cause5GSMPDUSessionTypeIPv4OnlyAllowed
+		return nil
+	}
+	ipv6Param := param.ipv6Selection()
+	if c.selectPSAandAllocUeIP(upi, ipv6Param) == nil {
+		*param = *ipv6Param
+		c.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv6
+		c.EstAcceptCause5gSMValue = nasMessage.Cause5GSMPDUSessionTypeIPv6OnlyAllowed
+		return nil
+	}
+	return err
+}
+
+// selectPSAandAllocUeIP selects the PSA UPF and allocates the UE IP of the PDU session type of param,
+// the IPv4 address and the IPv6 prefix of IPv4v6 PDU session are allocated from the same UPF
+func (c *SMContext) selectPSAandAllocUeIP(upi *UserPlaneInformation, param *UPFSelectionParams) error {
 	if GetSelf().ULCLSupport && CheckUEHasPreConfig(c.Supi) {
 		groupName := GetULCLGroupNameFromSUPI(c.Supi)
 		preConfigPathPool := GetUEDefaultPathPool(groupName)
@@ -535,9 +751,37 @@ func (c *SMContext) findPSAandAllocUeIP(param *UPFSelectionParams) error {
 		return fmt.Errorf("fail to allocate PDU address, Selection Parameter: %s",
 			param.String())
 	}
+	if param.PDUSessionType == nasMessage.PDUSessionTypeIPv4IPv6 {
+		// the IPv6 prefix is allocated from the UPF which the IPv4 address is allocated from
+		c.PDUAddressIPv6, c.UseStaticIPv6 = upi.AllocUEIPv6Prefix(c.SelectedUPF, param)
+		if c.PDUAddressIPv6 == nil {
+			upi.ReleaseUEIP(c.SelectedUPF, c.PDUAddress, c.UseStaticIP)
+			c.PDUAddress = nil
+			return fmt.Errorf("fail to allocate IPv6 prefix, Selection Parameter: %s",
+				param.String())
+		}
+		c.Log.Infof("Allocated IPv6 prefix[%s]", c.UeIPv6Prefix())
+	}
+	if param.PDUSessionType == nasMessage.PDUSessionTypeIPv6 ||
+		param.PDUSessionType == nasMessage.PDUSessionTypeIPv4IPv6 {
+		c.InterfaceIdentifier = newInterfaceIdentifier()
+	}
 	return nil
 }
 
+// newInterfaceIdentifier returns a random IPv6 interface identifier,
+// it only has to be unique in the link of the /64 prefix of the PDU session
+func newInterfaceIdentifier() []byte {
+	iid := make([]byte, 8)
+	if _, err := rand.Read(iid); err != nil {
+		logger.CtxLog.Warnf("generate interface identifier failed: %v", err)
+		iid[7] = 1
+	}
+	// the universal/local bit is set to local
+	iid[0] &^= 0x02
+	return iid
+}
+
 func (c *SMContext) AllocUeIP() error {
 	c.SelectionParam = &UPFSelectionParams{
 		Dnn: c.Dnn,
@@ -545,12 +789,47 @@ func (c *SMContext) AllocUeIP() error {
 			Sst: c.SNssai.Sst,
 			Sd:  c.SNssai.Sd,
 		},
+		PDUSessionType: c.SelectedPDUSessionType,
 	}
 
 	if len(c.DnnConfiguration.StaticIpAddress) > 0 {
 		staticIPConfig := c.DnnConfiguration.StaticIpAddress[0]
-		if staticIPConfig.Ipv4Addr != "" {
-			c.SelectionParam.PDUAddress = net.ParseIP(staticIPConfig.Ipv4Addr).To4()
+		var staticIPv6Prefix net.IP
+		if _, ipNet, err := net.ParseCIDR(staticIPConfig.Ipv6Prefix); err == nil {
+			staticIPv6Prefix = ipNet.IP
+		}
+		switch c.SelectedPDUSessionType {
+		case nasMessage.PDUSessionTypeEthernet:
+		case nasMessage.PDUSessionTypeIPv6:
+			c.SelectionParam.PDUAddress = staticIPv6Prefix
+		case nasMessage.PDUSessionTypeIPv4IPv6:
+			c.SelectionParam.PDUAddressIPv6 = staticIPv6Prefix
+			fallthrough
+		default:
+			if staticIPConfig.Ipv4Addr != "" {
+				c.SelectionParam.PDUAddress = net.ParseIP(staticIPConfig.Ipv4Addr).To4()
+			}
+		}
+	}
+
+	// the UE IP address authorized by DN-AAA takes precedence over the subscribed one
+	if auth := c.DnAaaAuthorization; auth != nil {
+		switch c.SelectedPDUSessionType {
+		case nasMessage.PDUSessionTypeIPv4:
+			if auth.UEIPv4Address != nil {
+				c.SelectionParam.PDUAddress = auth.UEIPv4Address.To4()
+			}
+		case nasMessage.PDUSessionTypeIPv6:
+			if prefix := c.dnAaaIPv6Prefix(); prefix != nil {
+				c.SelectionParam.PDUAddress = prefix
+			}
+		case nasMessage.PDUSessionTypeIPv4IPv6:
+			if auth.UEIPv4Address != nil {
+				c.SelectionParam.PDUAddress = auth.UEIPv4Address.To4()
+			}
+			if prefix := c.dnAaaIPv6Prefix(); prefix != nil {
+				c.SelectionParam.PDUAddressIPv6 = prefix
+			}
 		}
 	}
 
@@ -560,6 +839,20 @@ func (c *SMContext) AllocUeIP() error {
 	return nil
 }
 
+// dnAaaIPv6Prefix returns the IPv6 prefix authorized by DN-AAA, UE is assigned a /64 prefix
+// (TS 23.501 5.8.2.2.2), so the prefix of other length is ignored
+func (c *SMContext) dnAaaIPv6Prefix() net.IP {
+	prefix := c.DnAaaAuthorization.UEIPv6Prefix
+	if prefix == nil {
+		return nil
+	}
+	if ones, _ := prefix.Mask.Size(); ones != 64 {
+		c.Log.Warnf("Ignore IPv6 prefix %s authorized by DN-AAA: not /64", prefix)
+		return nil
+	}
+	return prefix.IP
+}
+
 func (c *SMContext) SelectDefaultDataPath() error {
 	if c.SelectionParam == nil || c.SelectedUPF == nil {
 		return fmt.Errorf("SelectDefaultDataPath err: SelectionParam or SelectedUPF is nil")
@@ -594,7 +887,7 @@ func (c *SMContext) SelectDefaultDataPath() error {
 }
 
 func (c *SMContext) CreatePccRuleDataPath(pccRule *PCCRule,
-	tcData *TrafficControlData, qosData *models.QosData,
+	tcData *TrafficControlData, qosData *models.QosData, chgData *models.ChargingData,
 ) error {
 	var targetRoute models.RouteToLocation
 	if tcData != nil && len(tcData.RouteToLocs) > 0 {
@@ -615,6 +908,12 @@ func (c *SMContext) CreatePccRuleDataPath(pccRule *PCCRule,
 	}
 	createdDataPath.GBRFlow = isGBRFlow(qosData)
 	createdDataPath.ActivateTunnelAndPDR(c, uint32(pccRule.Precedence))
+	if chgData != nil {
+		createdDataPath.addChargingKeyUrr(c, chgData)
+	}
+	if umID := pccRule.RefUmDataID(); umID != "" {
+		createdDataPath.addUsageMonitoringUrr(c, umID)
+	}
 	c.Tunnel.AddDataPath(createdDataPath)
 	pccRule.Datapath = createdDataPath
 	pccRule.AddDataPathForwardingParameters(c, &targetRoute)
@@ -683,7 +982,7 @@ func newEventExposureNotification(
 }
 
 type NotifCallback func(uri string,
-	notification *models.NsmfEventExposureNotification)
+	notification *models.NsmfEventExposureNotification) error
 
 func (c *SMContext) SendUpPathChgNotification(chgType string, notifCb NotifCallback) {
 	var notifications map[string]*EventExposureNotification
@@ -696,7 +995,7 @@ func (c *SMContext) SendUpPathChgNotification(chgType string, notifCb NotifCallb
 	}
 	for k, n := range notifications {
 		c.Log.Infof("Send UpPathChg Event Exposure Notification [%s][%s] to NEF/AF", chgType, n.NotifId)
-		go notifCb(n.Uri, n.NsmfEventExposureNotification)
+		dispatchEventExposureNotification(n, notifCb)
 		delete(notifications, k)
 	}
 }
@@ -707,6 +1006,53 @@ func (smContext *SMContext) RemovePDRfromPFCPSession(nodeID pfcpType.NodeID, pdr
 	delete(pfcpSessCtx.PDRs, pdr.PDRID)
 }
 
+// SupportedPDUSessionType returns "IPv4", "IPv6" or "IPv4v6" according to the UE IP pools of the DNN
+func (smContext *SMContext) SupportedPDUSessionType() string {
+	upi := GetUserPlaneInformation()
+	if upi == nil || smContext.SNssai == nil {
+		return "IPv4"
+	}
+	return upi.SupportedPDUSessionType(&SNssai{Sst: smContext.SNssai.Sst, Sd: smContext.SNssai.Sd}, smContext.Dnn)
+}
+
+// SelectSSCMode selects the SSC mode of the PDU session (TS 23.501 5.6.9.3), the SSC mode requested
+// by UE is accepted if the subscription allows it, otherwise the default SSC mode of the subscription
+// is selected. SSC mode 1 is selected if there is no subscribed SSC mode
+func (smContext *SMContext) SelectSSCMode(requestedSSCMode uint8) {
+	smContext.SSCMode = 1
+	sscModes := smContext.DnnConfiguration.SscModes
+	if sscModes == nil {
+		return
+	}
+	if requestedSSCMode != 0 {
+		requested := SscModeToModels(requestedSSCMode)
+		for _, allowed := range append([]models.SscMode{sscModes.DefaultSscMode}, sscModes.AllowedSscModes...) {
+			if allowed == requested {
+				smContext.SSCMode = requestedSSCMode
+				return
+			}
+		}
+	}
+	if mode := SscModeFromModels(sscModes.DefaultSscMode); mode != 0 {
+		smContext.SSCMode = mode
+	}
+}
+
+// SscModeToModels converts the SSC mode value of NAS to models.SscMode, e.g. 1 to SSC_MODE_1
+func SscModeToModels(sscMode uint8) models.SscMode {
+	return models.SscMode(fmt.Sprintf("SSC_MODE_%d", sscMode))
+}
+
+// SscModeFromModels converts models.SscMode to the SSC mode value of NAS, 0 if it's invalid
+func SscModeFromModels(sscMode models.SscMode) uint8 {
+	for mode := uint8(1); mode <= 3; mode++ {
+		if sscMode == SscModeToModels(mode) {
+			return mode
+		}
+	}
+	return 0
+}
+
 func (smContext *SMContext) IsAllowedPDUSessionType(requestedPDUSessionType uint8) error {
 	dnnPDUSessionType := smContext.DnnConfiguration.PduSessionTypes
 	if dnnPDUSessionType == nil {
@@ -716,6 +1062,7 @@ func (smContext *SMContext) IsAllowedPDUSessionType(requestedPDUSessionType uint
 	allowIPv4 := false
 	allowIPv6 := false
 	allowEthernet := false
+	allowUnstructured := false
 
 	for _, allowedPDUSessionType := range smContext.DnnConfiguration.PduSessionTypes.AllowedSessionTypes {
 		switch allowedPDUSessionType {
@@ -728,13 +1075,22 @@ func (smContext *SMContext) IsAllowedPDUSessionType(requestedPDUSessionType uint
 			allowIPv6 = true
 		case models.PduSessionType_ETHERNET:
 			allowEthernet = true
+		case models.PduSessionType_UNSTRUCTURED:
+			allowUnstructured = true
 		}
 	}
+	if !GetSelf().EthernetSupport {
+		allowEthernet = false
+	}
+	// Unstructured PDU session is only supported by the DNN with N6 point-to-point tunnel
+	if smContext.DNNInfo == nil || smContext.DNNInfo.N6Tunnel == nil {
+		allowUnstructured = false
+	}
 
-	supportedPDUSessionType := GetSelf().SupportedPDUSessionType
+	supportedPDUSessionType := smContext.SupportedPDUSessionType()
 	switch supportedPDUSessionType {
 	case "IPv4":
-		if !allowIPv4 {
+		if !allowIPv4 && !allowEthernet && !allowUnstructured {
 			return fmt.Errorf(
 				"No SupportedPDUSessionType[%q] in DNN[%s] configuration",
 				supportedPDUSessionType,
@@ -742,7 +1098,7 @@ func (smContext *SMContext) IsAllowedPDUSessionType(requestedPDUSessionType uint
 			)
 		}
 	case "IPv6":
-		if !allowIPv6 {
+		if !allowIPv6 && !allowEthernet && !allowUnstructured {
 			return fmt.Errorf(
 				"No SupportedPDUSessionType[%q] in DNN[%s] configuration",
 				supportedPDUSessionType,
@@ -750,15 +1106,7 @@ func (smContext *SMContext) IsAllowedPDUSessionType(requestedPDUSessionType uint
 			)
 		}
 	case "IPv4v6":
-		if !allowIPv4 && !allowIPv6 {
-			return fmt.Errorf(
-				"No SupportedPDUSessionType[%q] in DNN[%s] configuration",
-				supportedPDUSessionType,
-				smContext.Dnn,
-			)
-		}
-	case "Ethernet":
-		if !allowEthernet {
+		if !allowIPv4 && !allowIPv6 && !allowEthernet && !allowUnstructured {
 			return fmt.Errorf(
 				"No SupportedPDUSessionType[%q] in DNN[%s] configuration",
 				supportedPDUSessionType,
@@ -767,6 +1115,14 @@ func (smContext *SMContext) IsAllowedPDUSessionType(requestedPDUSessionType uint
 		}
 	}
 
+	// UE IP can only be allocated from the address family supported by the UE IP pools
+	switch supportedPDUSessionType {
+	case "IPv4":
+		allowIPv6 = false
+	case "IPv6":
+		allowIPv4 = false
+	}
+
 	smContext.EstAcceptCause5gSMValue = 0
 	switch nasConvert.PDUSessionTypeToModels(requestedPDUSessionType) {
 	case models.PduSessionType_IPV4:
@@ -799,6 +1155,12 @@ func (smContext *SMContext) IsAllowedPDUSessionType(requestedPDUSessionType uint
 		} else {
 			return fmt.Errorf("PduSessionType_ETHERNET is not allowed in DNN[%s] configuration", smContext.Dnn)
 		}
+	case models.PduSessionType_UNSTRUCTURED:
+		if allowUnstructured {
+			smContext.SelectedPDUSessionType = nasConvert.ModelsToPDUSessionType(models.PduSessionType_UNSTRUCTURED)
+		} else {
+			return fmt.Errorf("PduSessionType_UNSTRUCTURED is not allowed in DNN[%s] configuration", smContext.Dnn)
+		}
 	default:
 		return fmt.Errorf("Requested PDU Sesstion type[%d] is not supported", requestedPDUSessionType)
 	}
@@ -814,6 +1176,13 @@ func (smContext *SMContext) GetUrrTypeById(urrId uint32) (UrrType, error) {
 	return NOT_FOUND_URR, fmt.Errorf("Urr type not found ")
 }
 
+func (smContext *SMContext) StopT3590() {
+	if smContext.T3590 != nil {
+		smContext.T3590.Stop()
+		smContext.T3590 = nil
+	}
+}
+
 func (smContext *SMContext) StopT3591() {
 	if smContext.T3591 != nil {
 		smContext.T3591.Stop()
@@ -855,6 +1224,9 @@ func (smContext *SMContext) AssignQFI(qosId string) uint8 {
 			return 0
 		}
 		smContext.qosDataToQFI[qosId] = uint8(newId)
+		smContext.Log.Debugf("Allocate QFI[%d] for QosData[%s]", newId, qosId)
+		smContext.BuildEventExposureNotification(models.SmfEvent_QFI_ALLOC,
+			smContext.QFIAllocNotification(uint8(newId)))
 		return uint8(newId)
 	}
 	return qfi
@@ -5,7 +5,6 @@ import (
 	"reflect"
 
 	"bitbucket.org/free5gc-team/openapi/models"
-	"bitbucket.org/free5gc-team/smf/pkg/factory"
 )
 
 // SM Policy related operation
@@ -25,6 +24,9 @@ func (c *SMContext) ApplySessionRules(
 		return fmt.Errorf("SmPolicyDecision is nil")
 	}
 
+	// Usage monitoring data is referred by session rules and PCC rules
+	c.ApplyUsageMonitoringDatas(decision.UmDecs)
+
 	for id, r := range decision.SessRules {
 		if r == nil {
 			c.Log.Debugf("Delete SessionRule[%s]", id)
@@ -78,6 +80,7 @@ func (c *SMContext) ApplyPccRules(
 	finalPccRules := make(map[string]*PCCRule)
 	finalTcDatas := make(map[string]*TrafficControlData)
 	finalQosDatas := make(map[string]*models.QosData)
+	finalChgDatas := make(map[string]*models.ChargingData)
 
 	// Handle QoSData
 	for id, qos := range decision.QosDecs {
@@ -110,8 +113,11 @@ func (c *SMContext) ApplyPccRules(
 			_, tgtQosData := c.getSrcTgtQosData(decision.QosDecs, tgtQosID)
 			tgtPcc.SetQFI(c.AssignQFI(tgtQosID))
 
+			tgtChgID := tgtPcc.RefChgDataID()
+			_, tgtChgData := c.getSrcTgtChgData(decision.ChgDecs, tgtChgID)
+
 			// Create Data path for targetPccRule
-			if err := c.CreatePccRuleDataPath(tgtPcc, tgtTcData, tgtQosData); err != nil {
+			if err := c.CreatePccRuleDataPath(tgtPcc, tgtTcData, tgtQosData, tgtChgData); err != nil {
 				return err
 			}
 			if srcPcc != nil {
@@ -132,6 +138,9 @@ func (c *SMContext) ApplyPccRules(
 			if tgtQosID != "" {
 				finalQosDatas[tgtQosID] = tgtQosData
 			}
+			if tgtChgID != "" {
+				finalChgDatas[tgtChgID] = tgtChgData
+			}
 		}
 		if err := checkUpPathChgEvent(c, srcTcData, tgtTcData); err != nil {
 			c.Log.Warnf("Check UpPathChgEvent err: %v", err)
@@ -148,12 +157,16 @@ func (c *SMContext) ApplyPccRules(
 		qosID := pcc.RefQosDataID()
 		srcQosData, tgtQosData := c.getSrcTgtQosData(decision.QosDecs, qosID)
 
+		chgID := pcc.RefChgDataID()
+		srcChgData, tgtChgData := c.getSrcTgtChgData(decision.ChgDecs, chgID)
+
 		if !reflect.DeepEqual(srcTcData, tgtTcData) ||
-			!reflect.DeepEqual(srcQosData, tgtQosData) {
+			!reflect.DeepEqual(srcQosData, tgtQosData) ||
+			!reflect.DeepEqual(srcChgData, tgtChgData) {
 			// Remove old Data path
 			c.PreRemoveDataPath(pcc.Datapath)
 			// Create new Data path
-			if err := c.CreatePccRuleDataPath(pcc, tgtTcData, tgtQosData); err != nil {
+			if err := c.CreatePccRuleDataPath(pcc, tgtTcData, tgtQosData, tgtChgData); err != nil {
 				return err
 			}
 			if err := checkUpPathChgEvent(c, srcTcData, tgtTcData); err != nil {
@@ -167,11 +180,15 @@ func (c *SMContext) ApplyPccRules(
 		if qosID != "" {
 			finalQosDatas[qosID] = tgtQosData
 		}
+		if chgID != "" {
+			finalChgDatas[chgID] = tgtChgData
+		}
 	}
 
 	c.PCCRules = finalPccRules
 	c.TrafficControlDatas = finalTcDatas
 	c.QosDatas = finalQosDatas
+	c.ChargingDatas = finalChgDatas
 	return nil
 }
 
@@ -209,6 +226,23 @@ func (c *SMContext) getSrcTgtQosData(
 	return srcQosData, tgtQosData
 }
 
+func (c *SMContext) getSrcTgtChgData(
+	decisionChgDecs map[string]*models.ChargingData,
+	chgID string,
+) (*models.ChargingData, *models.ChargingData) {
+	if chgID == "" {
+		return nil, nil
+	}
+
+	srcChgData := c.ChargingDatas[chgID]
+	tgtChgData := decisionChgDecs[chgID]
+	if tgtChgData == nil {
+		// no Charging data in decision, use source Charging data as target Charging data
+		tgtChgData = srcChgData
+	}
+	return srcChgData, tgtChgData
+}
+
 // Set data path PDR to REMOVE beofre sending PFCP req
 func (c *SMContext) PreRemoveDataPath(dp *DataPath) {
 	if dp == nil {
@@ -234,6 +268,15 @@ func applyFlowInfoOrPFD(pcc *PCCRule) error {
 		return fmt.Errorf("No FlowInfo and AppID")
 	}
 
+	// Apply Ethernet flow description of Ethernet PDU session if it presents
+	if ethFlowDesc := pcc.EthFlowDescription(); ethFlowDesc != nil {
+		ulFilter, dlFilter, err := NewEthernetPacketFilters(pcc.FlowInfos)
+		if err != nil {
+			return err
+		}
+		return pcc.UpdateDataPathEthernetPacketFilter(ulFilter, dlFilter)
+	}
+
 	// Apply flow description if it presents
 	if flowDesc := pcc.FlowDescription(); flowDesc != "" {
 		if err := pcc.UpdateDataPathFlowDescription(flowDesc); err != nil {
@@ -242,28 +285,20 @@ func applyFlowInfoOrPFD(pcc *PCCRule) error {
 		return nil
 	}
 
-	// Find PFD with AppID if no flow description presents
-	// TODO: Get PFD from NEF (not from config)
-	var matchedPFD *factory.PfdDataForApp
-	for _, pfdDataForApp := range factory.UERoutingConfig.PfdDatas {
-		if pfdDataForApp.AppID == appID {
-			matchedPFD = pfdDataForApp
-			break
-		}
-	}
-
-	// Workaround: UPF doesn't support PFD. Get FlowDescription from PFD.
-	// TODO: Send PFD to UPF
-	if matchedPFD == nil ||
-		len(matchedPFD.Pfds) == 0 ||
-		len(matchedPFD.Pfds[0].FlowDescriptions) == 0 {
+	// Detect the application by the PFDs provisioned to UPF if no flow description presents
+	pfdData := GetPfdStore().Get(appID)
+	if pfdData == nil {
 		return fmt.Errorf("No PFD matched for AppID [%s]", appID)
 	}
-	if err := pcc.UpdateDataPathFlowDescription(
-		matchedPFD.Pfds[0].FlowDescriptions[0]); err != nil {
-		return err
+	// PFDs aren't provisioned to the UPF without PFD management, use the flow description of PFDs instead
+	if psa := pcc.Datapath.PSA(); psa != nil && !psa.UPF.SupportsUPFeature(UPFeaturePFDM) {
+		flowDesc := pfdData.FlowDescription()
+		if flowDesc == "" {
+			return fmt.Errorf("No flow description in PFDs of AppID [%s]", appID)
+		}
+		return pcc.UpdateDataPathFlowDescription(flowDesc)
 	}
-	return nil
+	return pcc.UpdateDataPathApplicationID(appID)
 }
 
 func checkUpPathChgEvent(c *SMContext,
@@ -312,5 +347,14 @@ func checkUpPathChgEvent(c *SMContext,
 		c.BuildUpPathChgEventExposureNotification(upPathChgEvt, &srcRoute, &tgtRoute)
 	}
 
+	if srcRoute.Dnai != tgtRoute.Dnai {
+		c.BuildEventExposureNotification(models.SmfEvent_DNAI_CH, models.EventNotification{
+			SourceDnai:       srcRoute.Dnai,
+			TargetDnai:       tgtRoute.Dnai,
+			SourceTraRouting: &srcRoute,
+			TargetTraRouting: &tgtRoute,
+		})
+	}
+
 	return nil
 }
@@ -0,0 +1,80 @@
+package context
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/nas/nasMessage"
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+)
+
+func TestIsAllowedPDUSessionTypeUnstructured(t *testing.T) {
+	smctx := NewSMContext("imsi-208930000000007", 10)
+	defer RemoveSMContext(smctx.Ref)
+	smctx.DnnConfiguration = models.DnnConfiguration{
+		PduSessionTypes: &models.PduSessionTypes{
+			DefaultSessionType:  models.PduSessionType_UNSTRUCTURED,
+			AllowedSessionTypes: []models.PduSessionType{models.PduSessionType_UNSTRUCTURED},
+		},
+	}
+
+	// the DNN has no N6 point-to-point tunnel
+	smctx.DNNInfo = &SnssaiSmfDnnInfo{}
+	require.Error(t, smctx.IsAllowedPDUSessionType(nasMessage.PDUSessionTypeUnstructured))
+
+	smctx.DNNInfo.N6Tunnel = &N6Tunnel{
+		ServerIP:   net.ParseIP("10.60.0.101").To4(),
+		ServerPort: 5683,
+		MTU:        DefaultUnstructuredLinkMTU,
+	}
+	require.NoError(t, smctx.IsAllowedPDUSessionType(nasMessage.PDUSessionTypeUnstructured))
+	require.Equal(t, nasMessage.PDUSessionTypeUnstructured, smctx.SelectedPDUSessionType)
+	require.Equal(t, smctx.DNNInfo.N6Tunnel, smctx.N6Tunnel())
+	require.Error(t, smctx.IsAllowedPDUSessionType(nasMessage.PDUSessionTypeIPv4))
+
+	// the tunnel endpoint of UPF is not the address of UE
+	smctx.PDUAddress = net.ParseIP("10.60.0.1").To4()
+	require.Empty(t, smctx.UeIPv4Address())
+}
+
+func TestSelectSSCMode(t *testing.T) {
+	smctx := &SMContext{}
+
+	// no subscribed SSC mode
+	smctx.SelectSSCMode(2)
+	require.Equal(t, uint8(1), smctx.SSCMode)
+
+	smctx.DnnConfiguration.SscModes = &models.SscModes{
+		DefaultSscMode:  "SSC_MODE_1",
+		AllowedSscModes: []models.SscMode{"SSC_MODE_2"},
+	}
+	smctx.SelectSSCMode(2)
+	require.Equal(t, uint8(2), smctx.SSCMode)
+	smctx.SelectSSCMode(3)
+	require.Equal(t, uint8(1), smctx.SSCMode)
+	smctx.SelectSSCMode(0)
+	require.Equal(t, uint8(1), smctx.SSCMode)
+
+	smctx.DnnConfiguration.SscModes.DefaultSscMode = "SSC_MODE_3"
+	smctx.SelectSSCMode(0)
+	require.Equal(t, uint8(3), smctx.SSCMode)
+	require.Equal(t, models.SscMode("SSC_MODE_3"), SscModeToModels(smctx.SSCMode))
+	require.Equal(t, uint8(0), SscModeFromModels("SSC_MODE_4"))
+}
+
+func TestPDNType(t *testing.T) {
+	smctx := &SMContext{}
+	for pduSessionType, pdnType := range map[uint8]uint8{
+		nasMessage.PDUSessionTypeIPv4:         pfcpType.PDNTypeIpv4,
+		nasMessage.PDUSessionTypeIPv6:         pfcpType.PDNTypeIpv6,
+		nasMessage.PDUSessionTypeIPv4IPv6:     pfcpType.PDNTypeIpv4v6,
+		nasMessage.PDUSessionTypeEthernet:     pfcpType.PDNTypeEthernet,
+		nasMessage.PDUSessionTypeUnstructured: pfcpType.PDNTypeNonIp,
+	} {
+		smctx.SelectedPDUSessionType = pduSessionType
+		require.Equal(t, pdnType, smctx.PDNType())
+	}
+}
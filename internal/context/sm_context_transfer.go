@@ -0,0 +1,268 @@
+package context
+
+import (
+	"fmt"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+)
+
+// SmContextRetrievedData is the SmContextRetrievedData of TS 29.502 R16 6.1.6.2.10,
+// the R16 attributes are not yet in the openapi models
+type SmContextRetrievedData struct {
+	UeEpsPdnConnection string     `json:"ueEpsPdnConnection,omitempty"`
+	SmContext          *SmContext `json:"smContext,omitempty"`
+}
+
+// SmContext is the complete SM context of a PDU session (TS 29.502 R16 6.1.6.2.39)
+// for the target AMF or a new SMF to take over the PDU session.
+// The rules derived from the SM policy decision and the user plane state are kept
+// in the free5GC specific attributes after the standard ones.
+type SmContext struct {
+	PduSessionId                    int32                                `json:"pduSessionId"`
+	Dnn                             string                               `json:"dnn"`
+	SNssai                          *models.Snssai                       `json:"sNssai"`
+	HplmnSnssai                     *models.Snssai                       `json:"hplmnSnssai,omitempty"`
+	PduSessionType                  models.PduSessionType                `json:"pduSessionType"`
+	Gpsi                            string                               `json:"gpsi,omitempty"`
+	HSmfUri                         string                               `json:"hSmfUri,omitempty"`
+	PduSessionRef                   string                               `json:"pduSessionRef,omitempty"`
+	PcfId                           string                               `json:"pcfId,omitempty"`
+	SelMode                         models.DnnSelectionMode              `json:"selMode,omitempty"`
+	UdmGroupId                      string                               `json:"udmGroupId,omitempty"`
+	RoutingIndicator                string                               `json:"routingIndicator,omitempty"`
+	SessionAmbr                     *models.Ambr                         `json:"sessionAmbr"`
+	QosFlowsList                    []models.QosFlowSetupItem            `json:"qosFlowsList"`
+	SmfInstanceId                   string                               `json:"smfInstanceId,omitempty"`
+	UeIpv4Address                   string                               `json:"ueIpv4Address,omitempty"`
+	UeIpv6Prefix                    string                               `json:"ueIpv6Prefix,omitempty"`
+	MaxIntegrityProtectedDataRateUl models.MaxIntegrityProtectedDataRate `json:"maxIntegrityProtectedDataRateUl,omitempty"`
+	MaxIntegrityProtectedDataRateDl models.MaxIntegrityProtectedDataRate `json:"maxIntegrityProtectedDataRateDl,omitempty"`
+	UpSecurity                      *models.UpSecurity                   `json:"upSecurity,omitempty"`
+
+	// free5GC specific attributes
+	SmPolicyId       string                   `json:"smPolicyId,omitempty"`
+	SmPolicyDecision *models.SmPolicyDecision `json:"smPolicyDecision,omitempty"`
+	SelectedSessRule string                   `json:"selectedSessRule,omitempty"`
+	// QosDataQfis is the QFI assigned to each QoS data of the PCC rules
+	QosDataQfis  map[string]uint8   `json:"qosDataQfis,omitempty"`
+	UpCnxState   models.UpCnxState  `json:"upCnxState,omitempty"`
+	AnTunnelInfo *models.TunnelInfo `json:"anTunnelInfo,omitempty"`
+	CnTunnelInfo *models.TunnelInfo `json:"cnTunnelInfo,omitempty"`
+	PfcpSessions []PfcpSessionInfo  `json:"pfcpSessions,omitempty"`
+}
+
+// PfcpSessionInfo identifies the PFCP session of a PDU session in a UPF
+type PfcpSessionInfo struct {
+	NodeId     string `json:"nodeId"`
+	LocalSeid  uint64 `json:"localSeid"`
+	RemoteSeid uint64 `json:"remoteSeid"`
+}
+
+// BuildSmContext serializes the SM context for RetrieveSmContext
+func (c *SMContext) BuildSmContext() *SmContext {
+	sc := &SmContext{
+		PduSessionId:                    c.PDUSessionID,
+		Dnn:                             c.Dnn,
+		SNssai:                          c.SNssai,
+		HplmnSnssai:                     c.HplmnSnssai,
+		PduSessionType:                  c.PduSessionType(),
+		Gpsi:                            c.Gpsi,
+		HSmfUri:                         c.HSmfUri,
+		PduSessionRef:                   c.Ref,
+		SelMode:                         c.SelMode,
+		UdmGroupId:                      c.UdmGroupId,
+		RoutingIndicator:                c.RoutingIndicator,
+		QosFlowsList:                    c.BuildQosFlowsSetupList(),
+		SmfInstanceId:                   GetSelf().NfInstanceID,
+		MaxIntegrityProtectedDataRateUl: c.MaximumDataRatePerUEForUserPlaneIntegrityProtectionForUpLink,
+		MaxIntegrityProtectedDataRateDl: c.MaximumDataRatePerUEForUserPlaneIntegrityProtectionForDownLink,
+		UpSecurity:                      c.UpSecurity,
+		SmPolicyId:                      c.SMPolicyID,
+		SmPolicyDecision:                c.buildSmPolicyDecision(),
+		SelectedSessRule:                c.SelectedSessionRuleID,
+		UpCnxState:                      c.UpCnxState,
+		PfcpSessions:                    make([]PfcpSessionInfo, 0, len(c.PFCPContext)),
+	}
+
+	if c.SelectedPCFProfile.NfInstanceId != "" {
+		sc.PcfId = c.SelectedPCFProfile.NfInstanceId
+	}
+	if sessRule := c.SelectedSessionRule(); sessRule != nil {
+		sc.SessionAmbr = sessRule.AuthSessAmbr
+	}
+	sc.UeIpv4Address = c.UeIPv4Address()
+	sc.UeIpv6Prefix = c.UeIPv6Prefix()
+	for _, qosFlow := range c.AdditonalQosFlows {
+		sc.QosFlowsList = append(sc.QosFlowsList, models.QosFlowSetupItem{
+			Qfi: int32(qosFlow.QFI),
+			QosFlowProfile: &models.QosFlowProfile{
+				Var5qi: qosFlow.QoSProfile.Var5qi,
+				Arp:    qosFlow.QoSProfile.Arp,
+			},
+		})
+	}
+
+	sc.QosDataQfis = make(map[string]uint8, len(c.qosDataToQFI))
+	for qosID, qfi := range c.qosDataToQFI {
+		sc.QosDataQfis[qosID] = qfi
+	}
+
+	if anIP := c.Tunnel.ANInformation.IPAddress; anIP != nil {
+		sc.AnTunnelInfo = NewTunnelInfo(anIP, c.Tunnel.ANInformation.TEID)
+	}
+	if cnTunnelInfo, err := c.buildCnTunnelInfo(); err == nil {
+		sc.CnTunnelInfo = cnTunnelInfo
+	}
+
+	for _, pfcpCtx := range c.PFCPContext {
+		sc.PfcpSessions = append(sc.PfcpSessions, PfcpSessionInfo{
+			NodeId:     pfcpCtx.NodeID.ResolveNodeIdToIp().String(),
+			LocalSeid:  pfcpCtx.LocalSEID,
+			RemoteSeid: pfcpCtx.RemoteSEID,
+		})
+	}
+	return sc
+}
+
+// buildSmPolicyDecision returns the SM policy decision which the rules of the SM context are derived from
+func (c *SMContext) buildSmPolicyDecision() *models.SmPolicyDecision {
+	decision := &models.SmPolicyDecision{
+		SessRules:     make(map[string]*models.SessionRule, len(c.SessionRules)),
+		PccRules:      make(map[string]*models.PccRule, len(c.PCCRules)),
+		QosDecs:       make(map[string]*models.QosData, len(c.QosDatas)),
+		TraffContDecs: make(map[string]*models.TrafficControlData, len(c.TrafficControlDatas)),
+	}
+	for id, sessRule := range c.SessionRules {
+		decision.SessRules[id] = sessRule.SessionRule
+	}
+	for id, pcc := range c.PCCRules {
+		decision.PccRules[id] = pcc.PccRule
+	}
+	for id, qos := range c.QosDatas {
+		decision.QosDecs[id] = qos
+	}
+	for id, tcData := range c.TrafficControlDatas {
+		decision.TraffContDecs[id] = tcData.TrafficControlData
+	}
+	return decision
+}
+
+// buildCnTunnelInfo returns the uplink N3 tunnel of the UPF next to AN
+func (c *SMContext) buildCnTunnelInfo() (*models.TunnelInfo, error) {
+	defaultPath := c.Tunnel.DataPathPool.GetDefaultPath()
+	if defaultPath == nil || defaultPath.FirstDPNode == nil {
+		return nil, fmt.Errorf("no default data path")
+	}
+	node := defaultPath.FirstDPNode
+	if node.UpLinkTunnel == nil {
+		return nil, fmt.Errorf("no N3 uplink tunnel")
+	}
+	ip, err := node.N3TunnelIP()
+	if err != nil {
+		return nil, err
+	}
+	return NewTunnelInfo(ip, node.UpLinkTunnel.TEID), nil
+}
+
+// ImportSmContext restores the SM context retrieved from another SMF,
+// the returned SM policy decision is applied with the user plane of this SMF
+func (c *SMContext) ImportSmContext(sc *SmContext) (*models.SmPolicyDecision, error) {
+	if sc.SmPolicyDecision == nil || len(sc.SmPolicyDecision.SessRules) == 0 {
+		return nil, fmt.Errorf("no session rule in the retrieved SM context")
+	}
+	if sc.HSmfUri != "" {
+		return nil, fmt.Errorf("home-routed PDU session is not supported")
+	}
+
+	c.SetPduSessionType(sc.PduSessionType)
+	c.SMPolicyID = sc.SmPolicyId
+	c.UpSecurity = sc.UpSecurity
+	c.MaximumDataRatePerUEForUserPlaneIntegrityProtectionForUpLink = sc.MaxIntegrityProtectedDataRateUl
+	c.MaximumDataRatePerUEForUserPlaneIntegrityProtectionForDownLink = sc.MaxIntegrityProtectedDataRateDl
+	c.SelectedSessionRuleID = sc.SelectedSessRule
+
+	if err := c.setPDUAddress(sc.UeIpv4Address, sc.UeIpv6Prefix); err != nil {
+		return nil, err
+	}
+
+	// keep the QFIs known by UE
+	if err := c.reserveQFIs(sc.QosDataQfis); err != nil {
+		return nil, err
+	}
+
+	if sc.AnTunnelInfo != nil {
+		ip, teid, err := ParseTunnelInfo(sc.AnTunnelInfo)
+		if err != nil {
+			return nil, fmt.Errorf("anTunnelInfo: %v", err)
+		}
+		c.Tunnel.UpdateANInformation(ip, teid)
+	}
+
+	for _, pfcpSession := range sc.PfcpSessions {
+		c.Log.Infof("PFCP session of the retrieved SM context: UPF[%s] SEID[%d] UP-SEID[%d]",
+			pfcpSession.NodeId, pfcpSession.LocalSeid, pfcpSession.RemoteSeid)
+	}
+	return sc.SmPolicyDecision, nil
+}
+
+// reserveQFIs allocates the given QFIs from the QFI generator of the SM context
+func (c *SMContext) reserveQFIs(qosDataQfis map[string]uint8) error {
+	var maxQFI uint8
+	used := make(map[uint8]bool, len(qosDataQfis))
+	for _, qfi := range qosDataQfis {
+		used[qfi] = true
+		if qfi > maxQFI {
+			maxQFI = qfi
+		}
+	}
+
+	// the generator of a new SM context allocates QFIs in ascending order
+	var allocated, unused []int64
+	rollback := func() {
+		for _, id := range allocated {
+			c.QFIGenerator.FreeID(id)
+		}
+	}
+	for len(used) > 0 {
+		id, err := c.QFIGenerator.Allocate()
+		if err != nil {
+			rollback()
+			return fmt.Errorf("reserve QFI failed: %v", err)
+		}
+		allocated = append(allocated, id)
+		if used[uint8(id)] {
+			delete(used, uint8(id))
+		} else {
+			unused = append(unused, id)
+		}
+		if uint8(id) >= maxQFI && len(used) > 0 {
+			rollback()
+			return fmt.Errorf("reserve QFI failed: QFI out of range")
+		}
+	}
+	for _, id := range unused {
+		c.QFIGenerator.FreeID(id)
+	}
+
+	for qosID, qfi := range qosDataQfis {
+		c.qosDataToQFI[qosID] = qfi
+	}
+	return nil
+}
+
+// AllocImportedUeIP selects the UPF and allocates the UE IP address kept in the retrieved SM context
+func (c *SMContext) AllocImportedUeIP() error {
+	c.SelectionParam = &UPFSelectionParams{
+		Dnn: c.Dnn,
+		SNssai: &SNssai{
+			Sst: c.SNssai.Sst,
+			Sd:  c.SNssai.Sd,
+		},
+		PDUAddress:     c.PDUAddress,
+		PDUAddressIPv6: c.PDUAddressIPv6,
+		PDUSessionType: c.SelectedPDUSessionType,
+	}
+	c.PDUAddress = nil
+	c.PDUAddressIPv6 = nil
+	return c.findPSAandAllocUeIP(c.SelectionParam)
+}
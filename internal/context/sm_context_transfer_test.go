@@ -0,0 +1,117 @@
+package context
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+)
+
+func TestSmContextTransfer(t *testing.T) {
+	initConfig()
+	smfContext := GetSelf()
+	smfContext.UserPlaneInformation = NewUserPlaneInformation(&userPlaneConfig)
+	for _, n := range smfContext.UserPlaneInformation.UPFs {
+		n.UPF.UPFStatus = AssociatedSetUpSuccess
+	}
+
+	createData := &models.SmContextCreateData{
+		Supi:         "imsi-208930000000004",
+		PduSessionId: 10,
+		Dnn:          "internet",
+		SNssai: &models.Snssai{
+			Sst: 1,
+			Sd:  "010203",
+		},
+		AnType: models.AccessType__3_GPP_ACCESS,
+	}
+	decision := &models.SmPolicyDecision{
+		SessRules: map[string]*models.SessionRule{
+			"SessRuleId-1": {
+				SessRuleId: "SessRuleId-1",
+				AuthSessAmbr: &models.Ambr{
+					Uplink:   "1000 Kbps",
+					Downlink: "1000 Kbps",
+				},
+				AuthDefQos: &models.AuthorizedDefaultQos{
+					Var5qi: 9,
+					Arp: &models.Arp{
+						PriorityLevel: 8,
+					},
+				},
+			},
+		},
+	}
+
+	// SM context in the old SMF
+	smctx := NewSMContext(createData.Supi, createData.PduSessionId)
+	smctx.SmContextCreateData = createData
+	smctx.SelectedPDUSessionType = 1
+	require.NoError(t, smctx.AllocUeIP())
+	require.NoError(t, smctx.ApplySessionRules(decision))
+	require.NoError(t, smctx.SelectDefaultDataPath())
+	require.Equal(t, uint8(2), smctx.AssignQFI("QosData-1"))
+	smctx.Tunnel.UpdateANInformation(net.ParseIP("10.1.0.1"), 0x20)
+	smctx.MaximumDataRatePerUEForUserPlaneIntegrityProtectionForUpLink = models.MaxIntegrityProtectedDataRate__64_KBPS
+	smctx.MaximumDataRatePerUEForUserPlaneIntegrityProtectionForDownLink = models.MaxIntegrityProtectedDataRate_MAX_UE_RATE
+	ueIP := smctx.PDUAddress.String()
+
+	buf, err := json.Marshal(SmContextRetrievedData{SmContext: smctx.BuildSmContext()})
+	require.NoError(t, err)
+	RemoveSMContext(smctx.Ref)
+
+	var retrievedData SmContextRetrievedData
+	require.NoError(t, json.Unmarshal(buf, &retrievedData))
+	retrieved := retrievedData.SmContext
+	require.Equal(t, int32(10), retrieved.PduSessionId)
+	require.Equal(t, models.PduSessionType_IPV4, retrieved.PduSessionType)
+	require.Equal(t, ueIP, retrieved.UeIpv4Address)
+	require.Equal(t, "1000 Kbps", retrieved.SessionAmbr.Uplink)
+	require.Len(t, retrieved.QosFlowsList, 1)
+	require.Equal(t, int32(1), retrieved.QosFlowsList[0].Qfi)
+	require.Equal(t, "SessRuleId-1", retrieved.SelectedSessRule)
+	require.Equal(t, map[string]uint8{"QosData-1": 2}, retrieved.QosDataQfis)
+	require.Equal(t, "10.1.0.1", retrieved.AnTunnelInfo.Ipv4Addr)
+	require.Equal(t, "10.3.0.11", retrieved.CnTunnelInfo.Ipv4Addr)
+	require.Len(t, retrieved.PfcpSessions, 2)
+
+	// SM context imported in the new SMF
+	smctx = NewSMContext(createData.Supi, createData.PduSessionId)
+	defer RemoveSMContext(smctx.Ref)
+	smctx.SmContextCreateData = createData
+	importedDecision, err := smctx.ImportSmContext(retrieved)
+	require.NoError(t, err)
+	require.NoError(t, smctx.AllocImportedUeIP())
+	require.Equal(t, ueIP, smctx.PDUAddress.String())
+	require.Equal(t, models.MaxIntegrityProtectedDataRate__64_KBPS,
+		smctx.MaximumDataRatePerUEForUserPlaneIntegrityProtectionForUpLink)
+	require.Equal(t, models.MaxIntegrityProtectedDataRate_MAX_UE_RATE,
+		smctx.MaximumDataRatePerUEForUserPlaneIntegrityProtectionForDownLink)
+	require.Equal(t, uint8(2), smctx.AssignQFI("QosData-1"))
+	require.Equal(t, uint8(3), smctx.AssignQFI("QosData-2"))
+	require.NoError(t, smctx.ApplySessionRules(importedDecision))
+	require.Equal(t, "SessRuleId-1", smctx.SelectedSessionRuleID)
+	require.NoError(t, smctx.SelectDefaultDataPath())
+
+	dlFAR := smctx.Tunnel.DataPathPool.GetDefaultPath().FirstDPNode.DownLinkTunnel.PDR.FAR
+	require.NotNil(t, dlFAR.ForwardingParameters.OuterHeaderCreation)
+	require.Equal(t, uint32(0x20), dlFAR.ForwardingParameters.OuterHeaderCreation.Teid)
+	require.Equal(t, net.ParseIP("10.1.0.1").To4(), dlFAR.ForwardingParameters.OuterHeaderCreation.Ipv4Address)
+
+	invalid := NewSMContext("imsi-208930000000005", 10)
+	defer RemoveSMContext(invalid.Ref)
+	_, err = invalid.ImportSmContext(&SmContext{})
+	require.Error(t, err)
+}
+
+func TestReserveQFIsRollback(t *testing.T) {
+	smctx := NewSMContext("imsi-208930000000006", 10)
+	defer RemoveSMContext(smctx.Ref)
+
+	// QFI 64 can't be reserved, the QFIs allocated before are freed
+	require.Error(t, smctx.reserveQFIs(map[string]uint8{"QosData-1": 2, "QosData-2": 64}))
+	require.Equal(t, uint8(2), smctx.AssignQFI("QosData-3"))
+}
@@ -43,6 +43,16 @@ func (d *DnnUPFInfoItem) ContainsDNAI(targetDnai string) bool {
 	return false
 }
 
+// ContainsPDUSessionType returns true if this dnn Info supports the PDU session type
+func (d *DnnUPFInfoItem) ContainsPDUSessionType(pduSessionType models.PduSessionType) bool {
+	for _, t := range d.PduSessionTypes {
+		if t == pduSessionType {
+			return true
+		}
+	}
+	return false
+}
+
 // ContainsIPPool returns true if the ip pool of this upf dnn info contains the `ip`
 func (d *DnnUPFInfoItem) ContainsIPPool(ip net.IP) bool {
 	if ip == nil {
@@ -1,6 +1,11 @@
 package context
 
-import "net"
+import (
+	"net"
+	"time"
+
+	"bitbucket.org/free5gc-team/smf/internal/dnaaa"
+)
 
 // SnssaiSmfInfo records the SMF S-NSSAI related information
 type SnssaiSmfInfo struct {
@@ -12,6 +17,14 @@ type SnssaiSmfInfo struct {
 type SnssaiSmfDnnInfo struct {
 	DNS   DNS
 	PCSCF PCSCF
+	// HSmfUri is the H-SMF of a home-routed DNN
+	HSmfUri string
+	// N6Tunnel is the N6 point-to-point tunnel of Unstructured PDU session
+	N6Tunnel *N6Tunnel
+	// DnAaa is the DN-AAA client of the secondary authentication, nil if it's not required
+	DnAaa dnaaa.Client
+	// Buffering is the downlink data buffering of PDU sessions while their UP connections are deactivated
+	Buffering BufferingPolicy
 }
 
 type DNS struct {
@@ -21,4 +34,30 @@ type DNS struct {
 
 type PCSCF struct {
 	IPv4Addr net.IP
+	IPv6Addr net.IP
+}
+
+const DefaultUnstructuredLinkMTU uint16 = 1400
+
+const DefaultSuggestedBufferingPacketsCount uint8 = 10
+
+// BufferingPolicy is the downlink data buffering of PDU sessions while their UP connections are deactivated
+type BufferingPolicy struct {
+	// InSMF buffers the downlink data in SMF instead of UPF
+	InSMF                 bool
+	DDNDelay              time.Duration
+	SuggestedPacketsCount uint8
+	// DDNThrottlingInterval is the minimum interval between the pagings of a PDU session, 0 if not throttled
+	DDNThrottlingInterval time.Duration
+	// DDNFailureBackoff suppresses the pagings of a PDU session after UE isn't reached
+	DDNFailureBackoff time.Duration
+	// ExtendedBuffering buffers the downlink data in UPF for UE in MICO mode or extended DRX
+	ExtendedBuffering bool
+}
+
+// N6Tunnel is the UDP/IPv4 point-to-point tunnel toward the application server
+type N6Tunnel struct {
+	ServerIP   net.IP
+	ServerPort uint16
+	MTU        uint16
 }
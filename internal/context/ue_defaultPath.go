@@ -192,7 +192,7 @@ func (dfp *UEDefaultPaths) SelectUPFAndAllocUEIPForULCL(upi *UserPlaneInformatio
 		upf := upi.UPFs[upfName]
 
 		pools, useStaticIPPool := getUEIPPool(upf, selection)
-		if len(pools) == 0 {
+		if len(pools) == 0 || !hasPairedIPv6Pool(upf, selection) {
 			continue
 		}
 		sortedPoolList := createPoolListForSelection(pools)
@@ -12,7 +12,9 @@ import (
 	"bitbucket.org/free5gc-team/smf/pkg/factory"
 )
 
-// UeIPPool represent IPv4 address pool for UE
+// UeIPPool represent IPv4 address pool or IPv6 prefix pool for UE,
+// a /64 prefix is delegated to each PDU session from an IPv6 prefix pool
+// and the value of a prefix in the pool is the upper 64 bits with the sign bit flipped
 type UeIPPool struct {
 	ueSubNet *net.IPNet
 	pool     *pool.LazyReusePool
@@ -25,13 +27,23 @@ func NewUEIPPool(factoryPool *factory.UEIPPool) *UeIPPool {
 		return nil
 	}
 
-	minAddr, maxAddr, err := calcAddrRange(ipNet)
-	if err != nil {
-		logger.InitLog.Errorln(err)
-		return nil
+	var minAddr, maxAddr int
+	if ipNet.IP.To4() != nil {
+		minIPv4, maxIPv4, err := calcAddrRange(ipNet)
+		if err != nil {
+			logger.InitLog.Errorln(err)
+			return nil
+		}
+		minAddr, maxAddr = int(minIPv4), int(maxIPv4)
+	} else {
+		minAddr, maxAddr, err = calcPrefixRange(ipNet)
+		if err != nil {
+			logger.InitLog.Errorln(err)
+			return nil
+		}
 	}
 
-	newPool, err := pool.NewLazyReusePool(int(minAddr), int(maxAddr))
+	newPool, err := pool.NewLazyReusePool(minAddr, maxAddr)
 	if err != nil {
 		logger.InitLog.Errorln(err)
 		return nil
@@ -48,7 +60,7 @@ func (ueIPPool *UeIPPool) allocate(request net.IP) net.IP {
 	var allocVal int
 	var ok bool
 	if request != nil {
-		allocVal = int(binary.BigEndian.Uint32(request))
+		allocVal = ueIPPool.ipToVal(request)
 		ok = ueIPPool.pool.Use(allocVal)
 		if !ok {
 			logger.CtxLog.Warnf("IP[%s] is used in Pool[%+v]", request, ueIPPool.ueSubNet)
@@ -65,15 +77,44 @@ func (ueIPPool *UeIPPool) allocate(request net.IP) net.IP {
 	}
 
 RETURNIP:
-	retIP := uint32ToIP(uint32(allocVal))
-	logger.CtxLog.Infof("Allocated UE IP address: %s", retIP)
+	retIP := ueIPPool.valToIP(allocVal)
+	if ueIPPool.isIPv6() {
+		logger.CtxLog.Infof("Allocated UE IPv6 prefix: %s/%d", retIP, IPv6PrefixLen)
+	} else {
+		logger.CtxLog.Infof("Allocated UE IP address: %s", retIP)
+	}
 	return retIP
 }
 
+// isIPv6 returns true if ueIPPool is an IPv6 prefix pool
+func (ueIPPool *UeIPPool) isIPv6() bool {
+	return ueIPPool.ueSubNet.IP.To4() == nil
+}
+
+func (ueIPPool *UeIPPool) ipToVal(ip net.IP) int {
+	if ueIPPool.isIPv6() {
+		return prefixToVal(ip.To16())
+	}
+	return int(binary.BigEndian.Uint32(ip.To4()))
+}
+
+func (ueIPPool *UeIPPool) valToIP(val int) net.IP {
+	if ueIPPool.isIPv6() {
+		return valToPrefix(val)
+	}
+	return uint32ToIP(uint32(val))
+}
+
 func (ueIPPool *UeIPPool) exclude(excludePool *UeIPPool) error {
+	if ueIPPool.isIPv6() != excludePool.isIPv6() {
+		return fmt.Errorf("exclude uePool fail: IP version mismatch")
+	}
 	excludeMin := excludePool.pool.Min()
 	excludeMax := excludePool.pool.Max() + 1
-	if !ueIPPool.ueSubNet.IP.Equal(excludePool.ueSubNet.IP) {
+	if ueIPPool.isIPv6() {
+		// there is no network address and broadcast address in a prefix pool
+		excludeMax -= 1
+	} else if !ueIPPool.ueSubNet.IP.Equal(excludePool.ueSubNet.IP) {
 		excludeMin -= 1
 	}
 	if err := ueIPPool.pool.Reserve(excludeMin, excludeMax); err != nil {
@@ -89,8 +130,7 @@ func uint32ToIP(intval uint32) net.IP {
 }
 
 func (ueIPPool *UeIPPool) release(addr net.IP) {
-	addrVal := binary.BigEndian.Uint32(addr)
-	res := ueIPPool.pool.Free(int(addrVal))
+	res := ueIPPool.pool.Free(ueIPPool.ipToVal(addr))
 	if !res {
 		logger.CtxLog.Warnf("failed to release UE Address: %s", addr)
 	}
@@ -101,14 +141,8 @@ func (ueIPPool *UeIPPool) dump() string {
 	str := "["
 	elements := ueIPPool.pool.Dump()
 	for index, element := range elements {
-		var firstAddr net.IP
-		var lastAddr net.IP
-		buf := make([]byte, 4)
-		binary.BigEndian.PutUint32(buf, uint32(element[0]))
-		firstAddr = buf
-		buf = make([]byte, 4)
-		binary.BigEndian.PutUint32(buf, uint32(element[1]))
-		lastAddr = buf
+		firstAddr := ueIPPool.valToIP(element[0])
+		lastAddr := ueIPPool.valToIP(element[1])
 		if index > 0 {
 			str += ("->")
 		}
@@ -125,6 +159,9 @@ func isOverlap(pools []*UeIPPool) bool {
 	}
 	for i := 0; i < len(pools)-1; i++ {
 		for j := i + 1; j < len(pools); j++ {
+			if pools[i].isIPv6() != pools[j].isIPv6() {
+				continue
+			}
 			if pools[i].pool.IsJoint(pools[j].pool) {
 				return true
 			}
@@ -146,3 +183,29 @@ func calcAddrRange(ipNet *net.IPNet) (minAddr, maxAddr uint32, err error) {
 	}
 	return minAddr, maxAddr, nil
 }
+
+// IPv6PrefixLen is the length of the IPv6 prefix delegated to a PDU session
+const IPv6PrefixLen = 64
+
+func calcPrefixRange(ipNet *net.IPNet) (minPrefix, maxPrefix int, err error) {
+	ones, bits := ipNet.Mask.Size()
+	if bits != net.IPv6len*8 || ones > IPv6PrefixLen {
+		return 0, 0, fmt.Errorf("IPv6 prefix length of %s should not be longer than %d", ipNet, IPv6PrefixLen)
+	}
+	baseVal := binary.BigEndian.Uint64(ipNet.IP.To16())
+	hostMask := uint64(math.MaxUint64) >> ones
+	minPrefix = int((baseVal &^ hostMask) ^ (1 << 63))
+	maxPrefix = int((baseVal | hostMask) ^ (1 << 63))
+	return minPrefix, maxPrefix, nil
+}
+
+// prefixToVal maps the /64 prefix of ip to the value in the prefix pool
+func prefixToVal(ip net.IP) int {
+	return int(binary.BigEndian.Uint64(ip) ^ (1 << 63))
+}
+
+func valToPrefix(val int) net.IP {
+	buf := make([]byte, net.IPv6len)
+	binary.BigEndian.PutUint64(buf, uint64(val)^(1<<63))
+	return buf
+}
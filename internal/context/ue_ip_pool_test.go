@@ -78,3 +78,59 @@ func TestUeIPPool_ExcludeRange(t *testing.T) {
 		ueIPPool.release(allocate)
 	}
 }
+
+func TestUeIPv6PrefixPool(t *testing.T) {
+	ueIPPool := NewUEIPPool(&factory.UEIPPool{
+		Cidr: "2001:db8:1::/62",
+	})
+	require.NotNil(t, ueIPPool)
+	require.True(t, ueIPPool.isIPv6())
+	require.Equal(t, 4, ueIPPool.pool.Remain())
+
+	// a /64 prefix is allocated to each PDU session
+	var prefixList []net.IP
+	for i := 0; i < 4; i++ {
+		prefixList = append(prefixList, net.ParseIP(fmt.Sprintf("2001:db8:1:%d::", i)))
+	}
+	for i := 0; i < 4; i++ {
+		allocIP := ueIPPool.allocate(nil)
+		require.Contains(t, prefixList, allocIP)
+	}
+	require.Nil(t, ueIPPool.allocate(nil))
+
+	for _, i := range rand.Perm(4) {
+		ueIPPool.release(prefixList[i])
+	}
+
+	// allocate specify prefix
+	require.Equal(t, prefixList[2], ueIPPool.allocate(prefixList[2]))
+	require.Nil(t, ueIPPool.allocate(prefixList[2]))
+
+	// prefix pool longer than /64 is invalid
+	require.Nil(t, NewUEIPPool(&factory.UEIPPool{
+		Cidr: "2001:db8:1::/96",
+	}))
+}
+
+func TestUeIPv6PrefixPool_ExcludeRange(t *testing.T) {
+	ueIPPool := NewUEIPPool(&factory.UEIPPool{
+		Cidr: "2001:db8:1::/60",
+	})
+	excludeUeIPPool := NewUEIPPool(&factory.UEIPPool{
+		Cidr: "2001:db8:1::/62",
+	})
+	require.Equal(t, 16, ueIPPool.pool.Remain())
+
+	require.NoError(t, ueIPPool.exclude(excludeUeIPPool))
+	require.Equal(t, 12, ueIPPool.pool.Remain())
+	for i := 4; i < 16; i++ {
+		allocate := ueIPPool.allocate(nil)
+		require.Equal(t, net.ParseIP(fmt.Sprintf("2001:db8:1:%x::", i)), allocate)
+	}
+
+	ipv4Pool := NewUEIPPool(&factory.UEIPPool{
+		Cidr: "10.10.0.0/24",
+	})
+	require.False(t, isOverlap([]*UeIPPool{excludeUeIPPool, ipv4Pool}))
+	require.True(t, isOverlap([]*UeIPPool{ueIPPool, excludeUeIPPool, ipv4Pool}))
+}
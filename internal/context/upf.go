@@ -46,11 +46,8 @@ func (t *UPTunnel) UpdateANInformation(ip net.IP, teid uint32) {
 				DLPDR.FAR.ForwardingParameters.SendEndMarker = true
 			}
 
-			DLPDR.FAR.ForwardingParameters.OuterHeaderCreation = new(pfcpType.OuterHeaderCreation)
-			dlOuterHeaderCreation := DLPDR.FAR.ForwardingParameters.OuterHeaderCreation
-			dlOuterHeaderCreation.OuterHeaderCreationDescription = pfcpType.OuterHeaderCreationGtpUUdpIpv4
-			dlOuterHeaderCreation.Teid = t.ANInformation.TEID
-			dlOuterHeaderCreation.Ipv4Address = t.ANInformation.IPAddress.To4()
+			DLPDR.FAR.ForwardingParameters.OuterHeaderCreation = GTPUOuterHeaderCreation(
+				t.ANInformation.IPAddress, t.ANInformation.TEID)
 			DLPDR.FAR.State = RULE_UPDATE
 		}
 	}
@@ -71,6 +68,17 @@ type UPF struct {
 	UPIPInfo          pfcpType.UserPlaneIPResourceInformation
 	UPFStatus         UPFStatus
 	RecoveryTimeStamp time.Time
+	// StaleSessionsDeleted is set after the PFCP sessions established before SMF restarts are deleted from UPF
+	StaleSessionsDeleted bool
+	// UPFunctionFeatures is the features supported by UPF, which are announced in PFCP association
+	UPFunctionFeatures *pfcpType.UPFunctionFeatures
+	// CPFunctionFeatures is the features of SMF advertised to UPF in PFCP association
+	CPFunctionFeatures *pfcpType.CPFunctionFeatures
+	// ChooseFTEID is configured for UPF to allocate the F-TEIDs of PDRs with F-TEID CHOOSE
+	ChooseFTEID bool
+	// Releasing is set when UPF requests to release the PFCP association, it's not selected for new PDU sessions
+	// while its PDU sessions are drained
+	Releasing bool
 
 	Ctx        context.Context
 	CancelFunc context.CancelFunc
@@ -91,6 +99,10 @@ type UPF struct {
 	urrIDGenerator *idgenerator.IDGenerator
 	qerIDGenerator *idgenerator.IDGenerator
 	teidGenerator  *idgenerator.IDGenerator
+
+	// failedGTPUPeers is the remote GTP-U peers whose user plane path from UPF has failed
+	gtpuPeerMu      sync.RWMutex
+	failedGTPUPeers map[string]bool
 }
 
 // UPFSelectionParams ... parameters for upf selection
@@ -99,6 +111,19 @@ type UPFSelectionParams struct {
 	SNssai     *SNssai
 	Dnai       string
 	PDUAddress net.IP
+	// PDUAddressIPv6 is the requested IPv6 prefix of IPv4v6 PDU session
+	PDUAddressIPv6 net.IP
+	// PDUSessionType selects the IPv4 address pools or the IPv6 prefix pools
+	PDUSessionType uint8
+}
+
+// ipv6Selection returns the parameters to select the IPv6 prefix pools for IPv4v6 PDU session
+func (upfSelectionParams *UPFSelectionParams) ipv6Selection() *UPFSelectionParams {
+	selection := *upfSelectionParams
+	selection.PDUAddress = upfSelectionParams.PDUAddressIPv6
+	selection.PDUAddressIPv6 = nil
+	selection.PDUSessionType = nasMessage.PDUSessionTypeIPv6
+	return &selection
 }
 
 // UPFInterfaceInfo store the UPF interface information
@@ -170,6 +195,12 @@ func (i *UPFInterfaceInfo) IP(pduSessType uint8) (net.IP, error) {
 	return nil, errors.New("not matched ip address")
 }
 
+// TunnelIP returns the IP of the GTP-U tunnel endpoint, which is independent of the PDU session type of UE,
+// the IPv4 address is preferred and the IPv6 address is used by IPv6 only interface
+func (i *UPFInterfaceInfo) TunnelIP() (net.IP, error) {
+	return i.IP(nasMessage.PDUSessionTypeIPv4IPv6)
+}
+
 func (upfSelectionParams *UPFSelectionParams) String() string {
 	str := ""
 	Dnn := upfSelectionParams.Dnn
@@ -192,6 +223,10 @@ func (upfSelectionParams *UPFSelectionParams) String() string {
 		str += fmt.Sprintf("PDUAddress: %s\n", pduAddress)
 	}
 
+	if pduAddressIPv6 := upfSelectionParams.PDUAddressIPv6; pduAddressIPv6 != nil {
+		str += fmt.Sprintf("PDUAddressIPv6: %s\n", pduAddressIPv6)
+	}
+
 	return str
 }
 
@@ -245,6 +280,7 @@ func NewUPF(nodeID *pfcpType.NodeID, ifaces []*factory.InterfaceUpfInfoItem) (up
 	upf.qerIDGenerator = idgenerator.NewGenerator(1, math.MaxUint32)
 	upf.urrIDGenerator = idgenerator.NewGenerator(1, math.MaxUint32)
 	upf.teidGenerator = idgenerator.NewGenerator(1, math.MaxUint32)
+	upf.failedGTPUPeers = make(map[string]bool)
 
 	upf.N3Interfaces = make([]*UPFInterfaceInfo, 0)
 	upf.N9Interfaces = make([]*UPFInterfaceInfo, 0)
@@ -317,10 +353,10 @@ func RetrieveUPFNodeByNodeID(nodeID pfcpType.NodeID) *UPF {
 		curUPF := value.(*UPF)
 		if curUPF.NodeID.NodeIdType != nodeID.NodeIdType &&
 			(curUPF.NodeID.NodeIdType == pfcpType.NodeIdTypeFqdn || nodeID.NodeIdType == pfcpType.NodeIdTypeFqdn) {
-			curUPFNodeIdIP := curUPF.NodeID.ResolveNodeIdToIp().To4()
-			nodeIdIP := nodeID.ResolveNodeIdToIp().To4()
+			curUPFNodeIdIP := curUPF.NodeID.ResolveNodeIdToIp()
+			nodeIdIP := nodeID.ResolveNodeIdToIp()
 			logger.CtxLog.Tracef("RetrieveUPF - upfNodeIdIP:[%+v], nodeIdIP:[%+v]", curUPFNodeIdIP, nodeIdIP)
-			if reflect.DeepEqual(curUPFNodeIdIP, nodeIdIP) {
+			if curUPFNodeIdIP != nil && curUPFNodeIdIP.Equal(nodeIdIP) {
 				targetUPF = curUPF
 				return false
 			}
@@ -342,10 +378,10 @@ func RemoveUPFNodeByNodeID(nodeID pfcpType.NodeID) bool {
 		upf := value.(*UPF)
 		if upf.NodeID.NodeIdType != nodeID.NodeIdType &&
 			(upf.NodeID.NodeIdType == pfcpType.NodeIdTypeFqdn || nodeID.NodeIdType == pfcpType.NodeIdTypeFqdn) {
-			upfNodeIdIP := upf.NodeID.ResolveNodeIdToIp().To4()
-			nodeIdIP := nodeID.ResolveNodeIdToIp().To4()
+			upfNodeIdIP := upf.NodeID.ResolveNodeIdToIp()
+			nodeIdIP := nodeID.ResolveNodeIdToIp()
 			logger.CtxLog.Tracef("RemoveUPF - upfNodeIdIP:[%+v], nodeIdIP:[%+v]", upfNodeIdIP, nodeIdIP)
-			if reflect.DeepEqual(upfNodeIdIP, nodeIdIP) {
+			if upfNodeIdIP != nil && upfNodeIdIP.Equal(nodeIdIP) {
 				return false
 			}
 		} else if reflect.DeepEqual(upf.NodeID, nodeID) {
@@ -631,6 +667,36 @@ func (upf *UPF) isSupportSnssai(snssai *SNssai) bool {
 	return false
 }
 
+// SMContextsOverUPF returns the SM contexts whose data paths go through UPF
+func (upf *UPF) SMContextsOverUPF() []*SMContext {
+	var smContexts []*SMContext
+	smContextPool.Range(func(key, value interface{}) bool {
+		smContext := value.(*SMContext)
+		smContext.SMLock.Lock()
+		over := smContext.isOverUPF(upf)
+		smContext.SMLock.Unlock()
+		if over {
+			smContexts = append(smContexts, smContext)
+		}
+		return true
+	})
+	return smContexts
+}
+
+func (smContext *SMContext) isOverUPF(upf *UPF) bool {
+	if smContext.Tunnel == nil {
+		return false
+	}
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+			if node.UPF == upf {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (upf *UPF) ProcEachSMContext(procFunc func(*SMContext)) {
 	smContextPool.Range(func(key, value interface{}) bool {
 		smContext := value.(*SMContext)
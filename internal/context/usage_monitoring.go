@@ -0,0 +1,255 @@
+package context
+
+import (
+	"sort"
+	"time"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+)
+
+// UsageMonitor is the usage monitoring of a monitoring key controlled by PCF (TS 29.512 4.2.2.10),
+// the usage of the session rule or the PCC rules referring to the key is measured by a URR on PSA UPFs
+type UsageMonitor struct {
+	MonitoringKey string
+	URRID         uint32
+
+	VolumeThreshold         uint64
+	VolumeThresholdUplink   uint64
+	VolumeThresholdDownlink uint64
+	TimeThreshold           time.Duration
+
+	// Usage is reported by UPFs and not yet reported to PCF
+	Usage MonitoredUsage
+}
+
+// MonitoredUsage is the usage accumulated for a monitoring key
+type MonitoredUsage struct {
+	TotalVolume    uint64
+	UplinkVolume   uint64
+	DownlinkVolume uint64
+	Time           time.Duration
+}
+
+// thresholdReached reports whether the usage reaches any threshold of the monitoring key
+func (m *UsageMonitor) thresholdReached() bool {
+	return (m.VolumeThreshold != 0 && m.Usage.TotalVolume >= m.VolumeThreshold) ||
+		(m.VolumeThresholdUplink != 0 && m.Usage.UplinkVolume >= m.VolumeThresholdUplink) ||
+		(m.VolumeThresholdDownlink != 0 && m.Usage.DownlinkVolume >= m.VolumeThresholdDownlink) ||
+		(m.TimeThreshold != 0 && m.Usage.Time >= m.TimeThreshold)
+}
+
+// setThresholds sets the thresholds of the usage monitoring data, the usage is kept
+func (m *UsageMonitor) setThresholds(umData *models.UsageMonitoringData) {
+	m.VolumeThreshold = uint64(umData.VolumeThreshold)
+	m.VolumeThresholdUplink = uint64(umData.VolumeThresholdUplink)
+	m.VolumeThresholdDownlink = uint64(umData.VolumeThresholdDownlink)
+	m.TimeThreshold = time.Duration(umData.TimeThreshold) * time.Second
+}
+
+// setURR sets the thresholds to the URR, UPF reports the usage when any threshold is reached.
+// The URR of a stopped monitoring has no threshold and isn't reported
+func (m *UsageMonitor) setURR(urr *URR) {
+	urr.MeasureMethod = MesureMethodVolTime
+	urr.MeasurementPeriod = 0
+	urr.VolumeThreshold = 0
+	urr.VolumeThresholds = nil
+	if m.VolumeThreshold != 0 || m.VolumeThresholdUplink != 0 || m.VolumeThresholdDownlink != 0 {
+		urr.VolumeThresholds = &pfcpType.VolumeThreshold{
+			Tovol:          m.VolumeThreshold != 0,
+			Ulvol:          m.VolumeThresholdUplink != 0,
+			Dlvol:          m.VolumeThresholdDownlink != 0,
+			TotalVolume:    m.VolumeThreshold,
+			UplinkVolume:   m.VolumeThresholdUplink,
+			DownlinkVolume: m.VolumeThresholdDownlink,
+		}
+	}
+	urr.TimeThreshold = m.TimeThreshold
+	urr.ReportingTrigger = pfcpType.ReportingTriggers{
+		Volth: urr.VolumeThresholds != nil,
+		Timth: m.TimeThreshold != 0,
+	}
+}
+
+// usageMonitoringUrrID returns the URR ID of the monitoring key, the ID is kept after the monitoring stops
+// as the URR stays on the PDRs
+func (c *SMContext) usageMonitoringUrrID(monitoringKey string) (uint32, error) {
+	if id, ok := c.UsageMonitoringUrrIDs[monitoringKey]; ok {
+		return id, nil
+	}
+	id, err := c.UrrIDGenerator.Allocate()
+	if err != nil {
+		return 0, err
+	}
+	if c.UsageMonitoringUrrIDs == nil {
+		c.UsageMonitoringUrrIDs = make(map[string]uint32)
+	}
+	c.UsageMonitoringUrrIDs[monitoringKey] = uint32(id)
+	return uint32(id), nil
+}
+
+// isUsageMonitoringUrr reports whether the URR measures the usage of a monitoring key
+func (c *SMContext) isUsageMonitoringUrr(urrID uint32) bool {
+	for _, id := range c.UsageMonitoringUrrIDs {
+		if id == urrID {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyUsageMonitoringDatas installs, modifies and removes the usage monitoring of the usage monitoring data
+// decided by PCF. The usage monitoring URRs installed on UPFs are updated with the new thresholds, and the
+// updated URRs of each UPF are returned
+func (c *SMContext) ApplyUsageMonitoringDatas(umDecs map[string]*models.UsageMonitoringData) map[*UPF][]*URR {
+	updated := make(map[*UPF][]*URR)
+	for id, umData := range umDecs {
+		monitor := c.UsageMonitors[id]
+		if umData == nil {
+			if monitor == nil {
+				continue
+			}
+			c.Log.Infof("Stop usage monitoring[%s]", id)
+			delete(c.UsageMonitors, id)
+			monitor = &UsageMonitor{MonitoringKey: id, URRID: monitor.URRID}
+		} else if monitor == nil {
+			urrID, err := c.usageMonitoringUrrID(id)
+			if err != nil {
+				c.Log.Errorf("Allocate URR ID of usage monitoring[%s] failed: %v", id, err)
+				continue
+			}
+			c.Log.Infof("Install usage monitoring[%s]: %+v", id, umData)
+			monitor = &UsageMonitor{MonitoringKey: id, URRID: urrID}
+			monitor.setThresholds(umData)
+			if c.UsageMonitors == nil {
+				c.UsageMonitors = make(map[string]*UsageMonitor)
+			}
+			c.UsageMonitors[id] = monitor
+		} else {
+			c.Log.Infof("Modify usage monitoring[%s]: %+v", id, umData)
+			monitor.setThresholds(umData)
+		}
+
+		for upf, urr := range c.psaURRs(monitor.URRID) {
+			monitor.setURR(urr)
+			if urr.State != RULE_INITIAL {
+				urr.State = RULE_UPDATE
+			}
+			updated[upf] = append(updated[upf], urr)
+		}
+	}
+	return updated
+}
+
+// psaURRs returns the URR of the ID on the PSA of each activated data path
+func (c *SMContext) psaURRs(urrID uint32) map[*UPF]*URR {
+	urrs := make(map[*UPF]*URR)
+	if c.Tunnel == nil {
+		return urrs
+	}
+	for _, dataPath := range c.Tunnel.DataPathPool {
+		if !dataPath.Activated {
+			continue
+		}
+		var psa *DataPathNode
+		for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+			psa = node
+		}
+		if psa == nil || psa.UpLinkTunnel == nil || psa.UpLinkTunnel.PDR == nil {
+			continue
+		}
+		for _, urr := range psa.UpLinkTunnel.PDR.URR {
+			if urr.URRID == urrID {
+				urrs[psa.UPF] = urr
+			}
+		}
+	}
+	return urrs
+}
+
+// addUsageMonitoringUrr adds the URR of the monitoring key to the PDRs of PSA of the data path,
+// the URR is shared by the session rule and the PCC rules referring to the key
+func (dataPath *DataPath) addUsageMonitoringUrr(smContext *SMContext, monitoringKey string) {
+	monitor := smContext.UsageMonitors[monitoringKey]
+	if monitor == nil {
+		logger.PduSessLog.Warnf("Usage monitoring data[%s] not found", monitoringKey)
+		return
+	}
+	var psa *DataPathNode
+	for node := dataPath.FirstDPNode; node != nil; node = node.Next() {
+		psa = node
+	}
+	if psa == nil {
+		return
+	}
+
+	key := getUrrIdKey(psa.UPF.UUID(), monitor.URRID)
+	urr, ok := smContext.UrrUpfMap[key]
+	if !ok {
+		var err error
+		if urr, err = psa.UPF.AddURR(monitor.URRID, monitor.setURR); err != nil {
+			logger.PduSessLog.Errorln("new usage monitoring URR failed:", err)
+			return
+		}
+		smContext.UrrUpfMap[key] = urr
+	}
+	for _, tunnel := range []*GTPTunnel{psa.UpLinkTunnel, psa.DownLinkTunnel} {
+		if tunnel != nil && tunnel.PDR != nil && !containsURR(tunnel.PDR.URR, urr) {
+			tunnel.PDR.URR = append(tunnel.PDR.URR, urr)
+		}
+	}
+}
+
+// addMonitoredUsage accounts the usage report of a usage monitoring URR to its monitoring key
+func (c *SMContext) addMonitoredUsage(report UsageReport) {
+	for _, m := range c.UsageMonitors {
+		if m.URRID != report.UrrId {
+			continue
+		}
+		m.Usage.TotalVolume += report.TotalVolume
+		m.Usage.UplinkVolume += report.UplinkVolume
+		m.Usage.DownlinkVolume += report.DownlinkVolume
+		m.Usage.Time += report.Duration
+	}
+}
+
+// UsageMonitoringReportPending reports whether the usage of a monitoring key reaches its threshold,
+// and the accumulated usage is to be reported to PCF
+func (c *SMContext) UsageMonitoringReportPending() bool {
+	for _, m := range c.UsageMonitors {
+		if m.thresholdReached() {
+			return true
+		}
+	}
+	return false
+}
+
+// AccuUsageReports returns the accumulated usage of the monitoring keys reaching the thresholds,
+// or of all the monitoring keys if all is set, e.g. when the SM policy association is terminated
+func (c *SMContext) AccuUsageReports(all bool) []models.AccuUsageReport {
+	var reports []models.AccuUsageReport
+	for id, m := range c.UsageMonitors {
+		if !all && !m.thresholdReached() {
+			continue
+		}
+		reports = append(reports, models.AccuUsageReport{
+			RefUmIds:         id,
+			VolUsage:         int64(m.Usage.TotalVolume),
+			VolUsageUplink:   int64(m.Usage.UplinkVolume),
+			VolUsageDownlink: int64(m.Usage.DownlinkVolume),
+			TimeUsage:        int32(m.Usage.Time / time.Second),
+		})
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].RefUmIds < reports[j].RefUmIds })
+	return reports
+}
+
+// ResetMonitoredUsage resets the usage of the monitoring keys reported to PCF
+func (c *SMContext) ResetMonitoredUsage(reports []models.AccuUsageReport) {
+	for _, r := range reports {
+		if m := c.UsageMonitors[r.RefUmIds]; m != nil {
+			m.Usage = MonitoredUsage{}
+		}
+	}
+}
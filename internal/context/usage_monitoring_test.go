@@ -0,0 +1,96 @@
+package context
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+	"bitbucket.org/free5gc-team/util/idgenerator"
+)
+
+func TestUsageMonitoring(t *testing.T) {
+	upf := NewUPF(mockIPv4NodeID, mockIfaces)
+	upf.UPFStatus = AssociatedSetUpSuccess
+	newDataPath := func() *DataPath {
+		return &DataPath{Activated: true, FirstDPNode: &DataPathNode{
+			UPF:            upf,
+			UpLinkTunnel:   &GTPTunnel{PDR: &PDR{}},
+			DownLinkTunnel: &GTPTunnel{PDR: &PDR{}},
+		}}
+	}
+	smContext := &SMContext{
+		Log:            logger.PduSessLog,
+		Tunnel:         NewUPTunnel(),
+		UrrIDGenerator: idgenerator.NewGenerator(1, math.MaxUint32),
+		UrrUpfMap:      make(map[string]*URR),
+	}
+
+	smContext.ApplyUsageMonitoringDatas(map[string]*models.UsageMonitoringData{
+		"um1": {UmId: "um1", VolumeThreshold: 1000, TimeThreshold: 60},
+		"um2": {UmId: "um2", VolumeThresholdDownlink: 500},
+	})
+	require.Len(t, smContext.UsageMonitors, 2)
+
+	// the session rule and the PCC rule of the same monitoring key share the URR
+	dataPath := newDataPath()
+	dataPath.addUsageMonitoringUrr(smContext, "um1")
+	dataPath.addUsageMonitoringUrr(smContext, "um1")
+	urrs := dataPath.FirstDPNode.UpLinkTunnel.PDR.URR
+	require.Len(t, urrs, 1)
+	urr := urrs[0]
+	require.Equal(t, smContext.UsageMonitors["um1"].URRID, urr.URRID)
+	require.Equal(t, MesureMethodVolTime, urr.MeasureMethod)
+	require.True(t, urr.VolumeThresholds.Tovol)
+	require.False(t, urr.VolumeThresholds.Dlvol)
+	require.Equal(t, uint64(1000), urr.VolumeThresholds.TotalVolume)
+	require.Equal(t, time.Minute, urr.TimeThreshold)
+	require.True(t, urr.ReportingTrigger.Volth)
+	require.True(t, urr.ReportingTrigger.Timth)
+	require.False(t, urr.ReportingTrigger.Perio)
+	smContext.Tunnel.AddDataPath(dataPath)
+
+	// the usage is accumulated until a threshold is reached
+	smContext.AddUsageReport(UsageReport{UrrId: urr.URRID, TotalVolume: 600, UplinkVolume: 100, DownlinkVolume: 500})
+	require.False(t, smContext.UsageMonitoringReportPending())
+	smContext.AddUsageReport(UsageReport{UrrId: urr.URRID, TotalVolume: 400, Duration: time.Second})
+	require.True(t, smContext.UsageMonitoringReportPending())
+
+	reports := smContext.AccuUsageReports(false)
+	require.Equal(t, []models.AccuUsageReport{{
+		RefUmIds:         "um1",
+		VolUsage:         1000,
+		VolUsageUplink:   100,
+		VolUsageDownlink: 500,
+		TimeUsage:        1,
+	}}, reports)
+	require.Len(t, smContext.AccuUsageReports(true), 2)
+
+	// the usage monitored isn't charged
+	record := smContext.ChargingRecord("", time.Now())
+	require.Empty(t, record.Usages)
+
+	smContext.ResetMonitoredUsage(reports)
+	require.False(t, smContext.UsageMonitoringReportPending())
+
+	// the URR is updated with the new thresholds
+	urr.State = RULE_CREATE
+	updated := smContext.ApplyUsageMonitoringDatas(map[string]*models.UsageMonitoringData{
+		"um1": {UmId: "um1", VolumeThreshold: 2000},
+	})
+	require.Equal(t, map[*UPF][]*URR{upf: {urr}}, updated)
+	require.Equal(t, RULE_UPDATE, urr.State)
+	require.Equal(t, uint64(2000), urr.VolumeThresholds.TotalVolume)
+	require.False(t, urr.ReportingTrigger.Timth)
+
+	// the URR of the stopped monitoring has no threshold
+	updated = smContext.ApplyUsageMonitoringDatas(map[string]*models.UsageMonitoringData{"um1": nil})
+	require.Len(t, updated[upf], 1)
+	require.NotContains(t, smContext.UsageMonitors, "um1")
+	require.Nil(t, urr.VolumeThresholds)
+	require.Equal(t, time.Duration(0), urr.TimeThreshold)
+	require.False(t, urr.ReportingTrigger.Volth)
+}
@@ -2,12 +2,15 @@ package context
 
 import (
 	"errors"
+	"fmt"
 	"math/rand"
 	"net"
 	"reflect"
 	"sort"
 	"sync"
 
+	"bitbucket.org/free5gc-team/nas/nasConvert"
+	"bitbucket.org/free5gc-team/nas/nasMessage"
 	"bitbucket.org/free5gc-team/openapi/models"
 	"bitbucket.org/free5gc-team/pfcp/pfcpType"
 	"bitbucket.org/free5gc-team/smf/internal/logger"
@@ -126,6 +129,7 @@ func NewUserPlaneInformation(upTopology *factory.UserPlaneInformation) *UserPlan
 
 			upNode.UPF = NewUPF(&upNode.NodeID, node.InterfaceUpfInfoList)
 			upNode.UPF.Addr = node.Addr
+			upNode.UPF.ChooseFTEID = node.ChooseFteid
 			snssaiInfos := make([]*SnssaiUPFInfo, 0)
 			for _, snssaiInfoConfig := range node.SNssaiInfos {
 				snssaiInfo := SnssaiUPFInfo{
@@ -237,6 +241,7 @@ func (upi *UserPlaneInformation) UpNodesToConfiguration() map[string]*factory.UP
 			u.NodeID = nodeIDtoIp.String()
 		}
 		if upNode.UPF != nil {
+			u.ChooseFteid = upNode.UPF.ChooseFTEID
 			if upNode.UPF.SNssaiInfos != nil {
 				FsNssaiInfoList := make([]*factory.SnssaiUpfInfoItem, 0)
 				for _, sNssaiInfo := range upNode.UPF.SNssaiInfos {
@@ -384,6 +389,7 @@ func (upi *UserPlaneInformation) UpNodesFromConfiguration(upTopology *factory.Us
 			}
 
 			upNode.UPF = NewUPF(&upNode.NodeID, node.InterfaceUpfInfoList)
+			upNode.UPF.ChooseFTEID = node.ChooseFteid
 			snssaiInfos := make([]*SnssaiUPFInfo, 0)
 			for _, snssaiInfoConfig := range node.SNssaiInfos {
 				snssaiInfo := &SnssaiUPFInfo{
@@ -521,6 +527,11 @@ func (upi *UserPlaneInformation) UpNodeDelete(upNodeName string) {
 	}
 }
 
+// isReleasing reports whether the UPF of the UP node is releasing the PFCP association
+func (u *UPNode) isReleasing() bool {
+	return u.Type == UPNODE_UPF && u.UPF != nil && u.UPF.Releasing
+}
+
 func nodeInPath(upNode *UPNode, path []*UPNode) int {
 	for i, u := range path {
 		if u == upNode {
@@ -596,6 +607,13 @@ func (upi *UserPlaneInformation) GetDefaultUserPlanePathByDNNAndUPF(selection *U
 	return nil
 }
 
+// InvalidateDefaultPaths removes the cached default paths, they are generated again
+// with the current status of the user plane paths
+func (upi *UserPlaneInformation) InvalidateDefaultPaths() {
+	upi.DefaultUserPlanePath = make(map[string][]*UPNode)
+	upi.DefaultUserPlanePathToUPF = make(map[string]map[string][]*UPNode)
+}
+
 func (upi *UserPlaneInformation) ExistDefaultPath(dnn string) bool {
 	_, exist := upi.DefaultUserPlanePath[dnn]
 	return exist
@@ -722,6 +740,9 @@ func (upi *UserPlaneInformation) selectMatchUPF(selection *UPFSelectionParams) [
 	upList := make([]*UPNode, 0)
 
 	for _, upNode := range upi.UPFs {
+		if upNode.isReleasing() {
+			continue
+		}
 		for _, snssaiInfo := range upNode.UPF.SNssaiInfos {
 			currentSnssai := snssaiInfo.SNssai
 			targetSnssai := selection.SNssai
@@ -755,6 +776,9 @@ func getPathBetween(cur *UPNode, dest *UPNode, visited map[*UPNode]bool,
 
 	for _, node := range cur.Links {
 		if !visited[node] {
+			if isUserPlanePathFailed(cur, node) || (node != dest && node.isReleasing()) {
+				continue
+			}
 			if !node.UPF.isSupportSnssai(selectedSNssai) {
 				visited[node] = true
 				continue
@@ -867,8 +891,13 @@ func (upi *UserPlaneInformation) SelectUPFAndAllocUEIP(selection *UPFSelectionPa
 				upi.GetUPFNameByIp(upf.NodeID.ResolveNodeIdToIp().String()))
 			continue
 		}
+		if upf.isReleasing() {
+			logger.CtxLog.Infof("PFCP Association is being released with: %s",
+				upi.GetUPFNameByIp(upf.NodeID.ResolveNodeIdToIp().String()))
+			continue
+		}
 		pools, useStaticIPPool := getUEIPPool(upf, selection)
-		if len(pools) == 0 {
+		if len(pools) == 0 || !hasPairedIPv6Pool(upf, selection) {
 			continue
 		}
 		sortedPoolList := createPoolListForSelection(pools)
@@ -892,6 +921,83 @@ func (upi *UserPlaneInformation) SelectUPFAndAllocUEIP(selection *UPFSelectionPa
 	return nil, nil, false
 }
 
+// SelectVUPF returns the UPF next to AN as the intermediate UPF of a home-routed PDU session,
+// UE IP is not allocated since the PSA is in HPLMN
+func (upi *UserPlaneInformation) SelectVUPF(selection *UPFSelectionParams) (*UPNode, error) {
+	source, err := upi.selectUPPathSource()
+	if err != nil {
+		return nil, err
+	}
+	for _, upf := range upi.sortUPFListByName(source.Links) {
+		if upf.Type != UPNODE_UPF || !upf.MatchedSelection(selection) {
+			continue
+		}
+		if upf.UPF.UPFStatus != AssociatedSetUpSuccess {
+			logger.CtxLog.Infof("PFCP Association not yet Established with: %s",
+				upi.GetUPFNameByIp(upf.NodeID.ResolveNodeIdToIp().String()))
+			continue
+		}
+		if upf.isReleasing() {
+			logger.CtxLog.Infof("PFCP Association is being released with: %s",
+				upi.GetUPFNameByIp(upf.NodeID.ResolveNodeIdToIp().String()))
+			continue
+		}
+		if upf.UPF.GetInterface(models.UpInterfaceType_N9, selection.Dnn) == nil {
+			continue
+		}
+		logger.CtxLog.Infof("Selected V-UPF: %s",
+			upi.GetUPFNameByIp(upf.NodeID.ResolveNodeIdToIp().String()))
+		return upf, nil
+	}
+	return nil, fmt.Errorf("can't find V-UPF with DNN[%s] S-NSSAI[sst: %d sd: %s]",
+		selection.Dnn, selection.SNssai.Sst, selection.SNssai.Sd)
+}
+
+// SelectUPFForNonIPSession returns the PSA of Ethernet or Unstructured PDU session,
+// the PSA is selected from the UPFs whose DNN supports the PDU session type and no UE IP is allocated
+func (upi *UserPlaneInformation) SelectUPFForNonIPSession(selection *UPFSelectionParams) (*UPNode, error) {
+	source, err := upi.selectUPPathSource()
+	if err != nil {
+		return nil, err
+	}
+	pduSessionType := nasConvert.PDUSessionTypeToModels(selection.PDUSessionType)
+	UPFList := upi.sortUPFListByName(upi.selectAnchorUPF(source, selection))
+	for _, upf := range UPFList {
+		if upf.UPF.UPFStatus != AssociatedSetUpSuccess {
+			logger.CtxLog.Infof("PFCP Association not yet Established with: %s",
+				upi.GetUPFNameByIp(upf.NodeID.ResolveNodeIdToIp().String()))
+			continue
+		}
+		if upf.isReleasing() {
+			logger.CtxLog.Infof("PFCP Association is being released with: %s",
+				upi.GetUPFNameByIp(upf.NodeID.ResolveNodeIdToIp().String()))
+			continue
+		}
+		if !upf.supportPDUSessionType(selection, pduSessionType) {
+			continue
+		}
+		logger.CtxLog.Infof("Selected UPF: %s",
+			upi.GetUPFNameByIp(upf.NodeID.ResolveNodeIdToIp().String()))
+		return upf, nil
+	}
+	return nil, fmt.Errorf("can't find UPF supporting PDU session type[%s] with DNN[%s] S-NSSAI[sst: %d sd: %s]",
+		pduSessionType, selection.Dnn, selection.SNssai.Sst, selection.SNssai.Sd)
+}
+
+func (u *UPNode) supportPDUSessionType(selection *UPFSelectionParams, pduSessionType models.PduSessionType) bool {
+	for _, snssaiInfo := range u.UPF.SNssaiInfos {
+		if !snssaiInfo.SNssai.Equal(selection.SNssai) {
+			continue
+		}
+		for _, dnnInfo := range snssaiInfo.DnnList {
+			if dnnInfo.Dnn == selection.Dnn && dnnInfo.ContainsPDUSessionType(pduSessionType) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func createUPFListForSelection(inputList []*UPNode) (outputList []*UPNode) {
 	offset := rand.Intn(len(inputList))
 	return append(inputList[offset:], inputList[:offset]...)
@@ -936,14 +1042,104 @@ func getUEIPPool(upNode *UPNode, selection *UPFSelectionParams) ([]*UeIPPool, bo
 					}
 
 					// if no specify static PDU Address
-					return dnnInfo.UeIPPools, false
+					return poolsOfPDUSessionType(dnnInfo.UeIPPools, selection.PDUSessionType), false
+				}
+			}
+		}
+	}
+	return nil, false
+}
+
+// SupportedPDUSessionType returns "IPv4", "IPv6" or "IPv4v6" according to
+// the IPv4 address pools and IPv6 prefix pools of the DNN of the S-NSSAI on UPFs
+func (upi *UserPlaneInformation) SupportedPDUSessionType(snssai *SNssai, dnn string) string {
+	var ipv4, ipv6 bool
+	for _, upf := range upi.UPFs {
+		for _, snssaiInfo := range upf.UPF.SNssaiInfos {
+			if !snssaiInfo.SNssai.Equal(snssai) {
+				continue
+			}
+			for _, dnnInfo := range snssaiInfo.DnnList {
+				if dnnInfo.Dnn != dnn {
+					continue
+				}
+				for _, pool := range dnnInfo.UeIPPools {
+					if pool.isIPv6() {
+						ipv6 = true
+					} else {
+						ipv4 = true
+					}
 				}
 			}
 		}
 	}
+	switch {
+	case ipv4 && ipv6:
+		return "IPv4v6"
+	case ipv6:
+		return "IPv6"
+	default:
+		return "IPv4"
+	}
+}
+
+// SupportPDUSessionType returns true if any UPF has DNN supporting the PDU session type
+func (upi *UserPlaneInformation) SupportPDUSessionType(pduSessionType models.PduSessionType) bool {
+	for _, upf := range upi.UPFs {
+		for _, snssaiInfo := range upf.UPF.SNssaiInfos {
+			for _, dnnInfo := range snssaiInfo.DnnList {
+				if dnnInfo.ContainsPDUSessionType(pduSessionType) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// hasPairedIPv6Pool returns false if the UPF has no IPv6 prefix pool for IPv4v6 PDU session,
+// the IPv4 address and the IPv6 prefix of a PDU session are allocated from the same UPF
+func hasPairedIPv6Pool(upNode *UPNode, selection *UPFSelectionParams) bool {
+	if selection.PDUSessionType != nasMessage.PDUSessionTypeIPv4IPv6 {
+		return true
+	}
+	pools, _ := getUEIPPool(upNode, selection.ipv6Selection())
+	return len(pools) != 0
+}
+
+// AllocUEIPv6Prefix allocates the IPv6 prefix of IPv4v6 PDU session from the IPv6 prefix pools of upf,
+// which the IPv4 address of the PDU session is allocated from
+func (upi *UserPlaneInformation) AllocUEIPv6Prefix(upf *UPNode, selection *UPFSelectionParams) (net.IP, bool) {
+	ipv6Selection := selection.ipv6Selection()
+	pools, useStaticIPPool := getUEIPPool(upf, ipv6Selection)
+	if len(pools) == 0 {
+		logger.CtxLog.Warnf("No IPv6 prefix pool in UPF: %s",
+			upi.GetUPFNameByIp(upf.NodeID.ResolveNodeIdToIp().String()))
+		return nil, false
+	}
+	for _, pool := range createPoolListForSelection(pools) {
+		if prefix := pool.allocate(ipv6Selection.PDUAddress); prefix != nil {
+			return prefix, useStaticIPPool
+		}
+	}
+	logger.CtxLog.Warnf("IPv6 prefix pool exhausted in UPF: %s",
+		upi.GetUPFNameByIp(upf.NodeID.ResolveNodeIdToIp().String()))
 	return nil, false
 }
 
+// poolsOfPDUSessionType returns the IPv6 prefix pools for IPv6 PDU session
+// and the IPv4 address pools for the others
+func poolsOfPDUSessionType(pools []*UeIPPool, pduSessionType uint8) []*UeIPPool {
+	ipv6 := pduSessionType == nasMessage.PDUSessionTypeIPv6
+	var matched []*UeIPPool
+	for _, pool := range pools {
+		if pool.isIPv6() == ipv6 {
+			matched = append(matched, pool)
+		}
+	}
+	return matched
+}
+
 func (upi *UserPlaneInformation) ReleaseUEIP(upf *UPNode, addr net.IP, static bool) {
 	pool := findPoolByAddr(upf, addr, static)
 	if pool == nil {
@@ -7,7 +7,9 @@ import (
 
 	"github.com/stretchr/testify/require"
 
+	"bitbucket.org/free5gc-team/nas/nasMessage"
 	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
 	"bitbucket.org/free5gc-team/smf/pkg/factory"
 )
 
@@ -256,6 +258,89 @@ func TestGenerateDefaultPath(t *testing.T) {
 	}
 }
 
+func TestGenerateDefaultPathWithFailedUserPlanePath(t *testing.T) {
+	upNodes := make(map[string]*factory.UPNode)
+	for name, node := range configuration.UPNodes {
+		upNode := *node
+		upNodes[name] = &upNode
+	}
+	upNodes["GNodeB"].ANIP = "192.168.179.100"
+	upNodes["UPF1"].InterfaceUpfInfoList = []*factory.InterfaceUpfInfoItem{
+		{InterfaceType: "N3", Endpoints: []string{"10.200.200.1"}, NetworkInstances: []string{"internet"}},
+		{InterfaceType: "N9", Endpoints: []string{"10.200.201.1"}, NetworkInstances: []string{"internet"}},
+	}
+	upNodes["UPF4"].InterfaceUpfInfoList = []*factory.InterfaceUpfInfoItem{
+		{InterfaceType: "N3", Endpoints: []string{"10.200.200.4"}, NetworkInstances: []string{"internet"}},
+		{InterfaceType: "N9", Endpoints: []string{"10.200.201.4"}, NetworkInstances: []string{"internet"}},
+	}
+	config := &factory.UserPlaneInformation{
+		UPNodes: upNodes,
+		Links: []*factory.UPLink{
+			{A: "GNodeB", B: "UPF1"},
+			{A: "UPF1", B: "UPF4"},
+			{A: "GNodeB", B: "UPF4"},
+		},
+	}
+	upi := NewUserPlaneInformation(config)
+	upf1, upf4 := upi.UPFs["UPF1"], upi.UPFs["UPF4"]
+	selection := &UPFSelectionParams{
+		SNssai: &SNssai{
+			Sst: 1,
+			Sd:  "112235",
+		},
+		Dnn: "internet",
+	}
+
+	require.Equal(t, UPPath{upf1, upf4}, upi.GetDefaultUserPlanePathByDNNAndUPF(selection, upf4))
+
+	// N9 path between UPF1 and UPF4 failed
+	require.True(t, upf4.UPF.SetGTPUPeerStatus(net.ParseIP("10.200.201.1"), true))
+	require.False(t, upf4.UPF.SetGTPUPeerStatus(net.ParseIP("10.200.201.1"), true))
+	upi.InvalidateDefaultPaths()
+	require.Equal(t, UPPath{upf4}, upi.GetDefaultUserPlanePathByDNNAndUPF(selection, upf4))
+
+	// N3 path between AN and UPF4 failed too
+	require.True(t, upf4.UPF.SetGTPUPeerStatus(net.ParseIP("192.168.179.100"), true))
+	upi.InvalidateDefaultPaths()
+	require.Nil(t, upi.GetDefaultUserPlanePathByDNNAndUPF(selection, upf4))
+
+	// N9 path recovered
+	require.True(t, upf4.UPF.SetGTPUPeerStatus(net.ParseIP("10.200.201.1"), false))
+	upi.InvalidateDefaultPaths()
+	require.Equal(t, UPPath{upf1, upf4}, upi.GetDefaultUserPlanePathByDNNAndUPF(selection, upf4))
+}
+
+func TestGenerateDefaultPathWithReleasingUPF(t *testing.T) {
+	config := &factory.UserPlaneInformation{
+		UPNodes: configuration.UPNodes,
+		Links: []*factory.UPLink{
+			{A: "GNodeB", B: "UPF1"},
+			{A: "UPF1", B: "UPF4"},
+			{A: "GNodeB", B: "UPF4"},
+		},
+	}
+	upi := NewUserPlaneInformation(config)
+	upf1, upf4 := upi.UPFs["UPF1"], upi.UPFs["UPF4"]
+	selection := &UPFSelectionParams{
+		SNssai: &SNssai{
+			Sst: 1,
+			Sd:  "112235",
+		},
+		Dnn: "internet",
+	}
+
+	require.Equal(t, UPPath{upf1, upf4}, upi.GetDefaultUserPlanePathByDNNAndUPF(selection, upf4))
+
+	// the releasing UPF is avoided as an intermediate UPF
+	upf1.UPF.Releasing = true
+	upi.InvalidateDefaultPaths()
+	require.Equal(t, UPPath{upf4}, upi.GetDefaultUserPlanePathByDNNAndUPF(selection, upf4))
+
+	upf1.UPF.Releasing = false
+	upi.InvalidateDefaultPaths()
+	require.Equal(t, UPPath{upf1, upf4}, upi.GetDefaultUserPlanePathByDNNAndUPF(selection, upf4))
+}
+
 func TestGetDefaultUPFTopoByDNN(t *testing.T) {
 }
 
@@ -516,3 +601,186 @@ func TestGetUEIPPool(t *testing.T) {
 		})
 	}
 }
+
+var configForDualStackAllocate = &factory.UserPlaneInformation{
+	UPNodes: map[string]*factory.UPNode{
+		"GNodeB": {
+			Type:   "AN",
+			NodeID: "192.168.179.100",
+		},
+		"UPF1": {
+			Type:   "UPF",
+			NodeID: "192.168.179.1",
+			SNssaiInfos: []*factory.SnssaiUpfInfoItem{
+				{
+					SNssai: &models.Snssai{
+						Sst: 1,
+						Sd:  "111111",
+					},
+					DnnUpfInfoList: []*factory.DnnUpfInfoItem{
+						{
+							Dnn: "internet",
+							Pools: []*factory.UEIPPool{
+								{
+									Cidr: "10.80.0.0/24",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		"UPF2": {
+			Type:   "UPF",
+			NodeID: "192.168.179.2",
+			SNssaiInfos: []*factory.SnssaiUpfInfoItem{
+				{
+					SNssai: &models.Snssai{
+						Sst: 1,
+						Sd:  "111111",
+					},
+					DnnUpfInfoList: []*factory.DnnUpfInfoItem{
+						{
+							Dnn: "internet",
+							Pools: []*factory.UEIPPool{
+								{
+									Cidr: "10.81.0.0/24",
+								},
+								{
+									Cidr: "2001:db8:81::/63",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	Links: []*factory.UPLink{
+		{
+			A: "GNodeB",
+			B: "UPF1",
+		},
+		{
+			A: "GNodeB",
+			B: "UPF2",
+		},
+	},
+}
+
+func TestAllocUEIPv4v6(t *testing.T) {
+	userplaneInformation := NewUserPlaneInformation(configForDualStackAllocate)
+	for _, upf := range userplaneInformation.UPFs {
+		upf.UPF.UPFStatus = AssociatedSetUpSuccess
+	}
+	require.Equal(t, "IPv4v6", userplaneInformation.SupportedPDUSessionType(&SNssai{Sst: 1, Sd: "111111"}, "internet"))
+	// the PDU session type is decided by the pools of the DNN of the S-NSSAI
+	require.Equal(t, "IPv4", userplaneInformation.SupportedPDUSessionType(&SNssai{Sst: 1, Sd: "222222"}, "internet"))
+
+	selection := &UPFSelectionParams{
+		Dnn: "internet",
+		SNssai: &SNssai{
+			Sst: 1,
+			Sd:  "111111",
+		},
+		PDUSessionType: nasMessage.PDUSessionTypeIPv4IPv6,
+	}
+	expectedPrefixes := []net.IP{
+		net.ParseIP("2001:db8:81::"),
+		net.ParseIP("2001:db8:81:1::"),
+	}
+
+	// the IPv4 address and the IPv6 prefix are allocated from the paired pools of UPF2
+	var allocated []net.IP
+	for i := 0; i < 2; i++ {
+		upf, ipv4, _ := userplaneInformation.SelectUPFAndAllocUEIP(selection)
+		require.Equal(t, "UPF2", upf.Name)
+		require.Contains(t, ipv4.String(), "10.81.0.")
+		prefix, _ := userplaneInformation.AllocUEIPv6Prefix(upf, selection)
+		require.Contains(t, expectedPrefixes, prefix)
+		allocated = append(allocated, prefix)
+	}
+	require.NotEqual(t, allocated[0], allocated[1])
+
+	upf := userplaneInformation.UPFs["UPF2"]
+	prefix, _ := userplaneInformation.AllocUEIPv6Prefix(upf, selection)
+	require.Nil(t, prefix)
+
+	userplaneInformation.ReleaseUEIP(upf, allocated[1], false)
+	prefix, _ = userplaneInformation.AllocUEIPv6Prefix(upf, selection)
+	require.Equal(t, allocated[1], prefix)
+
+	smContext := &SMContext{
+		SelectedPDUSessionType: nasMessage.PDUSessionTypeIPv4IPv6,
+		PDUAddress:             net.ParseIP("10.81.0.1").To4(),
+		PDUAddressIPv6:         prefix,
+		InterfaceIdentifier:    []byte{1, 2, 3, 4, 5, 6, 7, 8},
+	}
+	addr, addrLen := smContext.PDUAddressToNAS()
+	require.Equal(t, uint8(13), addrLen)
+	require.Equal(t, [12]byte{1, 2, 3, 4, 5, 6, 7, 8, 10, 81, 0, 1}, addr)
+	ueIPAddress := smContext.UEIPAddressIE(true)
+	require.True(t, ueIPAddress.V4 && ueIPAddress.V6)
+	require.Equal(t, prefix.To16(), ueIPAddress.Ipv6Address)
+	require.Equal(t, "2001:db8:81:1::/64", smContext.UeIPv6Prefix())
+}
+
+func TestFindPSAandAllocUeIPSingleStackFallback(t *testing.T) {
+	newSMContext := func(dnn string) *SMContext {
+		return &SMContext{
+			Log:                    logger.PduSessLog,
+			SelectedPDUSessionType: nasMessage.PDUSessionTypeIPv4IPv6,
+			SelectionParam: &UPFSelectionParams{
+				Dnn:            dnn,
+				SNssai:         &SNssai{Sst: 1, Sd: "111111"},
+				PDUSessionType: nasMessage.PDUSessionTypeIPv4IPv6,
+			},
+		}
+	}
+
+	smfContext.UserPlaneInformation = NewUserPlaneInformation(configForDualStackAllocate)
+	for _, upf := range smfContext.UserPlaneInformation.UPFs {
+		upf.UPF.UPFStatus = AssociatedSetUpSuccess
+	}
+	// the /63 IPv6 prefix pool has two /64 prefixes
+	for i := 0; i < 2; i++ {
+		smContext := newSMContext("internet")
+		require.NoError(t, smContext.findPSAandAllocUeIP(smContext.SelectionParam))
+		require.Equal(t, nasMessage.PDUSessionTypeIPv4IPv6, smContext.SelectedPDUSessionType)
+		require.NotNil(t, smContext.PDUAddressIPv6)
+	}
+	// the IPv6 prefix pool is exhausted, the PDU session falls back to IPv4 with #50
+	smContext := newSMContext("internet")
+	require.NoError(t, smContext.findPSAandAllocUeIP(smContext.SelectionParam))
+	require.Equal(t, nasMessage.PDUSessionTypeIPv4, smContext.SelectedPDUSessionType)
+	require.Equal(t, nasMessage.PDUSessionTypeIPv4, smContext.SelectionParam.PDUSessionType)
+	require.Equal(t, nasMessage.Cause5GSMPDUSessionTypeIPv4OnlyAllowed, smContext.EstAcceptCause5gSMValue)
+	require.NotNil(t, smContext.PDUAddress.To4())
+	require.Nil(t, smContext.PDUAddressIPv6)
+
+	// the DNN has no IPv4 address pool, the PDU session falls back to IPv6 with #51
+	smfContext.UserPlaneInformation = NewUserPlaneInformation(&factory.UserPlaneInformation{
+		UPNodes: map[string]*factory.UPNode{
+			"GNodeB": {Type: "AN", NodeID: "192.168.179.100"},
+			"UPF1": {
+				Type:   "UPF",
+				NodeID: "192.168.179.1",
+				SNssaiInfos: []*factory.SnssaiUpfInfoItem{{
+					SNssai: &models.Snssai{Sst: 1, Sd: "111111"},
+					DnnUpfInfoList: []*factory.DnnUpfInfoItem{{
+						Dnn:   "ims",
+						Pools: []*factory.UEIPPool{{Cidr: "2001:db8:90::/64"}},
+					}},
+				}},
+			},
+		},
+		Links: []*factory.UPLink{{A: "GNodeB", B: "UPF1"}},
+	})
+	smfContext.UserPlaneInformation.UPFs["UPF1"].UPF.UPFStatus = AssociatedSetUpSuccess
+	smContext = newSMContext("ims")
+	require.NoError(t, smContext.findPSAandAllocUeIP(smContext.SelectionParam))
+	require.Equal(t, nasMessage.PDUSessionTypeIPv6, smContext.SelectedPDUSessionType)
+	require.Equal(t, nasMessage.Cause5GSMPDUSessionTypeIPv6OnlyAllowed, smContext.EstAcceptCause5gSMValue)
+	require.Equal(t, "2001:db8:90::", smContext.PDUAddress.String())
+	require.Len(t, smContext.InterfaceIdentifier, 8)
+}
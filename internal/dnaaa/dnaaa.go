@@ -0,0 +1,106 @@
+// Package dnaaa implements the DN-AAA client of the secondary authentication/authorization
+// of PDU session establishment (TS 23.501 5.6.6, TS 29.561)
+package dnaaa
+
+import (
+	"fmt"
+	"net"
+)
+
+// ResultCode is the outcome of an EAP round trip with DN-AAA
+type ResultCode int
+
+const (
+	// ResultContinue means DN-AAA sends another EAP request to UE
+	ResultContinue ResultCode = iota
+	// ResultSuccess means UE is authenticated and authorized by DN-AAA
+	ResultSuccess
+	// ResultFailure means UE is rejected by DN-AAA
+	ResultFailure
+)
+
+func (c ResultCode) String() string {
+	switch c {
+	case ResultContinue:
+		return "Continue"
+	case ResultSuccess:
+		return "Success"
+	case ResultFailure:
+		return "Failure"
+	default:
+		return fmt.Sprintf("Unknown(%d)", int(c))
+	}
+}
+
+// Session is the secondary authentication of a PDU session toward DN-AAA
+type Session struct {
+	Supi string
+	// Gpsi is used as the calling station identifier if it presents
+	Gpsi string
+	Dnn  string
+	// Identity is the DN-specific identity of UE (TS 24.501 9.11.4.15)
+	Identity string
+	// State is kept by the client between the EAP round trips, e.g. RADIUS State attribute
+	State []byte
+	// Busy is set while an EAP message is relayed to DN-AAA, the EAP message of UE received meanwhile
+	// is a retransmission and dropped
+	Busy bool
+}
+
+// Authorization is the authorization data provided by DN-AAA on successful authentication
+type Authorization struct {
+	UEIPv4Address net.IP
+	// UEIPv6Prefix is the IPv6 prefix of UE with its length
+	UEIPv6Prefix *net.IPNet
+}
+
+// Result is the answer of DN-AAA to an EAP message of UE
+type Result struct {
+	Code ResultCode
+	// EAPMessage is relayed to UE, it is EAP-Success or EAP-Failure if the code is not ResultContinue
+	EAPMessage    []byte
+	Authorization *Authorization
+}
+
+// Client is the DN-AAA client, the implementation is selected by the secondary authentication
+// configuration of DNN
+type Client interface {
+	// Authenticate relays the EAP response of UE to DN-AAA and returns the answer of DN-AAA
+	Authenticate(session *Session, eapMsg []byte) (*Result, error)
+}
+
+// EAP codes and types, RFC 3748
+const (
+	EAPCodeRequest  uint8 = 1
+	EAPCodeResponse uint8 = 2
+	EAPCodeSuccess  uint8 = 3
+	EAPCodeFailure  uint8 = 4
+
+	EAPTypeIdentity uint8 = 1
+)
+
+// NewEAPIdentityRequest returns the EAP-Request/Identity which starts the authentication with UE
+func NewEAPIdentityRequest(identifier uint8) []byte {
+	return []byte{EAPCodeRequest, identifier, 0, 5, EAPTypeIdentity}
+}
+
+// NewEAPIdentityResponse returns the EAP-Response/Identity of the DN-specific identity provided by UE
+// in the SM PDU DN request container
+func NewEAPIdentityResponse(identifier uint8, identity string) []byte {
+	length := 5 + len(identity)
+	msg := []byte{EAPCodeResponse, identifier, uint8(length >> 8), uint8(length), EAPTypeIdentity}
+	return append(msg, identity...)
+}
+
+// NewEAPFailure returns the EAP-Failure for UE when DN-AAA can't be reached
+func NewEAPFailure(identifier uint8) []byte {
+	return []byte{EAPCodeFailure, identifier, 0, 4}
+}
+
+// EAPIdentifier returns the identifier of the EAP message
+func EAPIdentifier(eapMsg []byte) uint8 {
+	if len(eapMsg) < 2 {
+		return 0
+	}
+	return eapMsg[1]
+}
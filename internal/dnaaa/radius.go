@@ -0,0 +1,351 @@
+package dnaaa
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5" // #nosec G501 -- RADIUS authenticators are defined with MD5 (RFC 2865, RFC 3579)
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// RADIUS packet codes, RFC 2865 3
+const (
+	RadiusCodeAccessRequest   uint8 = 1
+	RadiusCodeAccessAccept    uint8 = 2
+	RadiusCodeAccessReject    uint8 = 3
+	RadiusCodeAccessChallenge uint8 = 11
+)
+
+// RADIUS attribute types, RFC 2865 5, RFC 3162 2.3 and RFC 3579 3
+const (
+	RadiusAttrUserName             uint8 = 1
+	RadiusAttrFramedIPAddress      uint8 = 8
+	RadiusAttrState                uint8 = 24
+	RadiusAttrCalledStationID      uint8 = 30
+	RadiusAttrCallingStationID     uint8 = 31
+	RadiusAttrNASIdentifier        uint8 = 32
+	RadiusAttrEAPMessage           uint8 = 79
+	RadiusAttrMessageAuthenticator uint8 = 80
+	RadiusAttrFramedIPv6Prefix     uint8 = 97
+)
+
+const (
+	radiusHeaderLen      = 20
+	radiusMaxPacketLen   = 4096
+	radiusMaxAttrDataLen = 253
+
+	DefaultRadiusTimeout = 3 * time.Second
+	DefaultRadiusRetries = 2
+)
+
+// RadiusAttribute is a RADIUS attribute with its raw value
+type RadiusAttribute struct {
+	Type  uint8
+	Value []byte
+}
+
+// RadiusPacket is the RADIUS packet, RFC 2865 3
+type RadiusPacket struct {
+	Code          uint8
+	Identifier    uint8
+	Authenticator [16]byte
+	Attributes    []RadiusAttribute
+}
+
+// Add appends the attribute, the value longer than 253 octets is split into several attributes,
+// e.g. EAP-Message (RFC 3579 3.1)
+func (p *RadiusPacket) Add(attrType uint8, value []byte) {
+	for len(value) > radiusMaxAttrDataLen {
+		p.Attributes = append(p.Attributes, RadiusAttribute{Type: attrType, Value: value[:radiusMaxAttrDataLen]})
+		value = value[radiusMaxAttrDataLen:]
+	}
+	p.Attributes = append(p.Attributes, RadiusAttribute{Type: attrType, Value: value})
+}
+
+// Get returns the concatenated value of the attributes of the type, or nil if the attribute is absent
+func (p *RadiusPacket) Get(attrType uint8) []byte {
+	var value []byte
+	for _, attr := range p.Attributes {
+		if attr.Type == attrType {
+			value = append(value, attr.Value...)
+		}
+	}
+	return value
+}
+
+// Marshal encodes the RADIUS packet
+func (p *RadiusPacket) Marshal() ([]byte, error) {
+	buf := make([]byte, radiusHeaderLen, radiusMaxPacketLen)
+	buf[0] = p.Code
+	buf[1] = p.Identifier
+	copy(buf[4:radiusHeaderLen], p.Authenticator[:])
+	for _, attr := range p.Attributes {
+		if len(attr.Value) > radiusMaxAttrDataLen {
+			return nil, fmt.Errorf("RADIUS attribute[%d] is too long: %d", attr.Type, len(attr.Value))
+		}
+		buf = append(buf, attr.Type, uint8(2+len(attr.Value)))
+		buf = append(buf, attr.Value...)
+	}
+	if len(buf) > radiusMaxPacketLen {
+		return nil, fmt.Errorf("RADIUS packet is too long: %d", len(buf))
+	}
+	binary.BigEndian.PutUint16(buf[2:4], uint16(len(buf)))
+	return buf, nil
+}
+
+// UnmarshalRadiusPacket decodes the RADIUS packet
+func UnmarshalRadiusPacket(b []byte) (*RadiusPacket, error) {
+	if len(b) < radiusHeaderLen {
+		return nil, fmt.Errorf("RADIUS packet is too short: %d", len(b))
+	}
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if length < radiusHeaderLen || length > len(b) {
+		return nil, fmt.Errorf("invalid RADIUS packet length: %d", length)
+	}
+	p := &RadiusPacket{
+		Code:       b[0],
+		Identifier: b[1],
+	}
+	copy(p.Authenticator[:], b[4:radiusHeaderLen])
+	for attrs := b[radiusHeaderLen:length]; len(attrs) > 0; {
+		if len(attrs) < 2 || int(attrs[1]) < 2 || int(attrs[1]) > len(attrs) {
+			return nil, fmt.Errorf("invalid RADIUS attribute")
+		}
+		p.Attributes = append(p.Attributes, RadiusAttribute{
+			Type:  attrs[0],
+			Value: append([]byte{}, attrs[2:attrs[1]]...),
+		})
+		attrs = attrs[attrs[1]:]
+	}
+	return p, nil
+}
+
+// SetMessageAuthenticator adds the Message-Authenticator attribute signed with the secret, RFC 3579 3.2.
+// The authenticator of the packet has to be the request authenticator
+func (p *RadiusPacket) SetMessageAuthenticator(secret []byte) error {
+	p.Attributes = append(p.Attributes, RadiusAttribute{
+		Type:  RadiusAttrMessageAuthenticator,
+		Value: make([]byte, md5.Size),
+	})
+	buf, err := p.Marshal()
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(md5.New, secret)
+	mac.Write(buf)
+	copy(p.Attributes[len(p.Attributes)-1].Value, mac.Sum(nil))
+	return nil
+}
+
+// verifyMessageAuthenticator checks the Message-Authenticator of the response with the request authenticator
+func (p *RadiusPacket) verifyMessageAuthenticator(secret []byte, requestAuthenticator [16]byte) bool {
+	signed := &RadiusPacket{
+		Code:          p.Code,
+		Identifier:    p.Identifier,
+		Authenticator: requestAuthenticator,
+	}
+	var received []byte
+	for _, attr := range p.Attributes {
+		if attr.Type == RadiusAttrMessageAuthenticator {
+			received = attr.Value
+			attr = RadiusAttribute{Type: attr.Type, Value: make([]byte, md5.Size)}
+		}
+		signed.Attributes = append(signed.Attributes, attr)
+	}
+	if received == nil {
+		return false
+	}
+	buf, err := signed.Marshal()
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(md5.New, secret)
+	mac.Write(buf)
+	return hmac.Equal(received, mac.Sum(nil))
+}
+
+// ResponseAuthenticator returns the response authenticator of the encoded response
+// and the request authenticator, RFC 2865 3
+func ResponseAuthenticator(response []byte, requestAuthenticator [16]byte, secret []byte) [16]byte {
+	h := md5.New() // #nosec G401
+	h.Write(response[:4])
+	h.Write(requestAuthenticator[:])
+	h.Write(response[radiusHeaderLen:])
+	h.Write(secret)
+	var auth [16]byte
+	copy(auth[:], h.Sum(nil))
+	return auth
+}
+
+// RadiusClient relays EAP to DN-AAA by RADIUS (TS 29.561 11.1, RFC 3579)
+type RadiusClient struct {
+	ServerAddr    string
+	Secret        []byte
+	NASIdentifier string
+	Timeout       time.Duration
+	Retries       int
+
+	identifier uint32
+}
+
+var _ Client = &RadiusClient{}
+
+func NewRadiusClient(serverAddr, secret, nasIdentifier string) *RadiusClient {
+	return &RadiusClient{
+		ServerAddr:    serverAddr,
+		Secret:        []byte(secret),
+		NASIdentifier: nasIdentifier,
+		Timeout:       DefaultRadiusTimeout,
+		Retries:       DefaultRadiusRetries,
+	}
+}
+
+// Authenticate sends the Access-Request with the EAP message and maps the answer of RADIUS server,
+// Access-Challenge continues the authentication and the State attribute is kept in the session
+func (c *RadiusClient) Authenticate(session *Session, eapMsg []byte) (*Result, error) {
+	request := &RadiusPacket{
+		Code:       RadiusCodeAccessRequest,
+		Identifier: uint8(atomic.AddUint32(&c.identifier, 1)),
+	}
+	if _, err := rand.Read(request.Authenticator[:]); err != nil {
+		return nil, fmt.Errorf("generate request authenticator failed: %v", err)
+	}
+	userName := session.Identity
+	if userName == "" {
+		userName = session.Supi
+	}
+	request.Add(RadiusAttrUserName, []byte(userName))
+	callingStationID := session.Gpsi
+	if callingStationID == "" {
+		callingStationID = session.Supi
+	}
+	request.Add(RadiusAttrCallingStationID, []byte(callingStationID))
+	request.Add(RadiusAttrCalledStationID, []byte(session.Dnn))
+	if c.NASIdentifier != "" {
+		request.Add(RadiusAttrNASIdentifier, []byte(c.NASIdentifier))
+	}
+	request.Add(RadiusAttrEAPMessage, eapMsg)
+	if session.State != nil {
+		request.Add(RadiusAttrState, session.State)
+	}
+	if err := request.SetMessageAuthenticator(c.Secret); err != nil {
+		return nil, err
+	}
+
+	response, err := c.exchange(request)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{
+		EAPMessage: response.Get(RadiusAttrEAPMessage),
+	}
+	session.State = response.Get(RadiusAttrState)
+	switch response.Code {
+	case RadiusCodeAccessChallenge:
+		if result.EAPMessage == nil {
+			return nil, fmt.Errorf("no EAP message in RADIUS Access-Challenge")
+		}
+		result.Code = ResultContinue
+	case RadiusCodeAccessAccept:
+		result.Code = ResultSuccess
+		result.Authorization = radiusAuthorization(response)
+	case RadiusCodeAccessReject:
+		result.Code = ResultFailure
+	default:
+		return nil, fmt.Errorf("unexpected RADIUS code: %d", response.Code)
+	}
+	return result, nil
+}
+
+func radiusAuthorization(response *RadiusPacket) *Authorization {
+	authorization := new(Authorization)
+	if ip := response.Get(RadiusAttrFramedIPAddress); len(ip) == net.IPv4len {
+		authorization.UEIPv4Address = net.IP(ip)
+	}
+	if prefix := response.Get(RadiusAttrFramedIPv6Prefix); prefix != nil {
+		authorization.UEIPv6Prefix = framedIPv6Prefix(prefix)
+	}
+	return authorization
+}
+
+// framedIPv6Prefix decodes Framed-IPv6-Prefix: Reserved (1 octet), Prefix-Length (1 octet) and Prefix
+// (up to 16 octets, RFC 3162 2.3), the bits beyond the prefix length are cleared. It returns nil if
+// the attribute is malformed
+func framedIPv6Prefix(value []byte) *net.IPNet {
+	if len(value) < 2 || len(value) > 2+net.IPv6len {
+		return nil
+	}
+	prefixLen := int(value[1])
+	if prefixLen > 8*net.IPv6len || (prefixLen+7)/8 > len(value)-2 {
+		return nil
+	}
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, value[2:])
+	mask := net.CIDRMask(prefixLen, 8*net.IPv6len)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
+
+// exchange sends the request and waits for the verified response, the request is retransmitted on timeout
+func (c *RadiusClient) exchange(request *RadiusPacket) (*RadiusPacket, error) {
+	buf, err := request.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial("udp", c.ServerAddr)
+	if err != nil {
+		return nil, fmt.Errorf("connect RADIUS server[%s] failed: %v", c.ServerAddr, err)
+	}
+	defer conn.Close()
+
+	rsp := make([]byte, radiusMaxPacketLen)
+	for attempt := 0; attempt <= c.Retries; attempt++ {
+		if _, err = conn.Write(buf); err != nil {
+			return nil, fmt.Errorf("send RADIUS request failed: %v", err)
+		}
+		if err = conn.SetReadDeadline(time.Now().Add(c.Timeout)); err != nil {
+			return nil, err
+		}
+		for {
+			var n int
+			n, err = conn.Read(rsp)
+			if err != nil {
+				break
+			}
+			response, verifyErr := c.verify(rsp[:n], request)
+			if verifyErr != nil {
+				// the response of the retransmitted request or a forged one, wait for the next
+				continue
+			}
+			return response, nil
+		}
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+			return nil, fmt.Errorf("receive RADIUS response failed: %v", err)
+		}
+	}
+	return nil, fmt.Errorf("no response from RADIUS server[%s]", c.ServerAddr)
+}
+
+func (c *RadiusClient) verify(b []byte, request *RadiusPacket) (*RadiusPacket, error) {
+	response, err := UnmarshalRadiusPacket(b)
+	if err != nil {
+		return nil, err
+	}
+	if response.Identifier != request.Identifier {
+		return nil, fmt.Errorf("unexpected RADIUS identifier: %d", response.Identifier)
+	}
+	length := binary.BigEndian.Uint16(b[2:4])
+	expected := ResponseAuthenticator(b[:length], request.Authenticator, c.Secret)
+	if !bytes.Equal(expected[:], response.Authenticator[:]) {
+		return nil, fmt.Errorf("invalid RADIUS response authenticator")
+	}
+	if response.Get(RadiusAttrEAPMessage) != nil &&
+		!response.verifyMessageAuthenticator(c.Secret, request.Authenticator) {
+		return nil, fmt.Errorf("invalid RADIUS Message-Authenticator")
+	}
+	return response, nil
+}
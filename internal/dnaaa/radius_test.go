@@ -0,0 +1,148 @@
+package dnaaa
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "testing123"
+
+// radiusStandIn is a local RADIUS server acting as DN-AAA, it challenges the identity once
+// and accepts the UE if the EAP response is the expected one
+type radiusStandIn struct {
+	conn     net.PacketConn
+	identity string
+	password []byte
+}
+
+func newRadiusStandIn(t *testing.T, identity string, password []byte) *radiusStandIn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &radiusStandIn{conn: conn, identity: identity, password: password}
+	go s.serve()
+	return s
+}
+
+func (s *radiusStandIn) serve() {
+	buf := make([]byte, radiusMaxPacketLen)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		request, err := UnmarshalRadiusPacket(buf[:n])
+		if err != nil || !request.verifyMessageAuthenticator([]byte(testSecret), request.Authenticator) {
+			// the request is silently discarded, RFC 3579 3.2
+			continue
+		}
+
+		response := &RadiusPacket{
+			Identifier:    request.Identifier,
+			Authenticator: request.Authenticator,
+		}
+		eapMsg := request.Get(RadiusAttrEAPMessage)
+		switch {
+		case request.Get(RadiusAttrState) == nil &&
+			bytes.Equal(eapMsg, NewEAPIdentityResponse(EAPIdentifier(eapMsg), s.identity)):
+			// challenge with a EAP request of an experimental type carrying nothing
+			response.Code = RadiusCodeAccessChallenge
+			response.Add(RadiusAttrEAPMessage, []byte{EAPCodeRequest, 2, 0, 5, 254})
+			response.Add(RadiusAttrState, []byte("challenged"))
+		case bytes.Equal(request.Get(RadiusAttrState), []byte("challenged")) &&
+			len(eapMsg) > 5 && bytes.Equal(eapMsg[5:], s.password):
+			response.Code = RadiusCodeAccessAccept
+			response.Add(RadiusAttrEAPMessage, []byte{EAPCodeSuccess, 2, 0, 4})
+			response.Add(RadiusAttrFramedIPAddress, net.ParseIP("10.60.100.1").To4())
+			response.Add(RadiusAttrFramedIPv6Prefix, append([]byte{0, 64}, net.ParseIP("2001:db8:1:2::")[:8]...))
+		default:
+			response.Code = RadiusCodeAccessReject
+			response.Add(RadiusAttrEAPMessage, []byte{EAPCodeFailure, EAPIdentifier(eapMsg), 0, 4})
+		}
+		if err = response.SetMessageAuthenticator([]byte(testSecret)); err != nil {
+			return
+		}
+		rsp, err := response.Marshal()
+		if err != nil {
+			return
+		}
+		auth := ResponseAuthenticator(rsp, request.Authenticator, []byte(testSecret))
+		copy(rsp[4:radiusHeaderLen], auth[:])
+		if _, err = s.conn.WriteTo(rsp, addr); err != nil {
+			return
+		}
+	}
+}
+
+func TestRadiusClient(t *testing.T) {
+	password := []byte("secret-password")
+	server := newRadiusStandIn(t, "user@dn.example", password)
+	defer server.conn.Close()
+
+	client := NewRadiusClient(server.conn.LocalAddr().String(), testSecret, "smf")
+	session := &Session{
+		Supi:     "imsi-208930000000003",
+		Dnn:      "internet",
+		Identity: "user@dn.example",
+	}
+
+	result, err := client.Authenticate(session, NewEAPIdentityResponse(1, session.Identity))
+	require.NoError(t, err)
+	require.Equal(t, ResultContinue, result.Code)
+	require.Equal(t, uint8(2), EAPIdentifier(result.EAPMessage))
+	require.Equal(t, []byte("challenged"), session.State)
+
+	response := append([]byte{EAPCodeResponse, 2, 0, uint8(5 + len(password)), 254}, password...)
+	result, err = client.Authenticate(session, response)
+	require.NoError(t, err)
+	require.Equal(t, ResultSuccess, result.Code)
+	require.Equal(t, EAPCodeSuccess, result.EAPMessage[0])
+	require.Equal(t, "10.60.100.1", result.Authorization.UEIPv4Address.String())
+	require.Equal(t, "2001:db8:1:2::/64", result.Authorization.UEIPv6Prefix.String())
+
+	// wrong password
+	session.State = nil
+	_, err = client.Authenticate(session, NewEAPIdentityResponse(1, session.Identity))
+	require.NoError(t, err)
+	result, err = client.Authenticate(session, []byte{EAPCodeResponse, 2, 0, 6, 254, 0})
+	require.NoError(t, err)
+	require.Equal(t, ResultFailure, result.Code)
+	require.Equal(t, EAPCodeFailure, result.EAPMessage[0])
+
+	// the request signed with another secret is discarded by the server
+	client.Secret = []byte("wrong")
+	client.Timeout /= 10
+	client.Retries = 0
+	_, err = client.Authenticate(session, NewEAPIdentityResponse(1, session.Identity))
+	require.Error(t, err)
+}
+
+func TestRadiusPacketLongEAPMessage(t *testing.T) {
+	eapMsg := bytes.Repeat([]byte{0xab}, 600)
+	p := &RadiusPacket{Code: RadiusCodeAccessRequest, Identifier: 7}
+	p.Add(RadiusAttrEAPMessage, eapMsg)
+	require.Len(t, p.Attributes, 3)
+
+	buf, err := p.Marshal()
+	require.NoError(t, err)
+	decoded, err := UnmarshalRadiusPacket(buf)
+	require.NoError(t, err)
+	require.Equal(t, eapMsg, decoded.Get(RadiusAttrEAPMessage))
+	require.Nil(t, decoded.Get(RadiusAttrState))
+}
+
+func TestFramedIPv6Prefix(t *testing.T) {
+	// the prefix may be sent in fewer octets than 16
+	prefix := framedIPv6Prefix([]byte{0, 56, 0x20, 0x01, 0x0d, 0xb8, 0x00, 0x01, 0x02})
+	require.Equal(t, "2001:db8:1:200::/56", prefix.String())
+	// the bits beyond the prefix length are cleared
+	prefix = framedIPv6Prefix(append([]byte{0, 48}, net.ParseIP("2001:db8:1:2::1")...))
+	require.Equal(t, "2001:db8:1::/48", prefix.String())
+
+	require.Nil(t, framedIPv6Prefix([]byte{0}))
+	require.Nil(t, framedIPv6Prefix([]byte{0, 129}))
+	// the prefix is shorter than the prefix length
+	require.Nil(t, framedIPv6Prefix([]byte{0, 64, 0x20, 0x01}))
+}
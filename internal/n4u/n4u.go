@@ -0,0 +1,123 @@
+// Package n4u implements the N4-u endpoint of SMF (TS 29.244 5.2.3), AN UPF forwards the downlink data
+// to be buffered in SMF over it in GTP-U, and SMF sends the data back to AN UPF when UE is reachable
+package n4u
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"runtime/debug"
+
+	"bitbucket.org/free5gc-team/smf/internal/context"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+)
+
+const GtpuPort = 2152
+
+const (
+	gtpuHeaderLen = 8
+	// version 1 and protocol type GTP
+	gtpuFlags = 0x30
+	// flags of the optional fields: extension header, sequence number and N-PDU number
+	gtpuOptionalFlags = 0x07
+	gtpuExtensionFlag = 0x04
+	msgTypeGPDU       = 0xff
+	maxPacketSize     = 65535
+)
+
+var conn *net.UDPConn
+
+// Run listens on the N4-u address of SMF, the T-PDUs of the G-PDUs received are passed to handle
+// with the TEIDs identifying their PDU sessions
+func Run(handle func(teid uint32, tpdu []byte)) {
+	addr := &net.UDPAddr{IP: context.GetSelf().ListenIP(), Port: GtpuPort}
+	var err error
+	if conn, err = net.ListenUDP("udp", addr); err != nil {
+		logger.PfcpLog.Errorf("Failed to listen N4-u: %v", err)
+		return
+	}
+
+	logger.PfcpLog.Infof("Listen N4-u on %s", conn.LocalAddr().String())
+
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				// Print stack for panic to log. Fatalf() will let program exit.
+				logger.PfcpLog.Fatalf("panic: %v\n%s", p, string(debug.Stack()))
+			}
+		}()
+
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				logger.PfcpLog.Warnf("Read N4-u error: %v", err)
+				continue
+			}
+			teid, tpdu, err := decodeGPDU(buf[:n])
+			if err != nil {
+				logger.PfcpLog.Debugf("Drop N4-u packet: %v", err)
+				continue
+			}
+			// the buffer is reused by the following reads
+			handle(teid, append([]byte(nil), tpdu...))
+		}
+	}()
+}
+
+// Send sends the T-PDU to the GTP-U tunnel of UPF
+func Send(upIP net.IP, teid uint32, tpdu []byte) error {
+	if conn == nil {
+		return errors.New("N4-u is not listened")
+	}
+	_, err := conn.WriteToUDP(encodeGPDU(teid, tpdu), &net.UDPAddr{IP: upIP, Port: GtpuPort})
+	return err
+}
+
+func encodeGPDU(teid uint32, tpdu []byte) []byte {
+	b := make([]byte, gtpuHeaderLen+len(tpdu))
+	b[0] = gtpuFlags
+	b[1] = msgTypeGPDU
+	binary.BigEndian.PutUint16(b[2:], uint16(len(tpdu)))
+	binary.BigEndian.PutUint32(b[4:], teid)
+	copy(b[gtpuHeaderLen:], tpdu)
+	return b
+}
+
+// decodeGPDU returns the TEID and T-PDU of the G-PDU, the optional fields and extension headers are skipped
+func decodeGPDU(b []byte) (uint32, []byte, error) {
+	if len(b) < gtpuHeaderLen || b[0]&0xf0 != gtpuFlags {
+		return 0, nil, errors.New("not GTPv1-U")
+	}
+	if b[1] != msgTypeGPDU {
+		return 0, nil, errors.New("not G-PDU")
+	}
+	teid := binary.BigEndian.Uint32(b[4:])
+	end := gtpuHeaderLen + int(binary.BigEndian.Uint16(b[2:]))
+	if end > len(b) {
+		return 0, nil, errors.New("truncated G-PDU")
+	}
+
+	offset := gtpuHeaderLen
+	if b[0]&gtpuOptionalFlags != 0 {
+		offset += 4
+		if offset > end {
+			return 0, nil, errors.New("truncated G-PDU")
+		}
+		if b[0]&gtpuExtensionFlag != 0 {
+			// each extension header has its length in 4 octets and the next type in the last octet
+			for next := b[offset-1]; next != 0; {
+				if offset >= end {
+					return 0, nil, errors.New("truncated extension header")
+				}
+				l := int(b[offset]) * 4
+				if l == 0 || offset+l > end {
+					return 0, nil, errors.New("invalid extension header")
+				}
+				next = b[offset+l-1]
+				offset += l
+			}
+		}
+	}
+	return teid, b[offset:end], nil
+}
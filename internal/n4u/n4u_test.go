@@ -0,0 +1,33 @@
+package n4u
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeGPDU(t *testing.T) {
+	tpdu := []byte{0x45, 0x00, 0x00, 0x14}
+
+	teid, decoded, err := decodeGPDU(encodeGPDU(100, tpdu))
+	require.NoError(t, err)
+	require.Equal(t, uint32(100), teid)
+	require.Equal(t, tpdu, decoded)
+
+	// G-PDU with PDU Session Container of QFI 9
+	withExt := []byte{
+		0x34, 0xff, 0x00, 0x0c, 0x00, 0x00, 0x00, 0xc8,
+		0x00, 0x00, 0x00, 0x85,
+		0x01, 0x00, 0x09, 0x00,
+	}
+	withExt = append(withExt, tpdu...)
+	teid, decoded, err = decodeGPDU(withExt)
+	require.NoError(t, err)
+	require.Equal(t, uint32(200), teid)
+	require.Equal(t, tpdu, decoded)
+
+	_, _, err = decodeGPDU(withExt[:14])
+	require.Error(t, err)
+	_, _, err = decodeGPDU([]byte{0x30, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	require.Error(t, err)
+}
@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"bitbucket.org/free5gc-team/openapi"
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+)
+
+// HandleN4uDownlinkData buffers the downlink data forwarded by AN UPF to SMF over N4-u,
+// the packet buffered triggers the paging as the Downlink Data Report of UPF does if it isn't sent yet.
+// The paging throttled is triggered again by the following packet after the throttling interval
+func HandleN4uDownlinkData(teid uint32, tpdu []byte) {
+	smContext := smf_context.GetSMContextByDLBufferTEID(teid)
+	if smContext == nil {
+		logger.PfcpLog.Debugf("N4-u TEID[%d] not found", teid)
+		return
+	}
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	// the buffer is released with the PDU session
+	if smContext.DLBuffer == nil || smContext.DLBuffer.TEID != teid {
+		return
+	}
+	page, buffered := smContext.DLBuffer.Push(tpdu)
+	if !buffered {
+		smContext.Log.Debugln("DL buffer is full, drop the downlink data")
+	}
+	if !page || smContext.UpCnxState != models.UpCnxState_DEACTIVATED {
+		return
+	}
+	handling := notifyDownlinkData(smContext, nil)
+	if handling.DropBuffered {
+		smContext.DLBuffer.Drain()
+	} else if !handling.Throttled {
+		smContext.DLBuffer.SetPaged()
+	}
+}
+
+// notifyDownlinkData requests AMF to page UE for the downlink data of the deactivated PDU session.
+// The paging priority is derived by AMF from the PPI and the QoS of the QoS flow of the downlink data.
+// It returns the handling of the buffered downlink data decided on the result of the paging
+func notifyDownlinkData(
+	smContext *smf_context.SMContext,
+	ddsi *pfcpType.DownlinkDataServiceInformation,
+) *smf_context.DLDataHandling {
+	if smContext.ThrottleDDN(time.Now()) {
+		smContext.Log.Infoln("Paging for downlink data is throttled")
+		return &smf_context.DLDataHandling{Throttled: true}
+	}
+
+	var ppi int32
+	var qfi uint8
+	if ddsi != nil {
+		if ddsi.Ppi {
+			ppi = int32(ddsi.PagingPolicyIndicationValue)
+		}
+		if ddsi.Qfii {
+			qfi = ddsi.Qfi
+		}
+	}
+	var5qi, arp := smContext.PagingQoS(qfi)
+
+	n1n2Request := models.N1N2MessageTransferRequest{}
+
+	// TS 23.502 4.2.3.3 3a. Send Namf_Communication_N1N2MessageTransfer Request, SMF->AMF
+	if n2SmBuf, err := smf_context.BuildPDUSessionResourceSetupRequestTransfer(smContext); err != nil {
+		logger.PduSessLog.Errorln("Build PDUSessionResourceSetupRequestTransfer failed:", err)
+	} else {
+		n1n2Request.BinaryDataN2Information = n2SmBuf
+	}
+
+	n1n2Request.JsonData = &models.N1N2MessageTransferReqData{
+		PduSessionId: smContext.PDUSessionID,
+		Ppi:          ppi,
+		Arp:          arp,
+		Var5qi:       var5qi,
+		// TS 23.502 4.2.3.3 5. Namf_Communication_N1N2TransferFailureNotification
+		N1n2FailureTxfNotifURI: fmt.Sprintf("%s://%s:%d/nsmf-callback/sm-contexts/%s/n1n2-failure",
+			smf_context.GetSelf().URIScheme,
+			smf_context.GetSelf().RegisterIPv4,
+			smf_context.GetSelf().SBIPort,
+			smContext.Ref),
+		N2InfoContainer: &models.N2InfoContainer{
+			N2InformationClass: models.N2InformationClass_SM,
+			SmInfo: &models.N2SmInformation{
+				PduSessionId: smContext.PDUSessionID,
+				N2InfoContent: &models.N2InfoContent{
+					NgapIeType: models.NgapIeType_PDU_RES_SETUP_REQ,
+					NgapData: &models.RefToBinaryData{
+						ContentId: "N2SmInformation",
+					},
+				},
+				SNssai: smContext.SNssai,
+			},
+		},
+	}
+
+	rspData, _, err := smContext.CommunicationClient.
+		N1N2MessageCollectionDocumentApi.
+		N1N2MessageTransfer(context.Background(), smContext.Supi, n1n2Request)
+	if err != nil {
+		logger.PfcpLog.Warnf("Send N1N2Transfer failed: %s", err)
+		return n1n2TransferErrorHandling(smContext, err)
+	}
+	switch rspData.Cause {
+	case models.N1N2MessageTransferCause_ATTEMPTING_TO_REACH_UE:
+		// the paging failure is notified to N1n2FailureTxfNotifURI
+		logger.PfcpLog.Infof("Receive %v, AMF is able to page the UE", rspData.Cause)
+	case models.N1N2MessageTransferCause_UE_NOT_RESPONDING:
+		logger.PfcpLog.Warnf("%v", rspData.Cause)
+		return PagingFailed(smContext)
+	}
+	return &smf_context.DLDataHandling{}
+}
+
+// n1n2TransferErrorHandling extends the buffering if AMF gives the maximum waiting time of UE in MICO mode
+// or extended DRX, or drops the buffered downlink data if UE isn't reachable
+func n1n2TransferErrorHandling(smContext *smf_context.SMContext, err error) *smf_context.DLDataHandling {
+	apiErr, ok := err.(openapi.GenericOpenAPIError)
+	if !ok {
+		return &smf_context.DLDataHandling{}
+	}
+	transferErr, ok := apiErr.Model().(models.N1N2MessageTransferError)
+	if !ok {
+		return &smf_context.DLDataHandling{}
+	}
+	if info := transferErr.ErrInfo; info != nil && info.MaxWaitingTime > 0 &&
+		smContext.BufferingPolicy().ExtendedBuffering {
+		waitingTime := time.Duration(info.MaxWaitingTime) * time.Second
+		smContext.Log.Infof("UE is reachable in %s, extend the buffering of downlink data", waitingTime)
+		// UE is paged when it's reachable, so the following downlink data doesn't trigger the paging
+		smContext.SuppressDDN(time.Now().Add(waitingTime))
+		return &smf_context.DLDataHandling{ExtendedBuffering: waitingTime}
+	}
+	if transferErr.Error != nil && transferErr.Error.Cause == "UE_NOT_REACHABLE" {
+		return PagingFailed(smContext)
+	}
+	return &smf_context.DLDataHandling{}
+}
+
+// PagingFailed backs off the pagings for the downlink data as UE isn't reached, and the buffered downlink data
+// is to be dropped (TS 23.502 4.2.3.3 3c)
+func PagingFailed(smContext *smf_context.SMContext) *smf_context.DLDataHandling {
+	if backoff := smContext.BufferingPolicy().DDNFailureBackoff; backoff > 0 {
+		smContext.Log.Infof("UE isn't reached, suppress paging for %s", backoff)
+		smContext.SuppressDDN(time.Now().Add(backoff))
+	}
+	return &smf_context.DLDataHandling{DropBuffered: true}
+}
@@ -1,8 +1,8 @@
 package handler
 
 import (
-	"context"
-	"fmt"
+	"net"
+	"time"
 
 	"bitbucket.org/free5gc-team/openapi/models"
 	"bitbucket.org/free5gc-team/pfcp"
@@ -40,6 +40,7 @@ func HandlePfcpAssociationSetupRequest(msg *pfcpUdp.Message) {
 	}
 
 	upf.UPIPInfo = *req.UserPlaneIPResourceInformation
+	upf.SetFunctionFeatures(req.UPFunctionFeatures)
 
 	// Response with PFCP Association Setup Response
 	cause := pfcpType.Cause{
@@ -48,8 +49,92 @@ func HandlePfcpAssociationSetupRequest(msg *pfcpUdp.Message) {
 	pfcp_message.SendPfcpAssociationSetupResponse(msg.RemoteAddr, cause)
 }
 
+// HandlePfcpAssociationUpdateRequest updates the UP function features and user plane IP resources of UPF,
+// or starts to release the association gracefully when UPF requests it (TS 29.244 6.2.7)
 func HandlePfcpAssociationUpdateRequest(msg *pfcpUdp.Message) {
-	logger.PfcpLog.Warnf("PFCP Association Update Request handling is not implemented")
+	req := msg.PfcpMessage.Body.(pfcp.PFCPAssociationUpdateRequest)
+	seqFromUPF := msg.PfcpMessage.Header.SequenceNumber
+
+	var cause pfcpType.Cause
+	nodeID := req.NodeID
+	if nodeID == nil {
+		logger.PfcpLog.Errorln("PFCP Association Update Request needs NodeID")
+		cause.CauseValue = pfcpType.CauseMandatoryIeMissing
+		pfcp_message.SendPfcpAssociationUpdateResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+	upfStr := nodeID.ResolveNodeIdToIp().String()
+	logger.PfcpLog.Infof("Handle PFCP Association Update Request with NodeID[%s]", upfStr)
+
+	upf := smf_context.RetrieveUPFNodeByNodeID(*nodeID)
+	if upf == nil || upf.UPFStatus != smf_context.AssociatedSetUpSuccess {
+		logger.PfcpLog.Warnf("PFCP Association Update Request : Not Associated with UPF[%s], Request Rejected",
+			upfStr)
+		cause.CauseValue = pfcpType.CauseNoEstablishedPfcpAssociation
+		pfcp_message.SendPfcpAssociationUpdateResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+
+	if req.UPFunctionFeatures != nil {
+		upf.SetFunctionFeatures(req.UPFunctionFeatures)
+	}
+	if req.UserPlaneIPResourceInformation != nil {
+		upf.UPIPInfo = *req.UserPlaneIPResourceInformation
+	}
+
+	cause.CauseValue = pfcpType.CauseRequestAccepted
+	pfcp_message.SendPfcpAssociationUpdateResponse(msg.RemoteAddr, cause, seqFromUPF)
+
+	if req.PFCPAssociationReleaseRequest == nil || !req.PFCPAssociationReleaseRequest.Sarr || upf.Releasing {
+		return
+	}
+	period, infinite := gracefulReleasePeriod(req.GracefulReleasePeriod)
+	if infinite {
+		logger.PfcpLog.Infof("UPF[%s] requests to release PFCP association with infinite graceful release period, "+
+			"keep the association", upfStr)
+		return
+	}
+	logger.PfcpLog.Infof("UPF[%s] requests to release PFCP association in %s", upfStr, period)
+	startReleasingUPF(upf, period)
+}
+
+// gracefulReleasePeriod converts the Graceful Release Period IE which is encoded as GPRS Timer (TS 29.244 8.2.78),
+// infinite is set if the timer is deactivated, and the association is never released by SMF
+func gracefulReleasePeriod(period *pfcpType.GracefulReleasePeriod) (d time.Duration, infinite bool) {
+	if period == nil {
+		return 0, false
+	}
+	value := time.Duration(period.GracefulReleasePeriodTimerValue)
+	switch period.GracefulReleasePeriodTimerUnit {
+	case 0:
+		return value * 2 * time.Second, false
+	case 2:
+		return value * 10 * time.Minute, false
+	case 3:
+		return value * time.Hour, false
+	case 4:
+		return value * 10 * time.Hour, false
+	case 7:
+		// timer deactivated
+		return 0, true
+	default:
+		// other values are interpreted as multiples of 1 minute
+		return value * time.Minute, false
+	}
+}
+
+// startReleasingUPF stops selecting UPF for new PDU sessions and drains its PDU sessions
+func startReleasingUPF(upf *smf_context.UPF, gracefulReleasePeriod time.Duration) {
+	upi := smf_context.GetUserPlaneInformation()
+	upi.Mu.Lock()
+	upf.Releasing = true
+	// the default paths are generated again without UPF
+	upi.InvalidateDefaultPaths()
+	upi.Mu.Unlock()
+
+	if h := smf_context.GetProcedureHandlers().UPFRelease; h != nil {
+		go h(upf, gracefulReleasePeriod)
+	}
 }
 
 func HandlePfcpAssociationReleaseRequest(msg *pfcpUdp.Message) {
@@ -58,26 +143,182 @@ func HandlePfcpAssociationReleaseRequest(msg *pfcpUdp.Message) {
 	var cause pfcpType.Cause
 	upf := smf_context.RetrieveUPFNodeByNodeID(*pfcpMsg.NodeID)
 
-	if upf != nil {
-		smf_context.RemoveUPFNodeByNodeID(*pfcpMsg.NodeID)
+	if upf != nil && upf.UPFStatus == smf_context.AssociatedSetUpSuccess {
 		cause.CauseValue = pfcpType.CauseRequestAccepted
 	} else {
 		cause.CauseValue = pfcpType.CauseNoEstablishedPfcpAssociation
 	}
 
-	pfcp_message.SendPfcpAssociationReleaseResponse(msg.RemoteAddr, cause)
+	pfcp_message.SendPfcpAssociationReleaseResponse(msg.RemoteAddr, cause, msg.PfcpMessage.Header.SequenceNumber)
+	if cause.CauseValue != pfcpType.CauseRequestAccepted {
+		return
+	}
+
+	// UPF has released its PFCP sessions, the PDU sessions are rerouted or released without graceful period
+	logger.PfcpLog.Infof("UPF[%s] released PFCP association", pfcpMsg.NodeID.ResolveNodeIdToIp().String())
+	upf.UPFStatus = smf_context.NotAssociated
+	startReleasingUPF(upf, 0)
 }
 
 func HandlePfcpNodeReportRequest(msg *pfcpUdp.Message) {
-	logger.PfcpLog.Warnf("PFCP Node Report Request handling is not implemented")
+	req := msg.PfcpMessage.Body.(pfcp.PFCPNodeReportRequest)
+	seqFromUPF := msg.PfcpMessage.Header.SequenceNumber
+
+	var cause pfcpType.Cause
+	nodeID := req.NodeID
+	if nodeID == nil {
+		logger.PfcpLog.Errorln("PFCP Node Report Request needs NodeID")
+		cause.CauseValue = pfcpType.CauseMandatoryIeMissing
+		pfcp_message.SendPfcpNodeReportResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+	upfStr := nodeID.ResolveNodeIdToIp().String()
+	logger.PfcpLog.Infof("Handle PFCP Node Report Request with NodeID[%s]", upfStr)
+
+	upf := smf_context.RetrieveUPFNodeByNodeID(*nodeID)
+	if upf == nil || upf.UPFStatus != smf_context.AssociatedSetUpSuccess {
+		logger.PfcpLog.Warnf("PFCP Node Report Request : Not Associated with UPF[%s], Request Rejected", upfStr)
+		cause.CauseValue = pfcpType.CauseNoEstablishedPfcpAssociation
+		pfcp_message.SendPfcpNodeReportResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+	if req.NodeReportType == nil {
+		logger.PfcpLog.Errorln("PFCP Node Report Request needs NodeReportType")
+		cause.CauseValue = pfcpType.CauseMandatoryIeMissing
+		pfcp_message.SendPfcpNodeReportResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+
+	var failedPeers, recoveredPeers []net.IP
+	if req.NodeReportType.Upfr && req.UserPlanePathFailureReport != nil {
+		failedPeers = remoteGTPUPeerIPs(req.UserPlanePathFailureReport.RemoteGTPUPeer)
+	}
+	if req.NodeReportType.Uprr && req.UserPlanePathRecoveryReport != nil {
+		recoveredPeers = remoteGTPUPeerIPs(req.UserPlanePathRecoveryReport.RemoteGTPUPeer)
+	}
+
+	// The response is sent before the PDU sessions are handled,
+	// which needs PFCP and SBI procedures with other NFs
+	cause.CauseValue = pfcpType.CauseRequestAccepted
+	pfcp_message.SendPfcpNodeReportResponse(msg.RemoteAddr, cause, seqFromUPF)
+
+	var newlyFailedPeers []net.IP
+	changed := false
+	for _, peer := range failedPeers {
+		if upf.SetGTPUPeerStatus(peer, true) {
+			logger.PfcpLog.Warnf("User plane path from UPF[%s] to GTP-U peer[%s] failed", upfStr, peer)
+			newlyFailedPeers = append(newlyFailedPeers, peer)
+			changed = true
+		}
+	}
+	for _, peer := range recoveredPeers {
+		if upf.SetGTPUPeerStatus(peer, false) {
+			logger.PfcpLog.Infof("User plane path from UPF[%s] to GTP-U peer[%s] recovered", upfStr, peer)
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	// the default paths are generated again without the failed user plane paths
+	upi := smf_context.GetUserPlaneInformation()
+	upi.Mu.Lock()
+	upi.InvalidateDefaultPaths()
+	upi.Mu.Unlock()
+
+	h := smf_context.GetProcedureHandlers().UserPlanePathFailure
+	if h == nil {
+		return
+	}
+	for _, peer := range newlyFailedPeers {
+		go h(upf, peer)
+	}
+}
+
+func remoteGTPUPeerIPs(remoteGTPUPeer *pfcpType.RemoteGTPUPeer) []net.IP {
+	var ips []net.IP
+	if remoteGTPUPeer == nil {
+		return ips
+	}
+	if remoteGTPUPeer.V4 {
+		ips = append(ips, remoteGTPUPeer.Ipv4Address)
+	}
+	if remoteGTPUPeer.V6 {
+		ips = append(ips, remoteGTPUPeer.Ipv6Address)
+	}
+	return ips
 }
 
+// HandlePfcpSessionSetDeletionRequest handles the partial failure of UPF,
+// the PFCP sessions in the sets of the FQ-CSIDs of UPF are deleted (TS 29.244 6.2.9)
 func HandlePfcpSessionSetDeletionRequest(msg *pfcpUdp.Message) {
-	logger.PfcpLog.Warnf("PFCP Session Set Deletion Request handling is not implemented")
+	req := msg.PfcpMessage.Body.(pfcp.PFCPSessionSetDeletionRequest)
+	seqFromUPF := msg.PfcpMessage.Header.SequenceNumber
+
+	var cause pfcpType.Cause
+	nodeID := req.NodeID
+	if nodeID == nil {
+		logger.PfcpLog.Errorln("PFCP Session Set Deletion Request needs NodeID")
+		cause.CauseValue = pfcpType.CauseMandatoryIeMissing
+		pfcp_message.SendPfcpSessionSetDeletionResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+	upfStr := nodeID.ResolveNodeIdToIp().String()
+	logger.PfcpLog.Infof("Handle PFCP Session Set Deletion Request with NodeID[%s]", upfStr)
+
+	upf := smf_context.RetrieveUPFNodeByNodeID(*nodeID)
+	if upf == nil || upf.UPFStatus != smf_context.AssociatedSetUpSuccess {
+		logger.PfcpLog.Warnf("PFCP Session Set Deletion Request : Not Associated with UPF[%s], Request Rejected",
+			upfStr)
+		cause.CauseValue = pfcpType.CauseNoEstablishedPfcpAssociation
+		pfcp_message.SendPfcpSessionSetDeletionResponse(msg.RemoteAddr, cause, seqFromUPF)
+		return
+	}
+
+	var fqcsids []*smf_context.FQCSID
+	for _, fqcsid := range []*pfcpType.FQCSID{req.PGWUFQCSID, req.SGWUFQCSID} {
+		if f := smf_context.FQCSIDFromPFCP(fqcsid); f != nil {
+			fqcsids = append(fqcsids, f)
+		}
+	}
+
+	// UPF has deleted the PFCP sessions, the PDU sessions are released after the response
+	cause.CauseValue = pfcpType.CauseRequestAccepted
+	pfcp_message.SendPfcpSessionSetDeletionResponse(msg.RemoteAddr, cause, seqFromUPF)
+
+	smContexts := smf_context.SMContextsOfFQCSIDs(upf, fqcsids)
+	logger.PfcpLog.Infof("%d PDU sessions are deleted by UPF[%s]", len(smContexts), upfStr)
+	if h := smf_context.GetProcedureHandlers().SessionSetDeletion; h != nil && len(smContexts) > 0 {
+		go h(upf, smContexts)
+	}
 }
 
+// HandlePfcpSessionSetDeletionResponse checks the result of the PFCP Session Set Deletion of SMF
 func HandlePfcpSessionSetDeletionResponse(msg *pfcpUdp.Message) {
-	logger.PfcpLog.Warnf("PFCP Session Set Deletion Response handling is not implemented")
+	rsp := msg.PfcpMessage.Body.(pfcp.PFCPSessionSetDeletionResponse)
+
+	upfStr := msg.RemoteAddr.IP.String()
+	if rsp.NodeID != nil {
+		upfStr = rsp.NodeID.ResolveNodeIdToIp().String()
+	}
+	if rsp.Cause == nil || rsp.Cause.CauseValue != pfcpType.CauseRequestAccepted {
+		logger.PfcpLog.Warnf("Received PFCP Session Set Deletion Not Accepted Response from UPF[%s]", upfStr)
+		return
+	}
+	logger.PfcpLog.Infof("Received PFCP Session Set Deletion Accepted Response from UPF[%s]", upfStr)
+}
+
+// DispatchUsageReports reports the usage recorded in the SM context to CHF and PCF when it reaches
+// the quotas or the thresholds, the SM context is locked by the caller
+func DispatchUsageReports(smContext *smf_context.SMContext) {
+	handlers := smf_context.GetProcedureHandlers()
+	if smContext.ChargingReportPending() && handlers.ChargingReport != nil {
+		go handlers.ChargingReport(smContext)
+	}
+	if smContext.UsageMonitoringReportPending() && handlers.UsageMonitoringReport != nil {
+		go handlers.UsageMonitoringReport(smContext)
+	}
 }
 
 func HandlePfcpSessionReportRequest(msg *pfcpUdp.Message) {
@@ -132,70 +373,28 @@ func HandlePfcpSessionReportRequest(msg *pfcpUdp.Message) {
 		return
 	}
 
+	var handling *smf_context.DLDataHandling
 	if smContext.UpCnxState == models.UpCnxState_DEACTIVATED {
 		if req.ReportType.Dldr {
-			downlinkDataReport := req.DownlinkDataReport
-
-			if downlinkDataReport.DownlinkDataServiceInformation != nil {
-				logger.PfcpLog.Warnf(
-					"PFCP Session Report Request DownlinkDataServiceInformation handling is not implemented")
+			var ddsi *pfcpType.DownlinkDataServiceInformation
+			if req.DownlinkDataReport != nil {
+				ddsi = req.DownlinkDataReport.DownlinkDataServiceInformation
 			}
-
-			n1n2Request := models.N1N2MessageTransferRequest{}
-
-			// TS 23.502 4.2.3.3 3a. Send Namf_Communication_N1N2MessageTransfer Request, SMF->AMF
-			if n2SmBuf, err := smf_context.BuildPDUSessionResourceSetupRequestTransfer(smContext); err != nil {
-				logger.PduSessLog.Errorln("Build PDUSessionResourceSetupRequestTransfer failed:", err)
-			} else {
-				n1n2Request.BinaryDataN2Information = n2SmBuf
-			}
-
-			n1n2Request.JsonData = &models.N1N2MessageTransferReqData{
-				PduSessionId: smContext.PDUSessionID,
-				// Temporarily assign SMF itself,
-				// TODO: TS 23.502 4.2.3.3 5. Namf_Communication_N1N2TransferFailureNotification
-				N1n2FailureTxfNotifURI: fmt.Sprintf("%s://%s:%d",
-					smf_context.GetSelf().URIScheme,
-					smf_context.GetSelf().RegisterIPv4,
-					smf_context.GetSelf().SBIPort),
-				N2InfoContainer: &models.N2InfoContainer{
-					N2InformationClass: models.N2InformationClass_SM,
-					SmInfo: &models.N2SmInformation{
-						PduSessionId: smContext.PDUSessionID,
-						N2InfoContent: &models.N2InfoContent{
-							NgapIeType: models.NgapIeType_PDU_RES_SETUP_REQ,
-							NgapData: &models.RefToBinaryData{
-								ContentId: "N2SmInformation",
-							},
-						},
-						SNssai: smContext.SNssai,
-					},
-				},
-			}
-
-			rspData, _, err := smContext.CommunicationClient.
-				N1N2MessageCollectionDocumentApi.
-				N1N2MessageTransfer(context.Background(), smContext.Supi, n1n2Request)
-			if err != nil {
-				logger.PfcpLog.Warnf("Send N1N2Transfer failed: %s", err)
-			}
-			if rspData.Cause == models.N1N2MessageTransferCause_ATTEMPTING_TO_REACH_UE {
-				logger.PfcpLog.Infof("Receive %v, AMF is able to page the UE", rspData.Cause)
-			}
-			if rspData.Cause == models.N1N2MessageTransferCause_UE_NOT_RESPONDING {
-				logger.PfcpLog.Warnf("%v", rspData.Cause)
-				// TODO: TS 23.502 4.2.3.3 3c. Failure indication
+			handling = notifyDownlinkData(smContext, ddsi)
+			if handling.ExtendedBuffering > 0 {
+				handling.BAR = smContext.DLBAR(upf)
 			}
 		}
 	}
 
 	if req.ReportType.Usar && req.UsageReport != nil {
 		HandleReports(req.UsageReport, nil, nil, smContext, upfNodeID)
+		DispatchUsageReports(smContext)
 	}
 
 	// TS 23.502 4.2.3.3 2b. Send Data Notification Ack, SMF->UPF
 	cause.CauseValue = pfcpType.CauseRequestAccepted
-	pfcp_message.SendPfcpSessionReportResponse(msg.RemoteAddr, cause, seqFromUPF, remoteSEID)
+	pfcp_message.SendPfcpSessionReportResponseForDLData(msg.RemoteAddr, cause, seqFromUPF, remoteSEID, handling)
 }
 
 func HandleReports(
@@ -205,39 +404,65 @@ func HandleReports(
 	smContext *smf_context.SMContext,
 	nodeId pfcpType.NodeID,
 ) {
-	var usageReport smf_context.UsageReport
-
 	for _, report := range UsageReportReport {
-		usageReport.UrrId = report.URRID.UrrIdValue
-		usageReport.TotalVolume = report.VolumeMeasurement.TotalVolume
-		usageReport.UplinkVolume = report.VolumeMeasurement.UplinkVolume
-		usageReport.DownlinkVolume = report.VolumeMeasurement.DownlinkVolume
-		usageReport.TotalPktNum = report.VolumeMeasurement.TotalPktNum
-		usageReport.UplinkPktNum = report.VolumeMeasurement.UplinkPktNum
-		usageReport.DownlinkPktNum = report.VolumeMeasurement.DownlinkPktNum
-
-		smContext.UrrReports = append(smContext.UrrReports, usageReport)
+		if ethInfo := report.EthernetTrafficInformation; ethInfo != nil {
+			handleEthernetTrafficInformation(ethInfo, smContext)
+		}
+		addUsageReport(smContext, report.URRID, report.VolumeMeasurement, report.DurationMeasurement,
+			report.UsageReportTrigger)
 	}
 	for _, report := range UsageReportModification {
-		usageReport.UrrId = report.URRID.UrrIdValue
-		usageReport.TotalVolume = report.VolumeMeasurement.TotalVolume
-		usageReport.UplinkVolume = report.VolumeMeasurement.UplinkVolume
-		usageReport.DownlinkVolume = report.VolumeMeasurement.DownlinkVolume
-		usageReport.TotalPktNum = report.VolumeMeasurement.TotalPktNum
-		usageReport.UplinkPktNum = report.VolumeMeasurement.UplinkPktNum
-		usageReport.DownlinkPktNum = report.VolumeMeasurement.DownlinkPktNum
-
-		smContext.UrrReports = append(smContext.UrrReports, usageReport)
+		addUsageReport(smContext, report.URRID, report.VolumeMeasurement, report.DurationMeasurement,
+			report.UsageReportTrigger)
 	}
 	for _, report := range UsageReportDeletion {
-		usageReport.UrrId = report.URRID.UrrIdValue
-		usageReport.TotalVolume = report.VolumeMeasurement.TotalVolume
-		usageReport.UplinkVolume = report.VolumeMeasurement.UplinkVolume
-		usageReport.DownlinkVolume = report.VolumeMeasurement.DownlinkVolume
-		usageReport.TotalPktNum = report.VolumeMeasurement.TotalPktNum
-		usageReport.UplinkPktNum = report.VolumeMeasurement.UplinkPktNum
-		usageReport.DownlinkPktNum = report.VolumeMeasurement.DownlinkPktNum
+		addUsageReport(smContext, report.URRID, report.VolumeMeasurement, report.DurationMeasurement,
+			report.UsageReportTrigger)
+	}
+}
 
-		smContext.UrrReports = append(smContext.UrrReports, usageReport)
+// addUsageReport records the volume and duration measured by the URR
+func addUsageReport(
+	smContext *smf_context.SMContext,
+	urrID *pfcpType.URRID,
+	volume *pfcpType.VolumeMeasurement,
+	duration *pfcpType.DurationMeasurement,
+	trigger *pfcpType.UsageReportTrigger,
+) {
+	if urrID == nil || (volume == nil && duration == nil) {
+		// e.g. the URR for MAC address reporting
+		return
+	}
+	usageReport := smf_context.UsageReport{
+		UrrId:      urrID.UrrIdValue,
+		ReportTpye: smf_context.ChargingTriggerType(trigger),
+	}
+	if volume != nil {
+		usageReport.TotalVolume = volume.TotalVolume
+		usageReport.UplinkVolume = volume.UplinkVolume
+		usageReport.DownlinkVolume = volume.DownlinkVolume
+		usageReport.TotalPktNum = volume.TotalPktNum
+		usageReport.UplinkPktNum = volume.UplinkPktNum
+		usageReport.DownlinkPktNum = volume.DownlinkPktNum
+	}
+	if duration != nil {
+		usageReport.Duration = time.Duration(duration.DurationValue) * time.Second
+	}
+	smContext.AddUsageReport(usageReport)
+}
+
+// handleEthernetTrafficInformation updates the MAC addresses detected or removed by UPF in Ethernet PDU session
+func handleEthernetTrafficInformation(ethInfo *pfcp.EthernetTrafficInformation, smContext *smf_context.SMContext) {
+	var detected, removed []net.HardwareAddr
+	if ethInfo.MACAddressesDetected != nil {
+		for _, mac := range ethInfo.MACAddressesDetected.MACAddressValue {
+			detected = append(detected, net.HardwareAddr(mac))
+		}
+	}
+	if ethInfo.MACAddressesRemoved != nil {
+		for _, mac := range ethInfo.MACAddressesRemoved.MACAddressValue {
+			removed = append(removed, net.HardwareAddr(mac))
+		}
 	}
+	smContext.UpdateUeMacAddresses(detected, removed)
 }
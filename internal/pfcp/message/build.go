@@ -19,9 +19,7 @@ func BuildPfcpAssociationSetupRequest() (pfcp.PFCPAssociationSetupRequest, error
 		RecoveryTimeStamp: udp.ServerStartTime,
 	}
 
-	msg.CPFunctionFeatures = &pfcpType.CPFunctionFeatures{
-		SupportedFeatures: 0,
-	}
+	msg.CPFunctionFeatures = context.GetSelf().CPFunctionFeatures()
 
 	return msg, nil
 }
@@ -37,9 +35,19 @@ func BuildPfcpAssociationSetupResponse(cause pfcpType.Cause) (pfcp.PFCPAssociati
 		RecoveryTimeStamp: udp.ServerStartTime,
 	}
 
-	msg.CPFunctionFeatures = &pfcpType.CPFunctionFeatures{
-		SupportedFeatures: 0,
-	}
+	msg.CPFunctionFeatures = context.GetSelf().CPFunctionFeatures()
+
+	return msg, nil
+}
+
+func BuildPfcpAssociationUpdateResponse(cause pfcpType.Cause) (pfcp.PFCPAssociationUpdateResponse, error) {
+	msg := pfcp.PFCPAssociationUpdateResponse{}
+
+	msg.NodeID = &context.GetSelf().CPNodeID
+
+	msg.Cause = &cause
+
+	msg.CPFunctionFeatures = context.GetSelf().CPFunctionFeatures()
 
 	return msg, nil
 }
@@ -62,6 +70,37 @@ func BuildPfcpAssociationReleaseResponse(cause pfcpType.Cause) (pfcp.PFCPAssocia
 	return msg, nil
 }
 
+func BuildPfcpNodeReportResponse(cause pfcpType.Cause) (pfcp.PFCPNodeReportResponse, error) {
+	msg := pfcp.PFCPNodeReportResponse{}
+
+	msg.NodeID = &context.GetSelf().CPNodeID
+
+	msg.Cause = &cause
+
+	return msg, nil
+}
+
+// BuildPfcpSessionSetDeletionRequest deletes the PFCP sessions in the sets of the FQ-CSID of SMF (TS 29.244 6.2.9)
+func BuildPfcpSessionSetDeletionRequest(fqcsid *context.FQCSID) (pfcp.PFCPSessionSetDeletionRequest, error) {
+	msg := pfcp.PFCPSessionSetDeletionRequest{}
+
+	msg.NodeID = &context.GetSelf().CPNodeID
+
+	msg.PGWCFQCSID = fqcsid.ToPFCP()
+
+	return msg, nil
+}
+
+func BuildPfcpSessionSetDeletionResponse(cause pfcpType.Cause) (pfcp.PFCPSessionSetDeletionResponse, error) {
+	msg := pfcp.PFCPSessionSetDeletionResponse{}
+
+	msg.NodeID = &context.GetSelf().CPNodeID
+
+	msg.Cause = &cause
+
+	return msg, nil
+}
+
 func pdrToCreatePDR(pdr *context.PDR) *pfcp.CreatePDR {
 	createPDR := new(pfcp.CreatePDR)
 
@@ -88,6 +127,16 @@ func pdrToCreatePDR(pdr *context.PDR) *pfcp.CreatePDR {
 		createPDR.PDI.SDFFilter = pdr.PDI.SDFFilter
 	}
 
+	if pdr.PDI.EthernetPDUSessionInformation {
+		createPDR.PDI.EthernetPDUSessionInformation = &pfcpType.EthernetPDUSessionInformation{
+			Ethi: true,
+		}
+	}
+
+	if pdr.PDI.EthernetPacketFilter != nil {
+		createPDR.PDI.EthernetPacketFilter = ethernetPacketFilterToPFCP(pdr.PDI.EthernetPacketFilter)
+	}
+
 	createPDR.OuterHeaderRemoval = pdr.OuterHeaderRemoval
 
 	createPDR.FARID = &pfcpType.FARID{
@@ -113,6 +162,50 @@ func pdrToCreatePDR(pdr *context.PDR) *pfcp.CreatePDR {
 	return createPDR
 }
 
+// Ethernet Packet Filter. 7.5.2.2-3
+func ethernetPacketFilterToPFCP(filter *context.EthernetPacketFilter) *pfcp.EthernetPacketFilter {
+	ethFilter := new(pfcp.EthernetPacketFilter)
+
+	if filter.SourceMAC != nil || filter.DestinationMAC != nil {
+		ethFilter.MACAddress = &pfcpType.MACAddress{
+			Sour:                  filter.SourceMAC != nil,
+			Dest:                  filter.DestinationMAC != nil,
+			SourceMACAddress:      filter.SourceMAC,
+			DestinationMACAddress: filter.DestinationMAC,
+		}
+	}
+
+	if filter.EtherType != 0 {
+		ethFilter.Ethertype = &pfcpType.Ethertype{
+			Ethertype: filter.EtherType,
+		}
+	}
+
+	if tag := filter.CTag; tag != nil {
+		ethFilter.CTAG = &pfcpType.CTAG{
+			PcpFlag:  true,
+			DeiFlag:  true,
+			VidFlag:  true,
+			PcpValue: tag.PCP,
+			DeiValue: tag.DEI,
+			CVid:     tag.VID,
+		}
+	}
+
+	if tag := filter.STag; tag != nil {
+		ethFilter.STAG = &pfcpType.STAG{
+			PcpFlag:  true,
+			DeiFlag:  true,
+			VidFlag:  true,
+			PcpValue: tag.PCP,
+			DeiValue: tag.DEI,
+			SVid:     tag.VID,
+		}
+	}
+
+	return ethFilter
+}
+
 func farToCreateFAR(far *context.FAR) *pfcp.CreateFAR {
 	createFAR := new(pfcp.CreateFAR)
 
@@ -143,6 +236,7 @@ func farToCreateFAR(far *context.FAR) *pfcp.CreateFAR {
 			NetworkInstance:      far.ForwardingParameters.NetworkInstance,
 			OuterHeaderCreation:  far.ForwardingParameters.OuterHeaderCreation,
 		}
+		createFAR.ForwardingParameters.RedirectInformation = far.ForwardingParameters.RedirectInformation
 		if far.ForwardingParameters.ForwardingPolicyID != "" {
 			createFAR.ForwardingParameters.ForwardingPolicy = &pfcpType.ForwardingPolicy{
 				ForwardingPolicyIdentifierLength: uint8(len(far.ForwardingParameters.ForwardingPolicyID)),
@@ -160,9 +254,19 @@ func barToCreateBAR(bar *context.BAR) *pfcp.CreateBAR {
 	createBAR.BARID = new(pfcpType.BARID)
 	createBAR.BARID.BarIdValue = bar.BARID
 
-	createBAR.DownlinkDataNotificationDelay = new(pfcpType.DownlinkDataNotificationDelay)
+	// the delay is set only if UPF supports DDND
+	if bar.DownlinkDataNotificationDelay.DelayValue != 0 {
+		createBAR.DownlinkDataNotificationDelay = &pfcpType.DownlinkDataNotificationDelay{
+			DelayValue: bar.DownlinkDataNotificationDelay.DelayValue,
+		}
+	}
 
-	// createBAR.SuggestedBufferingPacketsCount = new(pfcpType.SuggestedBufferingPacketsCount)
+	// the count is set only if UPF supports UDBC
+	if bar.SuggestedBufferingPacketsCount.PacketCountValue != 0 {
+		createBAR.SuggestedBufferingPacketsCount = &pfcpType.SuggestedBufferingPacketsCount{
+			PacketCountValue: bar.SuggestedBufferingPacketsCount.PacketCountValue,
+		}
+	}
 
 	return createBAR
 }
@@ -181,38 +285,135 @@ func qerToCreateQER(qer *context.QER) *pfcp.CreateQER {
 	return createQER
 }
 
+func qerToUpdateQER(qer *context.QER) *pfcp.UpdateQER {
+	return &pfcp.UpdateQER{
+		QERID: &pfcpType.QERID{
+			QERID: qer.QERID,
+		},
+		GateStatus:        qer.GateStatus,
+		QoSFlowIdentifier: &qer.QFI,
+		MaximumBitrate:    qer.MBR,
+		GuaranteedBitrate: qer.GBR,
+	}
+}
+
 func urrToCreateURR(urr *context.URR) *pfcp.CreateURR {
 	createURR := new(pfcp.CreateURR)
 
 	createURR.URRID = &pfcpType.URRID{
 		UrrIdValue: urr.URRID,
 	}
-	createURR.MeasurementMethod = &pfcpType.MeasurementMethod{}
-	switch urr.MeasureMethod {
-	case context.MesureMethodVol:
-		createURR.MeasurementMethod.Volum = true
-	case context.MesureMethodTime:
-		createURR.MeasurementMethod.Durat = true
-	}
+	createURR.MeasurementMethod = measurementMethod(urr)
 	createURR.ReportingTriggers = &urr.ReportingTrigger
 	if urr.MeasurementPeriod != 0 {
 		createURR.MeasurementPeriod = &pfcpType.MeasurementPeriod{
 			MeasurementPeriod: uint32(urr.MeasurementPeriod / time.Second),
 		}
 	}
-	if urr.VolumeThreshold != 0 {
-		createURR.VolumeThreshold = &pfcpType.VolumeThreshold{
-			Dlvol:          true,
-			Ulvol:          true,
-			DownlinkVolume: urr.VolumeThreshold,
-			UplinkVolume:   urr.VolumeThreshold,
-		}
-	}
+	createURR.VolumeThreshold = volumeThreshold(urr)
+	createURR.VolumeQuota = volumeQuota(urr)
+	createURR.TimeThreshold = timeThreshold(urr)
+	createURR.TimeQuota = timeQuota(urr)
+	createURR.QuotaValidityTime = quotaValidityTime(urr)
 	createURR.MeasurementInformation = &urr.MeasurementInformation
 
 	return createURR
 }
 
+// urrToUpdateURR updates the quota and the thresholds of the URR granted by CHF
+func urrToUpdateURR(urr *context.URR) *pfcp.UpdateURR {
+	return &pfcp.UpdateURR{
+		URRID: &pfcpType.URRID{
+			UrrIdValue: urr.URRID,
+		},
+		MeasurementMethod: measurementMethod(urr),
+		ReportingTriggers: &urr.ReportingTrigger,
+		VolumeThreshold:   volumeThreshold(urr),
+		VolumeQuota:       volumeQuota(urr),
+		TimeThreshold:     timeThreshold(urr),
+		TimeQuota:         timeQuota(urr),
+		QuotaValidityTime: quotaValidityTime(urr),
+	}
+}
+
+// measurementMethod measures the duration as well if the time quota or threshold is set
+func measurementMethod(urr *context.URR) *pfcpType.MeasurementMethod {
+	method := &pfcpType.MeasurementMethod{}
+	switch urr.MeasureMethod {
+	case context.MesureMethodVol:
+		method.Volum = true
+	case context.MesureMethodTime:
+		method.Durat = true
+	case context.MesureMethodVolTime:
+		method.Volum = true
+		method.Durat = true
+	}
+	if urr.TimeQuota != 0 || urr.TimeThreshold != 0 {
+		method.Durat = true
+	}
+	return method
+}
+
+// volumeThreshold is the total volume for the quota granted by CHF, the volumes monitored for PCF,
+// and the uplink and downlink volumes otherwise
+func volumeThreshold(urr *context.URR) *pfcpType.VolumeThreshold {
+	if urr.VolumeThresholds != nil {
+		return urr.VolumeThresholds
+	}
+	if urr.VolumeThreshold == 0 {
+		return nil
+	}
+	if urr.VolumeQuota != 0 {
+		return &pfcpType.VolumeThreshold{
+			Tovol:       true,
+			TotalVolume: urr.VolumeThreshold,
+		}
+	}
+	return &pfcpType.VolumeThreshold{
+		Dlvol:          true,
+		Ulvol:          true,
+		DownlinkVolume: urr.VolumeThreshold,
+		UplinkVolume:   urr.VolumeThreshold,
+	}
+}
+
+func volumeQuota(urr *context.URR) *pfcpType.VolumeQuota {
+	if urr.VolumeQuota == 0 {
+		return nil
+	}
+	return &pfcpType.VolumeQuota{
+		Tovol:       true,
+		TotalVolume: urr.VolumeQuota,
+	}
+}
+
+func timeThreshold(urr *context.URR) *pfcpType.TimeThreshold {
+	if urr.TimeThreshold == 0 {
+		return nil
+	}
+	return &pfcpType.TimeThreshold{
+		TimeThreshold: uint32(urr.TimeThreshold / time.Second),
+	}
+}
+
+func timeQuota(urr *context.URR) *pfcpType.TimeQuota {
+	if urr.TimeQuota == 0 {
+		return nil
+	}
+	return &pfcpType.TimeQuota{
+		TimeQuotaValue: uint32(urr.TimeQuota / time.Second),
+	}
+}
+
+func quotaValidityTime(urr *context.URR) *pfcpType.QuotaValidityTime {
+	if urr.QuotaValidityTime == 0 {
+		return nil
+	}
+	return &pfcpType.QuotaValidityTime{
+		QuotaValidityTime: uint32(urr.QuotaValidityTime / time.Second),
+	}
+}
+
 func pdrToUpdatePDR(pdr *context.PDR) *pfcp.UpdatePDR {
 	updatePDR := new(pfcp.UpdatePDR)
 
@@ -239,6 +440,16 @@ func pdrToUpdatePDR(pdr *context.PDR) *pfcp.UpdatePDR {
 		updatePDR.PDI.SDFFilter = pdr.PDI.SDFFilter
 	}
 
+	if pdr.PDI.EthernetPDUSessionInformation {
+		updatePDR.PDI.EthernetPDUSessionInformation = &pfcpType.EthernetPDUSessionInformation{
+			Ethi: true,
+		}
+	}
+
+	if pdr.PDI.EthernetPacketFilter != nil {
+		updatePDR.PDI.EthernetPacketFilter = ethernetPacketFilterToPFCP(pdr.PDI.EthernetPacketFilter)
+	}
+
 	updatePDR.OuterHeaderRemoval = pdr.OuterHeaderRemoval
 
 	updatePDR.FARID = &pfcpType.FARID{
@@ -279,6 +490,7 @@ func farToUpdateFAR(far *context.FAR) *pfcp.UpdateFAR {
 				Sndem: far.ForwardingParameters.SendEndMarker,
 			},
 		}
+		updateFAR.UpdateForwardingParameters.RedirectInformation = far.ForwardingParameters.RedirectInformation
 		if far.ForwardingParameters.ForwardingPolicyID != "" {
 			updateFAR.UpdateForwardingParameters.ForwardingPolicy = &pfcpType.ForwardingPolicy{
 				ForwardingPolicyIdentifierLength: uint8(len(far.ForwardingParameters.ForwardingPolicyID)),
@@ -304,16 +516,15 @@ func BuildPfcpSessionEstablishmentRequest(
 
 	msg.NodeID = &context.GetSelf().CPNodeID
 
-	isv4 := context.GetSelf().ExternalIP().To4() != nil
 	nodeIDtoIP := upNodeID.ResolveNodeIdToIp().String()
 
-	localSEID := smContext.PFCPContext[nodeIDtoIP].LocalSEID
+	pfcpSessionContext := smContext.PFCPContext[nodeIDtoIP]
+	localSEID := pfcpSessionContext.LocalSEID
 
-	msg.CPFSEID = &pfcpType.FSEID{
-		V4:          isv4,
-		V6:          !isv4,
-		Seid:        localSEID,
-		Ipv4Address: context.GetSelf().ExternalIP().To4(),
+	msg.CPFSEID = context.GetSelf().CPFSEID(localSEID)
+
+	if pfcpSessionContext.LocalFQCSID != nil {
+		msg.PGWCFQCSID = pfcpSessionContext.LocalFQCSID.ToPFCP()
 	}
 
 	msg.CreatePDR = make([]*pfcp.CreatePDR, 0)
@@ -365,7 +576,7 @@ func BuildPfcpSessionEstablishmentRequest(
 	}
 
 	msg.PDNType = &pfcpType.PDNType{
-		PdnType: pfcpType.PDNTypeIpv4,
+		PdnType: smContext.PDNType(),
 	}
 
 	// for _, far := range msg.CreateFAR {
@@ -432,12 +643,7 @@ func BuildPfcpSessionModificationRequest(
 
 	localSEID := smContext.PFCPContext[nodeIDtoIP].LocalSEID
 
-	msg.CPFSEID = &pfcpType.FSEID{
-		V4:          true,
-		V6:          false,
-		Seid:        localSEID,
-		Ipv4Address: context.GetSelf().ExternalIP().To4(),
-	}
+	msg.CPFSEID = context.GetSelf().CPFSEID(localSEID)
 
 	for _, pdr := range pdrList {
 		switch pdr.State {
@@ -476,12 +682,15 @@ func BuildPfcpSessionModificationRequest(
 		case context.RULE_INITIAL:
 			msg.CreateBAR = append(msg.CreateBAR, barToCreateBAR(bar))
 		}
+		bar.State = context.RULE_CREATE
 	}
 
 	for _, qer := range qerList {
 		switch qer.State {
 		case context.RULE_INITIAL:
 			msg.CreateQER = append(msg.CreateQER, qerToCreateQER(qer))
+		case context.RULE_UPDATE:
+			msg.UpdateQER = append(msg.UpdateQER, qerToUpdateQER(qer))
 		}
 		qer.State = context.RULE_CREATE
 	}
@@ -490,6 +699,14 @@ func BuildPfcpSessionModificationRequest(
 		switch urr.State {
 		case context.RULE_INITIAL:
 			msg.CreateURR = append(msg.CreateURR, urrToCreateURR(urr))
+		case context.RULE_UPDATE:
+			msg.UpdateURR = append(msg.UpdateURR, urrToUpdateURR(urr))
+		case context.RULE_REMOVE:
+			msg.RemoveURR = append(msg.RemoveURR, &pfcp.RemoveURR{
+				URRID: &pfcpType.URRID{
+					UrrIdValue: urr.URRID,
+				},
+			})
 		}
 		urr.State = context.RULE_CREATE
 	}
@@ -497,6 +714,25 @@ func BuildPfcpSessionModificationRequest(
 	return msg, nil
 }
 
+// BuildPfcpSessionModificationRequestToQueryURR builds the request querying the usage of the URRs,
+// it only needs the local SEID of the PFCP session so the SM context isn't read
+func BuildPfcpSessionModificationRequestToQueryURR(
+	localSEID uint64,
+	urrIDs []uint32,
+) (pfcp.PFCPSessionModificationRequest, error) {
+	msg := pfcp.PFCPSessionModificationRequest{}
+
+	msg.CPFSEID = context.GetSelf().CPFSEID(localSEID)
+
+	for _, id := range urrIDs {
+		msg.QueryURR = append(msg.QueryURR, &pfcp.QueryURR{
+			URRID: &pfcpType.URRID{UrrIdValue: id},
+		})
+	}
+
+	return msg, nil
+}
+
 // TODO: Replace dummy value in PFCP message
 func BuildPfcpSessionModificationResponse() (pfcp.PFCPSessionModificationResponse, error) {
 	msg := pfcp.PFCPSessionModificationResponse{}
@@ -547,14 +783,115 @@ func BuildPfcpSessionDeletionResponse() (pfcp.PFCPSessionDeletionResponse, error
 	return msg, nil
 }
 
-func BuildPfcpSessionReportResponse(cause pfcpType.Cause) (pfcp.PFCPSessionReportResponse, error) {
+// BuildPfcpSessionReportResponse responds to the report, the handling of the downlink data buffered in UPF
+// is given for the Downlink Data Report
+func BuildPfcpSessionReportResponse(cause pfcpType.Cause,
+	handling *context.DLDataHandling,
+) (pfcp.PFCPSessionReportResponse, error) {
 	msg := pfcp.PFCPSessionReportResponse{}
 
 	msg.Cause = &cause
 
+	if handling != nil {
+		if handling.DropBuffered {
+			msg.SxSRRspFlags = &pfcpType.PFCPSRRspFlags{Drobu: true}
+		}
+		if handling.ExtendedBuffering > 0 && handling.BAR != nil {
+			msg.UpdateBAR = &pfcp.UpdateBARPFCPSessionReportResponse{
+				BARID:               &pfcpType.BARID{BarIdValue: handling.BAR.BARID},
+				DLBufferingDuration: dlBufferingDuration(handling.ExtendedBuffering),
+			}
+			// the count is set only if UPF supports UDBC
+			if count := handling.BAR.SuggestedBufferingPacketsCount.PacketCountValue; count != 0 {
+				msg.UpdateBAR.DLBufferingSuggestedPacketCount = &pfcpType.DLBufferingSuggestedPacketCount{
+					PacketCountValue: uint16(count),
+				}
+			}
+		}
+	}
+
 	return msg, nil
 }
 
+// dlBufferingDurationUnits are the timer units of DL Buffering Duration (TS 29.244 8.2.45)
+var dlBufferingDurationUnits = []struct {
+	unit  uint8
+	value time.Duration
+}{
+	{0, 2 * time.Second},
+	{1, time.Minute},
+	{2, 10 * time.Minute},
+	{3, time.Hour},
+	{4, 10 * time.Hour},
+}
+
+// dlBufferingDuration encodes the duration in the smallest unit which holds it in the 5-bit timer value,
+// the duration is rounded up
+func dlBufferingDuration(d time.Duration) *pfcpType.DLBufferingDuration {
+	for _, u := range dlBufferingDurationUnits {
+		if value := (d + u.value - 1) / u.value; value <= 31 {
+			return &pfcpType.DLBufferingDuration{TimerUnit: u.unit, TimerValue: uint8(value)}
+		}
+	}
+	// infinite
+	return &pfcpType.DLBufferingDuration{TimerUnit: 7}
+}
+
+// BuildPfcpPfdManagementRequest provisions the PFDs of the application identifiers,
+// the PFDs of an application identifier without PFD are removed from UPF (TS 29.244 6.2.5)
+func BuildPfcpPfdManagementRequest(pfdDatas []*context.PfdDataForApp,
+	removedAppIDs []string,
+) (pfcp.PFCPPFDManagementRequest, error) {
+	msg := pfcp.PFCPPFDManagementRequest{}
+
+	for _, pfdData := range pfdDatas {
+		appIDsPFDs := &pfcp.ApplicationIDsPFDs{
+			ApplicationID: pfcpType.ApplicationID{
+				ApplicationIdentifier: []byte(pfdData.AppID),
+			},
+		}
+		if pfdContents := pfdContentsToPFCP(pfdData.Pfds); len(pfdContents) > 0 {
+			appIDsPFDs.PFD = &pfcp.PFDContext{
+				PFDContents: pfdContents,
+			}
+		}
+		msg.ApplicationIDsPFDs = append(msg.ApplicationIDsPFDs, appIDsPFDs)
+	}
+
+	for _, appID := range removedAppIDs {
+		msg.ApplicationIDsPFDs = append(msg.ApplicationIDsPFDs, &pfcp.ApplicationIDsPFDs{
+			ApplicationID: pfcpType.ApplicationID{
+				ApplicationIdentifier: []byte(appID),
+			},
+		})
+	}
+
+	return msg, nil
+}
+
+// pfdContentsToPFCP makes a PFD contents for each flow description, URL and domain name of the PFDs
+func pfdContentsToPFCP(pfds []context.Pfd) []pfcpType.PFDContents {
+	var pfdContents []pfcpType.PFDContents
+	for _, pfd := range pfds {
+		for _, flowDescription := range pfd.FlowDescriptions {
+			pfdContents = append(pfdContents, pfcpType.PFDContents{
+				FlowDescription: flowDescription,
+			})
+		}
+		for _, url := range pfd.Urls {
+			pfdContents = append(pfdContents, pfcpType.PFDContents{
+				URL: url,
+			})
+		}
+		for _, domainName := range pfd.DomainNames {
+			pfdContents = append(pfdContents, pfcpType.PFDContents{
+				DomainName: domainName,
+			})
+		}
+	}
+	return pfdContents
+}
+
 func BuildPfcpHeartbeatRequest() (pfcp.HeartbeatRequest, error) {
 	msg := pfcp.HeartbeatRequest{}
 
@@ -0,0 +1,129 @@
+package message_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+	"bitbucket.org/free5gc-team/smf/internal/context"
+	"bitbucket.org/free5gc-team/smf/internal/pfcp/message"
+)
+
+func TestBuildPfcpSessionReportResponse(t *testing.T) {
+	cause := pfcpType.Cause{CauseValue: pfcpType.CauseRequestAccepted}
+
+	msg, err := message.BuildPfcpSessionReportResponse(cause, nil)
+	require.NoError(t, err)
+	require.Nil(t, msg.SxSRRspFlags)
+	require.Nil(t, msg.UpdateBAR)
+
+	msg, err = message.BuildPfcpSessionReportResponse(cause, &context.DLDataHandling{DropBuffered: true})
+	require.NoError(t, err)
+	require.True(t, msg.SxSRRspFlags.Drobu)
+
+	bar := &context.BAR{BARID: 3}
+	bar.SuggestedBufferingPacketsCount.PacketCountValue = 10
+	msg, err = message.BuildPfcpSessionReportResponse(cause, &context.DLDataHandling{
+		ExtendedBuffering: 90 * time.Second,
+		BAR:               bar,
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint8(3), msg.UpdateBAR.BARID.BarIdValue)
+	// 90s is 2 minutes in the smallest unit holding it
+	require.Equal(t, uint8(1), msg.UpdateBAR.DLBufferingDuration.TimerUnit)
+	require.Equal(t, uint8(2), msg.UpdateBAR.DLBufferingDuration.TimerValue)
+	require.Equal(t, uint16(10), msg.UpdateBAR.DLBufferingSuggestedPacketCount.PacketCountValue)
+
+	// UPF without UDBC
+	bar.SuggestedBufferingPacketsCount.PacketCountValue = 0
+	msg, err = message.BuildPfcpSessionReportResponse(cause, &context.DLDataHandling{
+		ExtendedBuffering: 90 * time.Second,
+		BAR:               bar,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, msg.UpdateBAR.DLBufferingDuration)
+	require.Nil(t, msg.UpdateBAR.DLBufferingSuggestedPacketCount)
+}
+
+func TestBuildPfcpSessionModificationRequestQuotaURR(t *testing.T) {
+	nodeID := pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+		IP:         net.ParseIP("10.200.200.101").To4(),
+	}
+	smContext := &context.SMContext{
+		PFCPContext: map[string]*context.PFCPSessionContext{
+			"10.200.200.101": {NodeID: nodeID, LocalSEID: 1},
+		},
+	}
+	urr := &context.URR{URRID: 1, MeasureMethod: context.MesureMethodVol, State: context.RULE_UPDATE}
+	for _, opt := range []context.UrrOpt{
+		context.NewVolumeThreshold(800),
+		context.NewVolumeQuota(1000),
+		context.NewTimeQuota(time.Minute),
+		context.NewQuotaValidityTime(time.Hour),
+	} {
+		opt(urr)
+	}
+	removed := &context.URR{URRID: 2, State: context.RULE_REMOVE}
+
+	msg, err := message.BuildPfcpSessionModificationRequest(nodeID, "", smContext,
+		nil, nil, nil, nil, []*context.URR{urr, removed})
+	require.NoError(t, err)
+	require.Len(t, msg.UpdateURR, 1)
+	updateURR := msg.UpdateURR[0]
+	require.True(t, updateURR.MeasurementMethod.Volum)
+	require.True(t, updateURR.MeasurementMethod.Durat)
+	require.True(t, updateURR.VolumeThreshold.Tovol)
+	require.Equal(t, uint64(800), updateURR.VolumeThreshold.TotalVolume)
+	require.Equal(t, uint64(1000), updateURR.VolumeQuota.TotalVolume)
+	require.Equal(t, uint32(60), updateURR.TimeQuota.TimeQuotaValue)
+	require.Equal(t, uint32(3600), updateURR.QuotaValidityTime.QuotaValidityTime)
+	require.Nil(t, updateURR.TimeThreshold)
+	require.True(t, updateURR.ReportingTriggers.Volqu)
+	require.True(t, updateURR.ReportingTriggers.Quvti)
+	require.Len(t, msg.RemoveURR, 1)
+	require.Equal(t, uint32(2), msg.RemoveURR[0].URRID.UrrIdValue)
+	require.Equal(t, context.RULE_CREATE, urr.State)
+}
+
+func TestBuildPfcpSessionModificationRequestCreateBAR(t *testing.T) {
+	nodeID := pfcpType.NodeID{
+		NodeIdType: pfcpType.NodeIdTypeIpv4Address,
+		IP:         net.ParseIP("10.200.200.101").To4(),
+	}
+	smContext := &context.SMContext{
+		PFCPContext: map[string]*context.PFCPSessionContext{
+			"10.200.200.101": {NodeID: nodeID, LocalSEID: 1},
+		},
+	}
+	bar := &context.BAR{BARID: 1, State: context.RULE_INITIAL}
+
+	msg, err := message.BuildPfcpSessionModificationRequest(nodeID, "", smContext,
+		nil, nil, []*context.BAR{bar}, nil, nil)
+	require.NoError(t, err)
+	require.Len(t, msg.CreateBAR, 1)
+	// UPF without DDND and UDBC
+	require.Nil(t, msg.CreateBAR[0].DownlinkDataNotificationDelay)
+	require.Nil(t, msg.CreateBAR[0].SuggestedBufferingPacketsCount)
+	require.Equal(t, context.RULE_CREATE, bar.State)
+
+	// the BAR created in UPF isn't created again by the following modifications
+	msg, err = message.BuildPfcpSessionModificationRequest(nodeID, "", smContext,
+		nil, nil, []*context.BAR{bar}, nil, nil)
+	require.NoError(t, err)
+	require.Empty(t, msg.CreateBAR)
+}
+
+func TestBuildPfcpSessionModificationRequestToQueryURR(t *testing.T) {
+	msg, err := message.BuildPfcpSessionModificationRequestToQueryURR(5, []uint32{1, 3})
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), msg.CPFSEID.Seid)
+	require.Len(t, msg.QueryURR, 2)
+	require.Equal(t, uint32(1), msg.QueryURR[0].URRID.UrrIdValue)
+	require.Equal(t, uint32(3), msg.QueryURR[1].URRID.UrrIdValue)
+	require.Empty(t, msg.UpdatePDR)
+	require.Empty(t, msg.UpdateFAR)
+}
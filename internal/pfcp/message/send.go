@@ -87,7 +87,7 @@ func SendPfcpAssociationReleaseRequest(upNodeID pfcpType.NodeID) (resMsg *pfcpUd
 			MP:             0,
 			S:              pfcp.SEID_NOT_PRESENT,
 			MessageType:    pfcp.PFCP_ASSOCIATION_RELEASE_REQUEST,
-			SequenceNumber: 1,
+			SequenceNumber: getSeqNumber(),
 		},
 		Body: pfcpMsg,
 	}
@@ -109,7 +109,28 @@ func SendPfcpAssociationReleaseRequest(upNodeID pfcpType.NodeID) (resMsg *pfcpUd
 	return resMsg, nil
 }
 
-func SendPfcpAssociationReleaseResponse(addr *net.UDPAddr, cause pfcpType.Cause) {
+func SendPfcpAssociationUpdateResponse(addr *net.UDPAddr, cause pfcpType.Cause, seqFromUPF uint32) {
+	pfcpMsg, err := BuildPfcpAssociationUpdateResponse(cause)
+	if err != nil {
+		logger.PfcpLog.Errorf("Build PFCP Association Update Response failed: %v", err)
+		return
+	}
+
+	message := &pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			MP:             0,
+			S:              pfcp.SEID_NOT_PRESENT,
+			MessageType:    pfcp.PFCP_ASSOCIATION_UPDATE_RESPONSE,
+			SequenceNumber: seqFromUPF,
+		},
+		Body: pfcpMsg,
+	}
+
+	udp.SendPfcpResponse(message, addr)
+}
+
+func SendPfcpAssociationReleaseResponse(addr *net.UDPAddr, cause pfcpType.Cause, seqFromUPF uint32) {
 	pfcpMsg, err := BuildPfcpAssociationReleaseResponse(cause)
 	if err != nil {
 		logger.PfcpLog.Errorf("Build PFCP Association Release Response failed: %v", err)
@@ -122,7 +143,83 @@ func SendPfcpAssociationReleaseResponse(addr *net.UDPAddr, cause pfcpType.Cause)
 			MP:             0,
 			S:              pfcp.SEID_NOT_PRESENT,
 			MessageType:    pfcp.PFCP_ASSOCIATION_RELEASE_RESPONSE,
-			SequenceNumber: 1,
+			SequenceNumber: seqFromUPF,
+		},
+		Body: pfcpMsg,
+	}
+
+	udp.SendPfcpResponse(message, addr)
+}
+
+func SendPfcpNodeReportResponse(addr *net.UDPAddr, cause pfcpType.Cause, seqFromUPF uint32) {
+	pfcpMsg, err := BuildPfcpNodeReportResponse(cause)
+	if err != nil {
+		logger.PfcpLog.Errorf("Build PFCP Node Report Response failed: %v", err)
+		return
+	}
+
+	message := &pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			MP:             0,
+			S:              pfcp.SEID_NOT_PRESENT,
+			MessageType:    pfcp.PFCP_NODE_REPORT_RESPONSE,
+			SequenceNumber: seqFromUPF,
+		},
+		Body: pfcpMsg,
+	}
+
+	udp.SendPfcpResponse(message, addr)
+}
+
+func SendPfcpSessionSetDeletionRequest(upf *context.UPF, fqcsid *context.FQCSID) (resMsg *pfcpUdp.Message, err error) {
+	pfcpMsg, err := BuildPfcpSessionSetDeletionRequest(fqcsid)
+	if err != nil {
+		return nil, fmt.Errorf("Build PFCP Session Set Deletion Request failed: %w", err)
+	}
+
+	reqMsg := &pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			MP:             0,
+			S:              pfcp.SEID_NOT_PRESENT,
+			MessageType:    pfcp.PFCP_SESSION_SET_DELETION_REQUEST,
+			SequenceNumber: getSeqNumber(),
+		},
+		Body: pfcpMsg,
+	}
+
+	upfAddr := &net.UDPAddr{
+		IP:   upf.NodeID.ResolveNodeIdToIp(),
+		Port: pfcpUdp.PFCP_PORT,
+	}
+
+	resMsg, err = udp.SendPfcpRequest(reqMsg, upfAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if resMsg.MessageType() != pfcp.PFCP_SESSION_SET_DELETION_RESPONSE {
+		return resMsg, fmt.Errorf("received unexpected response message")
+	}
+
+	return resMsg, nil
+}
+
+func SendPfcpSessionSetDeletionResponse(addr *net.UDPAddr, cause pfcpType.Cause, seqFromUPF uint32) {
+	pfcpMsg, err := BuildPfcpSessionSetDeletionResponse(cause)
+	if err != nil {
+		logger.PfcpLog.Errorf("Build PFCP Session Set Deletion Response failed: %v", err)
+		return
+	}
+
+	message := &pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			MP:             0,
+			S:              pfcp.SEID_NOT_PRESENT,
+			MessageType:    pfcp.PFCP_SESSION_SET_DELETION_RESPONSE,
+			SequenceNumber: seqFromUPF,
 		},
 		Body: pfcpMsg,
 	}
@@ -234,8 +331,70 @@ func SendPfcpSessionModificationRequest(
 		return
 	}
 
+	return sendPfcpSessionModificationRequest(upf, ctx, pfcpMsg)
+}
+
+// SendPfcpSessionModificationRequestToDropBuffered requests UPF to discard the downlink data buffered
+// for the PFCP session of the PDU session
+func SendPfcpSessionModificationRequestToDropBuffered(
+	upf *context.UPF,
+	ctx *context.SMContext,
+) (resMsg *pfcpUdp.Message, err error) {
+	nodeIDtoIP := upf.NodeID.ResolveNodeIdToIp()
+	if upf.UPFStatus != context.AssociatedSetUpSuccess {
+		return nil, fmt.Errorf("Not Associated with UPF[%s]", nodeIDtoIP.String())
+	}
+
+	pfcpMsg, err := BuildPfcpSessionModificationRequest(upf.NodeID, nodeIDtoIP.String(),
+		ctx, nil, nil, nil, nil, nil)
+	if err != nil {
+		logger.PfcpLog.Errorf("Build PFCP Session Modification Request failed: %v", err)
+		return
+	}
+	pfcpMsg.PFCPSMReqFlags = &pfcpType.PFCPSMReqFlags{Drobu: true}
+
+	return sendPfcpSessionModificationRequest(upf, ctx, pfcpMsg)
+}
+
+// SendPfcpSessionModificationRequestToQueryURR requests UPF to report the usage of the URRs immediately.
+// The SEIDs of the PFCP session are copied by the caller, so the request is sent without the SM lock
+func SendPfcpSessionModificationRequestToQueryURR(
+	upf *context.UPF,
+	localSEID uint64,
+	remoteSEID uint64,
+	urrIDs []uint32,
+) (resMsg *pfcpUdp.Message, err error) {
+	nodeIDtoIP := upf.NodeID.ResolveNodeIdToIp()
+	if upf.UPFStatus != context.AssociatedSetUpSuccess {
+		return nil, fmt.Errorf("Not Associated with UPF[%s]", nodeIDtoIP.String())
+	}
+
+	pfcpMsg, err := BuildPfcpSessionModificationRequestToQueryURR(localSEID, urrIDs)
+	if err != nil {
+		logger.PfcpLog.Errorf("Build PFCP Session Modification Request failed: %v", err)
+		return
+	}
+
+	return sendPfcpSessionModificationRequestWithSEID(upf, localSEID, remoteSEID, pfcpMsg)
+}
+
+func sendPfcpSessionModificationRequest(
+	upf *context.UPF,
+	ctx *context.SMContext,
+	pfcpMsg pfcp.PFCPSessionModificationRequest,
+) (resMsg *pfcpUdp.Message, err error) {
+	pfcpCtx := ctx.PFCPContext[upf.NodeID.ResolveNodeIdToIp().String()]
+	return sendPfcpSessionModificationRequestWithSEID(upf, pfcpCtx.LocalSEID, pfcpCtx.RemoteSEID, pfcpMsg)
+}
+
+func sendPfcpSessionModificationRequestWithSEID(
+	upf *context.UPF,
+	localSEID uint64,
+	remoteSEID uint64,
+	pfcpMsg pfcp.PFCPSessionModificationRequest,
+) (resMsg *pfcpUdp.Message, err error) {
+	nodeIDtoIP := upf.NodeID.ResolveNodeIdToIp()
 	seqNum := getSeqNumber()
-	remoteSEID := ctx.PFCPContext[nodeIDtoIP.String()].RemoteSEID
 	message := &pfcp.Message{
 		Header: pfcp.Header{
 			Version:         pfcp.PfcpVersion,
@@ -263,7 +422,6 @@ func SendPfcpSessionModificationRequest(
 		return resMsg, fmt.Errorf("received unexpected type response message: %+v", resMsg.PfcpMessage.Header)
 	}
 
-	localSEID := ctx.PFCPContext[nodeIDtoIP.String()].LocalSEID
 	if resMsg.PfcpMessage.Header.SEID != localSEID {
 		return resMsg, fmt.Errorf("received unexpected SEID response message: %+v, exptcted: %d",
 			resMsg.PfcpMessage.Header, localSEID)
@@ -370,7 +528,15 @@ func SendPfcpSessionDeletionResponse(addr *net.UDPAddr) {
 }
 
 func SendPfcpSessionReportResponse(addr *net.UDPAddr, cause pfcpType.Cause, seqFromUPF uint32, SEID uint64) {
-	pfcpMsg, err := BuildPfcpSessionReportResponse(cause)
+	SendPfcpSessionReportResponseForDLData(addr, cause, seqFromUPF, SEID, nil)
+}
+
+// SendPfcpSessionReportResponseForDLData responds to the Downlink Data Report with the handling of the downlink
+// data buffered in UPF
+func SendPfcpSessionReportResponseForDLData(addr *net.UDPAddr, cause pfcpType.Cause, seqFromUPF uint32,
+	SEID uint64, handling *context.DLDataHandling,
+) {
+	pfcpMsg, err := BuildPfcpSessionReportResponse(cause, handling)
 	if err != nil {
 		logger.PfcpLog.Errorf("Build PFCP Session Report Response failed: %v", err)
 		return
@@ -425,6 +591,42 @@ func SendPfcpHeartbeatRequest(upf *context.UPF) (resMsg *pfcpUdp.Message, err er
 	return resMsg, nil
 }
 
+func SendPfcpPfdManagementRequest(upf *context.UPF, pfdDatas []*context.PfdDataForApp,
+	removedAppIDs []string,
+) (resMsg *pfcpUdp.Message, err error) {
+	pfcpMsg, err := BuildPfcpPfdManagementRequest(pfdDatas, removedAppIDs)
+	if err != nil {
+		return nil, fmt.Errorf("Build PFCP PFD Management Request failed: %w", err)
+	}
+
+	reqMsg := &pfcp.Message{
+		Header: pfcp.Header{
+			Version:        pfcp.PfcpVersion,
+			MP:             0,
+			S:              pfcp.SEID_NOT_PRESENT,
+			MessageType:    pfcp.PFCP_PFD_MANAGEMENT_REQUEST,
+			SequenceNumber: getSeqNumber(),
+		},
+		Body: pfcpMsg,
+	}
+
+	upfAddr := &net.UDPAddr{
+		IP:   upf.NodeID.ResolveNodeIdToIp(),
+		Port: pfcpUdp.PFCP_PORT,
+	}
+
+	resMsg, err = udp.SendPfcpRequest(reqMsg, upfAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if resMsg.MessageType() != pfcp.PFCP_PFD_MANAGEMENT_RESPONSE {
+		return resMsg, fmt.Errorf("received unexpected response message")
+	}
+
+	return resMsg, nil
+}
+
 func SendHeartbeatResponse(addr *net.UDPAddr, seq uint32) {
 	pfcpMsg := pfcp.HeartbeatResponse{
 		RecoveryTimeStamp: &pfcpType.RecoveryTimeStamp{
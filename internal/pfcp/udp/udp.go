@@ -26,7 +26,7 @@ func Run(Dispatch func(*pfcpUdp.Message)) {
 		}
 	}()
 
-	serverIP := context.GetSelf().ListenIP().To4()
+	serverIP := context.GetSelf().ListenIP()
 	Server = pfcpUdp.NewPfcpServer(serverIP.String())
 
 	err := Server.Listen()
@@ -70,7 +70,7 @@ func SendPfcpResponse(sndMsg *pfcp.Message, addr *net.UDPAddr) {
 }
 
 func SendPfcpRequest(sndMsg *pfcp.Message, addr *net.UDPAddr) (rsvMsg *pfcpUdp.Message, err error) {
-	if addr.IP.Equal(net.IPv4zero) {
+	if addr.IP == nil || addr.IP.IsUnspecified() {
 		return nil, errors.New("no destination IP address is specified")
 	}
 	return Server.WriteRequestTo(sndMsg, addr)
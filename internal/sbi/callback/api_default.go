@@ -60,3 +60,47 @@ func HTTPSmPolicyUpdateNotification(c *gin.Context) {
 func SmPolicyControlTerminationRequestNotification(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{})
 }
+
+// HTTPPfdChangeNotification - the PFDs of applications are changed in NEF, TS 29.551 5.2.2.3.4
+func HTTPPfdChangeNotification(c *gin.Context) {
+	var request []models.PfdChangeNotification
+
+	reqBody, err := c.GetRawData()
+	if err != nil {
+		logger.PduSessLog.Errorln("GetRawData failed")
+		c.JSON(http.StatusInternalServerError, gin.H{})
+		return
+	}
+
+	err = openapi.Deserialize(&request, reqBody, c.ContentType())
+	if err != nil {
+		logger.PduSessLog.Errorln("Deserialize request failed")
+		c.JSON(http.StatusBadRequest, gin.H{})
+		return
+	}
+
+	HTTPResponse := producer.HandlePfdChangeNotification(request)
+	c.Status(HTTPResponse.Status)
+}
+
+// HTTPN1N2MessageTransferFailureNotification - AMF failed to page UE for the downlink data, TS 29.518 5.2.2.3.3
+func HTTPN1N2MessageTransferFailureNotification(c *gin.Context) {
+	var request models.N1N2MsgTxfrFailureNotification
+
+	reqBody, err := c.GetRawData()
+	if err != nil {
+		logger.PduSessLog.Errorln("GetRawData failed")
+		c.JSON(http.StatusInternalServerError, gin.H{})
+		return
+	}
+
+	err = openapi.Deserialize(&request, reqBody, c.ContentType())
+	if err != nil {
+		logger.PduSessLog.Errorln("Deserialize request failed")
+		c.JSON(http.StatusBadRequest, gin.H{})
+		return
+	}
+
+	HTTPResponse := producer.HandleN1N2TransferFailureNotification(c.Params.ByName("smContextRef"), request)
+	c.Status(HTTPResponse.Status)
+}
@@ -70,4 +70,16 @@ var routes = Routes{
 		"/sm-policies/:smContextRef/terminate",
 		SmPolicyControlTerminationRequestNotification,
 	},
+	{
+		"N1N2MessageTransferFailureNotification",
+		"POST",
+		"/sm-contexts/:smContextRef/n1n2-failure",
+		HTTPN1N2MessageTransferFailureNotification,
+	},
+	{
+		"PfdChangeNotification",
+		"POST",
+		"/pfd-change",
+		HTTPPfdChangeNotification,
+	},
 }
@@ -0,0 +1,126 @@
+package consumer
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"bitbucket.org/free5gc-team/nas/nasConvert"
+	"bitbucket.org/free5gc-team/openapi/models"
+	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
+)
+
+// The Nchf_ConvergedCharging service (TS 32.291) is not provided by the generated openapi clients
+const ChfConvergedChargingUriPrefix = "/nchf-convergedcharging/v3"
+
+var chfClient = &http.Client{Timeout: 10 * time.Second}
+
+// SendConvergedChargingCreate creates the charging data of the PDU session on CHF and requests the quota
+// of the rating groups, the charging data reference is kept in the charging session
+func SendConvergedChargingCreate(smContext *smf_context.SMContext) (*models.ChargingDataResponse, error) {
+	session := smContext.ChargingSession
+	if session == nil {
+		return nil, errors.Errorf("no charging session")
+	}
+	uri := chargingDataUri(smf_context.GetSelf().Charging.ChfUri, "")
+	req := buildChargingDataRequest(smContext, smf_context.ChargingInitial)
+
+	var rsp models.ChargingDataResponse
+	status, location, err := sendJsonRequest(chfClient, "CHF", http.MethodPost, uri, req, &rsp)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusCreated {
+		return nil, fmt.Errorf("charging data create failed with status %d", status)
+	}
+	if location == "" {
+		return nil, fmt.Errorf("no Location header in charging data create response")
+	}
+	session.Ref = path.Base(location)
+	return &rsp, nil
+}
+
+// SendConvergedChargingUpdate reports the usage of the rating groups to CHF
+// and requests the new quota of the ones whose quotas are used up
+func SendConvergedChargingUpdate(smContext *smf_context.SMContext) (*models.ChargingDataResponse, error) {
+	session := smContext.ChargingSession
+	if session == nil || session.Ref == "" {
+		return nil, errors.Errorf("no charging data on CHF")
+	}
+	uri := chargingDataUri(smf_context.GetSelf().Charging.ChfUri, session.Ref) + "/update"
+	req := buildChargingDataRequest(smContext, smf_context.ChargingUpdate)
+
+	var rsp models.ChargingDataResponse
+	status, _, err := sendJsonRequest(chfClient, "CHF", http.MethodPost, uri, req, &rsp)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("charging data update failed with status %d", status)
+	}
+	return &rsp, nil
+}
+
+// SendConvergedChargingRelease reports the final usage to CHF and releases the charging data
+func SendConvergedChargingRelease(smContext *smf_context.SMContext) error {
+	session := smContext.ChargingSession
+	if session == nil || session.Ref == "" {
+		return nil
+	}
+	uri := chargingDataUri(smf_context.GetSelf().Charging.ChfUri, session.Ref) + "/release"
+	req := buildChargingDataRequest(smContext, smf_context.ChargingRelease)
+
+	status, _, err := sendJsonRequest(chfClient, "CHF", http.MethodPost, uri, req, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusNoContent && status != http.StatusNotFound {
+		return fmt.Errorf("charging data release failed with status %d", status)
+	}
+	return nil
+}
+
+func chargingDataUri(chfUri, ref string) string {
+	uri := strings.TrimSuffix(chfUri, "/") + ChfConvergedChargingUriPrefix + "/chargingdata"
+	if ref != "" {
+		uri += "/" + ref
+	}
+	return uri
+}
+
+func buildChargingDataRequest(
+	smContext *smf_context.SMContext,
+	requestType smf_context.ChargingRequestType,
+) models.ChargingDataRequest {
+	self := smf_context.GetSelf()
+	session := smContext.ChargingSession
+	now := time.Now()
+
+	return models.ChargingDataRequest{
+		SubscriberIdentifier: smContext.Supi,
+		NfConsumerIdentification: &models.NfIdentification{
+			NFName:            self.NfInstanceID,
+			NFIPv4Address:     self.RegisterIPv4,
+			NodeFunctionality: models.NodeFunctionality_SMF,
+		},
+		InvocationTimeStamp:      &now,
+		InvocationSequenceNumber: int32(session.NextInvocationSequenceNumber()),
+		MultipleUnitUsage:        session.UnitUsage(requestType),
+		PDUSessionChargingInformation: &models.PduSessionChargingInformation{
+			ChargingId: int32(session.ChargingID),
+			PduSessionInformation: &models.PduSessionInformation{
+				PduSessionID: smContext.PDUSessionID,
+				NetworkSlicingInfo: &models.NetworkSlicingInfo{
+					SNSSAI: smContext.SNssai,
+				},
+				DnnId:   smContext.Dnn,
+				PduType: nasConvert.PDUSessionTypeToModels(smContext.SelectedPDUSessionType),
+				RatType: smContext.RatType,
+			},
+		},
+	}
+}
@@ -0,0 +1,93 @@
+package consumer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
+)
+
+// newStubChf returns a CHF which grants 1000 bytes to rating group 1 and records the usage reported
+func newStubChf(t *testing.T, usages *[]models.MultipleUnitUsage) *httptest.Server {
+	prefix := ChfConvergedChargingUriPrefix + "/chargingdata"
+	grant := func(w http.ResponseWriter, r *http.Request, status int) {
+		var req models.ChargingDataRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		require.Equal(t, "imsi-208930000000001", req.SubscriberIdentifier)
+		*usages = append(*usages, req.MultipleUnitUsage...)
+		if status == http.StatusNoContent {
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("Content-Type", contentTypeJson)
+		w.WriteHeader(status)
+		require.NoError(t, json.NewEncoder(w).Encode(models.ChargingDataResponse{
+			MultipleUnitInformation: []models.MultipleUnitInformation{
+				{
+					RatingGroup: 1,
+					GrantedUnit: &models.GrantedUnit{TotalVolume: 1000},
+				},
+			},
+		}))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(prefix, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		w.Header().Set("Location", "http://"+r.Host+prefix+"/1")
+		grant(w, r, http.StatusCreated)
+	})
+	mux.HandleFunc(prefix+"/1/update", func(w http.ResponseWriter, r *http.Request) {
+		grant(w, r, http.StatusOK)
+	})
+	mux.HandleFunc(prefix+"/1/release", func(w http.ResponseWriter, r *http.Request) {
+		grant(w, r, http.StatusNoContent)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestSendConvergedCharging(t *testing.T) {
+	var usages []models.MultipleUnitUsage
+	chf := newStubChf(t, &usages)
+	defer chf.Close()
+
+	self := smf_context.GetSelf()
+	origCharging := self.Charging
+	self.Charging = &smf_context.OnlineCharging{ChfUri: chf.URL, RatingGroup: 1, RequestedVolume: 1000}
+	defer func() { self.Charging = origCharging }()
+
+	quota := &smf_context.ChargingQuota{RatingGroup: 1, URRID: 1}
+	smContext := &smf_context.SMContext{
+		Supi:            "imsi-208930000000001",
+		ChargingSession: &smf_context.ChargingSession{Quotas: map[uint32]*smf_context.ChargingQuota{1: quota}},
+	}
+
+	rsp, err := SendConvergedChargingCreate(smContext)
+	require.NoError(t, err)
+	require.Equal(t, "1", smContext.ChargingSession.Ref)
+	require.Equal(t, int32(1000), rsp.MultipleUnitInformation[0].GrantedUnit.TotalVolume)
+	require.Len(t, usages, 1)
+	require.Equal(t, int32(1000), usages[0].RequestedUnit.TotalVolume)
+
+	smContext.AddUsageReport(smf_context.UsageReport{
+		UrrId:       1,
+		TotalVolume: 1000,
+		ReportTpye:  models.TriggerType_QUOTA_EXHAUSTED,
+	})
+	_, err = SendConvergedChargingUpdate(smContext)
+	require.NoError(t, err)
+	require.Len(t, usages, 2)
+	require.Equal(t, int32(1000), usages[1].UsedUnitContainer[0].TotalVolume)
+	require.NotNil(t, usages[1].RequestedUnit)
+
+	require.NoError(t, SendConvergedChargingRelease(smContext))
+	// no usage since the last report
+	require.Len(t, usages, 2)
+	require.Equal(t, uint32(3), smContext.ChargingSession.InvocationSequenceNumber)
+}
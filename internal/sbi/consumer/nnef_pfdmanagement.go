@@ -0,0 +1,120 @@
+package consumer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+)
+
+// The Nnef_PFDManagement service (TS 29.551) is not provided by the generated openapi clients
+const NefPfdManagementUriPrefix = "/nnef-pfdmanagement/v1"
+
+const contentTypeJson = "application/json"
+
+var nefClient = &http.Client{Timeout: 10 * time.Second}
+
+// SendPfdFetch fetches the PFDs of the application identifiers from NEF,
+// the PFDs of all applications are fetched if appIDs is empty
+func SendPfdFetch(nefUri string, appIDs []string) ([]models.PfdDataForApp, error) {
+	uri := strings.TrimSuffix(nefUri, "/") + NefPfdManagementUriPrefix + "/applications"
+	if len(appIDs) > 0 {
+		uri += "?" + url.Values{"application-ids": {strings.Join(appIDs, ",")}}.Encode()
+	}
+
+	var pfdDatas []models.PfdDataForApp
+	status, _, err := sendJsonRequest(nefClient, "NEF", http.MethodGet, uri, nil, &pfdDatas)
+	if err != nil {
+		return nil, err
+	}
+	switch status {
+	case http.StatusOK:
+		return pfdDatas, nil
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("PFD fetch failed with status %d", status)
+	}
+}
+
+// SendPfdSubscription subscribes to the PFD changes of the application identifiers,
+// the changes of all applications are notified if appIDs is empty. The URI of the subscription is returned
+func SendPfdSubscription(nefUri string, appIDs []string) (string, error) {
+	self := smf_context.GetSelf()
+	subscription := models.PfdSubscription{
+		ApplicationIds: appIDs,
+		NotifyUri: fmt.Sprintf("%s://%s:%d/nsmf-callback/pfd-change",
+			self.URIScheme, self.RegisterIPv4, self.SBIPort),
+	}
+	uri := strings.TrimSuffix(nefUri, "/") + NefPfdManagementUriPrefix + "/subscriptions"
+
+	status, location, err := sendJsonRequest(nefClient, "NEF", http.MethodPost, uri, subscription, nil)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusCreated {
+		return "", fmt.Errorf("PFD subscription failed with status %d", status)
+	}
+	if location == "" {
+		return "", fmt.Errorf("no Location header in PFD subscription response")
+	}
+	return location, nil
+}
+
+// SendPfdUnsubscription removes the subscription to the PFD changes
+func SendPfdUnsubscription(subscriptionUri string) error {
+	status, _, err := sendJsonRequest(nefClient, "NEF", http.MethodDelete, subscriptionUri, nil, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusNoContent && status != http.StatusNotFound {
+		return fmt.Errorf("PFD unsubscription failed with status %d", status)
+	}
+	return nil
+}
+
+// sendJsonRequest sends the request of the SBI service not provided by the generated openapi clients,
+// the JSON body of the successful response is decoded to rspData. It returns the status and the Location header
+func sendJsonRequest(client *http.Client, nf, method, uri string, reqData, rspData interface{}) (int, string, error) {
+	var body io.Reader
+	if reqData != nil {
+		buf, err := json.Marshal(reqData)
+		if err != nil {
+			return 0, "", err
+		}
+		body = bytes.NewReader(buf)
+	}
+
+	req, err := http.NewRequest(method, uri, body)
+	if err != nil {
+		return 0, "", err
+	}
+	if reqData != nil {
+		req.Header.Set("Content-Type", contentTypeJson)
+	}
+
+	httpRsp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer func() {
+		if rspCloseErr := httpRsp.Body.Close(); rspCloseErr != nil {
+			logger.ConsumerLog.Errorf("%s response body cannot close: %+v", nf, rspCloseErr)
+		}
+	}()
+
+	if rspData != nil && (httpRsp.StatusCode == http.StatusOK || httpRsp.StatusCode == http.StatusCreated) {
+		if err := json.NewDecoder(httpRsp.Body).Decode(rspData); err != nil {
+			return 0, "", fmt.Errorf("decode %s response: %v", nf, err)
+		}
+	}
+	return httpRsp.StatusCode, httpRsp.Header.Get("Location"), nil
+}
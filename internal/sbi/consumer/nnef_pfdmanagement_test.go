@@ -0,0 +1,93 @@
+package consumer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+)
+
+// newStubNef returns a NEF which provides the PFDs of app1 and accepts the PFD subscriptions
+func newStubNef(t *testing.T, cachingTime time.Time) *httptest.Server {
+	pfdDatas := map[string]models.PfdDataForApp{
+		"app1": {
+			ApplicationId: "app1",
+			Pfds: []models.PfdContent{
+				{
+					PfdId:       "pfd1",
+					DomainNames: []string{"app1.example.com"},
+				},
+			},
+			CachingTime: &cachingTime,
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(NefPfdManagementUriPrefix+"/applications", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodGet, r.Method)
+		rsp := []models.PfdDataForApp{}
+		if appIDs := r.URL.Query().Get("application-ids"); appIDs != "" {
+			for _, appID := range strings.Split(appIDs, ",") {
+				if pfdData, ok := pfdDatas[appID]; ok {
+					rsp = append(rsp, pfdData)
+				}
+			}
+		} else {
+			for _, pfdData := range pfdDatas {
+				rsp = append(rsp, pfdData)
+			}
+		}
+		if len(rsp) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", contentTypeJson)
+		require.NoError(t, json.NewEncoder(w).Encode(rsp))
+	})
+	mux.HandleFunc(NefPfdManagementUriPrefix+"/subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		var subscription models.PfdSubscription
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&subscription))
+		require.True(t, strings.HasSuffix(subscription.NotifyUri, "/nsmf-callback/pfd-change"))
+		w.Header().Set("Location", "http://"+r.Host+NefPfdManagementUriPrefix+"/subscriptions/1")
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc(NefPfdManagementUriPrefix+"/subscriptions/1", func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodDelete, r.Method)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestSendPfdFetch(t *testing.T) {
+	cachingTime := time.Now().Add(time.Hour).Truncate(time.Second)
+	nef := newStubNef(t, cachingTime)
+	defer nef.Close()
+
+	pfdDatas, err := SendPfdFetch(nef.URL, nil)
+	require.NoError(t, err)
+	require.Len(t, pfdDatas, 1)
+	require.Equal(t, "app1", pfdDatas[0].ApplicationId)
+	require.True(t, cachingTime.Equal(*pfdDatas[0].CachingTime))
+	require.Equal(t, []string{"app1.example.com"}, pfdDatas[0].Pfds[0].DomainNames)
+
+	pfdDatas, err = SendPfdFetch(nef.URL, []string{"app2"})
+	require.NoError(t, err)
+	require.Empty(t, pfdDatas)
+}
+
+func TestSendPfdSubscription(t *testing.T) {
+	nef := newStubNef(t, time.Now())
+	defer nef.Close()
+
+	subscriptionUri, err := SendPfdSubscription(nef.URL, nil)
+	require.NoError(t, err)
+	require.Equal(t, nef.URL+NefPfdManagementUriPrefix+"/subscriptions/1", subscriptionUri)
+	require.NoError(t, SendPfdUnsubscription(subscriptionUri))
+}
@@ -0,0 +1,138 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"bitbucket.org/free5gc-team/openapi"
+	"bitbucket.org/free5gc-team/openapi/Nsmf_PDUSession"
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+	"bitbucket.org/free5gc-team/smf/pkg/factory"
+)
+
+const n1SmInfoFromUeContentId = "n1SmInfoFromUe"
+
+// newHsmfClient returns the Nsmf_PDUSession client of H-SMF and the resource path of the URI,
+// uri is the API URI of the Nsmf_PDUSession service or the URI of a PDU session in H-SMF
+func newHsmfClient(uri string) (*Nsmf_PDUSession.APIClient, string) {
+	apiRoot, resource := strings.TrimSuffix(uri, "/"), ""
+	if i := strings.Index(uri, factory.SmfPdusessionResUriPrefix); i >= 0 {
+		apiRoot, resource = uri[:i], uri[i+len(factory.SmfPdusessionResUriPrefix):]
+	}
+	configuration := Nsmf_PDUSession.NewConfiguration()
+	configuration.SetBasePath(apiRoot)
+	return Nsmf_PDUSession.NewAPIClient(configuration), resource
+}
+
+// hsmfPduSessionRef returns the client and the pduSessionRef of a PDU session in H-SMF
+func hsmfPduSessionRef(pduSessionUri string) (*Nsmf_PDUSession.APIClient, string, error) {
+	client, resource := newHsmfClient(pduSessionUri)
+	pduSessionRef := strings.TrimPrefix(resource, "/pdu-sessions/")
+	if pduSessionRef == resource || pduSessionRef == "" || strings.Contains(pduSessionRef, "/") {
+		return nil, "", fmt.Errorf("invalid H-SMF PDU session URI [%s]", pduSessionUri)
+	}
+	return client, pduSessionRef, nil
+}
+
+func closeHsmfResponse(httpResp *http.Response) {
+	if httpResp == nil {
+		return
+	}
+	if rspCloseErr := httpResp.Body.Close(); rspCloseErr != nil {
+		logger.ConsumerLog.Errorf("H-SMF response body cannot close: %+v", rspCloseErr)
+	}
+}
+
+// SendPostPduSessions creates the PDU session in H-SMF for a home-routed PDU session (V-SMF role),
+// hSmfUri is the API URI of the Nsmf_PDUSession service of H-SMF.
+// One of the response and the error response is returned with the URI of the created PDU session.
+func SendPostPduSessions(hSmfUri string, request models.PostPduSessionsRequest) (
+	*models.PostPduSessionsResponse, *models.PostPduSessionsErrorResponse, string, error,
+) {
+	if request.BinaryDataN1SmInfoFromUe != nil {
+		request.JsonData.N1SmInfoFromUe = &models.RefToBinaryData{ContentId: n1SmInfoFromUeContentId}
+	}
+	client, _ := newHsmfClient(hSmfUri)
+
+	rsp, httpResp, localErr := client.PDUSessionsCollectionApi.PostPduSessions(context.Background(), request)
+	defer closeHsmfResponse(httpResp)
+	if localErr == nil {
+		location := httpResp.Header.Get("Location")
+		if location == "" {
+			return nil, nil, "", fmt.Errorf("no Location header in PostPduSessions response")
+		}
+		return &rsp, nil, location, nil
+	} else if httpResp != nil {
+		if httpResp.Status != localErr.Error() {
+			return nil, nil, "", localErr
+		}
+		errRsp, ok := localErr.(openapi.GenericOpenAPIError).Model().(models.PostPduSessionsErrorResponse)
+		if !ok || errRsp.JsonData == nil || errRsp.JsonData.Error == nil {
+			return nil, nil, "", fmt.Errorf("PostPduSessions failed with status %d", httpResp.StatusCode)
+		}
+		return nil, &errRsp, "", nil
+	}
+	return nil, nil, "", openapi.ReportError("PostPduSessions failed[%s]", localErr.Error())
+}
+
+// SendUpdatePduSession relays the update of a home-routed PDU session to H-SMF (V-SMF role)
+func SendUpdatePduSession(pduSessionUri string, request models.UpdatePduSessionRequest) (
+	*models.UpdatePduSessionResponse, *models.UpdatePduSessionErrorResponse, error,
+) {
+	client, pduSessionRef, err := hsmfPduSessionRef(pduSessionUri)
+	if err != nil {
+		return nil, nil, err
+	}
+	if request.BinaryDataN1SmInfoFromUe != nil {
+		request.JsonData.N1SmInfoFromUe = &models.RefToBinaryData{ContentId: n1SmInfoFromUeContentId}
+	}
+
+	rsp, httpResp, localErr := client.IndividualPDUSessionHSMFApi.
+		UpdatePduSession(context.Background(), pduSessionRef, request)
+	defer closeHsmfResponse(httpResp)
+	if localErr == nil {
+		if rsp.JsonData == nil {
+			// 204 No Content
+			rsp.JsonData = new(models.HsmfUpdatedData)
+		}
+		return &rsp, nil, nil
+	} else if httpResp != nil {
+		if httpResp.Status != localErr.Error() {
+			return nil, nil, localErr
+		}
+		errRsp, ok := localErr.(openapi.GenericOpenAPIError).Model().(models.UpdatePduSessionErrorResponse)
+		if !ok || errRsp.JsonData == nil || errRsp.JsonData.Error == nil {
+			return nil, nil, fmt.Errorf("UpdatePduSession failed with status %d", httpResp.StatusCode)
+		}
+		return nil, &errRsp, nil
+	}
+	return nil, nil, openapi.ReportError("UpdatePduSession failed[%s]", localErr.Error())
+}
+
+// SendReleasePduSession releases a home-routed PDU session in H-SMF (V-SMF role)
+func SendReleasePduSession(pduSessionUri string, releaseData models.ReleaseData) (*models.ProblemDetails, error) {
+	client, pduSessionRef, err := hsmfPduSessionRef(pduSessionUri)
+	if err != nil {
+		return nil, err
+	}
+
+	httpResp, localErr := client.IndividualPDUSessionHSMFApi.
+		ReleasePduSession(context.Background(), pduSessionRef, releaseData)
+	defer closeHsmfResponse(httpResp)
+	if localErr == nil {
+		return nil, nil
+	} else if httpResp != nil {
+		if httpResp.Status != localErr.Error() {
+			return nil, localErr
+		}
+		problem, ok := localErr.(openapi.GenericOpenAPIError).Model().(models.ProblemDetails)
+		if !ok {
+			return nil, fmt.Errorf("ReleasePduSession failed with status %d", httpResp.StatusCode)
+		}
+		return &problem, nil
+	}
+	return nil, openapi.ReportError("ReleasePduSession failed[%s]", localErr.Error())
+}
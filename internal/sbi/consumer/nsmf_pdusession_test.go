@@ -0,0 +1,106 @@
+package consumer
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/h2non/gock.v1"
+
+	"bitbucket.org/free5gc-team/openapi"
+	"bitbucket.org/free5gc-team/openapi/models"
+)
+
+// multipartRelated builds the multipart/related body of the JSON data and the N1 SM information
+func multipartRelated(t *testing.T, jsonData interface{}, n1SmInfo []byte) (string, []byte) {
+	body := new(bytes.Buffer)
+	w := multipart.NewWriter(body)
+	part, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {contentTypeJson}})
+	require.NoError(t, err)
+	require.NoError(t, json.NewEncoder(part).Encode(jsonData))
+	part, err = w.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"application/vnd.3gpp.5gnas"},
+		"Content-Id":   {"n1SmInfoToUe"},
+	})
+	require.NoError(t, err)
+	_, err = part.Write(n1SmInfo)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return "multipart/related; boundary=" + w.Boundary(), body.Bytes()
+}
+
+func TestSendPostPduSessions(t *testing.T) {
+	openapi.InterceptH2CClient()
+	defer openapi.RestoreH2CClient()
+	defer gock.Off()
+
+	hSmfUri := "http://127.0.0.30:8000/nsmf-pdusession/v1"
+	location := hSmfUri + "/pdu-sessions/urn:uuid:1"
+	n1SmInfoToUe := []byte{0x2e, 0x01, 0x01, 0xc2}
+
+	contentType, body := multipartRelated(t, models.PduSessionCreatedData{
+		PduSessionId:  1,
+		HcnTunnelInfo: &models.TunnelInfo{Ipv4Addr: "10.100.0.1", GtpTeid: "00000010"},
+		N1SmInfoToUe:  &models.RefToBinaryData{ContentId: "n1SmInfoToUe"},
+	}, n1SmInfoToUe)
+	gock.New(hSmfUri).
+		Post("/pdu-sessions").
+		Reply(http.StatusCreated).
+		SetHeader("Location", location).
+		SetHeader("Content-Type", contentType).
+		Body(bytes.NewReader(body))
+	gock.New(hSmfUri).
+		Post("/pdu-sessions/urn:uuid:1/release").
+		Reply(http.StatusNoContent)
+	gock.New(hSmfUri).
+		Post("/pdu-sessions").
+		Reply(http.StatusForbidden).
+		JSON(models.PduSessionCreateError{
+			Error:     &models.ProblemDetails{Status: http.StatusForbidden, Cause: "DNN_DENIED"},
+			N1smCause: "1B",
+		})
+
+	request := models.PostPduSessionsRequest{
+		JsonData: &models.PduSessionCreateData{
+			Supi:          "imsi-208930000000001",
+			PduSessionId:  1,
+			Dnn:           "internet",
+			VcnTunnelInfo: &models.TunnelInfo{Ipv4Addr: "10.3.0.11", GtpTeid: "00000001"},
+		},
+		BinaryDataN1SmInfoFromUe: []byte{0x2e, 0x01, 0x01, 0xc1},
+	}
+	rsp, errRsp, rspLocation, err := SendPostPduSessions(hSmfUri, request)
+	require.NoError(t, err)
+	require.Nil(t, errRsp)
+	require.Equal(t, location, rspLocation)
+	require.Equal(t, "10.100.0.1", rsp.JsonData.HcnTunnelInfo.Ipv4Addr)
+	require.Equal(t, n1SmInfoToUe, rsp.BinaryDataN1SmInfoToUe)
+	require.Equal(t, n1SmInfoFromUeContentId, request.JsonData.N1SmInfoFromUe.ContentId)
+
+	problem, err := SendReleasePduSession(rspLocation, models.ReleaseData{})
+	require.NoError(t, err)
+	require.Nil(t, problem)
+
+	request.JsonData.Dnn = "ims"
+	rsp, errRsp, _, err = SendPostPduSessions(hSmfUri, request)
+	require.NoError(t, err)
+	require.Nil(t, rsp)
+	require.Equal(t, "DNN_DENIED", errRsp.JsonData.Error.Cause)
+	require.Equal(t, "1B", errRsp.JsonData.N1smCause)
+	require.True(t, gock.IsDone())
+}
+
+func TestHsmfPduSessionRef(t *testing.T) {
+	_, pduSessionRef, err := hsmfPduSessionRef("http://hsmf:8000/nsmf-pdusession/v1/pdu-sessions/urn:uuid:1")
+	require.NoError(t, err)
+	require.Equal(t, "urn:uuid:1", pduSessionRef)
+
+	_, _, err = hsmfPduSessionRef("http://hsmf:8000/nsmf-pdusession/v1")
+	require.Error(t, err)
+	_, _, err = hsmfPduSessionRef("http://hsmf:8000/nsmf-pdusession/v1/pdu-sessions/urn:uuid:1/modify")
+	require.Error(t, err)
+}
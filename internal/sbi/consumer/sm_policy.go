@@ -38,7 +38,8 @@ func SendSMPolicyAssociationCreate(smContext *smf_context.SMContext) (string, *m
 	smPolicyData.PduSessionType = nasConvert.PDUSessionTypeToModels(smContext.SelectedPDUSessionType)
 	smPolicyData.AccessType = smContext.AnType
 	smPolicyData.RatType = smContext.RatType
-	smPolicyData.Ipv4Address = smContext.PDUAddress.To4().String()
+	smPolicyData.Ipv4Address = smContext.UeIPv4Address()
+	smPolicyData.Ipv6AddressPrefix = smContext.UeIPv6Prefix()
 	smPolicyData.SubsSessAmbr = smContext.DnnConfiguration.SessionAmbr
 	smPolicyData.SubsDefQos = smContext.DnnConfiguration.Var5gQosProfile
 	smPolicyData.SliceInfo = smContext.SNssai
@@ -359,13 +360,47 @@ func buildPktFilterInfo(pf nasType.PacketFilter) (*models.PacketFilterInfo, erro
 	return pfInfo, nil
 }
 
+// SendSMPolicyAssociationUpdateByUsageReport reports the accumulated usage of the monitoring keys reaching
+// the thresholds to PCF, the returned decision carries the new thresholds of the monitoring keys
+func SendSMPolicyAssociationUpdateByUsageReport(
+	smContext *smf_context.SMContext, reports []models.AccuUsageReport,
+) (*models.SmPolicyDecision, error) {
+	if smContext.SMPolicyClient == nil {
+		return nil, errors.Errorf("smContext not selected PCF")
+	}
+
+	updateSMPolicy := models.SmPolicyUpdateContextData{
+		RepPolicyCtrlReqTriggers: []models.PolicyControlRequestTrigger{
+			models.PolicyControlRequestTrigger_US_RE,
+		},
+		AccuUsageReports: reports,
+	}
+	smPolicyDecision, rsp, err := smContext.SMPolicyClient.
+		DefaultApi.SmPoliciesSmPolicyIdUpdatePost(context.TODO(), smContext.SMPolicyID, updateSMPolicy)
+	defer func() {
+		if rsp != nil {
+			if closeErr := rsp.Body.Close(); closeErr != nil {
+				logger.PduSessLog.Errorf("rsp body close err: %v", closeErr)
+			}
+		}
+	}()
+	if err != nil {
+		return nil, fmt.Errorf("update sm policy [%s] association failed: %s", smContext.SMPolicyID, err)
+	}
+	return &smPolicyDecision, nil
+}
+
 func SendSMPolicyAssociationTermination(smContext *smf_context.SMContext) error {
 	if smContext.SMPolicyClient == nil {
 		return errors.Errorf("smContext not selected PCF")
 	}
 
+	// the accumulated usage of the monitoring keys is reported on the termination
+	deleteData := models.SmPolicyDeleteData{
+		AccuUsageReports: smContext.AccuUsageReports(true),
+	}
 	rsp, err := smContext.SMPolicyClient.DefaultApi.SmPoliciesSmPolicyIdDeletePost(
-		context.Background(), smContext.SMPolicyID, models.SmPolicyDeleteData{})
+		context.Background(), smContext.SMPolicyID, deleteData)
 	defer func() {
 		if rsp != nil {
 			if closeErr := rsp.Body.Close(); closeErr != nil {
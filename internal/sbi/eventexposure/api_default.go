@@ -13,24 +13,118 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+
+	"bitbucket.org/free5gc-team/openapi"
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+	"bitbucket.org/free5gc-team/smf/internal/sbi/producer"
+	"bitbucket.org/free5gc-team/util/httpwrapper"
 )
 
 // SubscriptionsPost -
 func SubscriptionsPost(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{})
+	var request models.NsmfEventExposure
+
+	reqBody, err := c.GetRawData()
+	if err != nil {
+		logger.PduSessLog.Errorln("GetRawData failed")
+		c.JSON(http.StatusInternalServerError, models.ProblemDetails{
+			Title:  "System failure",
+			Status: http.StatusInternalServerError,
+			Detail: err.Error(),
+			Cause:  "SYSTEM_FAILURE",
+		})
+		return
+	}
+
+	err = openapi.Deserialize(&request, reqBody, "application/json")
+	if err != nil {
+		logger.PduSessLog.Errorln("Deserialize request failed")
+		c.JSON(http.StatusBadRequest, models.ProblemDetails{
+			Title:  "Malformed request syntax",
+			Status: http.StatusBadRequest,
+			Detail: err.Error(),
+		})
+		return
+	}
+
+	req := httpwrapper.NewRequest(c.Request, request)
+	HTTPResponse := producer.HandleEventExposureSubscriptionCreate(req.Body.(models.NsmfEventExposure))
+
+	sendEventExposureResponse(c, HTTPResponse)
 }
 
 // SubscriptionsSubIdDelete -
 func SubscriptionsSubIdDelete(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{})
+	req := httpwrapper.NewRequest(c.Request, nil)
+	req.Params["subId"] = c.Params.ByName("subId")
+
+	HTTPResponse := producer.HandleEventExposureSubscriptionDelete(req.Params["subId"])
+
+	sendEventExposureResponse(c, HTTPResponse)
 }
 
 // SubscriptionsSubIdGet -
 func SubscriptionsSubIdGet(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{})
+	req := httpwrapper.NewRequest(c.Request, nil)
+	req.Params["subId"] = c.Params.ByName("subId")
+
+	HTTPResponse := producer.HandleEventExposureSubscriptionGet(req.Params["subId"])
+
+	sendEventExposureResponse(c, HTTPResponse)
 }
 
 // SubscriptionsSubIdPut -
 func SubscriptionsSubIdPut(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{})
+	var request models.NsmfEventExposure
+
+	reqBody, err := c.GetRawData()
+	if err != nil {
+		logger.PduSessLog.Errorln("GetRawData failed")
+		c.JSON(http.StatusInternalServerError, models.ProblemDetails{
+			Title:  "System failure",
+			Status: http.StatusInternalServerError,
+			Detail: err.Error(),
+			Cause:  "SYSTEM_FAILURE",
+		})
+		return
+	}
+
+	err = openapi.Deserialize(&request, reqBody, "application/json")
+	if err != nil {
+		logger.PduSessLog.Errorln("Deserialize request failed")
+		c.JSON(http.StatusBadRequest, models.ProblemDetails{
+			Title:  "Malformed request syntax",
+			Status: http.StatusBadRequest,
+			Detail: err.Error(),
+		})
+		return
+	}
+
+	req := httpwrapper.NewRequest(c.Request, request)
+	req.Params["subId"] = c.Params.ByName("subId")
+
+	HTTPResponse := producer.HandleEventExposureSubscriptionUpdate(
+		req.Params["subId"], req.Body.(models.NsmfEventExposure))
+
+	sendEventExposureResponse(c, HTTPResponse)
+}
+
+func sendEventExposureResponse(c *gin.Context, rsp *httpwrapper.Response) {
+	for key, val := range rsp.Header {
+		c.Header(key, val[0])
+	}
+
+	if rsp.Body == nil {
+		c.Status(rsp.Status)
+		return
+	}
+
+	resBody, err := openapi.Serialize(rsp.Body, "application/json")
+	if err != nil {
+		logger.PduSessLog.Errorln("Serialize failed")
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Data(rsp.Status, "application/json", resBody)
 }
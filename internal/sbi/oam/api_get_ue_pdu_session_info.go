@@ -16,3 +16,10 @@ func HTTPGetUEPDUSessionInfo(c *gin.Context) {
 
 	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
 }
+
+// HTTPQueryUEPDUSessionUsage queries the usage of the URRs of the PDU session from UPFs
+func HTTPQueryUEPDUSessionUsage(c *gin.Context) {
+	HTTPResponse := producer.HandleOAMQueryUEPDUSessionUsage(c.Params.ByName("smContextRef"))
+
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+}
@@ -0,0 +1,25 @@
+package oam
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"bitbucket.org/free5gc-team/smf/internal/sbi/producer"
+)
+
+func HTTPGetNotificationDeadLetters(c *gin.Context) {
+	HTTPResponse := producer.HandleOAMGetNotificationDeadLetters()
+
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+}
+
+func HTTPMuteNotification(c *gin.Context) {
+	HTTPResponse := producer.HandleOAMMuteNotification(c.Params.ByName("notifKey"))
+
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+}
+
+func HTTPUnmuteNotification(c *gin.Context) {
+	HTTPResponse := producer.HandleOAMUnmuteNotification(c.Params.ByName("notifKey"))
+
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+}
@@ -39,6 +39,10 @@ func AddService(engine *gin.Engine) *gin.RouterGroup {
 		switch route.Method {
 		case "GET":
 			group.GET(route.Pattern, route.HandlerFunc)
+		case "PUT":
+			group.PUT(route.Pattern, route.HandlerFunc)
+		case "DELETE":
+			group.DELETE(route.Pattern, route.HandlerFunc)
 		}
 	}
 	return group
@@ -62,4 +66,28 @@ var routes = Routes{
 		"/ue-pdu-session-info/:smContextRef",
 		HTTPGetUEPDUSessionInfo,
 	},
+	{
+		"Query UE PDU Session Usage",
+		"GET",
+		"/ue-pdu-session-info/:smContextRef/usage",
+		HTTPQueryUEPDUSessionUsage,
+	},
+	{
+		"Get Notification Dead Letters",
+		"GET",
+		"/notification-dead-letters",
+		HTTPGetNotificationDeadLetters,
+	},
+	{
+		"Mute Notification",
+		"PUT",
+		"/notification-mute/:notifKey",
+		HTTPMuteNotification,
+	},
+	{
+		"Unmute Notification",
+		"DELETE",
+		"/notification-mute/:notifKey",
+		HTTPUnmuteNotification,
+	},
 }
@@ -11,16 +11,82 @@ package pdusession
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+
+	"bitbucket.org/free5gc-team/openapi"
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+	"bitbucket.org/free5gc-team/smf/internal/sbi/producer"
+	"bitbucket.org/free5gc-team/util/httpwrapper"
 )
 
 // ReleasePduSession - Release
 func ReleasePduSession(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{})
+	logger.PduSessLog.Info("Receive Release PDU Session Request")
+	var request models.ReleaseData
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		problemDetail := "[Request Body] " + err.Error()
+		rsp := models.ProblemDetails{
+			Title:  "Malformed request syntax",
+			Status: http.StatusBadRequest,
+			Detail: problemDetail,
+		}
+		logger.PduSessLog.Errorln(problemDetail)
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+
+	req := httpwrapper.NewRequest(c.Request, request)
+	req.Params["pduSessionRef"] = c.Params.ByName("pduSessionRef")
+
+	pduSessionRef := req.Params["pduSessionRef"]
+	HTTPResponse := producer.HandlePDUSessionRelease(pduSessionRef, req.Body.(models.ReleaseData))
+
+	if HTTPResponse.Status == http.StatusNoContent {
+		c.Status(http.StatusNoContent)
+	} else {
+		c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+	}
 }
 
 // UpdatePduSession - Update (initiated by V-SMF)
 func UpdatePduSession(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{})
+	logger.PduSessLog.Info("Receive Update PDU Session Request")
+	var request models.UpdatePduSessionRequest
+	request.JsonData = new(models.HsmfUpdateData)
+
+	s := strings.Split(c.GetHeader("Content-Type"), ";")
+	var err error
+	switch s[0] {
+	case "application/json":
+		err = c.ShouldBindJSON(request.JsonData)
+	case "multipart/related":
+		err = c.ShouldBindWith(&request, openapi.MultipartRelatedBinding{})
+	}
+	if err != nil {
+		problemDetail := "[Request Body] " + err.Error()
+		rsp := models.ProblemDetails{
+			Title:  "Malformed request syntax",
+			Status: http.StatusBadRequest,
+			Detail: problemDetail,
+		}
+		logger.PduSessLog.Errorln(problemDetail)
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+
+	req := httpwrapper.NewRequest(c.Request, request)
+	req.Params["pduSessionRef"] = c.Params.ByName("pduSessionRef")
+
+	pduSessionRef := req.Params["pduSessionRef"]
+	HTTPResponse := producer.HandlePDUSessionUpdate(pduSessionRef, req.Body.(models.UpdatePduSessionRequest))
+
+	if HTTPResponse.Status < 300 {
+		c.Render(HTTPResponse.Status, openapi.MultipartRelatedRender{Data: HTTPResponse.Body})
+	} else {
+		c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+	}
 }
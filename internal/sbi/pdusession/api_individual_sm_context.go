@@ -10,6 +10,8 @@
 package pdusession
 
 import (
+	"bytes"
+	"encoding/json"
 	"log"
 	"net/http"
 	"strings"
@@ -54,7 +56,37 @@ func HTTPReleaseSmContext(c *gin.Context) {
 
 // RetrieveSmContext - Retrieve SM Context
 func RetrieveSmContext(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{})
+	logger.PduSessLog.Info("Receive Retrieve SM Context Request")
+	var retrieveData *models.SmContextRetrieveData
+	// the body is optional, ContentLength is -1 for a chunked body
+	requestBody, err := c.GetRawData()
+	if err != nil {
+		problemDetail := "[Request Body] " + err.Error()
+		logger.PduSessLog.Errorln(problemDetail)
+		c.JSON(http.StatusBadRequest, models.ProblemDetails{
+			Title:  "Malformed request syntax",
+			Status: http.StatusBadRequest,
+			Detail: problemDetail,
+		})
+		return
+	}
+	if len(bytes.TrimSpace(requestBody)) > 0 {
+		retrieveData = new(models.SmContextRetrieveData)
+		if err := json.Unmarshal(requestBody, retrieveData); err != nil {
+			problemDetail := "[Request Body] " + err.Error()
+			rsp := models.ProblemDetails{
+				Title:  "Malformed request syntax",
+				Status: http.StatusBadRequest,
+				Detail: problemDetail,
+			}
+			logger.PduSessLog.Errorln(problemDetail)
+			c.JSON(http.StatusBadRequest, rsp)
+			return
+		}
+	}
+
+	HTTPResponse := producer.HandlePDUSessionSMContextRetrieve(c.Params.ByName("smContextRef"), retrieveData)
+	c.JSON(HTTPResponse.Status, HTTPResponse.Body)
 }
 
 // HTTPUpdateSmContext - Update SM Context
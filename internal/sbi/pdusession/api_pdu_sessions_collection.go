@@ -11,11 +11,59 @@ package pdusession
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+
+	"bitbucket.org/free5gc-team/openapi"
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+	"bitbucket.org/free5gc-team/smf/internal/sbi/producer"
+	"bitbucket.org/free5gc-team/util/httpwrapper"
 )
 
 // PostPduSessions - Create
 func PostPduSessions(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{})
+	logger.PduSessLog.Info("Receive Create PDU Session Request")
+	var request models.PostPduSessionsRequest
+	request.JsonData = new(models.PduSessionCreateData)
+
+	s := strings.Split(c.GetHeader("Content-Type"), ";")
+	var err error
+	switch s[0] {
+	case "application/json":
+		err = c.ShouldBindJSON(request.JsonData)
+	case "multipart/related":
+		err = c.ShouldBindWith(&request, openapi.MultipartRelatedBinding{})
+	}
+	if err != nil {
+		problemDetail := "[Request Body] " + err.Error()
+		rsp := models.ProblemDetails{
+			Title:  "Malformed request syntax",
+			Status: http.StatusBadRequest,
+			Detail: problemDetail,
+		}
+		logger.PduSessLog.Errorln(problemDetail)
+		c.JSON(http.StatusBadRequest, rsp)
+		return
+	}
+
+	req := httpwrapper.NewRequest(c.Request, request)
+	HTTPResponse := producer.HandlePDUSessionCreate(req.Body.(models.PostPduSessionsRequest))
+	// Http Response to V-SMF
+	for key, val := range HTTPResponse.Header {
+		c.Header(key, val[0])
+	}
+	switch HTTPResponse.Status {
+	case http.StatusCreated,
+		http.StatusBadRequest,
+		http.StatusForbidden,
+		http.StatusNotFound,
+		http.StatusInternalServerError,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		c.Render(HTTPResponse.Status, openapi.MultipartRelatedRender{Data: HTTPResponse.Body})
+	default:
+		c.JSON(HTTPResponse.Status, HTTPResponse.Body)
+	}
 }
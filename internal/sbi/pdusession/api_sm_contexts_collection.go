@@ -14,9 +14,11 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 
 	"bitbucket.org/free5gc-team/openapi"
 	"bitbucket.org/free5gc-team/openapi/models"
+	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
 	"bitbucket.org/free5gc-team/smf/internal/logger"
 	"bitbucket.org/free5gc-team/smf/internal/sbi/producer"
 	"bitbucket.org/free5gc-team/util/httpwrapper"
@@ -29,11 +31,18 @@ func HTTPPostSmContexts(c *gin.Context) {
 
 	request.JsonData = new(models.SmContextCreateData)
 
+	// SM context retrieved from another SMF, carried in the JSON body without N1 SM message
+	var imported struct {
+		SmContext *smf_context.SmContext `json:"smContext"`
+	}
+
 	s := strings.Split(c.GetHeader("Content-Type"), ";")
 	var err error
 	switch s[0] {
 	case "application/json":
-		err = c.ShouldBindJSON(request.JsonData)
+		if err = c.ShouldBindBodyWith(request.JsonData, binding.JSON); err == nil {
+			err = c.ShouldBindBodyWith(&imported, binding.JSON)
+		}
 	case "multipart/related":
 		err = c.ShouldBindWith(&request, openapi.MultipartRelatedBinding{})
 	}
@@ -51,7 +60,12 @@ func HTTPPostSmContexts(c *gin.Context) {
 	}
 
 	req := httpwrapper.NewRequest(c.Request, request)
-	HTTPResponse := producer.HandlePDUSessionSMContextCreate(req.Body.(models.PostSmContextsRequest))
+	var HTTPResponse *httpwrapper.Response
+	if imported.SmContext != nil {
+		HTTPResponse = producer.HandlePDUSessionSMContextImport(request.JsonData, imported.SmContext)
+	} else {
+		HTTPResponse = producer.HandlePDUSessionSMContextCreate(req.Body.(models.PostSmContextsRequest))
+	}
 	// Http Response to AMF
 	for key, val := range HTTPResponse.Header {
 		c.Header(key, val[0])
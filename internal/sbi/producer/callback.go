@@ -2,12 +2,17 @@ package producer
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 
 	"bitbucket.org/free5gc-team/openapi/Nsmf_EventExposure"
 	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/pfcp"
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
 	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
 	"bitbucket.org/free5gc-team/smf/internal/logger"
+	"bitbucket.org/free5gc-team/smf/internal/pfcp/handler"
+	pfcp_message "bitbucket.org/free5gc-team/smf/internal/pfcp/message"
 	"bitbucket.org/free5gc-team/util/httpwrapper"
 )
 
@@ -48,20 +53,61 @@ func HandleSMPolicyUpdateNotify(smContextRef string, request models.SmPolicyNoti
 		return httpwrapper.NewResponse(http.StatusBadRequest, nil, nil)
 	}
 
-	smContext.SendUpPathChgNotification("EARLY", SendUpPathChgEventExposureNotification)
+	smContext.SendUpPathChgNotification("EARLY", SendEventExposureNotification)
 
 	ActivateUPFSession(smContext, nil)
 
-	smContext.SendUpPathChgNotification("LATE", SendUpPathChgEventExposureNotification)
+	smContext.SendUpPathChgNotification("LATE", SendEventExposureNotification)
+
+	smContext.SendEventExposureNotification(SendEventExposureNotification)
 
 	smContext.PostRemoveDataPath()
 
 	return httpwrapper.NewResponse(http.StatusNoContent, nil, nil)
 }
 
-func SendUpPathChgEventExposureNotification(
+// HandleN1N2TransferFailureNotification handles the failure of the paging for the downlink data notified by AMF,
+// the buffered downlink data is dropped and the following pagings are backed off (TS 23.502 4.2.3.3 5)
+func HandleN1N2TransferFailureNotification(
+	smContextRef string, notification models.N1N2MsgTxfrFailureNotification,
+) *httpwrapper.Response {
+	logger.PduSessLog.Infoln("In HandleN1N2TransferFailureNotification")
+	smContext := smf_context.GetSMContextByRef(smContextRef)
+	if smContext == nil {
+		logger.PduSessLog.Errorf("SMContext[%s] not found", smContextRef)
+		return httpwrapper.NewResponse(http.StatusNotFound, nil, nil)
+	}
+
+	smContext.SMLock.Lock()
+	smContext.Log.Warnf("N1N2 message transfer failed: %s", notification.Cause)
+	handler.PagingFailed(smContext)
+
+	var anUPF *smf_context.UPF
+	if smContext.DLBuffer != nil {
+		smContext.DLBuffer.Drain()
+	} else if defaultPath := smContext.Tunnel.DataPathPool.GetDefaultPath(); defaultPath != nil &&
+		defaultPath.FirstDPNode != nil {
+		anUPF = defaultPath.FirstDPNode.UPF
+	}
+	smContext.SMLock.Unlock()
+
+	// the PDU session isn't locked while waiting for the response of AN UPF
+	if anUPF != nil {
+		rcvMsg, err := pfcp_message.SendPfcpSessionModificationRequestToDropBuffered(anUPF, smContext)
+		if err != nil {
+			smContext.Log.Warnf("Request AN UPF to drop buffered data failed: %+v", err)
+		} else if rsp := rcvMsg.PfcpMessage.Body.(pfcp.PFCPSessionModificationResponse); rsp.Cause == nil ||
+			rsp.Cause.CauseValue != pfcpType.CauseRequestAccepted {
+			smContext.Log.Warnln("Received PFCP Session Modification Not Accepted Response from AN UPF")
+		}
+	}
+
+	return httpwrapper.NewResponse(http.StatusNoContent, nil, nil)
+}
+
+func SendEventExposureNotification(
 	uri string, notification *models.NsmfEventExposureNotification,
-) {
+) error {
 	configuration := Nsmf_EventExposure.NewConfiguration()
 	client := Nsmf_EventExposure.NewAPIClient(configuration)
 	_, httpResponse, err := client.
@@ -70,13 +116,13 @@ func SendUpPathChgEventExposureNotification(
 	if err != nil {
 		if httpResponse != nil {
 			logger.PduSessLog.Warnf("SMF Event Exposure Notification Error[%s]", httpResponse.Status)
-		} else {
-			logger.PduSessLog.Warnf("SMF Event Exposure Notification Failed[%s]", err.Error())
+			return fmt.Errorf("SMF Event Exposure Notification Error[%s]", httpResponse.Status)
 		}
-		return
+		logger.PduSessLog.Warnf("SMF Event Exposure Notification Failed[%s]", err.Error())
+		return err
 	} else if httpResponse == nil {
 		logger.PduSessLog.Warnln("SMF Event Exposure Notification Failed[HTTP Response is nil]")
-		return
+		return fmt.Errorf("SMF Event Exposure Notification Failed[HTTP Response is nil]")
 	}
 	defer func() {
 		if rspCloseErr := httpResponse.Body.Close(); rspCloseErr != nil {
@@ -85,7 +131,8 @@ func SendUpPathChgEventExposureNotification(
 	}()
 	if httpResponse.StatusCode != http.StatusOK && httpResponse.StatusCode != http.StatusNoContent {
 		logger.PduSessLog.Warnf("SMF Event Exposure Notification Failed")
-	} else {
-		logger.PduSessLog.Tracef("SMF Event Exposure Notification Success")
+		return fmt.Errorf("SMF Event Exposure Notification Failed[%s]", httpResponse.Status)
 	}
+	logger.PduSessLog.Tracef("SMF Event Exposure Notification Success")
+	return nil
 }
@@ -0,0 +1,240 @@
+package producer
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"bitbucket.org/free5gc-team/nas/nasMessage"
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/pfcp"
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
+	"bitbucket.org/free5gc-team/smf/internal/pfcp/handler"
+	pfcp_message "bitbucket.org/free5gc-team/smf/internal/pfcp/message"
+	"bitbucket.org/free5gc-team/smf/internal/sbi/consumer"
+)
+
+// startCharging creates the charging data of the PDU session on CHF for online charging,
+// the PDU session is rejected if CHF grants no quota. The quota URRs are created with the data path
+func startCharging(smContext *smf_context.SMContext) error {
+	if smf_context.GetSelf().Charging == nil {
+		return nil
+	}
+	if err := smContext.StartChargingSession(); err != nil {
+		return err
+	}
+	rsp, err := consumer.SendConvergedChargingCreate(smContext)
+	if err != nil {
+		smContext.ChargingSession = nil
+		return errors.Wrap(err, "charging data create")
+	}
+	for _, q := range smContext.ChargingSession.Quotas {
+		info := unitInformation(rsp, q.RatingGroup)
+		if info == nil || info.GrantedUnit == nil {
+			return errors.Errorf("no quota granted for rating group %d", q.RatingGroup)
+		}
+		q.Grant(info)
+		smContext.Log.Infof("Rating group %d is granted volume %d bytes, time %s",
+			q.RatingGroup, q.VolumeQuota, q.TimeQuota)
+	}
+	return nil
+}
+
+// releaseCharging reports the final usage to CHF and releases the charging data of the PDU session
+func releaseCharging(smContext *smf_context.SMContext) {
+	if smContext.ChargingSession == nil {
+		return
+	}
+	if err := consumer.SendConvergedChargingRelease(smContext); err != nil {
+		smContext.Log.Errorf("Charging data release failed: %s", err)
+	}
+	smContext.ChargingSession = nil
+}
+
+// ReportChargingUsage reports the usage of the quotas exhausted, reaching the thresholds or expired to CHF,
+// and updates the quota URRs on UPFs with the units granted. The final unit action is taken when the final
+// units are used up. It reports whether the PDU session is to be terminated
+func ReportChargingUsage(smContext *smf_context.SMContext) (terminate bool) {
+	session := smContext.ChargingSession
+	if session == nil || !smContext.ChargingReportPending() {
+		return false
+	}
+
+	// the usage is reset by the report, so the final units used up are checked before it
+	finalUnitsUsed := make(map[*smf_context.ChargingQuota]models.FinalUnitAction)
+	exhausted := make(map[*smf_context.ChargingQuota]bool)
+	for _, q := range session.Quotas {
+		exhausted[q] = q.Exhausted()
+		if exhausted[q] && q.FinalUnitAction != "" {
+			finalUnitsUsed[q] = q.FinalUnitAction
+		}
+	}
+
+	rsp, err := consumer.SendConvergedChargingUpdate(smContext)
+	if err != nil {
+		smContext.Log.Errorf("Charging data update failed: %s", err)
+		// the exhausted quota can't be replenished
+		for _, isExhausted := range exhausted {
+			if isExhausted {
+				return true
+			}
+		}
+		return false
+	}
+
+	rules := make(map[*smf_context.UPF]*smf_context.ChargingRules)
+	for _, q := range session.Quotas {
+		redirect := false
+		if action, ok := finalUnitsUsed[q]; ok {
+			if action != models.FinalUnitAction_REDIRECT {
+				smContext.Log.Infof("Final units of rating group %d are used up, final unit action: %s",
+					q.RatingGroup, action)
+				return true
+			}
+			smContext.Log.Infof("Final units of rating group %d are used up, redirect the traffic", q.RatingGroup)
+			redirect = true
+		} else if info := unitInformation(rsp, q.RatingGroup); info != nil && info.GrantedUnit != nil {
+			q.Grant(info)
+		} else if exhausted[q] {
+			smContext.Log.Infof("No quota granted for exhausted rating group %d", q.RatingGroup)
+			return true
+		} else {
+			continue
+		}
+		for upf, r := range smContext.ApplyChargingQuota(q, redirect) {
+			if rules[upf] == nil {
+				rules[upf] = &smf_context.ChargingRules{}
+			}
+			rules[upf].URRs = append(rules[upf].URRs, r.URRs...)
+			rules[upf].FARs = append(rules[upf].FARs, r.FARs...)
+		}
+	}
+
+	if len(rules) == 0 {
+		return false
+	}
+	resChan := make(chan SendPfcpResult)
+	for upf, r := range rules {
+		go modifyExistingPfcpSession(smContext, &PFCPState{upf: upf, farList: r.FARs, urrList: r.URRs}, resChan)
+	}
+	for i := 0; i < len(rules); i++ {
+		if res := <-resChan; res.Status == smf_context.SessionUpdateFailed {
+			smContext.Log.Warnf("Update quota URRs failed: %+v", res.Err)
+		}
+	}
+	close(resChan)
+	return false
+}
+
+// HandleChargingReport reports the usage of the PDU session to CHF when UPF reports the quota is exhausted,
+// reaches the threshold or expires. The PDU session is released if no more units are granted
+func HandleChargingReport(smContext *smf_context.SMContext) {
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	if smContext.State() != smf_context.Active {
+		return
+	}
+	if ReportChargingUsage(smContext) {
+		smContext.Log.Infoln("Release PDU session as the units granted by CHF are used up")
+		ReleasePDUSession(smContext, nil, nasMessage.Cause5GSMRegularDeactivation)
+	}
+}
+
+func unitInformation(rsp *models.ChargingDataResponse, ratingGroup uint32) *models.MultipleUnitInformation {
+	for i := range rsp.MultipleUnitInformation {
+		if info := &rsp.MultipleUnitInformation[i]; uint32(info.RatingGroup) == ratingGroup {
+			return info
+		}
+	}
+	return nil
+}
+
+// QueryURRUsage queries the usage of all the URRs of the PDU session from its UPFs in parallel
+// by PFCP Session Modification with Query URR. The usage reported is recorded in the SM context and
+// reported to CHF and PCF like the usage reports of UPFs, as UPFs reset the measurement once reported.
+// It returns the UPFs whose usage isn't recorded. The SM context must not be locked by the caller
+// as it's unlocked while waiting for the responses of UPFs
+func QueryURRUsage(smContext *smf_context.SMContext) (failedUPFs []string) {
+	type urrQuery struct {
+		nodeIP     string
+		nodeID     pfcpType.NodeID
+		localSEID  uint64
+		remoteSEID uint64
+		upf        *smf_context.UPF
+		urrIDs     []uint32
+		rsp        *pfcp.PFCPSessionModificationResponse
+	}
+
+	// the PFCP sessions are copied as they're changed by the other procedures while unlocked
+	smContext.SMLock.Lock()
+	var queries []*urrQuery
+	for nodeIP, pfcpCtx := range smContext.PFCPContext {
+		urrIDs := pfcpCtx.URRIDs()
+		if len(urrIDs) == 0 {
+			continue
+		}
+		upf := smf_context.RetrieveUPFNodeByNodeID(pfcpCtx.NodeID)
+		if upf == nil {
+			smContext.Log.Warnf("Query URR: UPF[%s] not found", nodeIP)
+			failedUPFs = append(failedUPFs, nodeIP)
+			continue
+		}
+		queries = append(queries, &urrQuery{
+			nodeIP:     nodeIP,
+			nodeID:     pfcpCtx.NodeID,
+			localSEID:  pfcpCtx.LocalSEID,
+			remoteSEID: pfcpCtx.RemoteSEID,
+			upf:        upf,
+			urrIDs:     urrIDs,
+		})
+	}
+	smContext.SMLock.Unlock()
+
+	var wg sync.WaitGroup
+	for _, q := range queries {
+		wg.Add(1)
+		go func(q *urrQuery) {
+			defer wg.Done()
+			rcvMsg, err := pfcp_message.SendPfcpSessionModificationRequestToQueryURR(
+				q.upf, q.localSEID, q.remoteSEID, q.urrIDs)
+			if err != nil {
+				smContext.Log.Warnf("Query URR of UPF[%s] failed: %+v", q.nodeIP, err)
+				return
+			}
+			rsp := rcvMsg.PfcpMessage.Body.(pfcp.PFCPSessionModificationResponse)
+			if rsp.Cause == nil || rsp.Cause.CauseValue != pfcpType.CauseRequestAccepted {
+				smContext.Log.Warnf("Query URR of UPF[%s] not accepted", q.nodeIP)
+				return
+			}
+			q.rsp = &rsp
+		}(q)
+	}
+	wg.Wait()
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+	active := smContext.State() == smf_context.Active
+	for _, q := range queries {
+		if q.rsp == nil {
+			failedUPFs = append(failedUPFs, q.nodeIP)
+			continue
+		}
+		// the PDU session is released or the PFCP session is replaced while the usage is queried
+		if pfcpCtx := smContext.PFCPContext[q.nodeIP]; !active || pfcpCtx == nil || pfcpCtx.LocalSEID != q.localSEID {
+			smContext.Log.Warnf("Query URR of UPF[%s]: PFCP session is released", q.nodeIP)
+			failedUPFs = append(failedUPFs, q.nodeIP)
+			continue
+		}
+		// the usage is recorded as UPF measures the usage from the report on
+		handler.HandleReports(nil, q.rsp.UsageReport, nil, smContext, q.nodeID)
+	}
+	if active {
+		handler.DispatchUsageReports(smContext)
+	}
+
+	sort.Strings(failedUPFs)
+	return failedUPFs
+}
@@ -11,6 +11,7 @@ import (
 	"bitbucket.org/free5gc-team/pfcp/pfcpUdp"
 	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
 	"bitbucket.org/free5gc-team/smf/internal/logger"
+	"bitbucket.org/free5gc-team/smf/internal/n4u"
 	"bitbucket.org/free5gc-team/smf/internal/pfcp/handler"
 	pfcp_message "bitbucket.org/free5gc-team/smf/internal/pfcp/message"
 )
@@ -94,8 +95,50 @@ func ActivateUPFSession(
 		}
 	}
 
-	waitAllPfcpRsp(smContext, len(pfcpPool), resChan, notifyUeHander)
+	waitAllPfcpRsp(smContext, len(pfcpPool), resChan, func(smContext *smf_context.SMContext, success bool) {
+		// the N9 FARs are updated after the neighbouring UPFs allocate the F-TEIDs of the PDRs
+		if success {
+			success = updateFARsToCreatedFTEIDs(smContext)
+		}
+		if notifyUeHander != nil {
+			notifyUeHander(smContext, success)
+		}
+	})
+	close(resChan)
+}
+
+// updateFARsToCreatedFTEIDs sends the FARs pointed to the F-TEIDs allocated by UPFs with F-TEID CHOOSE,
+// it reports whether all the PFCP Session Modifications succeed
+func updateFARsToCreatedFTEIDs(smContext *smf_context.SMContext) bool {
+	updatedFARs := smContext.UpdateFARsToCreatedFTEIDs()
+	if len(updatedFARs) == 0 {
+		return true
+	}
+
+	resChan := make(chan SendPfcpResult)
+	for upf, farList := range updatedFARs {
+		go modifyExistingPfcpSession(smContext, &PFCPState{upf: upf, farList: farList}, resChan)
+	}
+	success := true
+	for i := 0; i < len(updatedFARs); i++ {
+		if res := <-resChan; res.Status == smf_context.SessionUpdateFailed {
+			success = false
+		}
+	}
 	close(resChan)
+	return success
+}
+
+// createdFTEIDs returns the F-TEIDs allocated by UPF for the PDRs with F-TEID CHOOSE
+func createdFTEIDs(createdPDRs []*pfcp.CreatedPDR) map[uint16]*pfcpType.FTEID {
+	fteids := make(map[uint16]*pfcpType.FTEID)
+	for _, createdPDR := range createdPDRs {
+		if createdPDR == nil || createdPDR.PDRID == nil || createdPDR.LocalFTEID == nil {
+			continue
+		}
+		fteids[createdPDR.PDRID.RuleId] = createdPDR.LocalFTEID
+	}
+	return fteids
 }
 
 func establishPfcpSession(smContext *smf_context.SMContext,
@@ -120,10 +163,13 @@ func establishPfcpSession(smContext *smf_context.SMContext,
 		NodeIDtoIP := rsp.NodeID.ResolveNodeIdToIp().String()
 		pfcpSessionCtx := smContext.PFCPContext[NodeIDtoIP]
 		pfcpSessionCtx.RemoteSEID = rsp.UPFSEID.Seid
+		// the PFCP session is deleted by the PFCP Session Set Deletion of UPF with the FQ-CSID
+		pfcpSessionCtx.RemoteFQCSID = smf_context.FQCSIDFromPFCP(rsp.PGWUFQCSID)
 	}
 
 	if rsp.Cause != nil && rsp.Cause.CauseValue == pfcpType.CauseRequestAccepted {
 		logger.PduSessLog.Infoln("Received PFCP Session Establishment Accepted Response")
+		smContext.SetCreatedFTEIDs(state.upf, createdFTEIDs(rsp.CreatedPDR))
 		resCh <- SendPfcpResult{
 			Status: smf_context.SessionEstablishSuccess,
 			RcvMsg: rcvMsg,
@@ -159,15 +205,17 @@ func modifyExistingPfcpSession(
 
 	rsp := rcvMsg.PfcpMessage.Body.(pfcp.PFCPSessionModificationResponse)
 	if rsp.Cause != nil && rsp.Cause.CauseValue == pfcpType.CauseRequestAccepted {
-		resCh <- SendPfcpResult{
-			Status: smf_context.SessionUpdateSuccess,
-			RcvMsg: rcvMsg,
-		}
+		smContext.SetCreatedFTEIDs(state.upf, createdFTEIDs(rsp.CreatedPDR))
+		// the usage is recorded before the result, the usage of the PDU session is reported after it
 		if rsp.UsageReport != nil {
 			SEID := rcvMsg.PfcpMessage.Header.SEID
 			upfNodeID := smContext.GetNodeIDByLocalSEID(SEID)
 			handler.HandleReports(nil, rsp.UsageReport, nil, smContext, upfNodeID)
 		}
+		resCh <- SendPfcpResult{
+			Status: smf_context.SessionUpdateSuccess,
+			RcvMsg: rcvMsg,
+		}
 	} else {
 		resCh <- SendPfcpResult{
 			Status: smf_context.SessionUpdateFailed,
@@ -202,6 +250,12 @@ func waitAllPfcpRsp(
 func EstHandler(smContext *smf_context.SMContext, success bool) {
 	if success {
 		sendPDUSessionEstablishmentAccept(smContext)
+		smContext.StartChargingRecord()
+		if smContext.PDUAddress != nil {
+			smContext.BuildEventExposureNotification(models.SmfEvent_UE_IP_CH,
+				smContext.UeIPChangeNotification(false))
+		}
+		smContext.BuildEventExposureNotification(models.SmfEvent_PDU_SES_EST, models.EventNotification{})
 	} else {
 		// TODO: set appropriate 5GSM cause according to PFCP cause value
 		sendPDUSessionEstablishmentReject(smContext, nasMessage.Cause5GSMNetworkFailure)
@@ -216,7 +270,7 @@ func sendPDUSessionEstablishmentReject(
 	nasErrorCause uint8,
 ) {
 	smNasBuf, err := smf_context.BuildGSMPDUSessionEstablishmentReject(
-		smContext, nasMessage.Cause5GSMNetworkFailure)
+		smContext, nasErrorCause)
 	if err != nil {
 		logger.PduSessLog.Errorf("Build GSM PDUSessionEstablishmentReject failed: %s", err)
 		return
@@ -264,6 +318,12 @@ func sendPDUSessionEstablishmentAccept(
 		return
 	}
 
+	sendPDUSessionResourceSetup(smContext, smNasBuf)
+}
+
+// sendPDUSessionResourceSetup sends the PDU Session Establishment Accept and
+// the PDU Session Resource Setup Request Transfer to AMF
+func sendPDUSessionResourceSetup(smContext *smf_context.SMContext, smNasBuf []byte) {
 	n2Pdu, err := smf_context.BuildPDUSessionResourceSetupRequestTransfer(smContext)
 	if err != nil {
 		logger.PduSessLog.Errorf("Build PDUSessionResourceSetupRequestTransfer failed: %s", err)
@@ -341,6 +401,7 @@ func updateAnUpfPfcpSession(
 	}
 
 	logger.PduSessLog.Info("Received PFCP Session Modification Accepted Response from AN UPF")
+	smContext.SetCreatedFTEIDs(ANUPF.UPF, createdFTEIDs(rsp.CreatedPDR))
 
 	if smf_context.GetSelf().ULCLSupport && smContext.BPManager != nil {
 		if smContext.BPManager.BPStatus == smf_context.UnInitialized {
@@ -355,6 +416,12 @@ func updateAnUpfPfcpSession(
 }
 
 func ReleaseTunnel(smContext *smf_context.SMContext) []SendPfcpResult {
+	return ReleaseTunnelExcept(smContext, nil)
+}
+
+// ReleaseTunnelExcept deletes the PFCP sessions of the PDU session except the one on releasedUPF,
+// which has deleted it already, e.g. the UPF failed or released, and its rules are only removed locally
+func ReleaseTunnelExcept(smContext *smf_context.SMContext, releasedUPF *smf_context.UPF) []SendPfcpResult {
 	resChan := make(chan SendPfcpResult)
 
 	deletedPFCPNode := make(map[string]bool)
@@ -370,6 +437,9 @@ func ReleaseTunnel(smContext *smf_context.SMContext) []SendPfcpResult {
 				logger.PduSessLog.Error(err)
 				continue
 			}
+			if releasedUPF != nil && node.UPF == releasedUPF {
+				continue
+			}
 			if _, exist := deletedPFCPNode[curUPFID]; !exist {
 				go deletePfcpSession(node.UPF, smContext, resChan)
 				deletedPFCPNode[curUPFID] = true
@@ -402,14 +472,15 @@ func deletePfcpSession(upf *smf_context.UPF, ctx *smf_context.SMContext, resCh c
 	rsp := rcvMsg.PfcpMessage.Body.(pfcp.PFCPSessionDeletionResponse)
 	if rsp.Cause != nil && rsp.Cause.CauseValue == pfcpType.CauseRequestAccepted {
 		logger.PduSessLog.Info("Received PFCP Session Deletion Accepted Response")
-		resCh <- SendPfcpResult{
-			Status: smf_context.SessionReleaseSuccess,
-		}
+		// the final usage is recorded before the result, it's reported when the PDU session is released
 		if rsp.UsageReport != nil {
 			SEID := rcvMsg.PfcpMessage.Header.SEID
 			upfNodeID := ctx.GetNodeIDByLocalSEID(SEID)
 			handler.HandleReports(nil, nil, rsp.UsageReport, ctx, upfNodeID)
 		}
+		resCh <- SendPfcpResult{
+			Status: smf_context.SessionReleaseSuccess,
+		}
 	} else {
 		logger.PduSessLog.Warn("Received PFCP Session Deletion Not Accepted Response")
 		resCh <- SendPfcpResult{
@@ -418,3 +489,26 @@ func deletePfcpSession(upf *smf_context.UPF, ctx *smf_context.SMContext, resCh c
 		}
 	}
 }
+
+// sendBufferedDownlinkData sends the downlink data buffered in SMF to AN UPF after the UP connection is activated
+func sendBufferedDownlinkData(smContext *smf_context.SMContext) {
+	b := smContext.DLBuffer
+	if b == nil {
+		return
+	}
+	packets := b.Drain()
+	if len(packets) == 0 {
+		return
+	}
+	fteid := b.PDR.PDI.LocalFTeid
+	if fteid == nil || fteid.Ch {
+		smContext.Log.Warnf("F-TEID of DL buffer is not allocated, drop %d buffered packets", len(packets))
+		return
+	}
+	smContext.Log.Infof("Send %d buffered packets to AN UPF", len(packets))
+	for _, packet := range packets {
+		if err := n4u.Send(smf_context.FTEIDIP(fteid), fteid.Teid, packet); err != nil {
+			smContext.Log.Warnf("Send buffered packet failed: %+v", err)
+		}
+	}
+}
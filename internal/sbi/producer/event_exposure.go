@@ -0,0 +1,89 @@
+package producer
+
+import (
+	"fmt"
+	"net/http"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+	"bitbucket.org/free5gc-team/smf/pkg/factory"
+	"bitbucket.org/free5gc-team/util/httpwrapper"
+)
+
+func HandleEventExposureSubscriptionCreate(request models.NsmfEventExposure) *httpwrapper.Response {
+	logger.PduSessLog.Infoln("In HandleEventExposureSubscriptionCreate")
+
+	sub, err := smf_context.NewEventExposureSubscription(&request)
+	if err != nil {
+		logger.PduSessLog.Warnf("Create event exposure subscription failed: %v", err)
+		return eventExposureBadRequest(err)
+	}
+	sub.Activate(SendEventExposureNotification)
+
+	self := smf_context.GetSelf()
+	location := fmt.Sprintf("%s://%s:%d%s/subscriptions/%s",
+		self.URIScheme, self.RegisterIPv4, self.SBIPort, factory.SmfEventExposureResUriPrefix, sub.SubId)
+
+	return &httpwrapper.Response{
+		Header: http.Header{
+			"Location": {location},
+		},
+		Status: http.StatusCreated,
+		Body:   *sub.NsmfEventExposure,
+	}
+}
+
+func HandleEventExposureSubscriptionGet(subId string) *httpwrapper.Response {
+	logger.PduSessLog.Infoln("In HandleEventExposureSubscriptionGet")
+
+	sub := smf_context.GetEventExposureSubscription(subId)
+	if sub == nil {
+		return eventExposureNotFound(subId)
+	}
+	return httpwrapper.NewResponse(http.StatusOK, nil, *sub.NsmfEventExposure)
+}
+
+func HandleEventExposureSubscriptionUpdate(subId string, request models.NsmfEventExposure) *httpwrapper.Response {
+	logger.PduSessLog.Infoln("In HandleEventExposureSubscriptionUpdate")
+
+	if smf_context.GetEventExposureSubscription(subId) == nil {
+		return eventExposureNotFound(subId)
+	}
+
+	sub, err := smf_context.ReplaceEventExposureSubscription(subId, &request)
+	if err != nil {
+		logger.PduSessLog.Warnf("Update event exposure subscription[%s] failed: %v", subId, err)
+		return eventExposureBadRequest(err)
+	}
+	return httpwrapper.NewResponse(http.StatusOK, nil, *sub.NsmfEventExposure)
+}
+
+func HandleEventExposureSubscriptionDelete(subId string) *httpwrapper.Response {
+	logger.PduSessLog.Infoln("In HandleEventExposureSubscriptionDelete")
+
+	if !smf_context.RemoveEventExposureSubscription(subId) {
+		return eventExposureNotFound(subId)
+	}
+	return httpwrapper.NewResponse(http.StatusNoContent, nil, nil)
+}
+
+func eventExposureBadRequest(err error) *httpwrapper.Response {
+	problemDetails := &models.ProblemDetails{
+		Title:  "Invalid Subscription",
+		Status: http.StatusBadRequest,
+		Detail: err.Error(),
+		Cause:  "MANDATORY_IE_INCORRECT",
+	}
+	return httpwrapper.NewResponse(http.StatusBadRequest, nil, problemDetails)
+}
+
+func eventExposureNotFound(subId string) *httpwrapper.Response {
+	problemDetails := &models.ProblemDetails{
+		Title:  "Subscription Not Found",
+		Status: http.StatusNotFound,
+		Detail: fmt.Sprintf("subscription[%s] is not found", subId),
+		Cause:  "SUBSCRIPTION_NOT_FOUND",
+	}
+	return httpwrapper.NewResponse(http.StatusNotFound, nil, problemDetails)
+}
@@ -33,6 +33,17 @@ func HandlePDUSessionEstablishmentRequest(
 	// Retrieve PTI (Procedure transaction identity)
 	smCtx.Pti = req.GetPTI()
 
+	// DN-specific identity of UE for the secondary authentication by DN-AAA
+	if req.SMPDUDNRequestContainer != nil {
+		smCtx.DNSpecificIdentity = string(req.SMPDUDNRequestContainer.GetDNSpecificIdentity())
+	}
+
+	var requestedSSCMode uint8
+	if req.SSCMode != nil {
+		requestedSSCMode = req.SSCMode.GetSSCModeValue()
+	}
+	smCtx.SelectSSCMode(requestedSSCMode)
+
 	// Retrieve MaxIntegrityProtectedDataRate of UE for UP Security
 	switch req.GetMaximumDataRatePerUEForUserPlaneIntegrityProtectionForUpLink() {
 	case 0x00:
@@ -55,13 +66,24 @@ func HandlePDUSessionEstablishmentRequest(
 		requestedPDUSessionType := req.PDUSessionType.GetPDUSessionTypeValue()
 		if err := smCtx.IsAllowedPDUSessionType(requestedPDUSessionType); err != nil {
 			logger.CtxLog.Errorf("%s", err)
+			if requestedPDUSessionType == nasMessage.PDUSessionTypeEthernet ||
+				requestedPDUSessionType == nasMessage.PDUSessionTypeUnstructured {
+				return &GSMError{
+					GSMCause: nasMessage.Cause5GSMUnknownPDUSessionType,
+				}
+			}
+			if smCtx.SupportedPDUSessionType() == "IPv6" {
+				return &GSMError{
+					GSMCause: nasMessage.Cause5GSMPDUSessionTypeIPv6OnlyAllowed,
+				}
+			}
 			return &GSMError{
 				GSMCause: nasMessage.Cause5GSMPDUSessionTypeIPv4OnlyAllowed,
 			}
 		}
 	} else {
 		// Set to default supported PDU Session Type
-		switch smf_context.GetSelf().SupportedPDUSessionType {
+		switch smCtx.SupportedPDUSessionType() {
 		case "IPv4":
 			smCtx.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4
 		case "IPv6":
@@ -90,7 +112,7 @@ func HandlePDUSessionEstablishmentRequest(
 			logger.GsmLog.Traceln("Container Length: ", container.LengthOfContents)
 			switch container.ProtocolOrContainerID {
 			case nasMessage.PCSCFIPv6AddressRequestUL:
-				logger.GsmLog.Infoln("Didn't Implement container type PCSCFIPv6AddressRequestUL")
+				smCtx.ProtocolConfigurationOptions.PCSCFIPv6Request = true
 			case nasMessage.IMCNSubsystemSignalingFlagUL:
 				logger.GsmLog.Infoln("Didn't Implement container type IMCNSubsystemSignalingFlagUL")
 			case nasMessage.DNSServerIPv6AddressRequestUL:
@@ -144,7 +166,7 @@ func HandlePDUSessionEstablishmentRequest(
 			case nasMessage.EthernetFramePayloadMTURequestUL:
 				logger.GsmLog.Infoln("Didn't Implement container type EthernetFramePayloadMTURequestUL")
 			case nasMessage.UnstructuredLinkMTURequestUL:
-				logger.GsmLog.Infoln("Didn't Implement container type UnstructuredLinkMTURequestUL")
+				smCtx.ProtocolConfigurationOptions.UnstructuredLinkMTURequest = true
 			case nasMessage.I5GSMCauseValueUL:
 				logger.GsmLog.Infoln("Didn't Implement container type 5GSMCauseValueUL")
 			case nasMessage.QoSRulesWithTheLengthOfTwoOctetsSupportIndicatorUL:
@@ -2,7 +2,9 @@ package producer
 
 import (
 	"net/http"
+	"sort"
 	"strconv"
+	"time"
 
 	"bitbucket.org/free5gc-team/openapi/models"
 	"bitbucket.org/free5gc-team/smf/internal/context"
@@ -54,3 +56,94 @@ func HandleOAMGetUEPDUSessionInfo(smContextRef string) *httpwrapper.Response {
 	}
 	return httpResponse
 }
+
+// PDUSessionUsage is the usage measured by the URRs of the PDU session
+type PDUSessionUsage struct {
+	Supi         string
+	PDUSessionID string
+	URRs         []URRUsage
+	// FailedUPFs didn't report the usage queried, the usage on them is the one reported before
+	FailedUPFs []string
+}
+
+// URRUsage is the usage measured by the URR since the PDU session is established
+type URRUsage struct {
+	URRID          uint32
+	UplinkVolume   uint64
+	DownlinkVolume uint64
+	TotalVolume    uint64
+	UplinkPktNum   uint64
+	DownlinkPktNum uint64
+	TotalPktNum    uint64
+	// Duration in seconds
+	Duration uint64
+}
+
+// HandleOAMQueryUEPDUSessionUsage queries the usage of all the URRs of the PDU session from its UPFs
+// by PFCP Session Modification with Query URR, and returns the usage of each URR. UPFs reset the measurement
+// once reported, so the usage queried is recorded and reported to CHF and PCF like the usage reports of UPFs
+func HandleOAMQueryUEPDUSessionUsage(smContextRef string) *httpwrapper.Response {
+	smContext := context.GetSMContextByRef(smContextRef)
+	if smContext == nil {
+		return httpwrapper.NewResponse(http.StatusNotFound, nil, nil)
+	}
+
+	failedUPFs := QueryURRUsage(smContext)
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+	usage := PDUSessionUsage{
+		Supi:         smContext.Supi,
+		PDUSessionID: strconv.Itoa(int(smContext.PDUSessionID)),
+		URRs:         urrUsages(smContext.UrrReports),
+		FailedUPFs:   failedUPFs,
+	}
+	return httpwrapper.NewResponse(http.StatusOK, nil, usage)
+}
+
+// urrUsages aggregates the usage reports per URR
+func urrUsages(reports []context.UsageReport) []URRUsage {
+	usages := make(map[uint32]*URRUsage)
+	for _, report := range reports {
+		u, ok := usages[report.UrrId]
+		if !ok {
+			u = &URRUsage{URRID: report.UrrId}
+			usages[report.UrrId] = u
+		}
+		u.UplinkVolume += report.UplinkVolume
+		u.DownlinkVolume += report.DownlinkVolume
+		u.TotalVolume += report.TotalVolume
+		u.UplinkPktNum += report.UplinkPktNum
+		u.DownlinkPktNum += report.DownlinkPktNum
+		u.TotalPktNum += report.TotalPktNum
+		u.Duration += uint64(report.Duration / time.Second)
+	}
+	result := make([]URRUsage, 0, len(usages))
+	for _, u := range usages {
+		result = append(result, *u)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].URRID < result[j].URRID })
+	return result
+}
+
+func HandleOAMGetNotificationDeadLetters() *httpwrapper.Response {
+	return httpwrapper.NewResponse(http.StatusOK, nil,
+		context.GetNotificationDispatcher().DeadLetters())
+}
+
+// HandleOAMMuteNotification holds the notifications of notifKey (subId or notifId)
+func HandleOAMMuteNotification(notifKey string) *httpwrapper.Response {
+	if err := context.GetNotificationDispatcher().Mute(notifKey); err != nil {
+		return httpwrapper.NewResponse(http.StatusBadRequest, nil, nil)
+	}
+	return httpwrapper.NewResponse(http.StatusNoContent, nil, nil)
+}
+
+// HandleOAMUnmuteNotification delivers the held notifications of notifKey
+func HandleOAMUnmuteNotification(notifKey string) *httpwrapper.Response {
+	if !context.GetNotificationDispatcher().IsMuted(notifKey) {
+		return httpwrapper.NewResponse(http.StatusNotFound, nil, nil)
+	}
+	context.GetNotificationDispatcher().Unmute(notifKey)
+	return httpwrapper.NewResponse(http.StatusNoContent, nil, nil)
+}
@@ -0,0 +1,30 @@
+package producer
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/smf/internal/context"
+)
+
+func TestURRUsages(t *testing.T) {
+	usages := urrUsages([]context.UsageReport{
+		{UrrId: 2, UplinkVolume: 10, DownlinkVolume: 20, TotalVolume: 30, UplinkPktNum: 1, DownlinkPktNum: 2, TotalPktNum: 3},
+		{UrrId: 1, TotalVolume: 5, Duration: time.Minute},
+		{UrrId: 2, UplinkVolume: 1, DownlinkVolume: 2, TotalVolume: 3, TotalPktNum: 1, Duration: 2 * time.Second},
+	})
+	require.Equal(t, []URRUsage{
+		{URRID: 1, TotalVolume: 5, Duration: 60},
+		{
+			URRID: 2, UplinkVolume: 11, DownlinkVolume: 22, TotalVolume: 33,
+			UplinkPktNum: 1, DownlinkPktNum: 2, TotalPktNum: 4, Duration: 2,
+		},
+	}, usages)
+	require.Empty(t, urrUsages(nil))
+
+	rsp := HandleOAMQueryUEPDUSessionUsage("urn:uuid:unknown")
+	require.Equal(t, http.StatusNotFound, rsp.Status)
+}
@@ -80,46 +80,27 @@ func HandlePDUSessionSMContextCreate(request models.PostSmContextsRequest) *http
 	smContext.Log.Debugf("S-NSSAI[sst: %d, sd: %s] DNN[%s]",
 		smContext.SNssai.Sst, smContext.SNssai.Sd, smContext.Dnn)
 
-	// Query UDM
-	if problemDetails, err := consumer.SendNFDiscoveryUDM(); err != nil {
-		smContext.Log.Warnf("Send NF Discovery Serving UDM Error[%v]", err)
-	} else if problemDetails != nil {
-		smContext.Log.Warnf("Send NF Discovery Serving UDM Problem[%+v]", problemDetails)
-	} else {
-		smContext.Log.Infoln("Send NF Discovery Serving UDM Successfully")
-	}
-
-	smPlmnID := createData.Guami.PlmnId
-
-	smDataParams := &Nudm_SubscriberDataManagement.GetSmDataParamOpts{
-		Dnn:         optional.NewString(createData.Dnn),
-		PlmnId:      optional.NewInterface(openapi.MarshToJsonString(smPlmnID)),
-		SingleNssai: optional.NewInterface(openapi.MarshToJsonString(smContext.SNssai)),
-	}
-
-	SubscriberDataManagementClient := smf_context.GetSelf().SubscriberDataManagementClient
-
-	if sessSubData, rsp, err := SubscriberDataManagementClient.
-		SessionManagementSubscriptionDataRetrievalApi.
-		GetSmData(context.Background(), smContext.Supi, smDataParams); err != nil {
-		smContext.Log.Errorln("Get SessionManagementSubscriptionData error:", err)
-	} else {
-		defer func() {
-			if rspCloseErr := rsp.Body.Close(); rspCloseErr != nil {
-				smContext.Log.Errorf("GetSmData response body cannot close: %+v", rspCloseErr)
-			}
-		}()
-		if len(sessSubData) > 0 {
-			smContext.DnnConfiguration = sessSubData[0].DnnConfigurations[smContext.Dnn]
-			// UP Security info present in session management subscription data
-			if smContext.DnnConfiguration.UpSecurity != nil {
-				smContext.UpSecurity = smContext.DnnConfiguration.UpSecurity
-			}
-		} else {
-			smContext.Log.Errorln("SessionManagementSubscriptionData from UDM is nil")
+	// Home-routed roaming: relay the PDU session establishment to H-SMF
+	if hSmfUri := homeRoutedHSmfUri(smContext); hSmfUri != "" {
+		httpResponse, estAccept := handleVsmfPDUSessionSMContextCreate(smContext, m, request, hSmfUri)
+		if estAccept != nil {
+			// same as the non-roaming case, setup V-UPF and send N1N2 message asynchronously
+			needUnlock = false
+			go func() {
+				defer smContext.SMLock.Unlock()
+
+				ActivateUPFSession(smContext, func(smContext *smf_context.SMContext, success bool) {
+					vsmfEstHandler(smContext, success, estAccept)
+				})
+
+				smContext.SendEventExposureNotification(SendEventExposureNotification)
+			}()
 		}
+		return httpResponse
 	}
 
+	retrieveSmData(smContext, createData.Guami.PlmnId)
+
 	establishmentRequest := m.PDUSessionEstablishmentRequest
 	if err := HandlePDUSessionEstablishmentRequest(smContext, establishmentRequest); err != nil {
 		smContext.Log.Errorf("PDU Session Establishment fail by %s", err)
@@ -134,29 +115,45 @@ func HandlePDUSessionSMContextCreate(request models.PostSmContextsRequest) *http
 			&Nsmf_PDUSession.N1SmError)
 	}
 
-	// Discover and new Namf_Comm client for use later
-	if problemDetails, err := consumer.SendNFDiscoveryServingAMF(smContext); err != nil {
-		smContext.Log.Warnf("Send NF Discovery Serving AMF Error[%v]", err)
-	} else if problemDetails != nil {
-		smContext.Log.Warnf("Send NF Discovery Serving AMF Problem[%+v]", problemDetails)
+	discoverServingAMF(smContext)
+
+	// Secondary authentication by DN-AAA, the PDU session is set up after UE is authenticated
+	if smContext.DNNInfo != nil && smContext.DNNInfo.DnAaa != nil {
+		go startSecondaryAuthentication(smContext)
 	} else {
-		smContext.Log.Traceln("Send NF Discovery Serving AMF successfully")
+		if nasErrorCause, sbiError := setupPDUSession(smContext); sbiError != nil {
+			return makeEstRejectResAndReleaseSMContext(smContext, nasErrorCause, sbiError)
+		}
+
+		// generate goroutine to handle PFCP and
+		// reply PDUSessionSMContextCreate rsp immediately
+		needUnlock = false
+		go func() {
+			defer smContext.SMLock.Unlock()
+
+			activatePDUSession(smContext)
+		}()
 	}
 
-	for _, service := range *smContext.AMFProfile.NfServices {
-		if service.ServiceName == models.ServiceName_NAMF_COMM {
-			communicationConf := Namf_Communication.NewConfiguration()
-			communicationConf.SetBasePath(service.ApiPrefix)
-			smContext.CommunicationClient = Namf_Communication.NewAPIClient(communicationConf)
-		}
+	response.JsonData = smContext.BuildCreatedData()
+	return &httpwrapper.Response{
+		Header: http.Header{
+			"Location": {smContext.Ref},
+		},
+		Status: http.StatusCreated,
+		Body:   response,
 	}
+	// TODO: UECM registration
+}
 
+// setupPDUSession allocates UE IP, creates the SM policy association and selects the data path
+// of the PDU session, it returns the 5GSM cause and the SBI error if it fails
+func setupPDUSession(smContext *smf_context.SMContext) (uint8, *models.ProblemDetails) {
 	if err := smContext.AllocUeIP(); err != nil {
 		smContext.SetState(smf_context.InActive)
-		smContext.Log.Errorf("PDUSessionSMContextCreate err: %v", err)
-		return makeEstRejectResAndReleaseSMContext(smContext,
-			nasMessage.Cause5GSMInsufficientResourcesForSpecificSliceAndDNN,
-			&Nsmf_PDUSession.InsufficientResourceSliceDnn)
+		smContext.Log.Errorf("setup PDU session err: %v", err)
+		return nasMessage.Cause5GSMInsufficientResourcesForSpecificSliceAndDNN,
+			&Nsmf_PDUSession.InsufficientResourceSliceDnn
 	}
 
 	if err := smContext.PCFSelection(); err != nil {
@@ -170,61 +167,110 @@ func HandlePDUSessionSMContextCreate(request models.PostSmContextsRequest) *http
 			smContext.Log.Errorln("setup sm policy association failed:", err, problemDetails)
 			smContext.SetState(smf_context.InActive)
 			if problemDetails.Cause == "USER_UNKNOWN" {
-				return makeEstRejectResAndReleaseSMContext(smContext,
-					nasMessage.Cause5GSMRequestRejectedUnspecified,
-					&Nsmf_PDUSession.SubscriptionDenied)
+				return nasMessage.Cause5GSMRequestRejectedUnspecified, &Nsmf_PDUSession.SubscriptionDenied
 			}
 		}
-		return makeEstRejectResAndReleaseSMContext(smContext,
-			nasMessage.Cause5GSMNetworkFailure,
-			&Nsmf_PDUSession.NetworkFailure)
+		return nasMessage.Cause5GSMNetworkFailure, &Nsmf_PDUSession.NetworkFailure
 	}
 	smContext.SMPolicyID = smPolicyID
 
 	// Update SessionRule from decision
 	if err := smContext.ApplySessionRules(smPolicyDecision); err != nil {
-		smContext.Log.Errorf("PDUSessionSMContextCreate err: %v", err)
-		return makeEstRejectResAndReleaseSMContext(smContext,
-			nasMessage.Cause5GSMRequestRejectedUnspecified,
-			&Nsmf_PDUSession.SubscriptionDenied)
+		smContext.Log.Errorf("setup PDU session err: %v", err)
+		return nasMessage.Cause5GSMRequestRejectedUnspecified, &Nsmf_PDUSession.SubscriptionDenied
+	}
+
+	if err := startCharging(smContext); err != nil {
+		smContext.SetState(smf_context.InActive)
+		smContext.Log.Errorf("setup PDU session err: %v", err)
+		return nasMessage.Cause5GSMNetworkFailure, &Nsmf_PDUSession.NetworkFailure
 	}
 
 	if err := smContext.SelectDefaultDataPath(); err != nil {
 		smContext.SetState(smf_context.InActive)
-		smContext.Log.Errorf("PDUSessionSMContextCreate err: %v", err)
-		return makeEstRejectResAndReleaseSMContext(smContext,
-			nasMessage.Cause5GSMInsufficientResourcesForSpecificSliceAndDNN,
-			&Nsmf_PDUSession.InsufficientResourceSliceDnn)
+		smContext.Log.Errorf("setup PDU session err: %v", err)
+		return nasMessage.Cause5GSMInsufficientResourcesForSpecificSliceAndDNN,
+			&Nsmf_PDUSession.InsufficientResourceSliceDnn
 	}
 
 	if err := smContext.ApplyPccRules(smPolicyDecision); err != nil {
 		smContext.Log.Errorf("apply sm policy decision error: %+v", err)
 	}
+	return 0, nil
+}
 
-	// generate goroutine to handle PFCP and
-	// reply PDUSessionSMContextCreate rsp immediately
-	needUnlock = false
-	go func() {
-		defer smContext.SMLock.Unlock()
+// activatePDUSession establishes the PFCP sessions and sends the PDU Session Establishment Accept
+func activatePDUSession(smContext *smf_context.SMContext) {
+	smContext.SendUpPathChgNotification("EARLY", SendEventExposureNotification)
 
-		smContext.SendUpPathChgNotification("EARLY", SendUpPathChgEventExposureNotification)
+	ActivateUPFSession(smContext, EstHandler)
 
-		ActivateUPFSession(smContext, EstHandler)
+	smContext.SendUpPathChgNotification("LATE", SendEventExposureNotification)
 
-		smContext.SendUpPathChgNotification("LATE", SendUpPathChgEventExposureNotification)
+	smContext.SendEventExposureNotification(SendEventExposureNotification)
 
-		smContext.PostRemoveDataPath()
-	}()
+	smContext.PostRemoveDataPath()
+}
 
-	response.JsonData = smContext.BuildCreatedData()
-	return &httpwrapper.Response{
-		Header: http.Header{
-			"Location": {smContext.Ref},
-		},
-		Status: http.StatusCreated,
-		Body:   response,
+// discoverServingAMF discovers the serving AMF and news Namf_Comm client for use later
+func discoverServingAMF(smContext *smf_context.SMContext) {
+	if problemDetails, err := consumer.SendNFDiscoveryServingAMF(smContext); err != nil {
+		smContext.Log.Warnf("Send NF Discovery Serving AMF Error[%v]", err)
+	} else if problemDetails != nil {
+		smContext.Log.Warnf("Send NF Discovery Serving AMF Problem[%+v]", problemDetails)
+	} else {
+		smContext.Log.Traceln("Send NF Discovery Serving AMF successfully")
+	}
+
+	for _, service := range *smContext.AMFProfile.NfServices {
+		if service.ServiceName == models.ServiceName_NAMF_COMM {
+			communicationConf := Namf_Communication.NewConfiguration()
+			communicationConf.SetBasePath(service.ApiPrefix)
+			smContext.CommunicationClient = Namf_Communication.NewAPIClient(communicationConf)
+		}
+	}
+}
+
+// retrieveSmData gets the Session Management Subscription Data of the PDU session from UDM
+func retrieveSmData(smContext *smf_context.SMContext, smPlmnID *models.PlmnId) {
+	// Query UDM
+	if problemDetails, err := consumer.SendNFDiscoveryUDM(); err != nil {
+		smContext.Log.Warnf("Send NF Discovery Serving UDM Error[%v]", err)
+	} else if problemDetails != nil {
+		smContext.Log.Warnf("Send NF Discovery Serving UDM Problem[%+v]", problemDetails)
+	} else {
+		smContext.Log.Infoln("Send NF Discovery Serving UDM Successfully")
+	}
+
+	smDataParams := &Nudm_SubscriberDataManagement.GetSmDataParamOpts{
+		Dnn:         optional.NewString(smContext.Dnn),
+		PlmnId:      optional.NewInterface(openapi.MarshToJsonString(smPlmnID)),
+		SingleNssai: optional.NewInterface(openapi.MarshToJsonString(smContext.SNssai)),
+	}
+
+	SubscriberDataManagementClient := smf_context.GetSelf().SubscriberDataManagementClient
+
+	if sessSubData, rsp, err := SubscriberDataManagementClient.
+		SessionManagementSubscriptionDataRetrievalApi.
+		GetSmData(context.Background(), smContext.Supi, smDataParams); err != nil {
+		smContext.Log.Errorln("Get SessionManagementSubscriptionData error:", err)
+	} else {
+		defer func() {
+			if rspCloseErr := rsp.Body.Close(); rspCloseErr != nil {
+				smContext.Log.Errorf("GetSmData response body cannot close: %+v", rspCloseErr)
+			}
+		}()
+		if len(sessSubData) > 0 {
+			smContext.DnnConfiguration = sessSubData[0].DnnConfigurations[smContext.Dnn]
+			smContext.InternalGroupIds = sessSubData[0].InternalGroupIds
+			// UP Security info present in session management subscription data
+			if smContext.DnnConfiguration.UpSecurity != nil {
+				smContext.UpSecurity = smContext.DnnConfiguration.UpSecurity
+			}
+		} else {
+			smContext.Log.Errorln("SessionManagementSubscriptionData from UDM is nil")
+		}
 	}
-	// TODO: UECM registration
 }
 
 func HandlePDUSessionSMContextUpdate(smContextRef string, body models.UpdateSmContextRequest) *httpwrapper.Response {
@@ -260,6 +306,7 @@ func HandlePDUSessionSMContextUpdate(smContextRef string, body models.UpdateSmCo
 	var httpResponse *httpwrapper.Response
 	smContext.SMLock.Lock()
 	defer smContext.SMLock.Unlock()
+	defer smContext.SendEventExposureNotification(SendEventExposureNotification)
 
 	var sendPFCPModification bool
 	var pfcpResponseStatus smf_context.PFCPSessionResponseStatus
@@ -267,6 +314,7 @@ func HandlePDUSessionSMContextUpdate(smContextRef string, body models.UpdateSmCo
 	response.JsonData = new(models.SmContextUpdatedData)
 
 	smContextUpdateData := body.JsonData
+	checkServingNetworkAndAccessTypeChange(smContext, smContextUpdateData)
 
 	if body.BinaryDataN1SmMessage != nil {
 		m := nas.NewMessage()
@@ -285,6 +333,11 @@ func HandlePDUSessionSMContextUpdate(smContextRef string, body models.UpdateSmCo
 			return httpResponse
 		}
 
+		// Home-routed roaming: the 5GSM messages are handled by H-SMF
+		if smContext.Role == smf_context.SMFRoleVSMF {
+			return handleVsmfN1SmMessage(smContext, m, body.BinaryDataN1SmMessage, response)
+		}
+
 		switch m.GsmHeader.GetMessageType() {
 		case nas.MsgTypePDUSessionReleaseRequest:
 			smContext.CheckState(smf_context.Active)
@@ -294,8 +347,9 @@ func HandlePDUSessionSMContextUpdate(smContextRef string, body models.UpdateSmCo
 			HandlePDUSessionReleaseRequest(smContext, m.PDUSessionReleaseRequest)
 			if smContext.SelectedUPF != nil && smContext.PDUAddress != nil {
 				smContext.Log.Infof("Release IP[%s]", smContext.PDUAddress)
-				upi.ReleaseUEIP(smContext.SelectedUPF, smContext.PDUAddress, smContext.UseStaticIP)
-				smContext.PDUAddress = nil
+				smContext.BuildEventExposureNotification(models.SmfEvent_UE_IP_CH,
+					smContext.UeIPChangeNotification(true))
+				smContext.ReleaseUeIP()
 				// keep SelectedUPF until PDU Session Release is completed
 			}
 
@@ -378,6 +432,10 @@ func HandlePDUSessionSMContextUpdate(smContextRef string, body models.UpdateSmCo
 			smContext.StopT3591()
 		case nas.MsgTypePDUSessionModificationReject:
 			smContext.StopT3591()
+		case nas.MsgTypePDUSessionAuthenticationComplete:
+			smContext.StopT3590()
+			// relay the EAP message to DN-AAA asynchronously, the answer is sent to UE by N1N2 message
+			go HandlePDUSessionAuthenticationComplete(smContext, m.PDUSessionAuthenticationComplete)
 		}
 	}
 
@@ -438,9 +496,32 @@ func HandlePDUSessionSMContextUpdate(smContextRef string, body models.UpdateSmCo
 				smContext.Log.Warnf("Access network resource is released")
 			} else {
 				DLPDR.FAR.State = smf_context.RULE_UPDATE
-				DLPDR.FAR.ApplyAction.Forw = false
-				DLPDR.FAR.ApplyAction.Buff = true
-				DLPDR.FAR.ApplyAction.Nocp = true
+				bufferedInSMF := false
+				if smContext.BuffersInSMF(ANUPF.UPF) {
+					// AN UPF forwards the downlink data to SMF over N4-u
+					if pdr, err := smContext.SetupDLBuffer(dataPath); err != nil {
+						smContext.Log.Warnf("Setup DL buffer in SMF failed, buffer in UPF: %+v", err)
+					} else {
+						bufferedInSMF = true
+						if pdr != nil {
+							pdrList = append(pdrList, pdr)
+						}
+					}
+				}
+				if !bufferedInSMF {
+					DLPDR.FAR.ApplyAction.Forw = false
+					DLPDR.FAR.ApplyAction.Buff = true
+					DLPDR.FAR.ApplyAction.Nocp = true
+				}
+				// the BAR is kept with the FAR for the following deactivations
+				if !bufferedInSMF && DLPDR.FAR.BAR == nil {
+					if bar, err := smContext.NewBAR(ANUPF.UPF); err != nil {
+						smContext.Log.Warnf("Add BAR failed: %+v", err)
+					} else {
+						DLPDR.FAR.BAR = bar
+						barList = append(barList, bar)
+					}
+				}
 				farList = append(farList, DLPDR.FAR)
 				sendPFCPModification = true
 				smContext.SetState(smf_context.PFCPModification)
@@ -732,6 +813,9 @@ func HandlePDUSessionSMContextUpdate(smContextRef string, body models.UpdateSmCo
 		case smf_context.SessionUpdateSuccess:
 			smContext.Log.Traceln("In case SessionUpdateSuccess")
 			smContext.SetState(smf_context.Active)
+			if smContextUpdateData.N2SmInfoType == models.N2SmInfoType_PDU_RES_SETUP_RSP {
+				sendBufferedDownlinkData(smContext)
+			}
 			httpResponse = &httpwrapper.Response{
 				Status: http.StatusOK,
 				Body:   response,
@@ -841,8 +925,11 @@ func HandlePDUSessionSMContextRelease(smContextRef string, body models.ReleaseSm
 	smContext.SMLock.Lock()
 	defer smContext.SMLock.Unlock()
 
+	smContext.StopT3590()
 	smContext.StopT3591()
 	smContext.StopT3592()
+	// abort the ongoing secondary authentication, the answer of DN-AAA is dropped
+	smContext.DnAaaSession = nil
 
 	// remove SM Policy Association
 	if smContext.SMPolicyID != "" {
@@ -982,9 +1069,41 @@ func releaseSession(smContext *smf_context.SMContext) smf_context.PFCPSessionRes
 			return res.Status
 		}
 	}
+
+	smContext.BuildEventExposureNotification(models.SmfEvent_PDU_SES_REL, models.EventNotification{})
+	smContext.SendEventExposureNotification(SendEventExposureNotification)
 	return smf_context.SessionReleaseSuccess
 }
 
+// checkServingNetworkAndAccessTypeChange builds PLMN_CH and AC_TY_CH event notifications
+// if the serving network or the access type in SmContextUpdateData is changed
+func checkServingNetworkAndAccessTypeChange(
+	smContext *smf_context.SMContext, updateData *models.SmContextUpdateData,
+) {
+	if updateData == nil || smContext.SmContextCreateData == nil {
+		return
+	}
+
+	if plmnID := updateData.ServingNetwork; plmnID != nil {
+		if smContext.ServingNetwork == nil ||
+			smContext.ServingNetwork.Mcc != plmnID.Mcc || smContext.ServingNetwork.Mnc != plmnID.Mnc {
+			smContext.Log.Infof("Serving network is changed to [%s%s]", plmnID.Mcc, plmnID.Mnc)
+			smContext.ServingNetwork = plmnID
+			smContext.BuildEventExposureNotification(models.SmfEvent_PLMN_CH, models.EventNotification{
+				PlmnId: plmnID,
+			})
+		}
+	}
+
+	if updateData.AnType != "" && updateData.AnType != smContext.AnType {
+		smContext.Log.Infof("Access type is changed from [%s] to [%s]", smContext.AnType, updateData.AnType)
+		smContext.AnType = updateData.AnType
+		smContext.BuildEventExposureNotification(models.SmfEvent_AC_TY_CH, models.EventNotification{
+			AccType: updateData.AnType,
+		})
+	}
+}
+
 func makeEstRejectResAndReleaseSMContext(smContext *smf_context.SMContext, nasErrorCause uint8,
 	sbiError *models.ProblemDetails,
 ) *httpwrapper.Response {
@@ -0,0 +1,486 @@
+package producer
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"bitbucket.org/free5gc-team/nas"
+	"bitbucket.org/free5gc-team/nas/nasMessage"
+	"bitbucket.org/free5gc-team/openapi"
+	"bitbucket.org/free5gc-team/openapi/Nsmf_PDUSession"
+	"bitbucket.org/free5gc-team/openapi/models"
+	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+	"bitbucket.org/free5gc-team/smf/internal/sbi/consumer"
+	"bitbucket.org/free5gc-team/smf/pkg/factory"
+	"bitbucket.org/free5gc-team/util/httpwrapper"
+)
+
+// HandlePDUSessionCreate handles the PDU session creation from V-SMF (H-SMF role, TS 29.502 5.2.2.7)
+func HandlePDUSessionCreate(request models.PostPduSessionsRequest) *httpwrapper.Response {
+	logger.PduSessLog.Infoln("In HandlePDUSessionCreate")
+
+	createData := request.JsonData
+	if createData == nil || createData.VcnTunnelInfo == nil {
+		problemDetails := &models.ProblemDetails{
+			Title:  "Malformed request syntax",
+			Status: http.StatusBadRequest,
+			Detail: "PduSessionCreateData or vcnTunnelInfo is missing",
+			Cause:  "MANDATORY_IE_MISSING",
+		}
+		return httpwrapper.NewResponse(http.StatusBadRequest, nil, models.PostPduSessionsErrorResponse{
+			JsonData: &models.PduSessionCreateError{Error: problemDetails},
+		})
+	}
+
+	// Check has PDU Session Establishment Request
+	m := nas.NewMessage()
+	if err := m.GsmMessageDecode(&request.BinaryDataN1SmInfoFromUe); err != nil ||
+		m.GsmHeader.GetMessageType() != nas.MsgTypePDUSessionEstablishmentRequest {
+		logger.PduSessLog.Warnln("GsmMessageDecode Error: ", err)
+		return httpwrapper.NewResponse(http.StatusForbidden, nil, models.PostPduSessionsErrorResponse{
+			JsonData: &models.PduSessionCreateError{Error: &Nsmf_PDUSession.N1SmError},
+		})
+	}
+
+	smCreateData := smf_context.NewSmContextCreateDataFromPduSessionCreateData(createData)
+	// Check duplicate PDU Session
+	if dupSmCtx := smf_context.GetSMContextById(createData.Supi, createData.PduSessionId); dupSmCtx != nil {
+		HandlePDUSessionSMContextLocalRelease(dupSmCtx, smCreateData)
+	}
+
+	smContext := smf_context.NewSMContext(createData.Supi, createData.PduSessionId)
+	smContext.SetState(smf_context.ActivePending)
+	smContext.SmContextCreateData = smCreateData
+	smContext.Role = smf_context.SMFRoleHSMF
+	smContext.VsmfId = createData.VsmfId
+	smContext.VsmfPduSessionUri = createData.VsmfPduSessionUri
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	upi := smf_context.GetUserPlaneInformation()
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+
+	// DNN Information from config
+	smContext.DNNInfo = smf_context.RetrieveDnnInformation(smContext.SNssai, smContext.Dnn)
+	if smContext.DNNInfo == nil {
+		logger.PduSessLog.Errorf("S-NSSAI[sst: %d, sd: %s] DNN[%s] not matched DNN Config",
+			smContext.SNssai.Sst, smContext.SNssai.Sd, smContext.Dnn)
+	}
+
+	retrieveSmData(smContext, createData.ServingNetwork)
+
+	if err := HandlePDUSessionEstablishmentRequest(smContext, m.PDUSessionEstablishmentRequest); err != nil {
+		smContext.Log.Errorf("PDU Session Establishment fail by %s", err)
+		gsmError := &GSMError{}
+		if errors.As(err, &gsmError) {
+			return makePduSessionCreateErrorAndReleaseSMContext(smContext,
+				gsmError.GSMCause, &Nsmf_PDUSession.N1SmError)
+		}
+		return makePduSessionCreateErrorAndReleaseSMContext(smContext,
+			nasMessage.Cause5GSMRequestRejectedUnspecified, &Nsmf_PDUSession.N1SmError)
+	}
+
+	// The V-UPF N9 tunnel is the access side of the H-UPF
+	if err := smContext.UpdateVcnTunnelInfo(createData.VcnTunnelInfo); err != nil {
+		smContext.Log.Errorf("PDUSessionCreate err: %v", err)
+		return makePduSessionCreateErrorAndReleaseSMContext(smContext,
+			nasMessage.Cause5GSMRequestRejectedUnspecified, &Nsmf_PDUSession.N1SmError)
+	}
+
+	if err := smContext.AllocUeIP(); err != nil {
+		smContext.Log.Errorf("PDUSessionCreate err: %v", err)
+		return makePduSessionCreateErrorAndReleaseSMContext(smContext,
+			nasMessage.Cause5GSMInsufficientResourcesForSpecificSliceAndDNN,
+			&Nsmf_PDUSession.InsufficientResourceSliceDnn)
+	}
+
+	if err := smContext.PCFSelection(); err != nil {
+		smContext.Log.Errorln("pcf selection error:", err)
+	}
+
+	smPolicyID, smPolicyDecision, err := consumer.SendSMPolicyAssociationCreate(smContext)
+	if err != nil {
+		if openapiError, ok := err.(openapi.GenericOpenAPIError); ok {
+			problemDetails := openapiError.Model().(models.ProblemDetails)
+			smContext.Log.Errorln("setup sm policy association failed:", err, problemDetails)
+			if problemDetails.Cause == "USER_UNKNOWN" {
+				return makePduSessionCreateErrorAndReleaseSMContext(smContext,
+					nasMessage.Cause5GSMRequestRejectedUnspecified, &Nsmf_PDUSession.SubscriptionDenied)
+			}
+		}
+		return makePduSessionCreateErrorAndReleaseSMContext(smContext,
+			nasMessage.Cause5GSMNetworkFailure, &Nsmf_PDUSession.NetworkFailure)
+	}
+	smContext.SMPolicyID = smPolicyID
+
+	if err := smContext.ApplySessionRules(smPolicyDecision); err != nil {
+		smContext.Log.Errorf("PDUSessionCreate err: %v", err)
+		return makePduSessionCreateErrorAndReleaseSMContext(smContext,
+			nasMessage.Cause5GSMRequestRejectedUnspecified, &Nsmf_PDUSession.SubscriptionDenied)
+	}
+
+	if err := smContext.SelectDefaultDataPath(); err != nil {
+		smContext.Log.Errorf("PDUSessionCreate err: %v", err)
+		return makePduSessionCreateErrorAndReleaseSMContext(smContext,
+			nasMessage.Cause5GSMInsufficientResourcesForSpecificSliceAndDNN,
+			&Nsmf_PDUSession.InsufficientResourceSliceDnn)
+	}
+
+	if err := smContext.ApplyPccRules(smPolicyDecision); err != nil {
+		smContext.Log.Errorf("apply sm policy decision error: %+v", err)
+	}
+
+	// V-SMF waits for the N9 tunnel, so the PFCP sessions are established before responding
+	smContext.SendUpPathChgNotification("EARLY", SendEventExposureNotification)
+	pfcpSuccess := false
+	ActivateUPFSession(smContext, func(_ *smf_context.SMContext, success bool) {
+		pfcpSuccess = success
+	})
+	smContext.SendUpPathChgNotification("LATE", SendEventExposureNotification)
+	smContext.PostRemoveDataPath()
+
+	if !pfcpSuccess {
+		smContext.Log.Errorln("PDUSessionCreate err: PFCP session establishment failed")
+		return makePduSessionCreateErrorAndReleaseSMContext(smContext,
+			nasMessage.Cause5GSMNetworkFailure, &Nsmf_PDUSession.NetworkFailure)
+	}
+
+	createdData, err := buildPduSessionCreatedData(smContext)
+	if err != nil {
+		smContext.Log.Errorf("PDUSessionCreate err: %v", err)
+		ReleaseTunnel(smContext)
+		return makePduSessionCreateErrorAndReleaseSMContext(smContext,
+			nasMessage.Cause5GSMNetworkFailure, &Nsmf_PDUSession.NetworkFailure)
+	}
+	n1Buf, err := smf_context.BuildGSMPDUSessionEstablishmentAccept(smContext)
+	if err != nil {
+		smContext.Log.Errorf("Build GSM PDUSessionEstablishmentAccept failed: %s", err)
+		ReleaseTunnel(smContext)
+		return makePduSessionCreateErrorAndReleaseSMContext(smContext,
+			nasMessage.Cause5GSMNetworkFailure, &Nsmf_PDUSession.NetworkFailure)
+	}
+	createdData.N1SmInfoToUe = &models.RefToBinaryData{ContentId: "n1SmInfoToUe"}
+
+	smContext.SetState(smf_context.Active)
+	if smContext.PDUAddress != nil {
+		smContext.BuildEventExposureNotification(models.SmfEvent_UE_IP_CH,
+			smContext.UeIPChangeNotification(false))
+	}
+	smContext.BuildEventExposureNotification(models.SmfEvent_PDU_SES_EST, models.EventNotification{})
+	smContext.SendEventExposureNotification(SendEventExposureNotification)
+
+	location := fmt.Sprintf("%s%s/pdu-sessions/%s",
+		smf_context.GetSelf().GetIPv4Uri(), factory.SmfPdusessionResUriPrefix, smContext.Ref)
+	return &httpwrapper.Response{
+		Header: http.Header{
+			"Location": {location},
+		},
+		Status: http.StatusCreated,
+		Body: models.PostPduSessionsResponse{
+			JsonData:               createdData,
+			BinaryDataN1SmInfoToUe: n1Buf,
+		},
+	}
+}
+
+// HandlePDUSessionUpdate handles the PDU session update from V-SMF (H-SMF role, TS 29.502 5.2.2.8.2)
+func HandlePDUSessionUpdate(pduSessionRef string, body models.UpdatePduSessionRequest) *httpwrapper.Response {
+	logger.PduSessLog.Infoln("In HandlePDUSessionUpdate")
+
+	smContext := smf_context.GetSMContextByRef(pduSessionRef)
+	if smContext == nil || smContext.Role != smf_context.SMFRoleHSMF {
+		logger.PduSessLog.Warnf("PDU Session[%s] is not found", pduSessionRef)
+		return httpwrapper.NewResponse(http.StatusNotFound, nil, models.UpdatePduSessionErrorResponse{
+			JsonData: &models.HsmfUpdateError{Error: pduSessionNotFoundProblem()},
+		})
+	}
+
+	updateData := body.JsonData
+	if updateData == nil {
+		return httpwrapper.NewResponse(http.StatusBadRequest, nil, models.UpdatePduSessionErrorResponse{
+			JsonData: &models.HsmfUpdateError{
+				Error: &models.ProblemDetails{
+					Title:  "Malformed request syntax",
+					Status: http.StatusBadRequest,
+					Cause:  "MANDATORY_IE_MISSING",
+				},
+			},
+		})
+	}
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+	defer smContext.SendEventExposureNotification(SendEventExposureNotification)
+
+	upi := smf_context.GetUserPlaneInformation()
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+
+	smContext.Log.Infof("PDU Session Update with request indication [%s]", updateData.RequestIndication)
+	checkServingNetworkAndAccessTypeChange(smContext, &models.SmContextUpdateData{
+		ServingNetwork: updateData.ServingNetwork,
+		AnType:         updateData.AnType,
+	})
+	if updateData.UeLocation != nil {
+		smContext.UeLocation = updateData.UeLocation
+	}
+
+	var response models.UpdatePduSessionResponse
+	response.JsonData = new(models.HsmfUpdatedData)
+
+	if body.BinaryDataN1SmInfoFromUe != nil {
+		m := nas.NewMessage()
+		if err := m.GsmMessageDecode(&body.BinaryDataN1SmInfoFromUe); err != nil {
+			smContext.Log.Errorf("N1 Message parse failed: %v", err)
+			return httpwrapper.NewResponse(http.StatusForbidden, nil, models.UpdatePduSessionErrorResponse{
+				JsonData: &models.HsmfUpdateError{Error: &Nsmf_PDUSession.N1SmError},
+			})
+		}
+
+		switch m.GsmHeader.GetMessageType() {
+		case nas.MsgTypePDUSessionReleaseRequest:
+			return handleHsmfReleaseRequest(smContext, m.PDUSessionReleaseRequest, response)
+		case nas.MsgTypePDUSessionReleaseComplete:
+			smContext.StopT3592()
+		case nas.MsgTypePDUSessionModificationRequest:
+			if rsp, err := HandlePDUSessionModificationRequest(smContext, m.PDUSessionModificationRequest); err != nil {
+				if buf, err := smf_context.BuildGSMPDUSessionModificationReject(smContext); err != nil {
+					smContext.Log.Errorf("build GSM PDUSessionModificationReject failed: %+v", err)
+				} else {
+					response.BinaryDataN1SmInfoToUe = buf
+				}
+			} else {
+				if buf, err := rsp.PlainNasEncode(); err != nil {
+					smContext.Log.Errorf("build GSM PDUSessionModificationCommand failed: %+v", err)
+				} else {
+					response.BinaryDataN1SmInfoToUe = buf
+				}
+				// V-SMF updates the V-UPF and AN
+				smContext.BuildHsmfUpdatedQos(response.JsonData)
+			}
+			if response.BinaryDataN1SmInfoToUe != nil {
+				response.JsonData.N1SmInfoToUe = &models.RefToBinaryData{ContentId: "n1SmInfoToUe"}
+			}
+		case nas.MsgTypePDUSessionModificationComplete, nas.MsgTypePDUSessionModificationReject:
+			smContext.StopT3591()
+		}
+	}
+
+	// V-UPF tunnel is changed, e.g. V-UPF relocation
+	if updateData.VcnTunnelInfo != nil {
+		if err := smContext.UpdateVcnTunnelInfo(updateData.VcnTunnelInfo); err != nil {
+			smContext.Log.Errorf("PDUSessionUpdate err: %v", err)
+			return httpwrapper.NewResponse(http.StatusBadRequest, nil, models.UpdatePduSessionErrorResponse{
+				JsonData: &models.HsmfUpdateError{
+					Error: &models.ProblemDetails{
+						Title:  "Invalid vcnTunnelInfo",
+						Status: http.StatusBadRequest,
+						Detail: err.Error(),
+						Cause:  "MANDATORY_IE_INCORRECT",
+					},
+				},
+			})
+		}
+
+		pdrList := []*smf_context.PDR{}
+		farList := []*smf_context.FAR{}
+		for _, dataPath := range smContext.Tunnel.DataPathPool {
+			if dataPath.Activated {
+				DLPDR := dataPath.FirstDPNode.DownLinkTunnel.PDR
+				DLPDR.State = smf_context.RULE_UPDATE
+				pdrList = append(pdrList, DLPDR)
+				farList = append(farList, DLPDR.FAR)
+			}
+		}
+
+		smContext.SetState(smf_context.PFCPModification)
+		if updateAnUpfPfcpSession(smContext, pdrList, farList, nil, nil, nil) !=
+			smf_context.SessionUpdateSuccess {
+			smContext.SetState(smf_context.Active)
+			return httpwrapper.NewResponse(http.StatusInternalServerError, nil, models.UpdatePduSessionErrorResponse{
+				JsonData: &models.HsmfUpdateError{
+					Error: &models.ProblemDetails{
+						Status: http.StatusInternalServerError,
+						Cause:  "SYSTEM_FAILURE",
+					},
+				},
+			})
+		}
+		smContext.SetState(smf_context.Active)
+		smContext.PostRemoveDataPath()
+	}
+
+	return httpwrapper.NewResponse(http.StatusOK, nil, response)
+}
+
+// handleHsmfReleaseRequest handles the UE requested PDU session release relayed by V-SMF,
+// the PDU Session Release Command is returned to V-SMF
+func handleHsmfReleaseRequest(smContext *smf_context.SMContext,
+	req *nasMessage.PDUSessionReleaseRequest, response models.UpdatePduSessionResponse,
+) *httpwrapper.Response {
+	HandlePDUSessionReleaseRequest(smContext, req)
+	if smContext.CheckState(smf_context.InActivePending) {
+		// retransmission of UE, the PDU session is already released
+		if buf, err := smf_context.BuildGSMPDUSessionReleaseCommand(smContext,
+			nasMessage.Cause5GSMRegularDeactivation, true); err != nil {
+			smContext.Log.Errorf("Build GSM PDUSessionReleaseCommand failed: %+v", err)
+		} else {
+			response.BinaryDataN1SmInfoToUe = buf
+			response.JsonData.N1SmInfoToUe = &models.RefToBinaryData{ContentId: "n1SmInfoToUe"}
+		}
+		return httpwrapper.NewResponse(http.StatusOK, nil, response)
+	}
+	if !smContext.CheckState(smf_context.Active) {
+		// another procedure of the PDU session is ongoing, UE may retry the release later
+		smContext.Log.Warnf("Reject PDU Session Release Request in state [%s]", smContext.State())
+		errResponse := models.UpdatePduSessionErrorResponse{
+			JsonData: &models.HsmfUpdateError{Error: &Nsmf_PDUSession.N1SmError},
+		}
+		if buf, err := smf_context.BuildGSMPDUSessionReleaseReject(smContext); err != nil {
+			smContext.Log.Errorf("Build GSM PDUSessionReleaseReject failed: %+v", err)
+		} else {
+			errResponse.BinaryDataN1SmInfoToUe = buf
+			errResponse.JsonData.N1SmInfoToUe = &models.RefToBinaryData{ContentId: "n1SmInfoToUe"}
+		}
+		return httpwrapper.NewResponse(int(Nsmf_PDUSession.N1SmError.Status), nil, errResponse)
+	}
+
+	if smContext.SelectedUPF != nil && smContext.PDUAddress != nil {
+		smContext.Log.Infof("Release IP[%s]", smContext.PDUAddress)
+		smContext.BuildEventExposureNotification(models.SmfEvent_UE_IP_CH,
+			smContext.UeIPChangeNotification(true))
+		smContext.ReleaseUeIP()
+	}
+
+	// remove SM Policy Association
+	if smContext.SMPolicyID != "" {
+		if err := consumer.SendSMPolicyAssociationTermination(smContext); err != nil {
+			smContext.Log.Errorf("SM Policy Termination failed: %s", err)
+		} else {
+			smContext.SMPolicyID = ""
+		}
+	}
+
+	cause := nasMessage.Cause5GSMRegularDeactivation
+	if req.Cause5GSM != nil {
+		cause = req.Cause5GSM.GetCauseValue()
+	}
+
+	if releaseSession(smContext) != smf_context.SessionReleaseSuccess {
+		smContext.SetState(smf_context.Active)
+		errResponse := models.UpdatePduSessionErrorResponse{
+			JsonData: &models.HsmfUpdateError{
+				Error: &models.ProblemDetails{
+					Status: http.StatusInternalServerError,
+					Cause:  "SYSTEM_FAILURE",
+				},
+			},
+		}
+		if buf, err := smf_context.BuildGSMPDUSessionReleaseReject(smContext); err != nil {
+			smContext.Log.Errorf("Build GSM PDUSessionReleaseReject failed: %+v", err)
+		} else {
+			errResponse.BinaryDataN1SmInfoToUe = buf
+			errResponse.JsonData.N1SmInfoToUe = &models.RefToBinaryData{ContentId: "n1SmInfoToUe"}
+		}
+		return httpwrapper.NewResponse(http.StatusInternalServerError, nil, errResponse)
+	}
+	smContext.SetState(smf_context.InActivePending)
+
+	if buf, err := smf_context.BuildGSMPDUSessionReleaseCommand(smContext, cause, true); err != nil {
+		smContext.Log.Errorf("Build GSM PDUSessionReleaseCommand failed: %+v", err)
+	} else {
+		response.BinaryDataN1SmInfoToUe = buf
+		response.JsonData.N1SmInfoToUe = &models.RefToBinaryData{ContentId: "n1SmInfoToUe"}
+	}
+	return httpwrapper.NewResponse(http.StatusOK, nil, response)
+}
+
+// HandlePDUSessionRelease handles the PDU session release from V-SMF (H-SMF role, TS 29.502 5.2.2.9.2)
+func HandlePDUSessionRelease(pduSessionRef string, body models.ReleaseData) *httpwrapper.Response {
+	logger.PduSessLog.Infoln("In HandlePDUSessionRelease")
+
+	smContext := smf_context.GetSMContextByRef(pduSessionRef)
+	if smContext == nil || smContext.Role != smf_context.SMFRoleHSMF {
+		logger.PduSessLog.Warnf("PDU Session[%s] is not found", pduSessionRef)
+		return httpwrapper.NewResponse(http.StatusNotFound, nil, pduSessionNotFoundProblem())
+	}
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	smContext.Log.Infof("PDU Session Release with cause [%s]", body.Cause)
+	smContext.StopT3591()
+	smContext.StopT3592()
+
+	// PFCP sessions are already released if the release is requested by UE
+	if !smContext.CheckState(smf_context.InActivePending) && !smContext.CheckState(smf_context.InActive) {
+		if releaseSession(smContext) != smf_context.SessionReleaseSuccess {
+			smContext.SetState(smf_context.Active)
+			problemDetail := &models.ProblemDetails{
+				Status: http.StatusInternalServerError,
+				Cause:  "SYSTEM_FAILURE",
+			}
+			return httpwrapper.NewResponse(http.StatusInternalServerError, nil, problemDetail)
+		}
+	}
+
+	RemoveSMContextFromAllNF(smContext, false)
+	return httpwrapper.NewResponse(http.StatusNoContent, nil, nil)
+}
+
+func buildPduSessionCreatedData(smContext *smf_context.SMContext) (*models.PduSessionCreatedData, error) {
+	hcnTunnelInfo, err := smContext.BuildHcnTunnelInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	createdData := &models.PduSessionCreatedData{
+		PduType:           smContext.PduSessionType(),
+		SscMode:           string(smf_context.SscModeToModels(smContext.SSCMode)),
+		HcnTunnelInfo:     hcnTunnelInfo,
+		QosFlowsSetupList: smContext.BuildQosFlowsSetupList(),
+		HSmfInstanceId:    smf_context.GetSelf().NfInstanceID,
+		PduSessionId:      smContext.PDUSessionID,
+		SNssai:            smContext.SNssai,
+	}
+	if sessRule := smContext.SelectedSessionRule(); sessRule != nil {
+		createdData.SessionAmbr = sessRule.AuthSessAmbr
+	}
+	createdData.UeIpv4Address = smContext.UeIPv4Address()
+	createdData.UeIpv6Prefix = smContext.UeIPv6Prefix()
+	return createdData, nil
+}
+
+func makePduSessionCreateErrorAndReleaseSMContext(smContext *smf_context.SMContext, nasErrorCause uint8,
+	sbiError *models.ProblemDetails,
+) *httpwrapper.Response {
+	errResponse := models.PostPduSessionsErrorResponse{
+		JsonData: &models.PduSessionCreateError{
+			Error:     sbiError,
+			N1smCause: fmt.Sprintf("%02X", nasErrorCause),
+		},
+	}
+	if buf, err := smf_context.BuildGSMPDUSessionEstablishmentReject(smContext, nasErrorCause); err != nil {
+		smContext.Log.Errorf("Build GSM PDUSessionEstablishmentReject failed: %s", err)
+	} else {
+		errResponse.BinaryDataN1SmInfoToUe = buf
+		errResponse.JsonData.N1SmInfoToUe = &models.RefToBinaryData{ContentId: "n1SmInfoToUe"}
+	}
+
+	smContext.SetState(smf_context.InActive)
+	RemoveSMContextFromAllNF(smContext, false)
+	return httpwrapper.NewResponse(int(sbiError.Status), nil, errResponse)
+}
+
+func pduSessionNotFoundProblem() *models.ProblemDetails {
+	return &models.ProblemDetails{
+		Type:   "Resource Not Found",
+		Title:  "PDU Session Ref is not found",
+		Status: http.StatusNotFound,
+		Cause:  "CONTEXT_NOT_FOUND",
+	}
+}
@@ -0,0 +1,180 @@
+package producer_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/h2non/gock.v1"
+
+	"bitbucket.org/free5gc-team/nas"
+	"bitbucket.org/free5gc-team/nas/nasMessage"
+	"bitbucket.org/free5gc-team/openapi"
+	"bitbucket.org/free5gc-team/openapi/Nsmf_PDUSession"
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/smf/internal/context"
+	"bitbucket.org/free5gc-team/smf/internal/sbi/producer"
+)
+
+func buildPDUSessionReleaseRequest(pduSessID uint8, PTI uint8) []byte {
+	msg := nas.NewMessage()
+	msg.GsmMessage = nas.NewGsmMessage()
+	msg.GsmMessage.PDUSessionReleaseRequest = nasMessage.NewPDUSessionReleaseRequest(0)
+	msg.GsmHeader.SetMessageType(nas.MsgTypePDUSessionReleaseRequest)
+	msg.GsmHeader.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
+
+	pduRelReq := msg.GsmMessage.PDUSessionReleaseRequest
+	pduRelReq.SetExtendedProtocolDiscriminator(nasMessage.Epd5GSSessionManagementMessage)
+	pduRelReq.SetPDUSessionID(pduSessID)
+	pduRelReq.SetPTI(PTI)
+	pduRelReq.SetMessageType(nas.MsgTypePDUSessionReleaseRequest)
+
+	if b, err := msg.PlainNasEncode(); err != nil {
+		panic(err)
+	} else {
+		return b
+	}
+}
+
+// newHsmfSMContext returns a home-routed PDU session of H-SMF in the given state
+func newHsmfSMContext(t *testing.T, supi string, state context.SMContextState) *context.SMContext {
+	smContext := context.NewSMContext(supi, 10)
+	smContext.SmContextCreateData = &models.SmContextCreateData{
+		Supi:         supi,
+		PduSessionId: 10,
+		Dnn:          "internet",
+		SNssai:       &models.Snssai{Sst: 1, Sd: "112232"},
+	}
+	smContext.Role = context.SMFRoleHSMF
+	smContext.SetState(state)
+	t.Cleanup(func() { context.RemoveSMContext(smContext.Ref) })
+	return smContext
+}
+
+func decodeGsmMessageType(t *testing.T, buf []byte) uint8 {
+	m := nas.NewMessage()
+	require.NoError(t, m.GsmMessageDecode(&buf))
+	return m.GsmHeader.GetMessageType()
+}
+
+func TestHandlePDUSessionCreate(t *testing.T) {
+	openapi.InterceptH2CClient()
+	defer openapi.RestoreH2CClient()
+	defer gock.Off()
+	initConfig()
+	for _, upfNode := range context.GetSelf().UserPlaneInformation.UPFs {
+		upfNode.UPF.UPFStatus = context.AssociatedSetUpSuccess
+	}
+
+	createData := func(vcnTunnelInfo *models.TunnelInfo) *models.PduSessionCreateData {
+		return &models.PduSessionCreateData{
+			Supi:           "imsi-208930000007487",
+			PduSessionId:   10,
+			Dnn:            "internet",
+			SNssai:         &models.Snssai{Sst: 1, Sd: "112232"},
+			ServingNetwork: &models.PlmnId{Mcc: "208", Mnc: "93"},
+			AnType:         models.AccessType__3_GPP_ACCESS,
+			VcnTunnelInfo:  vcnTunnelInfo,
+		}
+	}
+
+	// vcnTunnelInfo is mandatory
+	rsp := producer.HandlePDUSessionCreate(models.PostPduSessionsRequest{
+		JsonData:                 createData(nil),
+		BinaryDataN1SmInfoFromUe: buildPDUSessionEstablishmentRequest(10, 1, nasMessage.PDUSessionTypeIPv4),
+	})
+	require.Equal(t, http.StatusBadRequest, rsp.Status)
+	require.Equal(t, "MANDATORY_IE_MISSING",
+		rsp.Body.(models.PostPduSessionsErrorResponse).JsonData.Error.Cause)
+
+	// the N1 SM information should be PDU Session Establishment Request
+	rsp = producer.HandlePDUSessionCreate(models.PostPduSessionsRequest{
+		JsonData:                 createData(&models.TunnelInfo{Ipv4Addr: "10.3.0.11", GtpTeid: "00000001"}),
+		BinaryDataN1SmInfoFromUe: buildPDUSessionModificationRequest(10, 2),
+	})
+	require.Equal(t, http.StatusForbidden, rsp.Status)
+	require.Equal(t, &Nsmf_PDUSession.N1SmError,
+		rsp.Body.(models.PostPduSessionsErrorResponse).JsonData.Error)
+
+	// the PDU Session Establishment Reject is returned to V-SMF for the invalid V-UPF tunnel
+	initDiscUDMStubNRF()
+	initGetSMDataStubUDM()
+	rsp = producer.HandlePDUSessionCreate(models.PostPduSessionsRequest{
+		JsonData:                 createData(&models.TunnelInfo{Ipv4Addr: "10.3.0.11", GtpTeid: "1"}),
+		BinaryDataN1SmInfoFromUe: buildPDUSessionEstablishmentRequest(10, 3, nasMessage.PDUSessionTypeIPv4),
+	})
+	require.Equal(t, http.StatusForbidden, rsp.Status)
+	errRsp := rsp.Body.(models.PostPduSessionsErrorResponse)
+	require.Equal(t, "1F", errRsp.JsonData.N1smCause)
+	require.Equal(t, buildPDUSessionEstablishmentReject(10, 3, nasMessage.Cause5GSMRequestRejectedUnspecified),
+		errRsp.BinaryDataN1SmInfoToUe)
+	require.Eventually(t, func() bool {
+		return context.GetSMContextById("imsi-208930000007487", 10) == nil
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestHandlePDUSessionUpdate(t *testing.T) {
+	initConfig()
+
+	rsp := producer.HandlePDUSessionUpdate("unknown", models.UpdatePduSessionRequest{
+		JsonData: &models.HsmfUpdateData{},
+	})
+	require.Equal(t, http.StatusNotFound, rsp.Status)
+
+	smContext := newHsmfSMContext(t, "imsi-208930000007488", context.ModificationPending)
+	rsp = producer.HandlePDUSessionUpdate(smContext.Ref, models.UpdatePduSessionRequest{})
+	require.Equal(t, http.StatusBadRequest, rsp.Status)
+
+	// the release is rejected while another procedure of the PDU session is ongoing
+	rsp = producer.HandlePDUSessionUpdate(smContext.Ref, models.UpdatePduSessionRequest{
+		JsonData: &models.HsmfUpdateData{
+			RequestIndication: models.RequestIndication_UE_REQ_PDU_SES_REL,
+		},
+		BinaryDataN1SmInfoFromUe: buildPDUSessionReleaseRequest(10, 4),
+	})
+	require.Equal(t, http.StatusForbidden, rsp.Status)
+	errRsp := rsp.Body.(models.UpdatePduSessionErrorResponse)
+	require.Equal(t, nas.MsgTypePDUSessionReleaseReject, decodeGsmMessageType(t, errRsp.BinaryDataN1SmInfoToUe))
+	require.Equal(t, context.ModificationPending, smContext.State())
+
+	// the PDU Session Release Command is returned again for the retransmission of UE
+	smContext.SetState(context.InActivePending)
+	rsp = producer.HandlePDUSessionUpdate(smContext.Ref, models.UpdatePduSessionRequest{
+		JsonData: &models.HsmfUpdateData{
+			RequestIndication: models.RequestIndication_UE_REQ_PDU_SES_REL,
+		},
+		BinaryDataN1SmInfoFromUe: buildPDUSessionReleaseRequest(10, 5),
+	})
+	require.Equal(t, http.StatusOK, rsp.Status)
+	updateRsp := rsp.Body.(models.UpdatePduSessionResponse)
+	require.Equal(t, nas.MsgTypePDUSessionReleaseCommand, decodeGsmMessageType(t, updateRsp.BinaryDataN1SmInfoToUe))
+
+	// the V-UPF tunnel should be valid
+	smContext.SetState(context.Active)
+	rsp = producer.HandlePDUSessionUpdate(smContext.Ref, models.UpdatePduSessionRequest{
+		JsonData: &models.HsmfUpdateData{
+			RequestIndication: models.RequestIndication_NW_REQ_PDU_SES_MOD,
+			VcnTunnelInfo:     &models.TunnelInfo{Ipv4Addr: "10.3.0.11", GtpTeid: "1"},
+		},
+	})
+	require.Equal(t, http.StatusBadRequest, rsp.Status)
+	require.Equal(t, "MANDATORY_IE_INCORRECT",
+		rsp.Body.(models.UpdatePduSessionErrorResponse).JsonData.Error.Cause)
+}
+
+func TestHandlePDUSessionRelease(t *testing.T) {
+	initConfig()
+
+	rsp := producer.HandlePDUSessionRelease("unknown", models.ReleaseData{})
+	require.Equal(t, http.StatusNotFound, rsp.Status)
+
+	// the PFCP sessions are already released by the UE requested release
+	smContext := newHsmfSMContext(t, "imsi-208930000007489", context.InActivePending)
+	rsp = producer.HandlePDUSessionRelease(smContext.Ref, models.ReleaseData{})
+	require.Equal(t, http.StatusNoContent, rsp.Status)
+	require.Equal(t, context.InActive, smContext.State())
+	require.Eventually(t, func() bool {
+		return context.GetSMContextByRef(smContext.Ref) == nil
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,179 @@
+package producer
+
+import (
+	"net/http"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+	"bitbucket.org/free5gc-team/util/httpwrapper"
+)
+
+// HandlePDUSessionSMContextRetrieve returns the complete SM context
+// for the target AMF at AMF relocation or the new SMF at SMF change / I-SMF insertion
+func HandlePDUSessionSMContextRetrieve(smContextRef string,
+	retrieveData *models.SmContextRetrieveData,
+) *httpwrapper.Response {
+	smContext := smf_context.GetSMContextByRef(smContextRef)
+	if smContext == nil {
+		logger.PduSessLog.Warnf("SMContext[%s] is not found", smContextRef)
+		return &httpwrapper.Response{
+			Status: http.StatusNotFound,
+			Body: models.ProblemDetails{
+				Title:  "SMContext Ref is not found",
+				Status: http.StatusNotFound,
+				Cause:  "CONTEXT_NOT_FOUND",
+			},
+		}
+	}
+
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	if retrieveData != nil && retrieveData.TargetMmeCap != nil {
+		// TODO: EPS interworking, the UE EPS PDN connection is not supported
+		smContext.Log.Warnln("RetrieveSmContext: UE EPS PDN connection is not supported")
+	}
+
+	smContext.Log.Infoln("Retrieve SM context")
+	return &httpwrapper.Response{
+		Status: http.StatusOK,
+		Body: smf_context.SmContextRetrievedData{
+			SmContext: smContext.BuildSmContext(),
+		},
+	}
+}
+
+// HandlePDUSessionSMContextImport creates the SM context from the SM context retrieved from another SMF,
+// the PDU session keeps its UE IP address, QFIs and rules while the user plane is re-established
+// in the UPFs of this SMF. No N1 SM message is exchanged with UE.
+func HandlePDUSessionSMContextImport(createData *models.SmContextCreateData,
+	retrieved *smf_context.SmContext,
+) *httpwrapper.Response {
+	logger.PduSessLog.Infoln("In HandlePDUSessionSMContextImport")
+
+	// Check duplicate SM Context
+	if dup_smCtx := smf_context.GetSMContextById(createData.Supi, createData.PduSessionId); dup_smCtx != nil {
+		HandlePDUSessionSMContextLocalRelease(dup_smCtx, createData)
+	}
+
+	smContext := smf_context.NewSMContext(createData.Supi, createData.PduSessionId)
+	smContext.SetState(smf_context.ActivePending)
+	smContext.SmContextCreateData = createData
+	smContext.SmStatusNotifyUri = createData.SmContextStatusUri
+
+	smContext.SMLock.Lock()
+	needUnlock := true
+	defer func() {
+		if needUnlock {
+			smContext.SMLock.Unlock()
+		}
+	}()
+
+	upi := smf_context.GetUserPlaneInformation()
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+
+	smContext.DNNInfo = smf_context.RetrieveDnnInformation(smContext.SNssai, smContext.Dnn)
+	if smContext.DNNInfo == nil {
+		logger.PduSessLog.Errorf("S-NSSAI[sst: %d, sd: %s] DNN[%s] not matched DNN Config",
+			smContext.SNssai.Sst, smContext.SNssai.Sd, smContext.Dnn)
+	}
+
+	smPolicyDecision, err := smContext.ImportSmContext(retrieved)
+	if err != nil {
+		smContext.Log.Errorf("Import SM context failed: %v", err)
+		return makeImportErrorResAndReleaseSMContext(smContext, http.StatusBadRequest, "MANDATORY_IE_INCORRECT", err)
+	}
+
+	discoverServingAMF(smContext)
+
+	if err := smContext.AllocImportedUeIP(); err != nil {
+		smContext.Log.Errorf("Import SM context failed: %v", err)
+		return makeImportErrorResAndReleaseSMContext(smContext,
+			http.StatusInternalServerError, "INSUFFICIENT_RESOURCES_SLICE_DNN", err)
+	}
+
+	if retrieved.SmPolicyId != "" {
+		// keep the SM policy association with PCF
+		if err := smContext.PCFSelection(); err != nil {
+			smContext.Log.Errorln("pcf selection error:", err)
+		}
+	}
+
+	if err := smContext.ApplySessionRules(smPolicyDecision); err != nil {
+		smContext.Log.Errorf("Import SM context failed: %v", err)
+		return makeImportErrorResAndReleaseSMContext(smContext, http.StatusBadRequest, "MANDATORY_IE_INCORRECT", err)
+	}
+
+	if err := smContext.SelectDefaultDataPath(); err != nil {
+		smContext.Log.Errorf("Import SM context failed: %v", err)
+		return makeImportErrorResAndReleaseSMContext(smContext,
+			http.StatusInternalServerError, "INSUFFICIENT_RESOURCES_SLICE_DNN", err)
+	}
+
+	if err := smContext.ApplyPccRules(smPolicyDecision); err != nil {
+		smContext.Log.Errorf("apply sm policy decision error: %+v", err)
+	}
+
+	var response models.PostSmContextsResponse
+	response.JsonData = smContext.BuildCreatedData()
+	response.JsonData.UpCnxState = retrieved.UpCnxState
+	// AN is informed of the uplink tunnel of the UPF of this SMF
+	if n2Buf, err := smf_context.BuildPDUSessionResourceSetupRequestTransfer(smContext); err != nil {
+		smContext.Log.Errorf("Build PDUSession Resource Setup Request Transfer Error(%s)", err.Error())
+	} else {
+		response.JsonData.N2SmInfoType = models.N2SmInfoType_PDU_RES_SETUP_REQ
+		response.JsonData.N2SmInfo = &models.RefToBinaryData{ContentId: "PDUSessionResourceSetupRequestTransfer"}
+		response.BinaryDataN2SmInformation = n2Buf
+	}
+
+	needUnlock = false
+	go func() {
+		defer smContext.SMLock.Unlock()
+
+		ActivateUPFSession(smContext, importHandler)
+
+		smContext.SendEventExposureNotification(SendEventExposureNotification)
+	}()
+
+	return &httpwrapper.Response{
+		Header: http.Header{
+			"Location": {smContext.Ref},
+		},
+		Status: http.StatusCreated,
+		Body:   response,
+	}
+}
+
+func importHandler(smContext *smf_context.SMContext, success bool) {
+	if !success {
+		// AMF is informed of the release by the SM context status notification
+		smContext.Log.Errorln("Import SM context: PFCP session establishment failed")
+		RemoveSMContextFromAllNF(smContext, true)
+		return
+	}
+	smContext.SetState(smf_context.Active)
+	smContext.Log.Infoln("Import SM context: user plane is re-established")
+}
+
+func makeImportErrorResAndReleaseSMContext(smContext *smf_context.SMContext,
+	status int, cause string, err error,
+) *httpwrapper.Response {
+	// the SM policy association is still used by the SMF which the SM context is retrieved from
+	smContext.SMPolicyID = ""
+	RemoveSMContextFromAllNF(smContext, false)
+	return &httpwrapper.Response{
+		Status: status,
+		Body: models.PostSmContextsErrorResponse{
+			JsonData: &models.SmContextCreateError{
+				Error: &models.ProblemDetails{
+					Title:  "Import SM context failed",
+					Status: int32(status),
+					Detail: err.Error(),
+					Cause:  cause,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,307 @@
+package producer
+
+import (
+	"net/http"
+	"strconv"
+
+	"bitbucket.org/free5gc-team/nas"
+	"bitbucket.org/free5gc-team/nas/nasMessage"
+	"bitbucket.org/free5gc-team/openapi/Nsmf_PDUSession"
+	"bitbucket.org/free5gc-team/openapi/models"
+	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
+	"bitbucket.org/free5gc-team/smf/internal/sbi/consumer"
+	"bitbucket.org/free5gc-team/util/httpwrapper"
+)
+
+// homeRoutedHSmfUri returns the H-SMF of a home-routed PDU session, the H-SMF URI from AMF
+// takes precedence over the home-routed DNN config. Empty if the PDU session is not home-routed.
+func homeRoutedHSmfUri(smContext *smf_context.SMContext) string {
+	if smContext.HSmfUri != "" {
+		return smContext.HSmfUri
+	}
+	if smContext.DNNInfo != nil {
+		return smContext.DNNInfo.HSmfUri
+	}
+	return ""
+}
+
+// handleVsmfPDUSessionSMContextCreate creates a home-routed PDU session as V-SMF (TS 23.502 4.3.2.2.2).
+// Only the V-UPF is selected, the UE IP and the policy are decided by H-SMF.
+// The PDU Session Establishment Accept from H-SMF is returned if the V-UPF should be set up.
+func handleVsmfPDUSessionSMContextCreate(smContext *smf_context.SMContext, m *nas.Message,
+	request models.PostSmContextsRequest, hSmfUri string,
+) (*httpwrapper.Response, []byte) {
+	smContext.Log.Infof("Home-routed PDU session, H-SMF[%s]", hSmfUri)
+	smContext.Role = smf_context.SMFRoleVSMF
+	smContext.HSmfUri = hSmfUri
+
+	establishmentRequest := m.PDUSessionEstablishmentRequest
+	smContext.Pti = establishmentRequest.GetPTI()
+	smContext.SelectedPDUSessionType = nasMessage.PDUSessionTypeIPv4
+	if establishmentRequest.PDUSessionType != nil {
+		smContext.SelectedPDUSessionType = establishmentRequest.PDUSessionType.GetPDUSessionTypeValue()
+	}
+
+	discoverServingAMF(smContext)
+
+	if err := smContext.ActivateVsmfDataPath(); err != nil {
+		smContext.SetState(smf_context.InActive)
+		smContext.Log.Errorf("PDUSessionSMContextCreate err: %v", err)
+		return makeEstRejectResAndReleaseSMContext(smContext,
+			nasMessage.Cause5GSMInsufficientResourcesForSpecificSliceAndDNN,
+			&Nsmf_PDUSession.InsufficientResourceSliceDnn), nil
+	}
+
+	vcnTunnelInfo, err := smContext.BuildVcnTunnelInfo()
+	if err != nil {
+		smContext.Log.Errorf("PDUSessionSMContextCreate err: %v", err)
+		releaseVsmfTunnel(smContext)
+		return makeEstRejectResAndReleaseSMContext(smContext,
+			nasMessage.Cause5GSMNetworkFailure,
+			&Nsmf_PDUSession.NetworkFailure), nil
+	}
+
+	createRsp, createErrRsp, location, err := consumer.SendPostPduSessions(hSmfUri, models.PostPduSessionsRequest{
+		JsonData:                 smContext.BuildPduSessionCreateData(vcnTunnelInfo),
+		BinaryDataN1SmInfoFromUe: request.BinaryDataN1SmMessage,
+	})
+	if err != nil {
+		smContext.Log.Errorf("Create PDU session in H-SMF failed: %v", err)
+		releaseVsmfTunnel(smContext)
+		return makeEstRejectResAndReleaseSMContext(smContext,
+			nasMessage.Cause5GSMNetworkFailure,
+			&Nsmf_PDUSession.NetworkFailure), nil
+	}
+	if createErrRsp != nil {
+		smContext.Log.Warnf("H-SMF rejected the PDU session: %+v", createErrRsp.JsonData.Error)
+		releaseVsmfTunnel(smContext)
+		return makeVsmfEstRejectResAndReleaseSMContext(smContext, createErrRsp), nil
+	}
+	smContext.HsmfPduSessionUri = location
+	smContext.Log.Infof("PDU session is created in H-SMF[%s]", location)
+
+	if err := smContext.ApplyPduSessionCreatedData(createRsp.JsonData); err != nil {
+		smContext.Log.Errorf("PDUSessionSMContextCreate err: %v", err)
+		releaseVsmfTunnel(smContext)
+		// H-SMF is released in RemoveSMContextFromAllNF
+		return makeEstRejectResAndReleaseSMContext(smContext,
+			nasMessage.Cause5GSMNetworkFailure,
+			&Nsmf_PDUSession.NetworkFailure), nil
+	}
+
+	estAccept := createRsp.BinaryDataN1SmInfoToUe
+	if estAccept == nil {
+		smContext.Log.Warnln("No PDU Session Establishment Accept from H-SMF")
+		if estAccept, err = smf_context.BuildGSMPDUSessionEstablishmentAccept(smContext); err != nil {
+			smContext.Log.Errorf("Build GSM PDUSessionEstablishmentAccept failed: %s", err)
+			releaseVsmfTunnel(smContext)
+			return makeEstRejectResAndReleaseSMContext(smContext,
+				nasMessage.Cause5GSMNetworkFailure,
+				&Nsmf_PDUSession.NetworkFailure), nil
+		}
+	}
+
+	return &httpwrapper.Response{
+		Header: http.Header{
+			"Location": {smContext.Ref},
+		},
+		Status: http.StatusCreated,
+		Body: models.PostSmContextsResponse{
+			JsonData: smContext.BuildCreatedData(),
+		},
+	}, estAccept
+}
+
+// vsmfEstHandler relays the PDU Session Establishment Accept from H-SMF to UE
+// once the V-UPF is set up
+func vsmfEstHandler(smContext *smf_context.SMContext, success bool, estAccept []byte) {
+	if success {
+		sendPDUSessionResourceSetup(smContext, estAccept)
+		smContext.BuildEventExposureNotification(models.SmfEvent_PDU_SES_EST, models.EventNotification{})
+	} else {
+		// H-SMF is released in RemoveSMContextFromAllNF
+		sendPDUSessionEstablishmentReject(smContext, nasMessage.Cause5GSMNetworkFailure)
+	}
+}
+
+// makeVsmfEstRejectResAndReleaseSMContext relays the PDU Session Establishment Reject from H-SMF
+func makeVsmfEstRejectResAndReleaseSMContext(smContext *smf_context.SMContext,
+	createErrRsp *models.PostPduSessionsErrorResponse,
+) *httpwrapper.Response {
+	sbiError := createErrRsp.JsonData.Error
+	if sbiError.Status == 0 {
+		sbiError.Status = http.StatusInternalServerError
+	}
+	if createErrRsp.BinaryDataN1SmInfoToUe == nil {
+		nasErrorCause := nasMessage.Cause5GSMNetworkFailure
+		if cause, err := strconv.ParseUint(createErrRsp.JsonData.N1smCause, 16, 8); err == nil {
+			nasErrorCause = uint8(cause)
+		}
+		return makeEstRejectResAndReleaseSMContext(smContext, nasErrorCause, sbiError)
+	}
+
+	RemoveSMContextFromAllNF(smContext, false)
+	return &httpwrapper.Response{
+		Status: int(sbiError.Status),
+		Body: models.PostSmContextsErrorResponse{
+			JsonData: &models.SmContextCreateError{
+				Error:   sbiError,
+				N1SmMsg: &models.RefToBinaryData{ContentId: "n1SmMsg"},
+			},
+			BinaryDataN1SmMessage: createErrRsp.BinaryDataN1SmInfoToUe,
+		},
+	}
+}
+
+// handleVsmfN1SmMessage relays the 5GSM message from UE to H-SMF and the response back to UE,
+// the V-UPF is released if H-SMF accepts the UE requested PDU session release
+func handleVsmfN1SmMessage(smContext *smf_context.SMContext, m *nas.Message, n1SmMsg []byte,
+	response models.UpdateSmContextResponse,
+) *httpwrapper.Response {
+	updateData := &models.HsmfUpdateData{
+		UeLocation:     smContext.UeLocation,
+		ServingNetwork: smContext.ServingNetwork,
+		AnType:         smContext.AnType,
+	}
+	switch m.GsmHeader.GetMessageType() {
+	case nas.MsgTypePDUSessionReleaseRequest, nas.MsgTypePDUSessionReleaseComplete:
+		updateData.RequestIndication = models.RequestIndication_UE_REQ_PDU_SES_REL
+	case nas.MsgTypePDUSessionModificationRequest:
+		updateData.RequestIndication = models.RequestIndication_UE_REQ_PDU_SES_MOD
+	default:
+		updateData.RequestIndication = models.RequestIndication_NW_REQ_PDU_SES_MOD
+	}
+
+	updateRsp, updateErrRsp, err := consumer.SendUpdatePduSession(smContext.HsmfPduSessionUri,
+		models.UpdatePduSessionRequest{
+			JsonData:                 updateData,
+			BinaryDataN1SmInfoFromUe: n1SmMsg,
+		})
+	if err != nil {
+		smContext.Log.Errorf("Relay N1 message to H-SMF failed: %v", err)
+		return &httpwrapper.Response{
+			Status: int(Nsmf_PDUSession.NetworkFailure.Status),
+			Body: models.UpdateSmContextErrorResponse{
+				JsonData: &models.SmContextUpdateError{
+					Error: &Nsmf_PDUSession.NetworkFailure,
+				},
+			},
+		}
+	}
+	if updateErrRsp != nil {
+		sbiError := updateErrRsp.JsonData.Error
+		smContext.Log.Warnf("H-SMF rejected the N1 message: %+v", sbiError)
+		errResponse := models.UpdateSmContextErrorResponse{
+			JsonData: &models.SmContextUpdateError{
+				Error: sbiError,
+			},
+		}
+		if updateErrRsp.BinaryDataN1SmInfoToUe != nil {
+			errResponse.BinaryDataN1SmMessage = updateErrRsp.BinaryDataN1SmInfoToUe
+			errResponse.JsonData.N1SmMsg = &models.RefToBinaryData{ContentId: "N1SmMsg"}
+		}
+		return &httpwrapper.Response{
+			Status: int(sbiError.Status),
+			Body:   errResponse,
+		}
+	}
+
+	if updateRsp.BinaryDataN1SmInfoToUe != nil {
+		response.BinaryDataN1SmMessage = updateRsp.BinaryDataN1SmInfoToUe
+		response.JsonData.N1SmMsg = &models.RefToBinaryData{ContentId: "N1SmMsg"}
+	}
+
+	switch m.GsmHeader.GetMessageType() {
+	case nas.MsgTypePDUSessionReleaseRequest:
+		if buf, err := smf_context.BuildPDUSessionResourceReleaseCommandTransfer(smContext); err != nil {
+			smContext.Log.Errorf("Build PDUSessionResourceReleaseCommandTransfer failed: %+v", err)
+		} else {
+			response.JsonData.N2SmInfoType = models.N2SmInfoType_PDU_RES_REL_CMD
+			response.BinaryDataN2SmInformation = buf
+			response.JsonData.N2SmInfo = &models.RefToBinaryData{ContentId: "PDUResourceReleaseCommand"}
+		}
+
+		if releaseSession(smContext) != smf_context.SessionReleaseSuccess {
+			smContext.SetState(smf_context.Active)
+			return &httpwrapper.Response{
+				Status: http.StatusInternalServerError,
+				Body: models.UpdateSmContextErrorResponse{
+					JsonData: &models.SmContextUpdateError{
+						Error: &models.ProblemDetails{
+							Status: http.StatusInternalServerError,
+							Cause:  "SYSTEM_FAILURE",
+						},
+					},
+				},
+			}
+		}
+		smContext.SetState(smf_context.InActivePending)
+	case nas.MsgTypePDUSessionReleaseComplete:
+		smContext.SetState(smf_context.InActive)
+		response.JsonData.UpCnxState = models.UpCnxState_DEACTIVATED
+		if smContext.Tunnel.ANInformation.IPAddress == nil {
+			RemoveSMContextFromAllNF(smContext, true)
+		}
+	default:
+		updateVsmfQos(smContext, updateRsp.JsonData, &response)
+	}
+
+	return &httpwrapper.Response{
+		Status: http.StatusOK,
+		Body:   response,
+	}
+}
+
+// updateVsmfQos updates the V-UPF and AN for the session AMBR and the QoS flows modified by H-SMF
+func updateVsmfQos(smContext *smf_context.SMContext, updatedData *models.HsmfUpdatedData,
+	response *models.UpdateSmContextResponse,
+) {
+	if updatedData == nil {
+		return
+	}
+	qerList, anModified, err := smContext.ApplyHsmfUpdatedData(updatedData)
+	if err != nil {
+		smContext.Log.Errorf("Apply QoS from H-SMF failed: %v", err)
+		return
+	}
+
+	if len(qerList) > 0 {
+		smContext.SetState(smf_context.PFCPModification)
+		if updateAnUpfPfcpSession(smContext, nil, nil, nil, qerList, nil) != smf_context.SessionUpdateSuccess {
+			smContext.Log.Warnln("Update session AMBR of V-UPF failed")
+		}
+		smContext.SetState(smf_context.Active)
+	}
+
+	if anModified {
+		if buf, err := smf_context.BuildPDUSessionResourceModifyRequestTransfer(smContext); err != nil {
+			smContext.Log.Errorf("build N2 BuildPDUSessionResourceModifyRequestTransfer failed: %v", err)
+		} else {
+			response.BinaryDataN2SmInformation = buf
+			response.JsonData.N2SmInfo = &models.RefToBinaryData{ContentId: "PDU_RES_MOD"}
+			response.JsonData.N2SmInfoType = models.N2SmInfoType_PDU_RES_MOD_REQ
+		}
+	}
+}
+
+// releaseHsmfPduSession releases the home-routed PDU session in H-SMF
+func releaseHsmfPduSession(smContext *smf_context.SMContext) {
+	problemDetails, err := consumer.SendReleasePduSession(smContext.HsmfPduSessionUri, models.ReleaseData{
+		UeLocation: smContext.UeLocation,
+	})
+	if err != nil {
+		smContext.Log.Errorf("Release PDU session in H-SMF failed: %v", err)
+	} else if problemDetails != nil {
+		smContext.Log.Warnf("Release PDU session in H-SMF Problem[%+v]", problemDetails)
+	} else {
+		smContext.Log.Infof("PDU session is released in H-SMF[%s]", smContext.HsmfPduSessionUri)
+	}
+	smContext.HsmfPduSessionUri = ""
+}
+
+// releaseVsmfTunnel frees the V-UPF tunnels allocated before the PFCP session is established
+func releaseVsmfTunnel(smContext *smf_context.SMContext) {
+	for _, dataPath := range smContext.Tunnel.DataPathPool {
+		dataPath.DeactivateTunnelAndPDR(smContext)
+	}
+}
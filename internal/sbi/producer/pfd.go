@@ -0,0 +1,129 @@
+package producer
+
+import (
+	"net/http"
+	"sync"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+	"bitbucket.org/free5gc-team/smf/internal/sbi/consumer"
+	"bitbucket.org/free5gc-team/util/httpwrapper"
+)
+
+// pfdSubscription is the subscription to the PFD changes of NEF
+var pfdSubscription struct {
+	mu  sync.Mutex
+	uri string
+}
+
+// InitPfdManagement fetches the PFDs of all applications from NEF and subscribes to their changes,
+// the PFDs of the UE routing config are used if NEF is not configured or can't be reached
+func InitPfdManagement() {
+	pfdStore := smf_context.GetPfdStore()
+	pfdStore.SetExpiredHandler(handlePfdExpired)
+
+	nefUri := smf_context.GetSelf().NefUri
+	if nefUri == "" {
+		return
+	}
+
+	pfdDatas, err := consumer.SendPfdFetch(nefUri, nil)
+	if err != nil {
+		logger.ConsumerLog.Errorf("Fetch PFDs from NEF failed: %+v", err)
+	}
+	for i := range pfdDatas {
+		pfdStore.Put(smf_context.PfdDataFromModels(&pfdDatas[i]))
+	}
+
+	uri, err := consumer.SendPfdSubscription(nefUri, nil)
+	if err != nil {
+		logger.ConsumerLog.Errorf("Subscribe to PFD changes of NEF failed: %+v", err)
+		return
+	}
+	pfdSubscription.mu.Lock()
+	pfdSubscription.uri = uri
+	pfdSubscription.mu.Unlock()
+}
+
+// TerminatePfdManagement removes the subscription to the PFD changes of NEF
+func TerminatePfdManagement() {
+	pfdSubscription.mu.Lock()
+	uri := pfdSubscription.uri
+	pfdSubscription.uri = ""
+	pfdSubscription.mu.Unlock()
+
+	if uri == "" {
+		return
+	}
+	if err := consumer.SendPfdUnsubscription(uri); err != nil {
+		logger.ConsumerLog.Errorf("Unsubscribe from PFD changes of NEF failed: %+v", err)
+	}
+}
+
+// HandlePfdChangeNotification removes the PFDs of the changed applications fetched from NEF,
+// the PFDs are fetched from NEF again and provisioned to UPFs after the notification is answered
+func HandlePfdChangeNotification(notifications []models.PfdChangeNotification) *httpwrapper.Response {
+	logger.PduSessLog.Infoln("In HandlePfdChangeNotification")
+
+	pfdStore := smf_context.GetPfdStore()
+	changedAppIDs := make([]string, 0, len(notifications))
+	fetchAppIDs := make([]string, 0, len(notifications))
+	for _, notification := range notifications {
+		pfdStore.Delete(notification.ApplicationId)
+		changedAppIDs = append(changedAppIDs, notification.ApplicationId)
+		if !notification.RemovalFlag {
+			fetchAppIDs = append(fetchAppIDs, notification.ApplicationId)
+		}
+	}
+
+	go refreshPfds(changedAppIDs, fetchAppIDs)
+
+	return &httpwrapper.Response{
+		Status: http.StatusNoContent,
+	}
+}
+
+// refreshPfds fetches the PFDs of fetchAppIDs from NEF and provisions the PFDs of changedAppIDs to UPFs
+func refreshPfds(changedAppIDs, fetchAppIDs []string) {
+	if len(fetchAppIDs) > 0 {
+		pfdDatas, err := consumer.SendPfdFetch(smf_context.GetSelf().NefUri, fetchAppIDs)
+		if err != nil {
+			logger.ConsumerLog.Errorf("Fetch PFDs from NEF failed: %+v", err)
+		}
+		for i := range pfdDatas {
+			smf_context.GetPfdStore().Put(smf_context.PfdDataFromModels(&pfdDatas[i]))
+		}
+	}
+	for _, appID := range changedAppIDs {
+		updatePfdsOfApp(appID)
+	}
+}
+
+// handlePfdExpired fetches the PFDs from NEF again when the cached PFDs expire
+func handlePfdExpired(appID string) {
+	if nefUri := smf_context.GetSelf().NefUri; nefUri != "" {
+		pfdDatas, err := consumer.SendPfdFetch(nefUri, []string{appID})
+		if err != nil {
+			logger.ConsumerLog.Errorf("Fetch PFDs of application[%s] from NEF failed: %+v", appID, err)
+		}
+		for i := range pfdDatas {
+			smf_context.GetPfdStore().Put(smf_context.PfdDataFromModels(&pfdDatas[i]))
+		}
+	}
+	updatePfdsOfApp(appID)
+}
+
+// updatePfdsOfApp provisions the current PFDs of the application to UPFs,
+// the PFDs are removed from UPFs if the application has no PFD
+func updatePfdsOfApp(appID string) {
+	update := smf_context.GetProcedureHandlers().PfdsUpdate
+	if update == nil {
+		return
+	}
+	if pfdData := smf_context.GetPfdStore().Get(appID); pfdData != nil {
+		update([]*smf_context.PfdDataForApp{pfdData}, nil)
+	} else {
+		update(nil, []string{appID})
+	}
+}
@@ -0,0 +1,38 @@
+package producer_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/smf/internal/context"
+	"bitbucket.org/free5gc-team/smf/internal/sbi/producer"
+)
+
+func TestHandlePfdChangeNotification(t *testing.T) {
+	removedCh := make(chan []string, 1)
+	context.SetProcedureHandlers(context.ProcedureHandlers{
+		PfdsUpdate: func(pfdDatas []*context.PfdDataForApp, removedAppIDs []string) {
+			removedCh <- removedAppIDs
+		},
+	})
+	defer context.SetProcedureHandlers(context.ProcedureHandlers{})
+
+	require.True(t, context.GetPfdStore().Put(&context.PfdDataForApp{AppID: "app-removed"}))
+	rsp := producer.HandlePfdChangeNotification([]models.PfdChangeNotification{
+		{ApplicationId: "app-removed", RemovalFlag: true},
+	})
+	require.Equal(t, http.StatusNoContent, rsp.Status)
+	require.Nil(t, context.GetPfdStore().Get("app-removed"))
+
+	// the PFDs are removed from UPFs after the notification is answered
+	select {
+	case removedAppIDs := <-removedCh:
+		require.Equal(t, []string{"app-removed"}, removedAppIDs)
+	case <-time.After(time.Second):
+		t.Fatal("PFDs of app-removed are not removed from UPFs")
+	}
+}
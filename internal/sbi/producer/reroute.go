@@ -0,0 +1,114 @@
+package producer
+
+import (
+	"context"
+	"fmt"
+
+	"bitbucket.org/free5gc-team/openapi/models"
+	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
+)
+
+// ReroutePDUSession moves the PDU session to an alternative data path to its anchor UPF which avoids
+// the failed user plane paths. The PFCP sessions of the old data path are deleted and established again
+// along the new one, then AN is requested to send the uplink traffic to the new AN UPF. The PFCP session on
+// releasedUPF, which has deleted it already, is only removed locally.
+// Only the PDU session with the default data path alone can be rerouted
+func ReroutePDUSession(smContext *smf_context.SMContext, releasedUPF *smf_context.UPF) error {
+	if smf_context.GetSelf().ULCLSupport && smf_context.CheckUEHasPreConfig(smContext.Supi) {
+		return fmt.Errorf("PDU session with pre-config routes can't be rerouted")
+	}
+	oldPath := smContext.Tunnel.DataPathPool.GetDefaultPath()
+	if oldPath == nil || len(smContext.Tunnel.DataPathPool) != 1 {
+		return fmt.Errorf("PDU session has data paths other than the default one")
+	}
+
+	upPath := smf_context.GetUserPlaneInformation().GetDefaultUserPlanePathByDNNAndUPF(
+		smContext.SelectionParam, smContext.SelectedUPF)
+	if upPath == nil || isSameUPPath(oldPath, upPath) {
+		return fmt.Errorf("no alternative data path to UPF[%s]", smContext.SelectedUPF.Name)
+	}
+
+	for _, res := range ReleaseTunnelExcept(smContext, releasedUPF) {
+		if res.Err != nil {
+			smContext.Log.Warnf("Delete PFCP session of old data path failed: %+v", res.Err)
+		}
+	}
+	smContext.Tunnel.RemoveDataPath(oldPath.PathID)
+	smContext.ReleasePFCPSessionContexts()
+
+	if err := smContext.SelectDefaultDataPath(); err != nil {
+		return err
+	}
+	success := true
+	ActivateUPFSession(smContext, func(_ *smf_context.SMContext, ok bool) {
+		success = ok
+	})
+	if !success {
+		return fmt.Errorf("establish PFCP sessions of new data path failed")
+	}
+
+	// AN gets the new uplink tunnel at the next Service Request if UP connection is deactivated
+	if smContext.UpCnxState == models.UpCnxState_ACTIVATED {
+		sendPDUSessionResourceModifyULTunnel(smContext)
+	}
+	return nil
+}
+
+func isSameUPPath(dataPath *smf_context.DataPath, upPath smf_context.UPPath) bool {
+	node := dataPath.FirstDPNode
+	for _, upNode := range upPath {
+		if node == nil || node.UPF != upNode.UPF {
+			return false
+		}
+		node = node.Next()
+	}
+	return node == nil
+}
+
+// sendPDUSessionResourceModifyULTunnel sends the uplink tunnel of the new AN UPF to AN
+func sendPDUSessionResourceModifyULTunnel(smContext *smf_context.SMContext) {
+	n2SmBuf, err := smf_context.BuildPDUSessionResourceModifyULTunnelRequestTransfer(smContext)
+	if err != nil {
+		smContext.Log.Errorf("Build PDUSessionResourceModifyULTunnelRequestTransfer failed: %v", err)
+		return
+	}
+
+	n1n2Request := models.N1N2MessageTransferRequest{
+		BinaryDataN2Information: n2SmBuf,
+		JsonData: &models.N1N2MessageTransferReqData{
+			PduSessionId: smContext.PDUSessionID,
+			N2InfoContainer: &models.N2InfoContainer{
+				N2InformationClass: models.N2InformationClass_SM,
+				SmInfo: &models.N2SmInformation{
+					PduSessionId: smContext.PDUSessionID,
+					N2InfoContent: &models.N2InfoContent{
+						NgapIeType: models.NgapIeType_PDU_RES_MOD_REQ,
+						NgapData: &models.RefToBinaryData{
+							ContentId: "N2SmInformation",
+						},
+					},
+					SNssai: smContext.SNssai,
+				},
+			},
+		},
+	}
+
+	rspData, rsp, err := smContext.
+		CommunicationClient.
+		N1N2MessageCollectionDocumentApi.
+		N1N2MessageTransfer(context.Background(), smContext.Supi, n1n2Request)
+	defer func() {
+		if rsp != nil {
+			if resCloseErr := rsp.Body.Close(); resCloseErr != nil {
+				smContext.Log.Warnf("response Body closed error")
+			}
+		}
+	}()
+	if err != nil {
+		smContext.Log.Warnf("Send N1N2Transfer failed: %v", err)
+		return
+	}
+	if rspData.Cause != models.N1N2MessageTransferCause_N1_N2_TRANSFER_INITIATED {
+		smContext.Log.Warnf("%v", rspData.Cause)
+	}
+}
@@ -0,0 +1,203 @@
+package producer
+
+import (
+	"context"
+
+	"bitbucket.org/free5gc-team/nas/nasMessage"
+	"bitbucket.org/free5gc-team/openapi/models"
+	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
+	"bitbucket.org/free5gc-team/smf/internal/dnaaa"
+	"bitbucket.org/free5gc-team/smf/pkg/factory"
+)
+
+// lockForSecondaryAuthentication locks the user plane information for the PDU session setup
+// and the PDU session, it returns the unlock function
+func lockForSecondaryAuthentication(smContext *smf_context.SMContext) func() {
+	upi := smf_context.GetUserPlaneInformation()
+	upi.Mu.RLock()
+	smContext.SMLock.Lock()
+	return func() {
+		smContext.SMLock.Unlock()
+		upi.Mu.RUnlock()
+	}
+}
+
+// startSecondaryAuthentication starts the secondary authentication of the PDU session by DN-AAA
+// (TS 23.502 4.3.2.3), the DN-specific identity is sent to DN-AAA directly if UE provides it,
+// otherwise UE is asked for the identity by EAP-Request/Identity
+func startSecondaryAuthentication(smContext *smf_context.SMContext) {
+	unlock := lockForSecondaryAuthentication(smContext)
+	smContext.DnAaaSession = &dnaaa.Session{
+		Supi:     smContext.Supi,
+		Gpsi:     smContext.Gpsi,
+		Dnn:      smContext.Dnn,
+		Identity: smContext.DNSpecificIdentity,
+	}
+
+	if smContext.DNSpecificIdentity == "" {
+		sendPDUSessionAuthenticationCommand(smContext, dnaaa.NewEAPIdentityRequest(1))
+		unlock()
+		return
+	}
+	unlock()
+	relayEAPMessageToDnAaa(smContext, dnaaa.NewEAPIdentityResponse(1, smContext.DNSpecificIdentity))
+}
+
+// HandlePDUSessionAuthenticationComplete relays the EAP response of UE to DN-AAA
+func HandlePDUSessionAuthenticationComplete(smContext *smf_context.SMContext,
+	req *nasMessage.PDUSessionAuthenticationComplete,
+) {
+	relayEAPMessageToDnAaa(smContext, req.EAPMessage.GetEAPMessage())
+}
+
+// relayEAPMessageToDnAaa sends the EAP message to DN-AAA and handles the answer, the PDU session
+// is set up when UE is authenticated, otherwise it's rejected with #29 (TS 24.501 6.4.1.4).
+// The PDU session isn't locked while waiting for DN-AAA, which may retransmit for seconds
+func relayEAPMessageToDnAaa(smContext *smf_context.SMContext, eapMsg []byte) {
+	unlock := lockForSecondaryAuthentication(smContext)
+	session := smContext.DnAaaSession
+	if session == nil {
+		smContext.Log.Warnln("EAP message without ongoing secondary authentication")
+		unlock()
+		return
+	}
+	if session.Busy {
+		smContext.Log.Infoln("EAP message is being relayed to DN-AAA, drop the retransmission")
+		unlock()
+		return
+	}
+	session.Busy = true
+	smContext.StopT3590()
+	dnAaa := smContext.DNNInfo.DnAaa
+	unlock()
+
+	result, err := dnAaa.Authenticate(session, eapMsg)
+
+	unlock = lockForSecondaryAuthentication(smContext)
+	defer unlock()
+	session.Busy = false
+	if smContext.DnAaaSession != session {
+		smContext.Log.Infoln("Secondary authentication is aborted, drop the answer of DN-AAA")
+		return
+	}
+
+	if err != nil {
+		smContext.Log.Errorf("Secondary authentication by DN-AAA failed: %v", err)
+		result = &dnaaa.Result{
+			Code:       dnaaa.ResultFailure,
+			EAPMessage: dnaaa.NewEAPFailure(dnaaa.EAPIdentifier(eapMsg)),
+		}
+	}
+	smContext.Log.Infof("Secondary authentication by DN-AAA: %s", result.Code)
+
+	switch result.Code {
+	case dnaaa.ResultContinue:
+		sendPDUSessionAuthenticationCommand(smContext, result.EAPMessage)
+	case dnaaa.ResultSuccess:
+		smContext.DnAaaSession = nil
+		smContext.DnAaaAuthorization = result.Authorization
+		smContext.EAPMessage = result.EAPMessage
+
+		if nasErrorCause, sbiError := setupPDUSession(smContext); sbiError != nil {
+			sendPDUSessionEstablishmentReject(smContext, nasErrorCause)
+			return
+		}
+		activatePDUSession(smContext)
+	default:
+		smContext.DnAaaSession = nil
+		smContext.EAPMessage = result.EAPMessage
+		sendPDUSessionEstablishmentReject(smContext,
+			nasMessage.Cause5GSMUserAuthenticationOrAuthorizationFailed)
+	}
+}
+
+// t3590 returns the configured T3590, or the default of TS 24.501 if it isn't configured
+func t3590() *factory.TimerValue {
+	if t := factory.SmfConfig.Configuration.T3590; t != nil {
+		return t
+	}
+	return &factory.TimerValue{
+		Enable:        true,
+		ExpireTime:    factory.DefaultT3590ExpireTime,
+		MaxRetryTimes: factory.DefaultT3590MaxRetryTimes,
+	}
+}
+
+// sendPDUSessionAuthenticationCommand sends the EAP request of DN-AAA to UE and starts T3590,
+// the command is retransmitted on the expiry and the PDU session is rejected on the last expiry
+// (TS 24.501 6.3.1.2)
+func sendPDUSessionAuthenticationCommand(smContext *smf_context.SMContext, eapMsg []byte) {
+	smNasBuf, err := smf_context.BuildGSMPDUSessionAuthenticationCommand(smContext, eapMsg)
+	if err != nil {
+		smContext.Log.Errorf("Build GSM PDUSessionAuthenticationCommand failed: %s", err)
+		return
+	}
+
+	n1n2Request := models.N1N2MessageTransferRequest{
+		BinaryDataN1Message: smNasBuf,
+		JsonData: &models.N1N2MessageTransferReqData{
+			PduSessionId: smContext.PDUSessionID,
+			N1MessageContainer: &models.N1MessageContainer{
+				N1MessageClass:   "SM",
+				N1MessageContent: &models.RefToBinaryData{ContentId: "GSM_NAS"},
+			},
+		},
+	}
+
+	smContext.StopT3590()
+	if err := transferPDUSessionAuthenticationCommand(smContext, n1n2Request); err != nil {
+		smContext.Log.Warnf("Send N1N2Transfer failed: %v", err)
+		smContext.DnAaaSession = nil
+		smContext.SetState(smf_context.InActive)
+		RemoveSMContextFromAllNF(smContext, true)
+		return
+	}
+
+	t := t3590()
+	if !t.Enable {
+		return
+	}
+	session := smContext.DnAaaSession
+	smContext.T3590 = smf_context.NewTimer(t.ExpireTime, t.MaxRetryTimes, func(expireTimes int32) {
+		smContext.SMLock.Lock()
+		defer smContext.SMLock.Unlock()
+		smContext.Log.Infof("T3590 expires %d times, retransmit PDU Session Authentication Command", expireTimes)
+		if err := transferPDUSessionAuthenticationCommand(smContext, n1n2Request); err != nil {
+			smContext.Log.Warnf("Send N1N2Transfer for PDUSessionAuthenticationCommand failed: %v", err)
+		}
+	}, func() {
+		unlock := lockForSecondaryAuthentication(smContext)
+		defer unlock()
+		smContext.T3590 = nil
+		if smContext.DnAaaSession != session || session.Busy {
+			return
+		}
+		smContext.Log.Warnf("T3590 expires %d times, abort secondary authentication", t.MaxRetryTimes+1)
+		smContext.DnAaaSession = nil
+		sendPDUSessionEstablishmentReject(smContext,
+			nasMessage.Cause5GSMUserAuthenticationOrAuthorizationFailed)
+	})
+}
+
+func transferPDUSessionAuthenticationCommand(smContext *smf_context.SMContext,
+	n1n2Request models.N1N2MessageTransferRequest,
+) error {
+	rspData, rsp, err := smContext.
+		CommunicationClient.
+		N1N2MessageCollectionDocumentApi.
+		N1N2MessageTransfer(context.Background(), smContext.Supi, n1n2Request)
+	defer func() {
+		if rsp != nil {
+			if resCloseErr := rsp.Body.Close(); resCloseErr != nil {
+				smContext.Log.Warnf("response Body closed error")
+			}
+		}
+	}()
+	if err != nil {
+		return err
+	}
+	if rspData.Cause == models.N1N2MessageTransferCause_N1_MSG_NOT_TRANSFERRED {
+		smContext.Log.Warnf("%v", rspData.Cause)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package producer
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/h2non/gock.v1"
+
+	"bitbucket.org/free5gc-team/openapi"
+	"bitbucket.org/free5gc-team/openapi/Namf_Communication"
+	"bitbucket.org/free5gc-team/openapi/models"
+	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
+	"bitbucket.org/free5gc-team/smf/internal/dnaaa"
+	"bitbucket.org/free5gc-team/smf/pkg/factory"
+)
+
+// fakeDnAaa answers the EAP messages with the results sent to the channel
+type fakeDnAaa struct {
+	smContext *smf_context.SMContext
+	results   chan *dnaaa.Result
+	relaying  chan struct{}
+
+	mu      sync.Mutex
+	eapMsgs [][]byte
+	// locked is set if the PDU session is locked while waiting for DN-AAA
+	locked bool
+}
+
+func (f *fakeDnAaa) Authenticate(session *dnaaa.Session, eapMsg []byte) (*dnaaa.Result, error) {
+	acquired := make(chan struct{})
+	go func() {
+		f.smContext.SMLock.Lock()
+		f.smContext.SMLock.Unlock()
+		close(acquired)
+	}()
+	locked := false
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		locked = true
+	}
+
+	f.mu.Lock()
+	f.eapMsgs = append(f.eapMsgs, eapMsg)
+	f.locked = f.locked || locked
+	f.mu.Unlock()
+
+	f.relaying <- struct{}{}
+	return <-f.results, nil
+}
+
+func newSecondaryAuthSMContext(t *testing.T, supi string) (*smf_context.SMContext, *fakeDnAaa) {
+	if smf_context.GetSelf().UserPlaneInformation == nil {
+		smf_context.GetSelf().UserPlaneInformation = &smf_context.UserPlaneInformation{}
+	}
+	origConfig := factory.SmfConfig
+	factory.SmfConfig = &factory.Config{
+		Configuration: &factory.Configuration{
+			T3590: &factory.TimerValue{Enable: true, ExpireTime: 100 * time.Millisecond, MaxRetryTimes: 1},
+		},
+	}
+	t.Cleanup(func() { factory.SmfConfig = origConfig })
+
+	smContext := smf_context.NewSMContext(supi, 10)
+	smContext.Supi = supi
+	smContext.Dnn = "internet"
+	smContext.DNSpecificIdentity = "user@dn.example"
+	client := &fakeDnAaa{
+		smContext: smContext,
+		results:   make(chan *dnaaa.Result, 1),
+		relaying:  make(chan struct{}, 2),
+	}
+	smContext.DNNInfo = &smf_context.SnssaiSmfDnnInfo{DnAaa: client}
+	communicationConf := Namf_Communication.NewConfiguration()
+	communicationConf.SetBasePath("http://127.0.0.18:8000")
+	smContext.CommunicationClient = Namf_Communication.NewAPIClient(communicationConf)
+	return smContext, client
+}
+
+func stubN1N2MessageTransfer(supi string, times int) {
+	gock.New("http://127.0.0.18:8000").
+		Post("/namf-comm/v1/ue-contexts/" + supi + "/n1-n2-messages").
+		Times(times).
+		Reply(http.StatusOK).
+		JSON(models.N1N2MessageTransferRspData{Cause: models.N1N2MessageTransferCause_N1_N2_TRANSFER_INITIATED})
+}
+
+func TestSecondaryAuthenticationT3590(t *testing.T) {
+	openapi.InterceptH2CClient()
+	defer openapi.RestoreH2CClient()
+	defer gock.Off()
+
+	supi := "imsi-208930000000101"
+	smContext, client := newSecondaryAuthSMContext(t, supi)
+	// PDU Session Authentication Command, its retransmission and PDU Session Establishment Reject
+	stubN1N2MessageTransfer(supi, 3)
+
+	client.results <- &dnaaa.Result{
+		Code:       dnaaa.ResultContinue,
+		EAPMessage: []byte{dnaaa.EAPCodeRequest, 2, 0, 5, 254},
+	}
+	startSecondaryAuthentication(smContext)
+
+	client.mu.Lock()
+	require.Equal(t, [][]byte{dnaaa.NewEAPIdentityResponse(1, "user@dn.example")}, client.eapMsgs)
+	require.False(t, client.locked)
+	client.mu.Unlock()
+
+	// the PDU session isn't set up until UE is authenticated
+	smContext.SMLock.Lock()
+	require.NotNil(t, smContext.DnAaaSession)
+	require.NotNil(t, smContext.T3590)
+	require.NotEqual(t, smf_context.Active, smContext.State())
+	smContext.SMLock.Unlock()
+
+	// UE doesn't answer, the PDU session is rejected on the last expiry of T3590
+	require.Eventually(t, func() bool {
+		smContext.SMLock.Lock()
+		defer smContext.SMLock.Unlock()
+		return smContext.DnAaaSession == nil && smContext.T3590 == nil
+	}, 2*time.Second, 20*time.Millisecond)
+	require.Eventually(t, gock.IsDone, time.Second, 20*time.Millisecond)
+	require.Equal(t, smf_context.InActive, smContext.State())
+}
+
+func TestSecondaryAuthenticationAborted(t *testing.T) {
+	openapi.InterceptH2CClient()
+	defer openapi.RestoreH2CClient()
+	defer gock.Off()
+
+	smContext, client := newSecondaryAuthSMContext(t, "imsi-208930000000102")
+	smContext.DnAaaSession = &dnaaa.Session{Supi: smContext.Supi, Dnn: smContext.Dnn}
+
+	done := make(chan struct{})
+	go func() {
+		relayEAPMessageToDnAaa(smContext, []byte{dnaaa.EAPCodeResponse, 2, 0, 6, 254, 0})
+		close(done)
+	}()
+	<-client.relaying
+
+	// the retransmission of UE received while waiting for DN-AAA is dropped
+	relayEAPMessageToDnAaa(smContext, []byte{dnaaa.EAPCodeResponse, 2, 0, 6, 254, 0})
+	client.mu.Lock()
+	require.Len(t, client.eapMsgs, 1)
+	require.False(t, client.locked)
+	client.mu.Unlock()
+
+	// the PDU session is released before DN-AAA answers
+	smContext.SMLock.Lock()
+	smContext.DnAaaSession = nil
+	smContext.SMLock.Unlock()
+
+	client.results <- &dnaaa.Result{
+		Code:          dnaaa.ResultSuccess,
+		EAPMessage:    []byte{dnaaa.EAPCodeSuccess, 2, 0, 4},
+		Authorization: &dnaaa.Authorization{},
+	}
+	<-done
+
+	// the answer is dropped, neither the PDU session is set up nor UE is notified
+	require.Nil(t, smContext.DnAaaAuthorization)
+	require.Nil(t, smContext.T3590)
+	require.False(t, gock.HasUnmatchedRequest())
+}
@@ -1,7 +1,14 @@
 package producer
 
 import (
+	"context"
+	"net/http"
+
+	"bitbucket.org/free5gc-team/nas/nasMessage"
+	"bitbucket.org/free5gc-team/openapi/models"
+	"bitbucket.org/free5gc-team/smf/internal/cdr"
 	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
 	"bitbucket.org/free5gc-team/smf/internal/sbi/consumer"
 )
 
@@ -16,6 +23,15 @@ func RemoveSMContextFromAllNF(smContext *smf_context.SMContext, sendNotification
 		}
 	}
 
+	// report the final usage to CHF
+	releaseCharging(smContext)
+	smContext.CloseChargingRecord()
+
+	// release the PDU session in H-SMF for a home-routed PDU session
+	if smContext.Role == smf_context.SMFRoleVSMF && smContext.HsmfPduSessionUri != "" {
+		releaseHsmfPduSession(smContext)
+	}
+
 	// Because the amfUE who called this SMF API is being locked until the API Handler returns,
 	// sending SMContext Status Notification should run asynchronously
 	// so that this function returns immediately.
@@ -48,3 +64,101 @@ func SendReleaseNotification(smContext *smf_context.SMContext) {
 		smContext.Log.Traceln("Send SMContext Status Notification successfully")
 	}
 }
+
+// ReleasePDUSession requests AMF to release the PDU session with the 5GSM cause and removes the SM context
+// at once. The PFCP sessions are deleted except the one on releasedUPF, which has deleted it already
+func ReleasePDUSession(smContext *smf_context.SMContext, releasedUPF *smf_context.UPF, cause uint8) {
+	if cause == nasMessage.Cause5GSMNetworkFailure {
+		smContext.RecordClosingCause = cdr.CauseAbnormalRelease
+	}
+	switch smContext.State() {
+	case smf_context.Active, smf_context.ModificationPending, smf_context.PFCPModification:
+		needToSendNotify, removeContext := requestAMFToReleasePDUResources(smContext, cause)
+		if needToSendNotify {
+			SendReleaseNotification(smContext)
+		}
+		if removeContext {
+			ReleaseTunnelExcept(smContext, releasedUPF)
+			// Notification has already been sent, if it is needed
+			RemoveSMContextFromAllNF(smContext, false)
+		}
+	}
+}
+
+func requestAMFToReleasePDUResources(
+	smContext *smf_context.SMContext, cause uint8,
+) (sendNotify bool, releaseContext bool) {
+	n1n2Request := models.N1N2MessageTransferRequest{}
+	// TS 23.502 4.3.4.2 3b. Send Namf_Communication_N1N2MessageTransfer Request, SMF->AMF
+	n1n2Request.JsonData = &models.N1N2MessageTransferReqData{
+		PduSessionId: smContext.PDUSessionID,
+		SkipInd:      true,
+	}
+	if buf, err := smf_context.BuildGSMPDUSessionReleaseCommand(smContext, cause, false); err != nil {
+		logger.PduSessLog.Errorf("Build GSM PDUSessionReleaseCommand failed: %+v", err)
+	} else {
+		n1n2Request.BinaryDataN1Message = buf
+		n1n2Request.JsonData.N1MessageContainer = &models.N1MessageContainer{
+			N1MessageClass:   "SM",
+			N1MessageContent: &models.RefToBinaryData{ContentId: "GSM_NAS"},
+		}
+	}
+	if smContext.UpCnxState != models.UpCnxState_DEACTIVATED {
+		if buf, err := smf_context.BuildPDUSessionResourceReleaseCommandTransfer(smContext); err != nil {
+			logger.PduSessLog.Errorf("Build PDUSessionResourceReleaseCommandTransfer failed: %+v", err)
+		} else {
+			n1n2Request.BinaryDataN2Information = buf
+			n1n2Request.JsonData.N2InfoContainer = &models.N2InfoContainer{
+				N2InformationClass: models.N2InformationClass_SM,
+				SmInfo: &models.N2SmInformation{
+					PduSessionId: smContext.PDUSessionID,
+					N2InfoContent: &models.N2InfoContent{
+						NgapIeType: models.NgapIeType_PDU_RES_REL_CMD,
+						NgapData: &models.RefToBinaryData{
+							ContentId: "N2SmInformation",
+						},
+					},
+					SNssai: smContext.SNssai,
+				},
+			}
+		}
+	}
+
+	rspData, res, err := smContext.CommunicationClient.
+		N1N2MessageCollectionDocumentApi.
+		N1N2MessageTransfer(context.Background(), smContext.Supi, n1n2Request)
+	if err != nil {
+		logger.PduSessLog.Warnf("Send N1N2Transfer failed: %+v", err)
+	}
+	defer func() {
+		if resCloseErr := res.Body.Close(); resCloseErr != nil {
+			logger.PduSessLog.Errorf("N1N2MessageTransfer response body cannot close: %+v", resCloseErr)
+		}
+	}()
+	switch res.StatusCode {
+	case http.StatusOK:
+		if rspData.Cause == models.N1N2MessageTransferCause_N1_MSG_NOT_TRANSFERRED {
+			// the PDU Session Release Command was not transferred to the UE since it is in CM-IDLE state.
+			//   ref. step3b of "4.3.4.2 UE or network requested PDU Session Release for Non-Roaming and
+			//        Roaming with Local Breakout" in TS23.502
+			// it is needed to remove both AMF's and SMF's SM Contexts immediately
+			smContext.SetState(smf_context.InActive)
+			return true, true
+		} else if rspData.Cause == models.N1N2MessageTransferCause_N1_N2_TRANSFER_INITIATED {
+			// wait for N2 PDU Session Release Response
+			smContext.SetState(smf_context.InActivePending)
+		} else {
+			// other causes are unexpected.
+			// keep SM Context to avoid inconsistency with AMF
+			smContext.SetState(smf_context.InActive)
+		}
+	case http.StatusNotFound:
+		// it is not needed to notify AMF, but needed to remove SM Context in SMF immediately
+		smContext.SetState(smf_context.InActive)
+		return false, true
+	default:
+		// keep SM Context to avoid inconsistency with AMF
+		smContext.SetState(smf_context.InActive)
+	}
+	return false, false
+}
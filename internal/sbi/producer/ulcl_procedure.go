@@ -265,11 +265,9 @@ func EstablishRANTunnelInfo(smContext *context.SMContext) {
 	}
 
 	activatingANUPFDLFAR.State = context.RULE_INITIAL
-	activatingANUPFDLFAR.ForwardingParameters.OuterHeaderCreation = new(pfcpType.OuterHeaderCreation)
-	anOuterHeaderCreation := activatingANUPFDLFAR.ForwardingParameters.OuterHeaderCreation
-	anOuterHeaderCreation.OuterHeaderCreationDescription = pfcpType.OuterHeaderCreationGtpUUdpIpv4
-	anOuterHeaderCreation.Teid = defaultANUPFDLFAR.ForwardingParameters.OuterHeaderCreation.Teid
-	anOuterHeaderCreation.Ipv4Address = defaultANUPFDLFAR.ForwardingParameters.OuterHeaderCreation.Ipv4Address
+	defaultOuterHeaderCreation := defaultANUPFDLFAR.ForwardingParameters.OuterHeaderCreation
+	activatingANUPFDLFAR.ForwardingParameters.OuterHeaderCreation = context.GTPUOuterHeaderCreation(
+		context.OuterHeaderCreationIP(defaultOuterHeaderCreation), defaultOuterHeaderCreation.Teid)
 }
 
 func UpdateRANAndIUPFUpLink(smContext *context.SMContext) {
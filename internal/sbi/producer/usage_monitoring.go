@@ -0,0 +1,57 @@
+package producer
+
+import (
+	"bitbucket.org/free5gc-team/openapi/models"
+	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
+	"bitbucket.org/free5gc-team/smf/internal/sbi/consumer"
+)
+
+// HandleUsageMonitoringReport reports the accumulated usage of the PDU session to PCF
+// when UPF reports the usage of a monitoring key reaches its threshold
+func HandleUsageMonitoringReport(smContext *smf_context.SMContext) {
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	if smContext.State() != smf_context.Active {
+		return
+	}
+	ReportUsageMonitoring(smContext)
+}
+
+// ReportUsageMonitoring reports the accumulated usage of the monitoring keys reaching the thresholds to PCF,
+// resets the usage and updates the usage monitoring URRs on UPFs with the thresholds of the response.
+// The monitoring of a reported key stops if PCF provides no new thresholds for it
+func ReportUsageMonitoring(smContext *smf_context.SMContext) {
+	reports := smContext.AccuUsageReports(false)
+	if len(reports) == 0 {
+		return
+	}
+	decision, err := consumer.SendSMPolicyAssociationUpdateByUsageReport(smContext, reports)
+	if err != nil {
+		smContext.Log.Errorf("Report usage monitoring failed: %v", err)
+		return
+	}
+	smContext.ResetMonitoredUsage(reports)
+
+	umDecs := make(map[string]*models.UsageMonitoringData)
+	for _, r := range reports {
+		umDecs[r.RefUmIds] = nil
+	}
+	for id, umData := range decision.UmDecs {
+		umDecs[id] = umData
+	}
+	urrs := smContext.ApplyUsageMonitoringDatas(umDecs)
+	if len(urrs) == 0 {
+		return
+	}
+	resChan := make(chan SendPfcpResult)
+	for upf, urrList := range urrs {
+		go modifyExistingPfcpSession(smContext, &PFCPState{upf: upf, urrList: urrList}, resChan)
+	}
+	for i := 0; i < len(urrs); i++ {
+		if res := <-resChan; res.Status == smf_context.SessionUpdateFailed {
+			smContext.Log.Warnf("Update usage monitoring URRs failed: %+v", res.Err)
+		}
+	}
+	close(resChan)
+}
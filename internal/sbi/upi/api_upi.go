@@ -33,6 +33,31 @@ func GetUpNodesLinks(c *gin.Context) {
 	c.JSON(httpResponse.Status, httpResponse.Body)
 }
 
+// UpNodeFeatures is the PFCP features negotiated with UPF,
+// and the functions of SMF which are skipped on UPF because it lacks the features
+type UpNodeFeatures struct {
+	UPFunctionFeatures []string `json:"upFunctionFeatures"`
+	CPFunctionFeatures []string `json:"cpFunctionFeatures"`
+	Unsupported        []string `json:"unsupported"`
+}
+
+func GetUpNodesFeatures(c *gin.Context) {
+	upi := smf_context.GetSelf().UserPlaneInformation
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+
+	json := make(map[string]*UpNodeFeatures)
+	for name, upNode := range upi.UPFs {
+		json[name] = &UpNodeFeatures{
+			UPFunctionFeatures: upNode.UPF.SupportedUPFeatures(),
+			CPFunctionFeatures: upNode.UPF.SupportedCPFeatures(),
+			Unsupported:        upNode.UPF.UnsupportedFeatureUsages(),
+		}
+	}
+
+	c.JSON(http.StatusOK, json)
+}
+
 func PostUpNodesLinks(c *gin.Context) {
 	upi := smf_context.GetSelf().UserPlaneInformation
 	upi.Mu.Lock()
@@ -73,6 +73,12 @@ var routes = Routes{
 		"/upNodesLinks",
 		GetUpNodesLinks,
 	},
+	{
+		"GetUpNodesFeatures",
+		strings.ToUpper("Get"),
+		"/upNodesFeatures",
+		GetUpNodesFeatures,
+	},
 	{
 		"DeleteUpNodeLink",
 		strings.ToUpper("Delete"),
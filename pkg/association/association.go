@@ -3,15 +3,14 @@ package association
 import (
 	"context"
 	"fmt"
-	"net/http"
 	"time"
 
 	"bitbucket.org/free5gc-team/nas/nasMessage"
-	"bitbucket.org/free5gc-team/openapi/models"
 	"bitbucket.org/free5gc-team/pfcp"
 	"bitbucket.org/free5gc-team/pfcp/pfcpType"
 	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
 	"bitbucket.org/free5gc-team/smf/internal/logger"
+	"bitbucket.org/free5gc-team/smf/internal/pfcp/handler"
 	"bitbucket.org/free5gc-team/smf/internal/pfcp/message"
 	"bitbucket.org/free5gc-team/smf/internal/sbi/producer"
 )
@@ -120,7 +119,23 @@ func setupPfcpAssociation(upf *smf_context.UPF, upfStr string) error {
 
 	logger.MainLog.Infof("Received PFCP Association Setup Accepted Response from UPF%s", upfStr)
 
+	// before any PFCP session is established by SMF
+	if !upf.StaleSessionsDeleted {
+		deleteStaleSessions(upf, upfStr)
+		upf.StaleSessionsDeleted = true
+	}
+
 	upf.UPFStatus = smf_context.AssociatedSetUpSuccess
+	upf.SetFunctionFeatures(rsp.UPFunctionFeatures)
+	logger.MainLog.Infof("UPF%s supports UP features %v", upfStr, upf.SupportedUPFeatures())
+	if upf.Releasing {
+		// UPF released before is selected again
+		upi := smf_context.GetUserPlaneInformation()
+		upi.Mu.Lock()
+		upf.Releasing = false
+		upi.InvalidateDefaultPaths()
+		upi.Mu.Unlock()
+	}
 
 	if rsp.UserPlaneIPResourceInformation != nil {
 		upf.UPIPInfo = *rsp.UserPlaneIPResourceInformation
@@ -129,9 +144,27 @@ func setupPfcpAssociation(upf *smf_context.UPF, upfStr string) error {
 			upf.NodeID.ResolveNodeIdToIp().String(), upf.UPIPInfo.NetworkInstance.NetworkInstance)
 	}
 
+	// the PDRs of PCC rules refer to the application identifiers of the PFDs
+	if err := provisionPfds(upf, upfStr, smf_context.GetPfdStore().All(), nil); err != nil {
+		logger.MainLog.Errorf("Failed to provision PFDs to UPF%s: %+v", upfStr, err)
+	}
+
 	return nil
 }
 
+// deleteStaleSessions deletes the PFCP sessions left on UPF by SMF before it restarts,
+// they're in the set of the FQ-CSID of SMF which is the same after the restart
+func deleteStaleSessions(upf *smf_context.UPF, upfStr string) {
+	logger.MainLog.Infof("Sending PFCP Session Set Deletion Request to UPF%s", upfStr)
+
+	resMsg, err := message.SendPfcpSessionSetDeletionRequest(upf, smf_context.GetSelf().LocalFQCSID())
+	if err != nil {
+		logger.MainLog.Warnf("Failed to delete stale PFCP sessions from UPF%s: %+v", upfStr, err)
+		return
+	}
+	handler.HandlePfcpSessionSetDeletionResponse(resMsg)
+}
+
 func keepHeartbeatTo(ctx context.Context, upf *smf_context.UPF, upfStr string) {
 	for {
 		err := doPfcpHeartbeat(upf, upfStr)
@@ -193,93 +226,19 @@ func releaseAllResourcesOfUPF(upf *smf_context.UPF, upfStr string) {
 	upf.ProcEachSMContext(func(smContext *smf_context.SMContext) {
 		smContext.SMLock.Lock()
 		defer smContext.SMLock.Unlock()
-		switch smContext.State() {
-		case smf_context.Active, smf_context.ModificationPending, smf_context.PFCPModification:
-			needToSendNotify, removeContext := requestAMFToReleasePDUResources(smContext)
-			if needToSendNotify {
-				producer.SendReleaseNotification(smContext)
-			}
-			if removeContext {
-				// Notification has already been sent, if it is needed
-				producer.RemoveSMContextFromAllNF(smContext, false)
-			}
-		}
+		producer.ReleasePDUSession(smContext, upf, nasMessage.Cause5GSMNetworkFailure)
 	})
 }
 
-func requestAMFToReleasePDUResources(smContext *smf_context.SMContext) (sendNotify bool, releaseContext bool) {
-	n1n2Request := models.N1N2MessageTransferRequest{}
-	// TS 23.502 4.3.4.2 3b. Send Namf_Communication_N1N2MessageTransfer Request, SMF->AMF
-	n1n2Request.JsonData = &models.N1N2MessageTransferReqData{
-		PduSessionId: smContext.PDUSessionID,
-		SkipInd:      true,
-	}
-	cause := nasMessage.Cause5GSMNetworkFailure
-	if buf, err := smf_context.BuildGSMPDUSessionReleaseCommand(smContext, cause, false); err != nil {
-		logger.MainLog.Errorf("Build GSM PDUSessionReleaseCommand failed: %+v", err)
-	} else {
-		n1n2Request.BinaryDataN1Message = buf
-		n1n2Request.JsonData.N1MessageContainer = &models.N1MessageContainer{
-			N1MessageClass:   "SM",
-			N1MessageContent: &models.RefToBinaryData{ContentId: "GSM_NAS"},
-		}
-	}
-	if smContext.UpCnxState != models.UpCnxState_DEACTIVATED {
-		if buf, err := smf_context.BuildPDUSessionResourceReleaseCommandTransfer(smContext); err != nil {
-			logger.MainLog.Errorf("Build PDUSessionResourceReleaseCommandTransfer failed: %+v", err)
-		} else {
-			n1n2Request.BinaryDataN2Information = buf
-			n1n2Request.JsonData.N2InfoContainer = &models.N2InfoContainer{
-				N2InformationClass: models.N2InformationClass_SM,
-				SmInfo: &models.N2SmInformation{
-					PduSessionId: smContext.PDUSessionID,
-					N2InfoContent: &models.N2InfoContent{
-						NgapIeType: models.NgapIeType_PDU_RES_REL_CMD,
-						NgapData: &models.RefToBinaryData{
-							ContentId: "N2SmInformation",
-						},
-					},
-					SNssai: smContext.SNssai,
-				},
-			}
-		}
-	}
+// HandleSessionSetDeletion releases the PDU sessions whose PFCP sessions are deleted
+// by PFCP Session Set Deletion of UPF, and AMF is notified to release them. Their PFCP sessions
+// on the other UPFs of the data paths are deleted
+func HandleSessionSetDeletion(upf *smf_context.UPF, smContexts []*smf_context.SMContext) {
+	logger.MainLog.Infof("Release PDU sessions deleted by UPF[%s]", upf.NodeID.ResolveNodeIdToIp().String())
 
-	rspData, res, err := smContext.CommunicationClient.
-		N1N2MessageCollectionDocumentApi.
-		N1N2MessageTransfer(context.Background(), smContext.Supi, n1n2Request)
-	if err != nil {
-		logger.MainLog.Warnf("Send N1N2Transfer failed: %+v", err)
-	}
-	defer func() {
-		if resCloseErr := res.Body.Close(); resCloseErr != nil {
-			logger.PduSessLog.Errorf("N1N2MessageTransfer response body cannot close: %+v", resCloseErr)
-		}
-	}()
-	switch res.StatusCode {
-	case http.StatusOK:
-		if rspData.Cause == models.N1N2MessageTransferCause_N1_MSG_NOT_TRANSFERRED {
-			// the PDU Session Release Command was not transferred to the UE since it is in CM-IDLE state.
-			//   ref. step3b of "4.3.4.2 UE or network requested PDU Session Release for Non-Roaming and
-			//        Roaming with Local Breakout" in TS23.502
-			// it is needed to remove both AMF's and SMF's SM Contexts immediately
-			smContext.SetState(smf_context.InActive)
-			return true, true
-		} else if rspData.Cause == models.N1N2MessageTransferCause_N1_N2_TRANSFER_INITIATED {
-			// wait for N2 PDU Session Release Response
-			smContext.SetState(smf_context.InActivePending)
-		} else {
-			// other causes are unexpected.
-			// keep SM Context to avoid inconsistency with AMF
-			smContext.SetState(smf_context.InActive)
-		}
-	case http.StatusNotFound:
-		// it is not needed to notify AMF, but needed to remove SM Context in SMF immediately
-		smContext.SetState(smf_context.InActive)
-		return false, true
-	default:
-		// keep SM Context to avoid inconsistency with AMF
-		smContext.SetState(smf_context.InActive)
+	for _, smContext := range smContexts {
+		smContext.SMLock.Lock()
+		producer.ReleasePDUSession(smContext, upf, nasMessage.Cause5GSMNetworkFailure)
+		smContext.SMLock.Unlock()
 	}
-	return false, false
 }
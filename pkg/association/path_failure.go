@@ -0,0 +1,51 @@
+package association
+
+import (
+	"fmt"
+	"net"
+
+	"bitbucket.org/free5gc-team/nas/nasMessage"
+	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+	"bitbucket.org/free5gc-team/smf/internal/sbi/producer"
+)
+
+// HandleUserPlanePathFailure handles the PDU sessions over the failed user plane path between UPF and
+// the remote GTP-U peer reported by PFCP Node Report. The PDU sessions are rerouted through alternative
+// UPFs if the peer is another UPF and they can be, otherwise they are released
+func HandleUserPlanePathFailure(upf *smf_context.UPF, remoteGTPUPeer net.IP) {
+	upfStr := fmt.Sprintf("[%s]", upf.NodeID.ResolveNodeIdToIp().String())
+	logger.MainLog.Infof("Handle user plane path failure from UPF%s to GTP-U peer[%s]", upfStr, remoteGTPUPeer)
+
+	upi := smf_context.GetUserPlaneInformation()
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+
+	peerIsUPF := false
+	for _, upNode := range upi.UPFs {
+		if upNode.UPF.HasGTPUAddress(remoteGTPUPeer) {
+			peerIsUPF = true
+			break
+		}
+	}
+
+	for _, smContext := range upf.SMContextsOverGTPUPath(remoteGTPUPeer) {
+		handlePDUSessionOverFailedPath(smContext, peerIsUPF)
+	}
+}
+
+func handlePDUSessionOverFailedPath(smContext *smf_context.SMContext, reroute bool) {
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	if reroute && smContext.State() == smf_context.Active {
+		err := producer.ReroutePDUSession(smContext, nil)
+		if err == nil {
+			smContext.Log.Infoln("PDU session is rerouted over alternative user plane path")
+			return
+		}
+		smContext.Log.Warnf("Reroute PDU session failed: %+v", err)
+	}
+	smContext.Log.Infoln("Release PDU session over failed user plane path")
+	producer.ReleasePDUSession(smContext, nil, nasMessage.Cause5GSMNetworkFailure)
+}
@@ -0,0 +1,60 @@
+package association
+
+import (
+	"fmt"
+
+	"bitbucket.org/free5gc-team/pfcp"
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+	"bitbucket.org/free5gc-team/smf/internal/pfcp/message"
+)
+
+// provisionPfds sends the PFDs to UPF by PFCP PFD Management, the PFDs of removedAppIDs are
+// removed from UPF (TS 29.244 6.2.5)
+func provisionPfds(upf *smf_context.UPF, upfStr string,
+	pfdDatas []*smf_context.PfdDataForApp, removedAppIDs []string,
+) error {
+	if len(pfdDatas) == 0 && len(removedAppIDs) == 0 {
+		return nil
+	}
+	if !upf.SupportsUPFeature(smf_context.UPFeaturePFDM) {
+		logger.MainLog.Debugf("UPF%s doesn't support PFD management, PFDs are not provisioned", upfStr)
+		return nil
+	}
+
+	logger.MainLog.Infof("Sending PFCP PFD Management Request to UPF%s", upfStr)
+
+	resMsg, err := message.SendPfcpPfdManagementRequest(upf, pfdDatas, removedAppIDs)
+	if err != nil {
+		return err
+	}
+
+	rsp := resMsg.PfcpMessage.Body.(pfcp.PFCPPFDManagementResponse)
+	if rsp.Cause == nil || rsp.Cause.CauseValue != pfcpType.CauseRequestAccepted {
+		return fmt.Errorf("received PFCP PFD Management Not Accepted Response from UPF%s", upfStr)
+	}
+
+	logger.MainLog.Infof("Received PFCP PFD Management Accepted Response from UPF%s", upfStr)
+	return nil
+}
+
+// UpdatePfds sends the updated PFDs to all associated UPFs
+func UpdatePfds(pfdDatas []*smf_context.PfdDataForApp, removedAppIDs []string) {
+	upi := smf_context.GetUserPlaneInformation()
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+
+	for _, upNode := range upi.UPFs {
+		upf := upNode.UPF
+		if upf.UPFStatus != smf_context.AssociatedSetUpSuccess {
+			continue
+		}
+		upfStr := fmt.Sprintf("[%s]", upf.NodeID.ResolveNodeIdToIp().String())
+		go func() {
+			if err := provisionPfds(upf, upfStr, pfdDatas, removedAppIDs); err != nil {
+				logger.MainLog.Errorf("Failed to provision PFDs to UPF%s: %+v", upfStr, err)
+			}
+		}()
+	}
+}
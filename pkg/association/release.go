@@ -0,0 +1,87 @@
+package association
+
+import (
+	"fmt"
+	"time"
+
+	"bitbucket.org/free5gc-team/nas/nasMessage"
+	"bitbucket.org/free5gc-team/pfcp"
+	"bitbucket.org/free5gc-team/pfcp/pfcpType"
+	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
+	"bitbucket.org/free5gc-team/smf/internal/logger"
+	"bitbucket.org/free5gc-team/smf/internal/pfcp/message"
+	"bitbucket.org/free5gc-team/smf/internal/sbi/producer"
+)
+
+// drainCheckInterval is the interval to check whether the PDU sessions of the releasing UPF are drained
+const drainCheckInterval = time.Second
+
+// HandleUPFRelease drains the PDU sessions of UPF which requests to release the PFCP association.
+// The PDU sessions are rerouted through other UPFs if UPF is not their anchor, otherwise UE is requested
+// to reactivate them on another anchor. The association is released when the PDU sessions are drained
+// or the graceful release period expires
+func HandleUPFRelease(upf *smf_context.UPF, gracefulReleasePeriod time.Duration) {
+	upfStr := fmt.Sprintf("[%s]", upf.NodeID.ResolveNodeIdToIp().String())
+	logger.MainLog.Infof("Drain PDU sessions of UPF%s", upfStr)
+
+	deadline := time.Now().Add(gracefulReleasePeriod)
+	drainPDUSessions(upf)
+
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+	for time.Now().Before(deadline) && len(upf.SMContextsOverUPF()) > 0 {
+		<-ticker.C
+	}
+	if n := len(upf.SMContextsOverUPF()); n > 0 {
+		logger.MainLog.Warnf("%d PDU sessions are left on UPF%s after graceful release period", n, upfStr)
+	}
+
+	// the association is released already if UPF requested it by PFCP Association Release
+	if upf.UPFStatus != smf_context.AssociatedSetUpSuccess {
+		return
+	}
+	logger.MainLog.Infof("Sending PFCP Association Release Request to UPF%s", upfStr)
+	resMsg, err := message.SendPfcpAssociationReleaseRequest(upf.NodeID)
+	if err != nil {
+		logger.MainLog.Warnf("Failed to release PFCP association with UPF%s: %+v", upfStr, err)
+	} else {
+		rsp := resMsg.PfcpMessage.Body.(pfcp.PFCPAssociationReleaseResponse)
+		if rsp.Cause == nil || rsp.Cause.CauseValue != pfcpType.CauseRequestAccepted {
+			logger.MainLog.Warnf("Received PFCP Association Release Not Accepted Response from UPF%s", upfStr)
+		} else {
+			logger.MainLog.Infof("Received PFCP Association Release Accepted Response from UPF%s", upfStr)
+		}
+	}
+	// the association is set up again when UPF accepts it
+	upf.UPFStatus = smf_context.NotAssociated
+}
+
+// drainPDUSessions drains the PDU sessions one by one, so the locks aren't held while the others are drained.
+// UPF releases their PFCP sessions with the association, so they're only removed locally from it
+func drainPDUSessions(upf *smf_context.UPF) {
+	for _, smContext := range upf.SMContextsOverUPF() {
+		drainPDUSession(smContext, upf)
+	}
+}
+
+func drainPDUSession(smContext *smf_context.SMContext, upf *smf_context.UPF) {
+	upi := smf_context.GetUserPlaneInformation()
+	upi.Mu.RLock()
+	defer upi.Mu.RUnlock()
+	smContext.SMLock.Lock()
+	defer smContext.SMLock.Unlock()
+
+	if smContext.SelectedUPF == nil || smContext.SelectedUPF.UPF != upf {
+		if smContext.State() == smf_context.Active {
+			err := producer.ReroutePDUSession(smContext, upf)
+			if err == nil {
+				smContext.Log.Infoln("PDU session is rerouted away from releasing UPF")
+				return
+			}
+			smContext.Log.Warnf("Reroute PDU session failed: %+v", err)
+		}
+	}
+	// UE establishes the PDU session again, and it's anchored on another UPF
+	smContext.Log.Infoln("Release PDU session over releasing UPF")
+	producer.ReleasePDUSession(smContext, upf, nasMessage.Cause5GSMReactivationRequested)
+}
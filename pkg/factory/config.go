@@ -7,6 +7,7 @@ package factory
 import (
 	"errors"
 	"fmt"
+	"net"
 	"strconv"
 	"sync"
 	"time"
@@ -74,21 +75,26 @@ func (i *Info) validate() (bool, error) {
 }
 
 type Configuration struct {
-	SmfName              string               `yaml:"smfName" valid:"type(string),required"`
-	Sbi                  *Sbi                 `yaml:"sbi" valid:"required"`
-	PFCP                 *PFCP                `yaml:"pfcp" valid:"required"`
-	NrfUri               string               `yaml:"nrfUri" valid:"url,required"`
-	UserPlaneInformation UserPlaneInformation `yaml:"userplaneInformation" valid:"required"`
-	ServiceNameList      []string             `yaml:"serviceNameList" valid:"required"`
-	SNssaiInfo           []*SnssaiInfoItem    `yaml:"snssaiInfos" valid:"required"`
-	ULCL                 bool                 `yaml:"ulcl" valid:"type(bool),optional"`
-	PLMNList             []PlmnID             `yaml:"plmnList"  valid:"optional"`
-	Locality             string               `yaml:"locality" valid:"type(string),optional"`
-	UrrPeriod            uint16               `yaml:"urrPeriod,omitempty" valid:"optional"`
-	UrrThreshold         uint64               `yaml:"urrThreshold,omitempty" valid:"optional"`
-	T3591                *TimerValue          `yaml:"t3591" valid:"required"`
-	T3592                *TimerValue          `yaml:"t3592" valid:"required"`
-	NwInstFqdnEncoding   bool                 `yaml:"nwInstFqdnEncoding" valid:"type(bool),optional"`
+	SmfName              string                `yaml:"smfName" valid:"type(string),required"`
+	Sbi                  *Sbi                  `yaml:"sbi" valid:"required"`
+	PFCP                 *PFCP                 `yaml:"pfcp" valid:"required"`
+	NrfUri               string                `yaml:"nrfUri" valid:"url,required"`
+	NefUri               string                `yaml:"nefUri,omitempty" valid:"url,optional"`
+	UserPlaneInformation UserPlaneInformation  `yaml:"userplaneInformation" valid:"required"`
+	ServiceNameList      []string              `yaml:"serviceNameList" valid:"required"`
+	SNssaiInfo           []*SnssaiInfoItem     `yaml:"snssaiInfos" valid:"required"`
+	ULCL                 bool                  `yaml:"ulcl" valid:"type(bool),optional"`
+	PLMNList             []PlmnID              `yaml:"plmnList"  valid:"optional"`
+	Locality             string                `yaml:"locality" valid:"type(string),optional"`
+	UrrPeriod            uint16                `yaml:"urrPeriod,omitempty" valid:"optional"`
+	UrrThreshold         uint64                `yaml:"urrThreshold,omitempty" valid:"optional"`
+	T3590                *TimerValue           `yaml:"t3590,omitempty" valid:"optional"`
+	T3591                *TimerValue           `yaml:"t3591" valid:"required"`
+	T3592                *TimerValue           `yaml:"t3592" valid:"required"`
+	NwInstFqdnEncoding   bool                  `yaml:"nwInstFqdnEncoding" valid:"type(bool),optional"`
+	NotificationDelivery *NotificationDelivery `yaml:"notificationDelivery,omitempty" valid:"optional"`
+	Charging             *Charging             `yaml:"charging,omitempty" valid:"optional"`
+	Cdr                  *Cdr                  `yaml:"cdr,omitempty" valid:"optional"`
 }
 
 type Logger struct {
@@ -142,6 +148,12 @@ func (c *Configuration) validate() (bool, error) {
 		}
 	}
 
+	if t3590 := c.T3590; t3590 != nil {
+		if result, err := t3590.validate(); err != nil {
+			return result, err
+		}
+	}
+
 	if t3591 := c.T3591; t3591 != nil {
 		if result, err := t3591.validate(); err != nil {
 			return result, err
@@ -154,6 +166,24 @@ func (c *Configuration) validate() (bool, error) {
 		}
 	}
 
+	if notificationDelivery := c.NotificationDelivery; notificationDelivery != nil {
+		if result, err := notificationDelivery.validate(); err != nil {
+			return result, err
+		}
+	}
+
+	if charging := c.Charging; charging != nil {
+		if result, err := charging.validate(); err != nil {
+			return result, err
+		}
+	}
+
+	if cdr := c.Cdr; cdr != nil {
+		if result, err := cdr.validate(); err != nil {
+			return result, err
+		}
+	}
+
 	result, err := govalidator.ValidateStruct(c)
 	return result, appendInvalid(err)
 }
@@ -187,9 +217,13 @@ func (s *SnssaiInfoItem) validate() (bool, error) {
 }
 
 type SnssaiDnnInfoItem struct {
-	Dnn   string `yaml:"dnn" valid:"type(string),minstringlength(1),required"`
-	DNS   *DNS   `yaml:"dns" valid:"required"`
-	PCSCF *PCSCF `yaml:"pcscf,omitempty" valid:"optional"`
+	Dnn           string         `yaml:"dnn" valid:"type(string),minstringlength(1),required"`
+	DNS           *DNS           `yaml:"dns" valid:"required"`
+	PCSCF         *PCSCF         `yaml:"pcscf,omitempty" valid:"optional"`
+	HomeRouted    *HomeRouted    `yaml:"homeRouted,omitempty" valid:"optional"`
+	N6Tunnel      *N6Tunnel      `yaml:"n6Tunnel,omitempty" valid:"optional"`
+	SecondaryAuth *SecondaryAuth `yaml:"secondaryAuth,omitempty" valid:"optional"`
+	Buffering     *Buffering     `yaml:"buffering,omitempty" valid:"optional"`
 }
 
 func (s *SnssaiDnnInfoItem) validate() (bool, error) {
@@ -205,10 +239,120 @@ func (s *SnssaiDnnInfoItem) validate() (bool, error) {
 		}
 	}
 
+	if homeRouted := s.HomeRouted; homeRouted != nil {
+		if result, err := homeRouted.validate(); err != nil {
+			return result, err
+		}
+	}
+
+	if n6Tunnel := s.N6Tunnel; n6Tunnel != nil {
+		if result, err := n6Tunnel.validate(); err != nil {
+			return result, err
+		}
+	}
+
+	if secondaryAuth := s.SecondaryAuth; secondaryAuth != nil {
+		if result, err := secondaryAuth.validate(); err != nil {
+			return result, err
+		}
+	}
+
+	if buffering := s.Buffering; buffering != nil {
+		if result, err := buffering.validate(); err != nil {
+			return result, err
+		}
+	}
+
+	result, err := govalidator.ValidateStruct(s)
+	return result, appendInvalid(err)
+}
+
+// HomeRouted marks the DNN as home-routed roaming, the SMF acts as V-SMF toward the H-SMF.
+// HSmfUri is the API URI of the Nsmf_PDUSession service of H-SMF, e.g. http://hsmf:8000/nsmf-pdusession/v1
+type HomeRouted struct {
+	HSmfUri string `yaml:"hSmfUri" valid:"url,required"`
+}
+
+func (h *HomeRouted) validate() (bool, error) {
+	result, err := govalidator.ValidateStruct(h)
+	return result, appendInvalid(err)
+}
+
+// N6Tunnel is the N6 point-to-point tunnel toward the application server for Unstructured PDU session
+// of the DNN (TS 23.501 5.6.10.3), only UDP/IPv4 tunnelling is supported.
+// MTU is the unstructured link MTU provided to UE, 1400 if it is not configured
+type N6Tunnel struct {
+	Type       string `yaml:"type" valid:"in(udp),required"`
+	ServerIPv4 string `yaml:"serverIPv4" valid:"ipv4,required"`
+	ServerPort int    `yaml:"serverPort" valid:"port,required"`
+	MTU        uint16 `yaml:"mtu,omitempty" valid:"optional"`
+}
+
+func (n *N6Tunnel) validate() (bool, error) {
+	result, err := govalidator.ValidateStruct(n)
+	return result, appendInvalid(err)
+}
+
+// SecondaryAuth enables the secondary authentication/authorization of PDU sessions of the DNN
+// by DN-AAA (TS 23.501 5.6.6), only RADIUS is supported
+type SecondaryAuth struct {
+	Type   string        `yaml:"type" valid:"in(radius),required"`
+	Radius *RadiusServer `yaml:"radius" valid:"required"`
+}
+
+func (s *SecondaryAuth) validate() (bool, error) {
+	if radius := s.Radius; radius != nil {
+		if result, err := radius.validate(); err != nil {
+			return result, err
+		}
+	}
+
 	result, err := govalidator.ValidateStruct(s)
 	return result, appendInvalid(err)
 }
 
+// MaxDDNDelay is the longest Downlink Data Notification Delay, which is 255 multiples of 50ms (TS 29.244 8.2.28)
+const MaxDDNDelay = 255 * 50 * time.Millisecond
+
+// Buffering is the downlink data buffering of PDU sessions of the DNN while their UP connections are
+// deactivated (TS 23.502 4.2.3.3). Location is upf or smf, UPF forwards the downlink data to SMF over N4-u
+// to buffer it in SMF. DDNDelay is sent to UPF in BAR, it is rounded down to multiples of 50ms.
+// SuggestedPacketsCount is the packets buffered per PDU session, 10 if it is not configured.
+// DDNThrottlingInterval is the minimum interval between the pagings of a PDU session for downlink data.
+// DDNFailureBackoff suppresses the pagings of a PDU session after UE isn't reached, the buffered downlink data
+// is dropped. ExtendedBuffering keeps the downlink data buffered in UPF for the maximum waiting time of UE
+// in MICO mode or extended DRX given by AMF (TS 23.401 5.3.4.3, TS 23.502 4.2.3.3)
+type Buffering struct {
+	Location              string        `yaml:"location" valid:"in(upf|smf),required"`
+	DDNDelay              time.Duration `yaml:"ddnDelay,omitempty" valid:"type(time.Duration),optional"`
+	SuggestedPacketsCount uint8         `yaml:"suggestedPacketsCount,omitempty" valid:"optional"`
+	DDNThrottlingInterval time.Duration `yaml:"ddnThrottlingInterval,omitempty" valid:"type(time.Duration),optional"`
+	DDNFailureBackoff     time.Duration `yaml:"ddnFailureBackoff,omitempty" valid:"type(time.Duration),optional"`
+	ExtendedBuffering     bool          `yaml:"extendedBuffering,omitempty" valid:"type(bool),optional"`
+}
+
+func (b *Buffering) validate() (bool, error) {
+	if b.DDNDelay < 0 || b.DDNDelay > MaxDDNDelay {
+		return false, errors.New("Invalid ddnDelay: " + b.DDNDelay.String() + ", should be in range 0~12.75s.")
+	}
+
+	result, err := govalidator.ValidateStruct(b)
+	return result, appendInvalid(err)
+}
+
+// RadiusServer is the RADIUS server of DN-AAA, ServerAddr is in host:port form, e.g. 10.60.0.200:1812.
+// NASIdentifier is sent in the NAS-Identifier attribute, "SMF" if it is not configured
+type RadiusServer struct {
+	ServerAddr    string `yaml:"serverAddr" valid:"dialstring,required"`
+	Secret        string `yaml:"secret" valid:"type(string),minstringlength(1),required"`
+	NASIdentifier string `yaml:"nasIdentifier,omitempty" valid:"type(string),optional"`
+}
+
+func (r *RadiusServer) validate() (bool, error) {
+	result, err := govalidator.ValidateStruct(r)
+	return result, appendInvalid(err)
+}
+
 type Sbi struct {
 	Scheme       string `yaml:"scheme" valid:"scheme,required"`
 	Tls          *Tls   `yaml:"tls" valid:"optional"`
@@ -243,10 +387,13 @@ func (t *Tls) validate() (bool, error) {
 	return result, appendInvalid(err)
 }
 
+// PFCP is the N4 of SMF, the addresses are IPv4 or IPv6. ListenAddr is "::" to listen on both of them
+// for dual-stack N4, where ExternalAddrIPv6 is the IPv6 address of SMF besides the IPv4 ExternalAddr
 type PFCP struct {
-	ListenAddr   string `yaml:"listenAddr,omitempty" valid:"host,required"`
-	ExternalAddr string `yaml:"externalAddr,omitempty" valid:"host,required"`
-	NodeID       string `yaml:"nodeID,omitempty" valid:"host,required"`
+	ListenAddr       string `yaml:"listenAddr,omitempty" valid:"host,required"`
+	ExternalAddr     string `yaml:"externalAddr,omitempty" valid:"host,required"`
+	ExternalAddrIPv6 string `yaml:"externalAddrIPv6,omitempty" valid:"ipv6,optional"`
+	NodeID           string `yaml:"nodeID,omitempty" valid:"host,required"`
 	// interval at which PFCP Association Setup error messages are output.
 	AssocFailAlertInterval time.Duration `yaml:"assocFailAlertInterval,omitempty" valid:"type(time.Duration),optional"`
 	AssocFailRetryInterval time.Duration `yaml:"assocFailRetryInterval,omitempty" valid:"type(time.Duration),optional"`
@@ -259,20 +406,29 @@ func (p *PFCP) validate() (bool, error) {
 }
 
 type DNS struct {
-	IPv4Addr string `yaml:"ipv4,omitempty" valid:"ipv4,required"`
+	IPv4Addr string `yaml:"ipv4,omitempty" valid:"ipv4,optional"`
 	IPv6Addr string `yaml:"ipv6,omitempty" valid:"ipv6,optional"`
 }
 
 func (d *DNS) validate() (bool, error) {
+	if d.IPv4Addr == "" && d.IPv6Addr == "" {
+		return false, errors.New("Invalid DNS: ipv4 or ipv6 should be set")
+	}
+
 	result, err := govalidator.ValidateStruct(d)
 	return result, appendInvalid(err)
 }
 
 type PCSCF struct {
-	IPv4Addr string `yaml:"ipv4,omitempty" valid:"ipv4,required"`
+	IPv4Addr string `yaml:"ipv4,omitempty" valid:"ipv4,optional"`
+	IPv6Addr string `yaml:"ipv6,omitempty" valid:"ipv6,optional"`
 }
 
 func (p *PCSCF) validate() (bool, error) {
+	if p.IPv4Addr == "" && p.IPv6Addr == "" {
+		return false, errors.New("Invalid PCSCF: ipv4 or ipv6 should be set")
+	}
+
 	result, err := govalidator.ValidateStruct(p)
 	return result, appendInvalid(err)
 }
@@ -478,6 +634,8 @@ type UPNode struct {
 	Dnn                  string                  `json:"dnn" yaml:"dnn" valid:"type(string),minstringlength(1),optional"`
 	SNssaiInfos          []*SnssaiUpfInfoItem    `json:"sNssaiUpfInfos" yaml:"sNssaiUpfInfos,omitempty" valid:"optional"`
 	InterfaceUpfInfoList []*InterfaceUpfInfoItem `json:"interfaces" yaml:"interfaces,omitempty" valid:"optional"`
+	// ChooseFteid lets UPF allocate the F-TEIDs of PDRs, UPF must support FTUP
+	ChooseFteid bool `json:"chooseFteid,omitempty" yaml:"chooseFteid,omitempty" valid:"optional"`
 }
 
 func (u *UPNode) validate() (bool, error) {
@@ -617,6 +775,8 @@ func appendInvalid(err error) error {
 	return error(errs)
 }
 
+// UEIPPool is an IPv4 address pool or an IPv6 prefix pool,
+// a /64 prefix of an IPv6 prefix pool is delegated to each PDU session
 type UEIPPool struct {
 	Cidr string `yaml:"cidr" valid:"cidr,required"`
 }
@@ -627,7 +787,16 @@ func (u *UEIPPool) validate() (bool, error) {
 	})
 
 	result, err := govalidator.ValidateStruct(u)
-	return result, appendInvalid(err)
+	if err != nil {
+		return result, appendInvalid(err)
+	}
+
+	if _, ipNet, err := net.ParseCIDR(u.Cidr); err == nil && ipNet.IP.To4() == nil {
+		if ones, _ := ipNet.Mask.Size(); ones > 64 {
+			return false, errors.New("Invalid UEIPPool.cidr: " + u.Cidr + ", IPv6 prefix should not be longer than /64")
+		}
+	}
+	return true, nil
 }
 
 type SpecificPath struct {
@@ -678,11 +847,85 @@ type TimerValue struct {
 	MaxRetryTimes int           `yaml:"maxRetryTimes,omitempty" valid:"type(int)"`
 }
 
+// T3590 of PDU SESSION AUTHENTICATION COMMAND, TS 24.501 10.3 Table 10.3.2
+const (
+	DefaultT3590ExpireTime    = 16 * time.Second
+	DefaultT3590MaxRetryTimes = 4
+)
+
 func (t *TimerValue) validate() (bool, error) {
 	result, err := govalidator.ValidateStruct(t)
 	return result, err
 }
 
+// NotificationDelivery configures retries and queuing of SMF notifications,
+// zero values fall back to the SMF defaults
+type NotificationDelivery struct {
+	MaxRetryTimes  int           `yaml:"maxRetryTimes,omitempty" valid:"type(int),optional"`
+	InitialBackoff time.Duration `yaml:"initialBackoff,omitempty" valid:"type(time.Duration),optional"`
+	MaxBackoff     time.Duration `yaml:"maxBackoff,omitempty" valid:"type(time.Duration),optional"`
+	MaxQueueLength int           `yaml:"maxQueueLength,omitempty" valid:"type(int),optional"`
+	MaxDeadLetters int           `yaml:"maxDeadLetters,omitempty" valid:"type(int),optional"`
+}
+
+func (n *NotificationDelivery) validate() (bool, error) {
+	if n.MaxRetryTimes < 0 || n.MaxQueueLength < 0 || n.MaxDeadLetters < 0 {
+		return false, errors.New("Invalid notificationDelivery: negative value")
+	}
+	if n.InitialBackoff < 0 || n.MaxBackoff < 0 {
+		return false, errors.New("Invalid notificationDelivery: negative backoff")
+	}
+	if n.InitialBackoff > 0 && n.MaxBackoff > 0 && n.InitialBackoff > n.MaxBackoff {
+		return false, errors.New("Invalid notificationDelivery: initialBackoff is larger than maxBackoff")
+	}
+	result, err := govalidator.ValidateStruct(n)
+	return result, err
+}
+
+// Charging configures the online charging of PDU sessions by CHF over Nchf_ConvergedCharging (TS 32.291),
+// the PDU sessions are charged with the rating group and the units requested for it
+type Charging struct {
+	ChfUri          string        `yaml:"chfUri" valid:"url,required"`
+	RatingGroup     uint32        `yaml:"ratingGroup,omitempty" valid:"optional"`
+	RequestedVolume uint64        `yaml:"requestedVolume,omitempty" valid:"optional"`
+	RequestedTime   time.Duration `yaml:"requestedTime,omitempty" valid:"type(time.Duration),optional"`
+}
+
+func (c *Charging) validate() (bool, error) {
+	if c.RequestedTime < 0 {
+		return false, errors.New("Invalid charging: negative requestedTime")
+	}
+	result, err := govalidator.ValidateStruct(c)
+	return result, appendInvalid(err)
+}
+
+// Cdr configures the offline charging records written by SMF to the local files,
+// the usage of PDU sessions is recorded on release and on the interim interval
+type Cdr struct {
+	Directory string `yaml:"directory" valid:"required"`
+	// Format is "json" for JSON lines or "3gpp" for the layout of TS 32.298 PDU session charging records
+	Format          string        `yaml:"format,omitempty" valid:"in(json|3gpp),optional"`
+	InterimInterval time.Duration `yaml:"interimInterval,omitempty" valid:"type(time.Duration),optional"`
+	// the file is rotated when it reaches the size in bytes or the age, zero values fall back to the SMF defaults.
+	// The oldest files beyond maxFiles are removed, all of them are kept if it's zero
+	MaxFileSize int64         `yaml:"maxFileSize,omitempty" valid:"optional"`
+	MaxFileAge  time.Duration `yaml:"maxFileAge,omitempty" valid:"type(time.Duration),optional"`
+	MaxFiles    int           `yaml:"maxFiles,omitempty" valid:"type(int),optional"`
+	// RatingGroup of the usage which isn't charged with a rating group by CHF or PCC rules
+	RatingGroup uint32 `yaml:"ratingGroup,omitempty" valid:"optional"`
+}
+
+func (c *Cdr) validate() (bool, error) {
+	if c.InterimInterval < 0 || c.MaxFileAge < 0 {
+		return false, errors.New("Invalid cdr: negative interval")
+	}
+	if c.MaxFileSize < 0 || c.MaxFiles < 0 {
+		return false, errors.New("Invalid cdr: negative value")
+	}
+	result, err := govalidator.ValidateStruct(c)
+	return result, appendInvalid(err)
+}
+
 func (c *Config) GetVersion() string {
 	c.RLock()
 	defer c.RUnlock()
@@ -7,6 +7,7 @@ package factory
 import (
 	"fmt"
 	"io/ioutil"
+	"sync"
 
 	"github.com/asaskevich/govalidator"
 	"gopkg.in/yaml.v2"
@@ -15,10 +16,28 @@ import (
 )
 
 var (
-	SmfConfig       *Config
-	UERoutingConfig *RoutingConfig
+	SmfConfig *Config
+	// ueRoutingConfig is replaced on reload while PDU sessions are handled
+	ueRoutingConfig    *RoutingConfig
+	ueRoutingConfigMtx sync.RWMutex
+	// UERoutingConfigPath is the path of the UE routing config, it's read again on reload
+	UERoutingConfigPath string
 )
 
+// GetUERoutingConfig returns the current UE routing config
+func GetUERoutingConfig() *RoutingConfig {
+	ueRoutingConfigMtx.RLock()
+	defer ueRoutingConfigMtx.RUnlock()
+	return ueRoutingConfig
+}
+
+// SetUERoutingConfig replaces the UE routing config
+func SetUERoutingConfig(cfg *RoutingConfig) {
+	ueRoutingConfigMtx.Lock()
+	defer ueRoutingConfigMtx.Unlock()
+	ueRoutingConfig = cfg
+}
+
 // TODO: Support configuration update from REST api
 func InitConfigFactory(f string, cfg *Config) error {
 	if f == "" {
@@ -86,5 +105,6 @@ func ReadUERoutingConfig(cfgPath string) (*RoutingConfig, error) {
 		return nil, fmt.Errorf("Config validate Error")
 	}
 
+	UERoutingConfigPath = cfgPath
 	return ueRoutingCfg, nil
 }
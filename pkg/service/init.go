@@ -15,13 +15,16 @@ import (
 	"bitbucket.org/free5gc-team/openapi/models"
 	smf_context "bitbucket.org/free5gc-team/smf/internal/context"
 	"bitbucket.org/free5gc-team/smf/internal/logger"
+	"bitbucket.org/free5gc-team/smf/internal/n4u"
 	"bitbucket.org/free5gc-team/smf/internal/pfcp"
+	"bitbucket.org/free5gc-team/smf/internal/pfcp/handler"
 	"bitbucket.org/free5gc-team/smf/internal/pfcp/udp"
 	"bitbucket.org/free5gc-team/smf/internal/sbi/callback"
 	"bitbucket.org/free5gc-team/smf/internal/sbi/consumer"
 	"bitbucket.org/free5gc-team/smf/internal/sbi/eventexposure"
 	"bitbucket.org/free5gc-team/smf/internal/sbi/oam"
 	"bitbucket.org/free5gc-team/smf/internal/sbi/pdusession"
+	"bitbucket.org/free5gc-team/smf/internal/sbi/producer"
 	"bitbucket.org/free5gc-team/smf/internal/sbi/upi"
 	"bitbucket.org/free5gc-team/smf/pkg/association"
 	"bitbucket.org/free5gc-team/smf/pkg/factory"
@@ -99,7 +102,7 @@ func (a *SmfApp) Start(tlsKeyLogPath string) {
 	smf_context.InitSmfContext(factory.SmfConfig)
 	// allocate id for each upf
 	smf_context.AllocateUPFID()
-	smf_context.InitSMFUERouting(factory.UERoutingConfig)
+	smf_context.InitSMFUERouting(factory.GetUERoutingConfig())
 
 	logger.InitLog.Infoln("Server started")
 	router := logger_util.NewGinWithLogrus(logger.GinLog)
@@ -113,6 +116,18 @@ func (a *SmfApp) Start(tlsKeyLogPath string) {
 		}
 	}
 
+	smf_context.SetProcedureHandlers(smf_context.ProcedureHandlers{
+		UPFRelease:            association.HandleUPFRelease,
+		UserPlanePathFailure:  association.HandleUserPlanePathFailure,
+		SessionSetDeletion:    association.HandleSessionSetDeletion,
+		ChargingReport:        producer.HandleChargingReport,
+		UsageMonitoringReport: producer.HandleUsageMonitoringReport,
+		UsageQuery:            producer.QueryURRUsage,
+		PfdsUpdate:            association.UpdatePfds,
+	})
+	// the PFDs are provisioned to UPFs when the PFCP associations are set up
+	producer.InitPfdManagement()
+
 	signalChannel := make(chan os.Signal, 1)
 	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM)
 	go func() {
@@ -128,6 +143,14 @@ func (a *SmfApp) Start(tlsKeyLogPath string) {
 		os.Exit(0)
 	}()
 
+	reloadChannel := make(chan os.Signal, 1)
+	signal.Notify(reloadChannel, syscall.SIGHUP)
+	go func() {
+		for range reloadChannel {
+			a.ReloadUERoutingConfig()
+		}
+	}()
+
 	oam.AddService(router)
 	callback.AddService(router)
 	upi.AddService(router)
@@ -140,6 +163,10 @@ func (a *SmfApp) Start(tlsKeyLogPath string) {
 		}
 	}
 	udp.Run(pfcp.Dispatch)
+	// GTP-U port is bound only if the downlink data is buffered in SMF, as UPF may run on the same host
+	if smf_context.GetSelf().DLBufferingInSMF() {
+		n4u.Run(handler.HandleN4uDownlinkData)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	smf_context.GetSelf().Ctx = ctx
@@ -175,8 +202,25 @@ func (a *SmfApp) Start(tlsKeyLogPath string) {
 	}
 }
 
+// ReloadUERoutingConfig reads the UE routing config again and refreshes the PFDs of UPFs,
+// the UE routing paths are not changed until SMF restarts
+func (a *SmfApp) ReloadUERoutingConfig() {
+	logger.InitLog.Infof("Reloading UE routing config...")
+	ueRoutingCfg, err := factory.ReadUERoutingConfig(factory.UERoutingConfigPath)
+	if err != nil {
+		logger.InitLog.Errorf("Reload UE routing config failed: %+v", err)
+		return
+	}
+	factory.SetUERoutingConfig(ueRoutingCfg)
+
+	pfdStore := smf_context.GetPfdStore()
+	removedAppIDs := pfdStore.Replace(smf_context.PfdDatasFromConfig(ueRoutingCfg))
+	association.UpdatePfds(pfdStore.All(), removedAppIDs)
+}
+
 func (a *SmfApp) Terminate() {
 	logger.InitLog.Infof("Terminating SMF...")
+	producer.TerminatePfdManagement()
 	// deregister with NRF
 	problemDetails, err := consumer.SendDeregisterNFInstance()
 	if problemDetails != nil {
@@ -186,4 +230,10 @@ func (a *SmfApp) Terminate() {
 	} else {
 		logger.InitLog.Infof("Deregister from NRF successfully")
 	}
+	// complete the CDR file being written
+	if offline := smf_context.GetSelf().Cdr; offline != nil {
+		if err := offline.Writer.Close(); err != nil {
+			logger.InitLog.Errorf("Close CDR writer failed: %+v", err)
+		}
+	}
 }